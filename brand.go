@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* brandMonitor: the -brand mode. Rather than crawling an organization, search
+ * CT for certificates on typosquats of the brand's domain and report which ones
+ * have had certificates issued, and by whom.
+ */
+func brandMonitor(ctx context.Context, crawler *sancrawler.Crawler, opts *options) {
+	fuzzers, err := listOrFile(opts.brandFuzzers)
+	if err != nil {
+		log.Fatal("Could not read fuzzers: ", err)
+	}
+
+	typosquats, err := sancrawler.Typosquats(opts.brand, fuzzers)
+	if err != nil {
+		log.Fatal("Could not permute brand domain: ", err)
+	}
+
+	log.WithFields(log.Fields{
+		"Domain":     opts.brand,
+		"Typosquats": len(typosquats),
+	}).Info("Searching CT for typosquats")
+
+	var queries []sancrawler.Query
+	for _, t := range typosquats {
+		queries = append(queries, sancrawler.Query{Value: t.Domain})
+	}
+
+	results, err := crawler.CrawlAll(ctx, queries)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sancrawler.ErrStopped) {
+		log.WithFields(log.Fields{
+			"Reason": err,
+		}).Warn("Search interrupted, keeping partial results")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	hits := sancrawler.TyposquatHits(typosquats, results)
+
+	for _, hit := range hits {
+		log.WithFields(log.Fields{
+			"Domain":       hit.Domain,
+			"Fuzzer":       hit.Fuzzer,
+			"Certificates": hit.Certificates,
+			"Issuers":      strings.Join(hit.Issuers, "; "),
+			"LastSeen":     hit.LastSeen.Format("2006-01-02"),
+		}).Warn("Typosquat has certificates")
+	}
+
+	log.WithFields(log.Fields{
+		"Typosquats": len(typosquats),
+		"Issued":     len(hits),
+	}).Info("Finished searching for typosquats")
+
+	fHandle, err := openOutput(opts.outfile, false)
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	w := bufio.NewWriter(fHandle)
+	if err := writeTyposquats(w, opts.format, hits); err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+}
+
+/* writeTyposquats: one typosquat per line (or row, or object), most recently
+ * issued first.
+ */
+func writeTyposquats(w *bufio.Writer, format string, hits []sancrawler.TyposquatHit) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if hits == nil {
+			hits = []sancrawler.TyposquatHit{}
+		}
+		return enc.Encode(hits)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"domain", "fuzzer", "certificates", "issuers", "first_seen", "last_seen", "names"})
+		for _, hit := range hits {
+			out.Write([]string{
+				hit.Domain,
+				hit.Fuzzer,
+				strconv.Itoa(hit.Certificates),
+				strings.Join(hit.Issuers, ";"),
+				csvTime(hit.FirstSeen),
+				csvTime(hit.LastSeen),
+				strings.Join(hit.Names, ";"),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	}
+
+	for _, hit := range hits {
+		w.WriteString(hit.Domain + "\t" + hit.Fuzzer + "\t" + strconv.Itoa(hit.Certificates) + "\t" + strings.Join(hit.Issuers, "; ") + "\n")
+	}
+	return nil
+}
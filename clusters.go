@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* writeClusters: groups the names by the certificates they share and writes
+ * the groups to path, JSON if it ends in .json and text otherwise.
+ */
+func writeClusters(path string, subdomains sancrawler.Results, maxNames int) error {
+	clusters, alone := sancrawler.ClusterNames(subdomains, maxNames)
+
+	log.WithFields(log.Fields{
+		"Clusters":    len(clusters),
+		"Unclustered": len(alone),
+		"File":        path,
+	}).Info("Clustered names by the certificates they share")
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		if clusters == nil {
+			clusters = []sancrawler.Cluster{}
+		}
+		if alone == nil {
+			alone = []string{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(struct {
+			Clusters    []sancrawler.Cluster `json:"clusters"`
+			Unclustered []string             `json:"unclustered"`
+		}{clusters, alone})
+	} else {
+		writeClustersText(w, clusters, alone)
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+/* writeClustersText: one block per cluster with a header saying what it is,
+ * then the names that didn't fit anywhere.
+ */
+func writeClustersText(w *bufio.Writer, clusters []sancrawler.Cluster, alone []string) {
+	for _, c := range clusters {
+		fmt.Fprintf(w, "# %s: %d names on %d certificates", c.Label, len(c.Names), c.Certificates)
+		if len(c.Apexes) > 1 {
+			fmt.Fprintf(w, ", under %s", strings.Join(c.Apexes, ", "))
+		}
+		w.WriteString("\n")
+		for _, name := range c.Names {
+			w.WriteString(name + "\n")
+		}
+		w.WriteString("\n")
+	}
+
+	if len(alone) > 0 {
+		fmt.Fprintf(w, "# Unclustered: %d names that share no certificate with any other\n", len(alone))
+		for _, name := range alone {
+			w.WriteString(name + "\n")
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	"golang.org/x/net/publicsuffix"
+)
+
+// A line of subfinder's JSON output (-oJ, with -oI for the address)
+type subfinderRecord struct {
+	Host   string `json:"host"`
+	Input  string `json:"input"`
+	Source string `json:"source"`
+	IP     string `json:"ip,omitempty"`
+}
+
+// A line of amass enum's JSON output
+type amassRecord struct {
+	Name      string         `json:"name"`
+	Domain    string         `json:"domain"`
+	Addresses []amassAddress `json:"addresses"`
+	Tag       string         `json:"tag"`
+	Sources   []string       `json:"sources"`
+}
+
+type amassAddress struct {
+	IP   string `json:"ip"`
+	CIDR string `json:"cidr"`
+	ASN  uint   `json:"asn"`
+	Desc string `json:"desc"`
+}
+
+/* compatSource: the source name the other tools use for ours.
+ */
+func compatSource(res sancrawler.Result) string {
+	switch res.Source {
+	case "crt.sh", "":
+		return "crtsh"
+	}
+	return res.Source
+}
+
+/* compatRecord: res as a line of subfinder or amass output, or nil if it isn't
+ * a host name. Wildcards lose their *., since both tools only list hosts.
+ */
+func compatRecord(format string, res sancrawler.Result) interface{} {
+	if res.Type != "" {
+		return nil
+	}
+	name := strings.TrimPrefix(res.Name, "*.")
+
+	var addresses []string
+	if res.DNS != nil {
+		addresses = append(append(addresses, res.DNS.A...), res.DNS.AAAA...)
+	}
+
+	if format == "subfinder" {
+		rec := subfinderRecord{Host: name, Source: compatSource(res)}
+		if len(res.Seeds) > 0 {
+			rec.Input = res.Seeds[0]
+		}
+		if len(addresses) > 0 {
+			rec.IP = addresses[0]
+		}
+		return rec
+	}
+
+	rec := amassRecord{Name: name, Addresses: []amassAddress{}, Tag: "cert", Sources: []string{compatSource(res)}}
+	if apex, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		rec.Domain = apex
+	}
+	for _, ip := range addresses {
+		addr := amassAddress{IP: ip}
+		for _, asn := range res.DNS.ASN {
+			if asn.IP == ip {
+				addr.CIDR, addr.ASN, addr.Desc = asn.Prefix, asn.ASN, asn.Org
+			}
+		}
+		rec.Addresses = append(rec.Addresses, addr)
+	}
+	return rec
+}
+
+/* writeCompat: the results as subfinder or amass JSON lines, one per host.
+ */
+func writeCompat(w *bufio.Writer, format string, results []sancrawler.Result) error {
+	enc := json.NewEncoder(w)
+	seen := make(map[string]bool)
+
+	for _, res := range results {
+		name := strings.TrimPrefix(res.Name, "*.")
+		if seen[name] {
+			continue
+		}
+
+		if rec := compatRecord(format, res); rec != nil {
+			seen[name] = true
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys in the config file that aren't flags. They set environment variables
+// (if they aren't already set) so API keys can live in the config too.
+var configEnv = map[string]string{
+	"censys-api-id":     "CENSYS_API_ID",
+	"censys-api-secret": "CENSYS_API_SECRET",
+	"facebook-token":    "FACEBOOK_ACCESS_TOKEN",
+	"facebook-app-id":   "FACEBOOK_APP_ID",
+	"facebook-secret":   "FACEBOOK_APP_SECRET",
+}
+
+/* configPath: finds -config on the command line before the flags get parsed,
+ * since the config has to be applied first for the command line to win.
+ * Without one, ~/.sancrawler.yaml is used if it exists. "none" turns it off.
+ */
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	path := filepath.Join(home, ".sancrawler.yaml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+/* loadConfig: reads a YAML config file and sets every flag named in it, so its
+ * values become the defaults for this run. Keys are flag names without the
+ * dash, lists become comma separated values (or get added one at a time for
+ * flags like -k that can be repeated).
+ */
+func loadConfig(fs *flag.FlagSet, path string) error {
+	if path == "" || path == "none" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for key, value := range config {
+		if env, ok := configEnv[key]; ok {
+			if os.Getenv(env) == "" {
+				os.Setenv(env, fmt.Sprint(value))
+			}
+			continue
+		}
+
+		// Settings for flags of other commands are skipped, they're not wrong
+		f := fs.Lookup(key)
+		if f == nil && key != "config" && knownFlag(key) {
+			continue
+		}
+		if f == nil || key == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+
+		if err := setFlag(f, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+/* setFlag: sets a single flag from a config value.
+ */
+func setFlag(f *flag.Flag, value interface{}) error {
+	list, ok := value.([]interface{})
+	if !ok {
+		if value == nil {
+			return errors.New("missing value")
+		}
+		return f.Value.Set(fmt.Sprint(value))
+	}
+
+	// Repeatable flags get each item on its own, everything else takes a comma
+	// separated list.
+	if _, repeatable := f.Value.(*seedList); repeatable {
+		for _, item := range list {
+			if err := f.Value.Set(fmt.Sprint(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+	return f.Value.Set(strings.Join(items, ","))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	"github.com/cramppet/sancrawler2/pkg/store"
+)
+
+/* loadPrevious: loads the results of an earlier run to diff against, either
+ * JSON output from -format json or the last run in a -sqlite database. Also
+ * returns roughly when those results were collected.
+ */
+func loadPrevious(path string) (sancrawler.Results, time.Time, error) {
+	if store.IsDatabase(path) {
+		db, err := store.Open(path)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		defer db.Close()
+
+		return db.LastRun()
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var records []sancrawler.Result
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	ret := make(sancrawler.Results)
+	for _, res := range records {
+		ret[res.Name] = res
+	}
+
+	// The file was last written when that run finished, which is close enough
+	return ret, info.ModTime(), nil
+}
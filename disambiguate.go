@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// How many organizations to list when asking, the rest are rarely worth it
+const disambiguateListSize = 50
+
+// An organization a keyword matched, with how many certificates
+type matchedOrg struct {
+	name  string
+	certs int
+}
+
+/* disambiguate: which organizations to crawl in place of the keyword q, going
+ * by -auto-top or asking. Returns nil to crawl the keyword as it is.
+ */
+func disambiguate(ctx context.Context, crawler *sancrawler.Crawler, opts *options, q sancrawler.Query) []string {
+	counts, err := crawler.MatchedOrganizations(ctx, q)
+	if err != nil {
+		log.Fatal("Could not count the keyword's organizations: ", err)
+	}
+
+	var orgs []matchedOrg
+	for name, certs := range counts {
+		if name != "" {
+			orgs = append(orgs, matchedOrg{name, certs})
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].certs != orgs[j].certs {
+			return orgs[i].certs > orgs[j].certs
+		}
+		return orgs[i].name < orgs[j].name
+	})
+
+	log.WithFields(log.Fields{
+		"Keyword":        q.Value,
+		"Organizations":  len(orgs),
+		"NoOrganization": counts[""],
+	}).Info("Counted the organizations the keyword matches")
+
+	if len(orgs) == 0 {
+		log.WithFields(log.Fields{
+			"Keyword": q.Value,
+		}).Warn("Keyword matches no certificates with an organization, crawling it as is")
+		return nil
+	}
+
+	var chosen []matchedOrg
+	if opts.autoTop > 0 {
+		chosen = orgs
+		if len(chosen) > opts.autoTop {
+			chosen = chosen[:opts.autoTop]
+		}
+	} else {
+		chosen = askOrganizations(q.Value, orgs, counts[""])
+		if chosen == nil {
+			return nil
+		}
+	}
+
+	var names []string
+	for _, org := range chosen {
+		log.WithFields(log.Fields{
+			"Keyword":      q.Value,
+			"Organization": org.name,
+			"Certificates": org.certs,
+		}).Info("Crawling organization in place of keyword")
+		names = append(names, org.name)
+	}
+	return names
+}
+
+/* askOrganizations: lists the organizations on stderr, most certificates
+ * first, and reads which ones to crawl from stdin. nil means keep the keyword.
+ */
+func askOrganizations(keyword string, orgs []matchedOrg, none int) []matchedOrg {
+	fmt.Fprintf(os.Stderr, "%q matches certificates from %d organizations:\n", keyword, len(orgs))
+
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for i, org := range orgs {
+		if i == disambiguateListSize {
+			fmt.Fprintf(tw, "\t\t... %d more\n", len(orgs)-i)
+			break
+		}
+		fmt.Fprintf(tw, "  %d)\t%d\t  %s\n", i+1, org.certs, org.name)
+	}
+	if none > 0 {
+		fmt.Fprintf(tw, "\t%d\t  (no organization)\n", none)
+	}
+	tw.Flush()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "Crawl which? (eg. 1,3, top 5, all, or k to crawl the keyword as is): ")
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatal("No organizations picked. Quitting.")
+		}
+
+		line = strings.ToLower(strings.TrimSpace(line))
+		switch {
+		case line == "k":
+			return nil
+		case line == "all":
+			return orgs
+		case strings.HasPrefix(line, "top "):
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "top ")))
+			if err == nil && n > 0 {
+				if n > len(orgs) {
+					n = len(orgs)
+				}
+				return orgs[:n]
+			}
+			continue
+		}
+
+		var chosen []matchedOrg
+		ok := line != ""
+		for _, f := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' }) {
+			n, err := strconv.Atoi(f)
+			if err != nil || n < 1 || n > len(orgs) {
+				ok = false
+				break
+			}
+			chosen = append(chosen, orgs[n-1])
+		}
+		if ok {
+			return chosen
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* auditDNS: checks the CAA records and DNSSEC of every apex domain found,
+ * warning about CAs issuing against CAA policy and broken DNSSEC, and lists
+ * every apex on stderr.
+ */
+func auditDNS(ctx context.Context, resolver *sancrawler.Resolver, subdomains sancrawler.Results) {
+	auditor := &sancrawler.DNSAuditor{Servers: resolver.Servers}
+	audits := auditor.AuditAll(ctx, subdomains)
+
+	unauthorized, withCAA, secure := 0, 0, 0
+	for _, audit := range audits {
+		if len(audit.CAA) > 0 {
+			withCAA++
+		}
+		if audit.DNSSEC == sancrawler.DNSSECSecure {
+			secure++
+		}
+
+		for _, issuer := range audit.Unauthorized {
+			unauthorized++
+			log.WithFields(log.Fields{
+				"Apex":   audit.Apex,
+				"Issuer": issuer,
+			}).Warn("Valid certificates from a CA the apex's CAA records don't allow")
+		}
+		if audit.DNSSEC == sancrawler.DNSSECBroken {
+			log.WithFields(log.Fields{
+				"Apex": audit.Apex,
+			}).Warn("Apex has a DS record but no DNSKEY, validating resolvers won't answer for it")
+		}
+		if audit.Error != "" {
+			log.WithFields(log.Fields{
+				"Apex":  audit.Apex,
+				"Error": audit.Error,
+			}).Warn("Could not audit apex's DNS")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Apexes":       len(audits),
+		"WithCAA":      withCAA,
+		"DNSSEC":       secure,
+		"Unauthorized": unauthorized,
+	}).Info("Finished auditing CAA and DNSSEC")
+
+	if len(audits) > 0 {
+		writeDNSAudits(os.Stderr, audits)
+	}
+}
+
+/* writeDNSAudits: a line per apex with its DNSSEC status, CAA policy and the
+ * CAs CT says issued for it, the ones against policy marked with a !.
+ */
+func writeDNSAudits(w io.Writer, audits []sancrawler.DNSAudit) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Apex\tDNSSEC\tCAA\tIssuers")
+
+	for _, audit := range audits {
+		if audit.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t%s\n", audit.Apex, audit.Error)
+			continue
+		}
+
+		var policy []string
+		for _, caa := range audit.CAA {
+			if caa.Tag == "issue" || caa.Tag == "issuewild" {
+				policy = append(policy, caa.Tag+" "+caa.Value)
+			}
+		}
+		caa := "none"
+		if len(policy) > 0 {
+			caa = strings.Join(policy, ", ")
+		}
+
+		var issuers []string
+		for _, issuer := range audit.Issuers {
+			if containsString(audit.Unauthorized, issuer) {
+				issuer = "! " + issuer
+			}
+			issuers = append(issuers, issuer)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", audit.Apex, audit.DNSSEC, caa, strings.Join(issuers, "; "))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* enumerateDNS: folds the names DNS knows about under each apex (nameservers,
+ * mail servers, SPF and DMARC hosts, whole zones when transfers are allowed)
+ * into subdomains, leaving out any the scope excludes.
+ */
+func enumerateDNS(ctx context.Context, resolver *sancrawler.Resolver, scope *sancrawler.Scope, subdomains sancrawler.Results) {
+	log.Info("Looking up NS, MX, SPF and DMARC records and trying zone transfers")
+
+	enumerator := &sancrawler.DNSEnumerator{Resolver: resolver}
+	extra, transfers := enumerator.EnumerateAll(ctx, subdomains)
+
+	for _, transfer := range transfers {
+		log.WithFields(log.Fields{
+			"Apex":       transfer.Apex,
+			"Nameserver": transfer.Nameserver,
+			"Records":    transfer.Records,
+		}).Warn("Nameserver allowed a zone transfer")
+	}
+
+	added := 0
+	for name, res := range extra {
+		if scope != nil && scope.Excludes(name) {
+			continue
+		}
+		subdomains[name] = res
+		added++
+	}
+
+	log.WithFields(log.Fields{
+		"Found":     added,
+		"Transfers": len(transfers),
+	}).Info("Finished DNS enumeration")
+}
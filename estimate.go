@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// What -estimate writes out as JSON, one per seed
+type estimateJSON struct {
+	sancrawler.Estimate
+	PageSeconds float64 `json:"page_seconds"`
+	Seconds     float64 `json:"seconds"`
+}
+
+/* estimate: -estimate. Counts what each query would crawl and times a page of
+ * it, instead of crawling.
+ */
+func estimate(ctx context.Context, crawler *sancrawler.Crawler, opts *options, queries []sancrawler.Query) {
+	db, ok := crawler.Backend.(*sancrawler.DBBackend)
+	if !ok {
+		log.Fatal("-estimate only works with the db backend")
+	}
+	if opts.recursive || opts.issuerPivot {
+		log.Warn("Only the seeds get estimated, not what -recursive or -issuer-pivot would go on to crawl")
+	}
+
+	var estimates []sancrawler.Estimate
+	for _, q := range queries {
+		est, err := db.Estimate(ctx, q)
+		if err != nil {
+			log.Fatal("Could not estimate the crawl: ", err)
+		}
+
+		log.WithFields(log.Fields{
+			"Seed":         q.Value,
+			"Certificates": est.Certificates,
+			"CAs":          len(est.CAs),
+			"Estimate":     est.Duration.Round(time.Second),
+		}).Info("Estimated crawl")
+
+		estimates = append(estimates, est)
+	}
+
+	fHandle, err := openOutput(opts.outfile, false)
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	w := bufio.NewWriter(fHandle)
+	switch opts.format {
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, est := range estimates {
+			if err = enc.Encode(estimateJSON{est, est.PageTime.Seconds(), est.Duration.Seconds()}); err != nil {
+				break
+			}
+		}
+	case "text":
+		err = writeEstimates(w, estimates, opts.statsTop)
+	default:
+		log.Fatal("-estimate only writes text or json")
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+}
+
+/* writeEstimates: the estimates in a form meant for people, with the top CAs
+ * by certificates for each seed (all of them if top is 0).
+ */
+func writeEstimates(w io.Writer, estimates []sancrawler.Estimate, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	for _, est := range estimates {
+		fmt.Fprintf(tw, "Seed\t%s\n", est.Seed)
+		fmt.Fprintf(tw, "  Certificates\t%d\n", est.Certificates)
+		fmt.Fprintf(tw, "  CAs\t%d\n", len(est.CAs))
+		fmt.Fprintf(tw, "  Pages\t%d (%d passes over the certificates)\n", est.Pages, est.Jobs)
+		fmt.Fprintf(tw, "  Crawlers\t%d, up to %d\n", est.Crawlers, est.MaxCrawlers)
+		fmt.Fprintf(tw, "  Sample page\t%s\n", est.PageTime.Round(time.Millisecond))
+		fmt.Fprintf(tw, "  Estimate\t%s\n", est.Duration.Round(time.Second))
+
+		for i, ca := range est.CAs {
+			if top > 0 && i == top {
+				fmt.Fprintf(tw, "    ...\t%d more\n", len(est.CAs)-top)
+				break
+			}
+			fmt.Fprintf(tw, "    %s\t%d\n", ca.Name, ca.Certificates)
+		}
+		fmt.Fprintf(tw, "\t\n")
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* expiringNames: only the names whose last certificate runs out within
+ * -expiring-within, with those certificates listed on stderr by issuer so
+ * whoever looks after them knows what to renew.
+ */
+func expiringNames(opts *options, subdomains sancrawler.Results) sancrawler.Results {
+	within, err := parseSpan(opts.expiringWithin)
+	if err != nil {
+		log.Fatal("Bad -expiring-within: ", err)
+	}
+
+	expiring := sancrawler.ExpiringBefore(subdomains, time.Now().Add(within))
+	issuers := sancrawler.GroupExpiring(expiring)
+
+	certs := 0
+	for _, issuer := range issuers {
+		certs += len(issuer.Certificates)
+	}
+
+	log.WithFields(log.Fields{
+		"Within":       opts.expiringWithin,
+		"Names":        len(expiring),
+		"Certificates": certs,
+		"Issuers":      len(issuers),
+	}).Info("Found names whose certificates expire soon")
+
+	if len(issuers) > 0 {
+		writeExpiring(os.Stderr, issuers, time.Now())
+	}
+	return expiring
+}
+
+/* writeExpiring: each issuer, then its certificates soonest first with when
+ * they run out and the names on them.
+ */
+func writeExpiring(w io.Writer, issuers []sancrawler.ExpiringIssuer, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, issuer := range issuers {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+
+		name := issuer.IssuerName
+		if name == "" {
+			name = "Unknown issuer"
+		}
+		fmt.Fprintf(tw, "%s: %d certificates, %d names\n", name, len(issuer.Certificates), issuer.Names)
+
+		for _, cert := range issuer.Certificates {
+			days := int(cert.NotAfter.Sub(now).Hours() / 24)
+			id := "-"
+			if cert.ID != 0 {
+				id = fmt.Sprintf("crt.sh/?id=%d", cert.ID)
+			}
+			fmt.Fprintf(tw, "  %s\tin %dd\t%s\t%s\n", cert.NotAfter.UTC().Format("2006-01-02"), days, id, strings.Join(cert.Names, ", "))
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+// A graph of how the results hang together: seeds found names, certificates
+// contain names and CAs issued certificates. Good for pulling into Gephi and
+// friends when working out who owns what.
+type graph struct {
+	nodes []graphNode
+	edges []graphEdge
+	seen  map[string]bool
+}
+
+type graphNode struct {
+	id    string
+	kind  string
+	label string
+}
+
+type graphEdge struct {
+	from string
+	to   string
+	kind string
+}
+
+func (g *graph) node(id string, kind string, label string) string {
+	if !g.seen[id] {
+		g.seen[id] = true
+		g.nodes = append(g.nodes, graphNode{id, kind, label})
+	}
+	return id
+}
+
+func (g *graph) edge(from string, to string, kind string) {
+	if key := from + "\x00" + to + "\x00" + kind; !g.seen[key] {
+		g.seen[key] = true
+		g.edges = append(g.edges, graphEdge{from, to, kind})
+	}
+}
+
+/* buildGraph: turns the results into nodes and edges. Names that didn't come
+ * from crt.sh have no certificate ID, so they only get linked to their seeds.
+ */
+func buildGraph(results []sancrawler.Result) *graph {
+	g := &graph{seen: make(map[string]bool)}
+
+	for _, res := range results {
+		kind := res.Type
+		if kind == "" {
+			kind = "dns"
+		}
+		name := g.node("name:"+res.Name, "name", res.Name)
+
+		for _, seed := range res.Seeds {
+			g.edge(g.node("seed:"+seed, "seed", seed), name, "found")
+		}
+
+		if res.CertificateID == 0 {
+			continue
+		}
+
+		cert := g.node("cert:"+strconv.Itoa(res.CertificateID), "certificate", strconv.Itoa(res.CertificateID))
+		g.edge(cert, name, strings.ToLower(res.Field))
+
+		if res.IssuerCAID != 0 {
+			label := res.IssuerName
+			if label == "" {
+				label = strconv.Itoa(res.IssuerCAID)
+			}
+			g.edge(g.node("ca:"+strconv.Itoa(res.IssuerCAID), "ca", label), cert, "issued")
+		}
+	}
+
+	return g
+}
+
+/* writeGraphML: the graph as GraphML, every node and edge gets a type so they
+ * can be styled separately.
+ */
+func writeGraphML(w *bufio.Writer, results []sancrawler.Result) error {
+	g := buildGraph(results)
+
+	w.WriteString(xml.Header)
+	w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	w.WriteString(`  <key id="type" for="node" attr.name="type" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="relation" for="edge" attr.name="relation" attr.type="string"/>` + "\n")
+	w.WriteString(`  <graph id="sancrawler" edgedefault="directed">` + "\n")
+
+	for _, n := range g.nodes {
+		w.WriteString(`    <node id="` + xmlEscape(n.id) + `">`)
+		w.WriteString(`<data key="type">` + n.kind + `</data>`)
+		w.WriteString(`<data key="label">` + xmlEscape(n.label) + `</data></node>` + "\n")
+	}
+
+	for i, e := range g.edges {
+		w.WriteString(`    <edge id="e` + strconv.Itoa(i) + `" source="` + xmlEscape(e.from) + `" target="` + xmlEscape(e.to) + `">`)
+		w.WriteString(`<data key="relation">` + e.kind + `</data></edge>` + "\n")
+	}
+
+	w.WriteString("  </graph>\n</graphml>\n")
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// How each kind of node gets drawn in DOT output
+var dotShapes = map[string]string{
+	"seed":        "doubleoctagon",
+	"ca":          "house",
+	"certificate": "note",
+	"name":        "ellipse",
+}
+
+/* writeDOT: the graph in Graphviz's DOT language.
+ */
+func writeDOT(w *bufio.Writer, results []sancrawler.Result) error {
+	g := buildGraph(results)
+
+	w.WriteString("digraph sancrawler {\n")
+	for _, n := range g.nodes {
+		w.WriteString("  " + dotQuote(n.id) + " [label=" + dotQuote(n.label) + ", shape=" + dotShapes[n.kind] + "];\n")
+	}
+	for _, e := range g.edges {
+		w.WriteString("  " + dotQuote(e.from) + " -> " + dotQuote(e.to) + " [label=" + dotQuote(e.kind) + "];\n")
+	}
+	w.WriteString("}\n")
+	return nil
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	"github.com/cramppet/sancrawler2/pkg/sancrawlerpb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer streams crawls to gRPC clients. It shares the REST API's worker
+// slots, so -workers limits crawls across both.
+type grpcServer struct {
+	sancrawlerpb.UnimplementedSANCrawlerServer
+
+	crawler *sancrawler.Crawler
+	slots   chan struct{}
+	tenants *tenants
+	opts    *options
+}
+
+/* serveGRPC: runs the gRPC service on opts.grpcListen until ctx is cancelled.
+ */
+func serveGRPC(ctx context.Context, crawler *sancrawler.Crawler, opts *options, slots chan struct{}, tenants *tenants) {
+	lis, err := net.Listen("tcp", opts.grpcListen)
+	if err != nil {
+		log.Fatal("Could not listen for gRPC: ", err)
+	}
+
+	server := grpc.NewServer()
+	sancrawlerpb.RegisterSANCrawlerServer(server, &grpcServer{crawler: crawler, slots: slots, tenants: tenants, opts: opts})
+
+	// The standard health service, for grpc_health_probe and Kubernetes' grpc
+	// probes
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	go func() {
+		<-ctx.Done()
+		healthServer.Shutdown()
+		server.Stop()
+	}()
+
+	log.WithFields(log.Fields{
+		"Listen": opts.grpcListen,
+	}).Info("Serving gRPC API")
+
+	if err := server.Serve(lis); err != nil {
+		log.Fatal("gRPC server failed: ", err)
+	}
+}
+
+/* Crawl: crawls the seeds in the request, sending each name down the stream
+ * as soon as it's found.
+ */
+func (s *grpcServer) Crawl(req *sancrawlerpb.CrawlRequest, stream sancrawlerpb.SANCrawler_CrawlServer) error {
+	queries, err := crawlRequest{
+		Keywords:      req.Keywords,
+		Organizations: req.Organizations,
+		Field:         req.Field,
+		Values:        req.Values,
+	}.queries()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// With -api-keys the key comes as "authorization: Bearer ..." or "x-api-key"
+	// metadata, and the crawl counts against its limits same as a REST job
+	var t *tenant
+	if s.tenants != nil {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		keys := md.Get("x-api-key")
+		for _, auth := range md.Get("authorization") {
+			keys = append(keys, strings.TrimPrefix(auth, "Bearer "))
+		}
+		for _, key := range keys {
+			if t == nil {
+				t = s.tenants.byKey(key)
+			}
+		}
+		if t == nil {
+			return status.Error(codes.Unauthenticated, "missing or unknown API key")
+		}
+		if err := s.tenants.tryStart(t); err != nil {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		defer s.tenants.finish(t)
+	}
+
+	ctx, cancel := context.WithCancel(t.crawlContext(stream.Context()))
+	defer cancel()
+
+	if s.opts.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.opts.timeout)
+		defer cancel()
+	}
+
+	// Wait for a free slot, same as a queued REST job would
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	// found is never called concurrently, so the stream is safe to use. A client
+	// that goes away cancels the crawl.
+	var sendErr error
+	_, err = s.crawler.CrawlAllStream(ctx, queries, func(res sancrawler.Result) {
+		if sendErr != nil {
+			return
+		}
+		if sendErr = stream.Send(resultToProto(res)); sendErr != nil {
+			cancel()
+		}
+	})
+
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+func resultToProto(res sancrawler.Result) *sancrawlerpb.Result {
+	ret := &sancrawlerpb.Result{
+		Name:          res.Name,
+		Type:          res.Type,
+		Field:         res.Field,
+		CertificateId: int64(res.CertificateID),
+		IssuerCaId:    int64(res.IssuerCAID),
+		IssuerName:    res.IssuerName,
+		Source:        res.Source,
+		Seeds:         res.Seeds,
+		Expired:       res.Expired,
+		Precert:       res.Precert,
+	}
+	if !res.NotBefore.IsZero() {
+		ret.NotBefore = timestamppb.New(res.NotBefore)
+	}
+	if !res.NotAfter.IsZero() {
+		ret.NotAfter = timestamppb.New(res.NotAfter)
+	}
+	return ret
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How long requests in flight get to finish once a server is told to stop.
+// Kubernetes waits 30s between SIGTERM and SIGKILL by default, so this leaves
+// time for the rest of the shutdown.
+const shutdownGrace = 10 * time.Second
+
+/* handleHealth: adds the probes to mux. /healthz answers as long as the process
+ * is up, /readyz only while ready returns nil, otherwise it's a 503 with the
+ * reason. Neither needs an API key, probes don't have one.
+ */
+func handleHealth(mux *http.ServeMux, ready func() error) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "not ready:", err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+/* shutdownOnDone: shuts server down gently once ctx is cancelled, waiting up to
+ * shutdownGrace for the requests in flight before cutting them off.
+ */
+func shutdownOnDone(ctx context.Context, server *http.Server) {
+	<-ctx.Done()
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	if err := server.Shutdown(graceCtx); err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Warn("Requests still running at shutdown, cutting them off")
+		server.Close()
+	}
+}
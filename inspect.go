@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// What the inspect command writes out as JSON
+type inspection struct {
+	URL    string                       `json:"url"`
+	Chain  []sancrawler.CertificateInfo `json:"chain"`
+	Pivots []sancrawler.Pivot           `json:"pivots"`
+}
+
+/* inspect: the inspect command. Doesn't crawl, just shows everything on the
+ * certificate chain at -u and what could be crawled on, so the seed can be
+ * picked by hand.
+ */
+func inspect(ctx context.Context, opts *options) {
+	chain, err := sancrawler.FetchChain(ctx, opts.autoURL, fetchOptions(opts))
+	if err != nil {
+		log.Fatal(err, ". Quitting.")
+	}
+
+	report := inspection{URL: opts.autoURL, Pivots: sancrawler.Pivots(chain[0])}
+	for _, cert := range chain {
+		report.Chain = append(report.Chain, sancrawler.Describe(cert))
+	}
+
+	fHandle, err := openOutput(opts.outfile, false)
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	w := bufio.NewWriter(fHandle)
+	switch opts.format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	case "text":
+		err = writeInspection(w, report)
+	default:
+		log.Fatal("inspect only writes text or json")
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+}
+
+/* writeInspection: the chain and pivots in a form meant for people.
+ */
+func writeInspection(w io.Writer, report inspection) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	for i, cert := range report.Chain {
+		role := "intermediate"
+		if i == 0 {
+			role = "leaf"
+		}
+		fmt.Fprintf(tw, "Certificate %d (%s)\t\n", i, role)
+		fmt.Fprintf(tw, "  Subject\t%s\n", cert.Subject)
+		fmt.Fprintf(tw, "  Issuer\t%s\n", cert.Issuer)
+		fmt.Fprintf(tw, "  Serial\t%s\n", cert.Serial)
+		fmt.Fprintf(tw, "  Validity\t%s to %s\n", cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"))
+		fmt.Fprintf(tw, "  Key\t%s\n", cert.KeyType)
+		fmt.Fprintf(tw, "  SPKI SHA-256\t%s\n", cert.SPKI)
+		fmt.Fprintf(tw, "  SHA-256\t%s\n", cert.SHA256)
+		if len(cert.DNSNames) > 0 {
+			fmt.Fprintf(tw, "  SANs\t%s\n", strings.Join(cert.DNSNames, ", "))
+		}
+		if len(cert.IPAddresses) > 0 {
+			fmt.Fprintf(tw, "  IP SANs\t%s\n", strings.Join(cert.IPAddresses, ", "))
+		}
+		if len(cert.EmailAddresses) > 0 {
+			fmt.Fprintf(tw, "  Email SANs\t%s\n", strings.Join(cert.EmailAddresses, ", "))
+		}
+		fmt.Fprintf(tw, "\t\n")
+	}
+
+	fmt.Fprintf(tw, "Pivots (-pivot-field)\t\n")
+	for _, p := range report.Pivots {
+		fmt.Fprintf(tw, "  %s\t%s\n", p.Field, p.Value)
+	}
+
+	return tw.Flush()
+}
+
+/* choosePivots: the queries to crawl for a seed certificate, going by
+ * -pivot-field. "ask" lists every candidate and lets the user pick.
+ */
+func choosePivots(opts *options, cert *x509.Certificate) []sancrawler.Query {
+	pivots := sancrawler.Pivots(cert)
+
+	var chosen []sancrawler.Pivot
+	if strings.EqualFold(opts.pivotField, "ask") {
+		chosen = askPivots(pivots)
+	} else {
+		var err error
+		fields, _ := listOrFile(opts.pivotField)
+		if chosen, err = sancrawler.SelectPivots(pivots, fields); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(chosen) == 0 {
+		log.WithFields(log.Fields{
+			"Subject": cert.Subject.String(),
+			"Fields":  opts.pivotField,
+		}).Warn("Certificate has none of the fields to pivot on")
+	}
+
+	var queries []sancrawler.Query
+	for _, p := range chosen {
+		log.WithFields(log.Fields{
+			"Field": p.Field,
+			"Value": p.Value,
+		}).Info("Using certificate field as seed")
+		queries = append(queries, p.Query)
+	}
+	return queries
+}
+
+/* askPivots: lists the pivots on stderr and reads which ones to use from
+ * stdin, as numbers separated by commas or spaces.
+ */
+func askPivots(pivots []sancrawler.Pivot) []sancrawler.Pivot {
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for i, p := range pivots {
+		fmt.Fprintf(tw, "  %d)\t%s\t%s\n", i+1, p.Field, p.Value)
+	}
+	tw.Flush()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "Pivot on which? (eg. 1,3, or all): ")
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatal("No pivots picked. Quitting.")
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.EqualFold(line, "all") {
+			return pivots
+		}
+
+		var chosen []sancrawler.Pivot
+		ok := line != ""
+		for _, f := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' }) {
+			n, err := strconv.Atoi(f)
+			if err != nil || n < 1 || n > len(pivots) {
+				ok = false
+				break
+			}
+			chosen = append(chosen, pivots[n-1])
+		}
+		if ok {
+			return chosen
+		}
+	}
+}
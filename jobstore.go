@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket       = []byte("jobs")
+	jobResultsBucket = []byte("results")
+)
+
+// jobStore keeps the server's jobs in a bbolt file (-jobs-db), so queued and
+// running crawls start again after a restart and finished ones keep their
+// results. A nil jobStore keeps nothing.
+type jobStore struct {
+	db *bolt.DB
+}
+
+/* openJobStore: opens (creating if needed) the job store at path.
+ */
+func openJobStore(path string) (*jobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(jobResultsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &jobStore{db: db}, nil
+}
+
+func (st *jobStore) close() error {
+	if st == nil {
+		return nil
+	}
+	return st.db.Close()
+}
+
+/* save: stores the job, not including its results.
+ */
+func (st *jobStore) save(j *job) error {
+	if st == nil {
+		return nil
+	}
+	value, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(j.ID), value)
+	})
+}
+
+/* saveResults: stores a finished job's results, gzipped since big
+ * organizations can have a lot of names.
+ */
+func (st *jobStore) saveResults(id string, results sancrawler.Results) error {
+	if st == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(results.Sorted()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobResultsBucket).Put([]byte(id), buf.Bytes())
+	})
+}
+
+/* results: a finished job's results, nil if there aren't any stored.
+ */
+func (st *jobStore) results(id string) (sancrawler.Results, error) {
+	if st == nil {
+		return nil, nil
+	}
+
+	var value []byte
+	err := st.db.View(func(tx *bolt.Tx) error {
+		// Whatever bbolt hands back is only valid inside the transaction
+		value = append([]byte(nil), tx.Bucket(jobResultsBucket).Get([]byte(id))...)
+		return nil
+	})
+	if err != nil || len(value) == 0 {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var results []sancrawler.Result
+	if err := json.NewDecoder(zr).Decode(&results); err != nil {
+		return nil, err
+	}
+
+	ret := make(sancrawler.Results, len(results))
+	for _, res := range results {
+		ret[res.Name] = res
+	}
+	return ret, nil
+}
+
+/* remove: forgets a job and its results.
+ */
+func (st *jobStore) remove(id string) error {
+	if st == nil {
+		return nil
+	}
+	return st.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(jobsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(jobResultsBucket).Delete([]byte(id))
+	})
+}
+
+/* load: every job in the store.
+ */
+func (st *jobStore) load() ([]*job, error) {
+	if st == nil {
+		return nil, nil
+	}
+
+	var jobs []*job
+	err := st.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			j := &job{}
+			if err := json.Unmarshal(v, j); err != nil {
+				return err
+			}
+			jobs = append(jobs, j)
+			return nil
+		})
+	})
+	return jobs, err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* limitCrawl: stops the crawl gently, the same way the first Ctrl-C does, once
+ * it has been running for -max-runtime or has found -max-results names. Queries
+ * already running get to finish, so a few more names than that can turn up.
+ * Returns found wrapped to count the names, and a func to call once the crawl
+ * is over so nothing gets stopped afterwards.
+ */
+func limitCrawl(opts *options, limiter *sancrawler.Limiter, found func(sancrawler.Result)) (func(sancrawler.Result), func()) {
+	done := func() {}
+
+	if opts.maxRuntime > 0 {
+		timer := time.AfterFunc(opts.maxRuntime, func() {
+			log.WithFields(log.Fields{
+				"Limit": opts.maxRuntime,
+			}).Warn("Reached -max-runtime, stopping the crawl")
+			limiter.Stop()
+		})
+		done = func() { timer.Stop() }
+	}
+
+	if opts.maxResults <= 0 {
+		return found, done
+	}
+
+	// Names can come up again in later rounds of -recursive, so they only count
+	// the first time. -low-memory already makes sure of that, and keeping them
+	// in a map is what it's there to avoid.
+	var (
+		mu    sync.Mutex
+		seen  = make(map[string]bool)
+		count int
+	)
+
+	return func(res sancrawler.Result) {
+		mu.Lock()
+		if opts.lowMemory || !seen[res.Name] {
+			if !opts.lowMemory {
+				seen[res.Name] = true
+			}
+			count++
+			if count == opts.maxResults {
+				log.WithFields(log.Fields{
+					"Limit": opts.maxResults,
+				}).Warn("Reached -max-results, stopping the crawl")
+				limiter.Stop()
+			}
+		}
+		mu.Unlock()
+
+		if found != nil {
+			found(res)
+		}
+	}, done
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Adds the run's ID to every entry, so runs appending to the same -log-file (or
+// shipped to the same place) can be told apart.
+type runHook struct {
+	id string
+}
+
+func (h runHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h runHook) Fire(entry *log.Entry) error {
+	if _, ok := entry.Data["run"]; !ok {
+		entry.Data["run"] = h.id
+	}
+	return nil
+}
+
+// With -log-file, errors still go to stderr as well so a run that dies doesn't
+// do so silently.
+type stderrHook struct {
+	formatter log.Formatter
+}
+
+func (h stderrHook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel}
+}
+
+func (h stderrHook) Fire(entry *log.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stderr.Write(line)
+	return err
+}
+
+/* setupLogging: sets the log level, format and destination from -v, -q,
+ * -silent, -log-format and -log-file. Logs only ever go to stderr or the log file, never
+ * stdout, so they can't end up mixed in with the results.
+ */
+func setupLogging(opts *options) {
+	switch {
+	case opts.verbose && (opts.quiet || opts.silent):
+		log.Fatal("-v can't be used with -q or -silent")
+	case opts.verbose || opts.debugMode:
+		log.SetLevel(log.DebugLevel)
+	case opts.silent:
+		log.SetLevel(log.ErrorLevel)
+	case opts.quiet:
+		log.SetLevel(log.WarnLevel)
+	}
+
+	switch opts.logFormat {
+	case "", "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	default:
+		log.Fatal("Unknown log format: ", opts.logFormat)
+	}
+
+	if opts.logFormat == "json" || opts.logFile != "" {
+		id := make([]byte, 4)
+		rand.Read(id)
+		log.AddHook(runHook{id: hex.EncodeToString(id)})
+	}
+
+	if opts.logFile != "" {
+		f, err := os.OpenFile(opts.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal("Could not open log file: ", err)
+		}
+
+		// The file gets plain text without colours, stderr whatever it had
+		stderrFormatter := log.StandardLogger().Formatter
+		if opts.logFormat != "json" {
+			log.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
+		}
+		log.AddHook(stderrHook{formatter: stderrFormatter})
+		log.SetOutput(f)
+	}
+}
+
+/* showBanner: whether there's a person watching stderr who'd want the ASCII
+ * art, rather than something parsing it.
+ */
+func showBanner(opts *options) bool {
+	return opts.logFormat != "json" && opts.logFile == "" && !opts.quiet && !opts.silent && !opts.noBanner
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// The bits of Maltego's transform protocol we need. Maltego POSTs the input
+// entity and gets back a list of entities to add to the graph.
+type maltegoRequest struct {
+	XMLName  xml.Name        `xml:"MaltegoMessage"`
+	Entities []maltegoEntity `xml:"MaltegoTransformRequestMessage>Entities>Entity"`
+	Limits   struct {
+		SoftLimit int `xml:"SoftLimit,attr"`
+	} `xml:"MaltegoTransformRequestMessage>Limits"`
+}
+
+type maltegoEntity struct {
+	Type   string `xml:"Type,attr"`
+	Value  string `xml:"Value"`
+	Weight int    `xml:"Weight,omitempty"`
+}
+
+type maltegoMessage struct {
+	Text string `xml:",chardata"`
+	Type string `xml:"MessageType,attr"`
+}
+
+type maltegoResponse struct {
+	XMLName  xml.Name         `xml:"MaltegoMessage"`
+	Entities []maltegoEntity  `xml:"MaltegoTransformResponseMessage>Entities>Entity"`
+	Messages []maltegoMessage `xml:"MaltegoTransformResponseMessage>UIMessages>UIMessage"`
+}
+
+// A transform turns the value of the input entity into entities to return
+type maltegoTransform func(ctx context.Context, crawler *sancrawler.Crawler, value string) ([]maltegoEntity, error)
+
+// Each transform gets a path of its own, which is what gets entered in
+// Maltego's local transform setup.
+var maltegoTransforms = map[string]maltegoTransform{
+	"/org-to-domains": maltegoOrgToDomains,
+	"/domain-to-orgs": maltegoDomainToOrgs,
+	"/cert-to-sans":   maltegoCertToSANs,
+}
+
+/* serveMaltego: the serve-maltego subcommand. Runs a transform server on
+ * opts.listen until ctx is cancelled.
+ */
+func serveMaltego(ctx context.Context, crawler *sancrawler.Crawler, opts *options) {
+	mux := http.NewServeMux()
+	for path, transform := range maltegoTransforms {
+		mux.Handle(path, maltegoHandler(crawler, transform))
+	}
+
+	handleHealth(mux, func() error {
+		if ctx.Err() != nil {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+
+	server := &http.Server{Addr: opts.listen, Handler: mux}
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnDone(ctx, server)
+		close(stopped)
+	}()
+
+	log.WithFields(log.Fields{
+		"Listen": opts.listen,
+	}).Info("Serving Maltego transforms")
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal("Maltego transform server failed: ", err)
+	}
+	<-stopped
+}
+
+/* maltegoHandler: unwraps the request, runs the transform on every input
+ * entity and wraps up whatever it found. Errors go back to Maltego as a UI
+ * message rather than an HTTP error, so the analyst actually sees them.
+ */
+func maltegoHandler(crawler *sancrawler.Crawler, transform maltegoTransform) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req maltegoRequest
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad transform request", http.StatusBadRequest)
+			return
+		}
+
+		var res maltegoResponse
+		for _, entity := range req.Entities {
+			log.WithFields(log.Fields{
+				"Transform": r.URL.Path,
+				"Value":     entity.Value,
+			}).Info("Running Maltego transform")
+
+			found, err := transform(r.Context(), crawler, strings.TrimSpace(entity.Value))
+			if err != nil {
+				res.Messages = append(res.Messages, maltegoMessage{Type: "PartialError", Text: err.Error()})
+			}
+			res.Entities = append(res.Entities, found...)
+		}
+
+		if limit := req.Limits.SoftLimit; limit > 0 && len(res.Entities) > limit {
+			res.Entities = res.Entities[:limit]
+			res.Messages = append(res.Messages, maltegoMessage{Type: "Inform", Text: "Results truncated to " + strconv.Itoa(limit) + " entities"})
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(res)
+	})
+}
+
+/* maltegoOrgToDomains: every DNS name on the organization's certificates.
+ */
+func maltegoOrgToDomains(ctx context.Context, crawler *sancrawler.Crawler, org string) ([]maltegoEntity, error) {
+	results, err := crawler.ByOrganization(ctx, org)
+
+	var entities []maltegoEntity
+	for _, res := range results.Sorted() {
+		if res.Type == "" {
+			entities = append(entities, maltegoEntity{Type: "maltego.DNSName", Value: res.Name})
+		}
+	}
+	return entities, err
+}
+
+/* maltegoDomainToOrgs: the organizations on the domain's certificates, weighted
+ * by how many certificates they're on.
+ */
+func maltegoDomainToOrgs(ctx context.Context, crawler *sancrawler.Crawler, domain string) ([]maltegoEntity, error) {
+	attrs, err := crawler.DomainSubjects(ctx, domain)
+
+	var entities []maltegoEntity
+	for _, attr := range attrs {
+		if attr.Field == "O" {
+			entities = append(entities, maltegoEntity{Type: "maltego.Organization", Value: attr.Value, Weight: attr.Certificates})
+		}
+	}
+	return entities, err
+}
+
+/* maltegoCertToSANs: the names on the certificate with the given SHA-256
+ * fingerprint.
+ */
+func maltegoCertToSANs(ctx context.Context, crawler *sancrawler.Crawler, fingerprint string) ([]maltegoEntity, error) {
+	certs, err := crawler.LookupCertificates(ctx, sancrawler.LookupFingerprint, fingerprint)
+
+	var entities []maltegoEntity
+	for _, cert := range certs {
+		for _, name := range cert.DNSNames {
+			entities = append(entities, maltegoEntity{Type: "maltego.DNSName", Value: strings.ToLower(name)})
+		}
+	}
+	return entities, err
+}
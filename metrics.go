@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+/* useMetrics: has the crawler (and its database backend, if it has one) count
+ * what it gets up to, for GET /metrics.
+ */
+func useMetrics(crawler *sancrawler.Crawler) *sancrawler.Metrics {
+	metrics := sancrawler.NewMetrics()
+	crawler.Metrics = metrics
+	for _, backend := range []sancrawler.Backend{crawler.Backend, crawler.Fallback} {
+		if db, ok := backend.(*sancrawler.DBBackend); ok {
+			db.Metrics = metrics
+		}
+	}
+	return metrics
+}
+
+/* handleMetrics: GET /metrics hands over the crawler's metrics and the
+ * server's own (jobs, workers, queries in flight) for Prometheus to scrape.
+ */
+func (s *jobServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	s.metrics.WritePrometheus(out)
+
+	s.mu.Lock()
+	statuses := make(map[string]int)
+	for _, j := range s.jobs {
+		statuses[j.Status]++
+	}
+	queued := len(s.pending)
+	s.mu.Unlock()
+
+	fmt.Fprintln(out, "# HELP sancrawler_jobs Jobs the server knows about, by status.")
+	fmt.Fprintln(out, "# TYPE sancrawler_jobs gauge")
+	for _, status := range []string{jobQueued, jobRunning, jobDone, jobFailed, jobCancelled} {
+		fmt.Fprintf(out, "sancrawler_jobs{status=%q} %d\n", status, statuses[status])
+	}
+
+	// gRPC crawls hold a slot too, so this covers both APIs
+	fmt.Fprintln(out, "# HELP sancrawler_workers_busy Crawls running right now, REST and gRPC.")
+	fmt.Fprintln(out, "# TYPE sancrawler_workers_busy gauge")
+	fmt.Fprintf(out, "sancrawler_workers_busy %d\n", len(s.slots))
+	fmt.Fprintln(out, "# HELP sancrawler_workers How many crawls can run at once (-workers).")
+	fmt.Fprintln(out, "# TYPE sancrawler_workers gauge")
+	fmt.Fprintf(out, "sancrawler_workers %d\n", cap(s.slots))
+	fmt.Fprintln(out, "# HELP sancrawler_queue_length Jobs waiting for a worker.")
+	fmt.Fprintln(out, "# TYPE sancrawler_queue_length gauge")
+	fmt.Fprintf(out, "sancrawler_queue_length %d\n", queued)
+
+	if limiter := backendLimiter(s.crawler.Backend); limiter != nil {
+		running, max := limiter.Conns()
+		fmt.Fprintln(out, "# HELP sancrawler_queries_in_flight Queries to crt.sh running right now.")
+		fmt.Fprintln(out, "# TYPE sancrawler_queries_in_flight gauge")
+		fmt.Fprintf(out, "sancrawler_queries_in_flight %d\n", running)
+		fmt.Fprintln(out, "# HELP sancrawler_queries_max Most queries to crt.sh allowed at once, 0 for no limit.")
+		fmt.Fprintln(out, "# TYPE sancrawler_queries_max gauge")
+		fmt.Fprintf(out, "sancrawler_queries_max %d\n", max)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+// How many names we spell out in a Slack message before summarising the rest
+const slackMaxNames = 50
+
+// The payload posted to plain webhooks
+type notification struct {
+	Time  time.Time           `json:"time"`
+	Seeds []string            `json:"seeds"`
+	New   []sancrawler.Result `json:"new"`
+}
+
+// Slack incoming webhooks only care about the text field
+type slackNotification struct {
+	Text string `json:"text"`
+}
+
+/* notify: POSTs the newly found names to the -notify-url webhook, either as our
+ * own JSON document or as a message Slack (and anything Slack compatible) will
+ * display.
+ */
+func notify(opts *options, queries []sancrawler.Query, found []sancrawler.Result) error {
+	seeds := make([]string, 0, len(queries))
+	for _, query := range queries {
+		seeds = append(seeds, query.Value)
+	}
+
+	var payload interface{}
+
+	switch opts.notifyFormat {
+	case "slack":
+		payload = slackNotification{Text: slackText(seeds, found)}
+	default:
+		payload = notification{Time: time.Now().UTC(), Seeds: seeds, New: found}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(opts.notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+/* slackText: formats the new names as a short Slack message. Big batches get cut
+ * off, nobody is reading a thousand names in a channel anyway.
+ */
+func slackText(seeds []string, found []sancrawler.Result) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "SANCrawler found %d new name(s) for %s\n", len(found), strings.Join(seeds, ", "))
+
+	for i, result := range found {
+		if i == slackMaxNames {
+			fmt.Fprintf(&b, "... and %d more\n", len(found)-slackMaxNames)
+			break
+		}
+		fmt.Fprintf(&b, "• `%s`\n", result.Name)
+	}
+	return b.String()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* writeJSON: writes the results as a JSON array, sorted by name so that the output
+ * is stable between runs. Makes life easier for jq and friends.
+ */
+func writeJSON(w *bufio.Writer, subdomains sancrawler.Results) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(subdomains.Sorted())
+}
+
+/* writeCSV: writes one row per name with the metadata of the certificate it was
+ * first seen on. Names found by more than one seed have them separated by ;
+ */
+func writeCSV(w *bufio.Writer, subdomains sancrawler.Results) error {
+	out := csv.NewWriter(w)
+	out.Write(csvHeader)
+
+	for _, res := range subdomains.Sorted() {
+		out.Write(csvRow(res))
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+var csvHeader = []string{"name", "name_type", "type", "certificate_id", "issuer_ca", "issuer_name", "not_before", "not_after", "expired", "precert", "seed", "key_type", "subject_key_id", "authority_key_id", "punycode", "homoglyph_of", "score", "findings"}
+
+func csvRow(res sancrawler.Result) []string {
+	nameType := res.Type
+	if nameType == "" {
+		nameType = "dns"
+	}
+
+	score := ""
+	if res.Score != nil {
+		score = strconv.Itoa(res.Score.Value)
+	}
+
+	return []string{
+		res.Name,
+		nameType,
+		res.Field,
+		strconv.Itoa(res.CertificateID),
+		strconv.Itoa(res.IssuerCAID),
+		res.IssuerName,
+		csvTime(res.NotBefore),
+		csvTime(res.NotAfter),
+		strconv.FormatBool(res.Expired),
+		strconv.FormatBool(res.Precert),
+		strings.Join(res.Seeds, ";"),
+		res.KeyType,
+		res.SubjectKeyID,
+		res.AuthorityKeyID,
+		res.Punycode,
+		res.Homoglyph,
+		score,
+		strings.Join(res.Findings, ";"),
+	}
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+/* writeNames: writes one name per line, only writing those that keep returns
+ * true for (or all of them if keep is nil).
+ */
+func writeNames(w *bufio.Writer, subdomains sancrawler.Results, keep func(sancrawler.Result) bool) {
+	for k, res := range subdomains {
+		if keep == nil || keep(res) {
+			w.WriteString(k)
+			w.WriteString("\n")
+		}
+	}
+}
+
+/* writeTypedFiles: writes every result that isn't a DNS name into a file per
+ * type named after the output file.
+ */
+func writeTypedFiles(outfile string, subdomains sancrawler.Results) error {
+	types := make(map[string]bool)
+	for _, res := range subdomains {
+		if res.Type != "" {
+			types[res.Type] = true
+		}
+	}
+
+	for nameType := range types {
+		err := writeNamesFile(outfile+"."+nameType, subdomains, func(r sancrawler.Result) bool { return r.Type == nameType })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* writeNamesFile: writeNames but straight into a new file.
+ */
+func writeNamesFile(path string, subdomains sancrawler.Results, keep func(sancrawler.Result) bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	writeNames(w, subdomains, keep)
+	return w.Flush()
+}
+
+/* writeDiffJSON: writes every change as a single JSON object.
+ */
+func writeDiffJSON(w *bufio.Writer, diff sancrawler.Diff) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diff)
+}
+
+/* writeDiffCSV: same columns as writeCSV, with the kind of change in front.
+ */
+func writeDiffCSV(w *bufio.Writer, diff sancrawler.Diff) error {
+	out := csv.NewWriter(w)
+	out.Write(append([]string{"change"}, csvHeader...))
+
+	for _, change := range []struct {
+		kind    string
+		results []sancrawler.Result
+	}{{"new", diff.New}, {"removed", diff.Removed}, {"reissued", diff.Reissued}, {"expired", diff.Expired}} {
+		for _, res := range change.results {
+			out.Write(append([]string{change.kind}, csvRow(res)...))
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+/* openOutput: the -o file, or stdout if there isn't one. Appending is used by
+ * -watch so each round adds to the file rather than replacing it.
+ */
+func openOutput(path string, appendTo bool) (*os.File, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+
+	if appendTo {
+		return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	return os.Create(path)
+}
+
+/* writeOutput: writes the results, or just the diff if there is one, in the
+ * format asked for.
+ */
+func writeOutput(opts *options, subdomains sancrawler.Results, diff *sancrawler.Diff) error {
+	if opts.outfile != "" {
+		log.WithFields(log.Fields{
+			"Outfile": opts.outfile,
+		}).Info("Writing results to output file")
+	}
+
+	fHandle, err := openOutput(opts.outfile, false)
+	if err != nil {
+		return err
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	bufWriter := bufio.NewWriter(fHandle)
+
+	if diff != nil {
+		// Plain text only gets the new names, the other formats say what
+		// kind of change each entry is.
+		switch opts.format {
+		case "json":
+			err = writeDiffJSON(bufWriter, *diff)
+		case "csv":
+			err = writeDiffCSV(bufWriter, *diff)
+		case "graphml":
+			err = writeGraphML(bufWriter, diff.New)
+		case "dot":
+			err = writeDOT(bufWriter, diff.New)
+		case "xlsx":
+			err = writeXLSX(bufWriter, subdomains, diff)
+		case "stix":
+			err = writeSTIX(bufWriter, diff.New)
+		case "misp":
+			err = writeMISP(bufWriter, diff.New)
+		case "nmap-targets":
+			err = writeTargets(bufWriter, opts.outfile, diff.New)
+		case "burp":
+			err = writeBurpScope(bufWriter, opts, diff.New)
+		case "zap":
+			err = writeZAPContext(bufWriter, opts, diff.New)
+		case "subfinder", "amass":
+			err = writeCompat(bufWriter, opts.format, diff.New)
+		default:
+			for _, res := range diff.New {
+				bufWriter.WriteString(res.Name + "\n")
+			}
+		}
+	} else if opts.format == "json" {
+		err = writeJSON(bufWriter, subdomains)
+	} else if opts.format == "csv" {
+		err = writeCSV(bufWriter, subdomains)
+	} else if opts.format == "graphml" {
+		err = writeGraphML(bufWriter, subdomains.Sorted())
+	} else if opts.format == "dot" {
+		err = writeDOT(bufWriter, subdomains.Sorted())
+	} else if opts.format == "xlsx" {
+		err = writeXLSX(bufWriter, subdomains, nil)
+	} else if opts.format == "stix" {
+		err = writeSTIX(bufWriter, subdomains.Sorted())
+	} else if opts.format == "misp" {
+		err = writeMISP(bufWriter, subdomains.Sorted())
+	} else if opts.format == "nmap-targets" {
+		err = writeTargets(bufWriter, opts.outfile, subdomains.Sorted())
+	} else if opts.format == "burp" {
+		err = writeBurpScope(bufWriter, opts, subdomains.Sorted())
+	} else if opts.format == "zap" {
+		err = writeZAPContext(bufWriter, opts, subdomains.Sorted())
+	} else if opts.format == "subfinder" || opts.format == "amass" {
+		err = writeCompat(bufWriter, opts.format, subdomains.Sorted())
+	} else if opts.resolve && opts.outfile != "" {
+		// Only the live hosts go in the output file, everything else gets put
+		// next to it so it isn't lost.
+		writeNames(bufWriter, subdomains, func(r sancrawler.Result) bool { return r.Type == "" && r.DNS != nil && r.DNS.Live })
+
+		err = writeNamesFile(opts.outfile+".unresolved", subdomains, func(r sancrawler.Result) bool { return r.Type == "" && (r.DNS == nil || !r.DNS.Live) })
+	} else {
+		writeNames(bufWriter, subdomains, func(r sancrawler.Result) bool { return r.Type == "" })
+	}
+
+	// Anything that isn't a DNS name gets a file of its own next to the output
+	// file, eg. IP addresses go in <outfile>.ip
+
+	if err == nil && diff == nil && opts.format == "text" && opts.outfile != "" {
+		err = writeTypedFiles(opts.outfile, subdomains)
+	}
+
+	if err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+/* writeNew: appends newly found names to the output, one per line for text and
+ * one JSON object per line for json, so a long running -watch keeps a single
+ * growing file that is easy to tail.
+ */
+func writeNew(opts *options, found []sancrawler.Result) error {
+	fHandle, err := openOutput(opts.outfile, true)
+	if err != nil {
+		return err
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	bufWriter := bufio.NewWriter(fHandle)
+
+	switch opts.format {
+	case "json":
+		enc := json.NewEncoder(bufWriter)
+		for _, res := range found {
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+		}
+	case "csv":
+		out := csv.NewWriter(bufWriter)
+		for _, res := range found {
+			out.Write(csvRow(res))
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return err
+		}
+	default:
+		for _, res := range found {
+			bufWriter.WriteString(res.Name + "\n")
+		}
+	}
+
+	return bufWriter.Flush()
+}
@@ -0,0 +1,151 @@
+/* Package cache keeps crt.sh query results on disk between runs, so crawling
+ * the same organization again during an engagement doesn't mean pulling
+ * hundreds of thousands of rows out of crt.sh again. It lives outside of
+ * pkg/sancrawler so the library doesn't drag in bbolt for everyone.
+ */
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("results")
+
+// Each value is when it was stored, as big endian Unix seconds, followed by
+// the results as gzipped JSON. The timestamp comes first so stale entries can
+// be spotted without unpacking them.
+const stampLen = 8
+
+// Cache is a bbolt database of query results, implementing sancrawler.Cache.
+// Only one process can have it open at a time.
+type Cache struct {
+	db *bolt.DB
+	// TTL is how long results stay fresh, zero keeps them forever.
+	TTL time.Duration
+}
+
+/* Open: opens (creating if needed) the cache at path. Waits up to a few seconds
+ * if another run has it open.
+ */
+func Open(path string, ttl time.Duration) (*Cache, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Cache{db: db, TTL: ttl}, nil
+}
+
+/* Close: closes the database.
+ */
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+/* stale: whether a stored value has gone stale, or isn't one we can read.
+ */
+func (c *Cache) stale(value []byte) bool {
+	if len(value) < stampLen {
+		return true
+	}
+	stored := time.Unix(int64(binary.BigEndian.Uint64(value)), 0)
+	return c.TTL > 0 && time.Since(stored) > c.TTL
+}
+
+/* Get: the results stored under key, unless they're missing or stale.
+ */
+func (c *Cache) Get(key string) (sancrawler.Results, bool, error) {
+	var value []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		// Whatever bbolt hands back is only valid inside the transaction
+		value = append([]byte(nil), tx.Bucket(bucket).Get([]byte(key))...)
+		return nil
+	})
+	if err != nil || len(value) == 0 || c.stale(value) {
+		return nil, false, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(value[stampLen:]))
+	if err != nil {
+		return nil, false, err
+	}
+	defer zr.Close()
+
+	var results []sancrawler.Result
+	if err := json.NewDecoder(zr).Decode(&results); err != nil {
+		return nil, false, err
+	}
+
+	ret := make(sancrawler.Results, len(results))
+	for _, res := range results {
+		ret[res.Name] = res
+	}
+	return ret, true, nil
+}
+
+/* Put: stores results under key, replacing whatever was there. They're stored
+ * gzipped since big organizations can have a lot of names.
+ */
+func (c *Cache) Put(key string, results sancrawler.Results) error {
+	var buf bytes.Buffer
+
+	var stamp [stampLen]byte
+	binary.BigEndian.PutUint64(stamp[:], uint64(time.Now().Unix()))
+	buf.Write(stamp[:])
+
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(results.Sorted()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), buf.Bytes())
+	})
+}
+
+/* Purge: throws away every stale entry, returning how many there were.
+ */
+func (c *Cache) Purge() (int, error) {
+	purged := 0
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		var stale [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if c.stale(v) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		purged = len(stale)
+		return nil
+	})
+
+	return purged, err
+}
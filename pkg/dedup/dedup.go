@@ -0,0 +1,213 @@
+/* Package dedup keeps track of which names a crawl has already seen without
+ * keeping them all in memory, for organizations with millions of them. It
+ * lives outside of pkg/sancrawler so the library doesn't drag in bbolt for
+ * everyone.
+ */
+package dedup
+
+import (
+	"hash/fnv"
+	"math"
+	"os"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("names")
+
+// Set is a set of names, implementing sancrawler.NameSet. A bloom filter sits
+// in front: most names are new, and the filter can say so for certain without
+// touching anything else. Only the names it isn't sure about get looked up in
+// the set proper, which is kept in memory until it has maxMemory names and then
+// spills into a bbolt file on disk. The filter is sized for the number of names
+// expected, going over just means more of them get looked up.
+type Set struct {
+	mu sync.Mutex
+
+	bits   []uint64
+	hashes uint64
+
+	memory    map[string]struct{}
+	maxMemory int
+
+	dir  string
+	path string
+	db   *bolt.DB
+
+	count int
+}
+
+// How often the bloom filter can think it's seen a name it hasn't, when there
+// aren't more names than expected
+const falsePositives = 0.01
+
+/* New: an empty set for around expected names, keeping up to maxMemory of them
+ * in memory and the rest in a file in dir (the system's temporary directory if
+ * it's empty).
+ */
+func New(expected int, maxMemory int, dir string) *Set {
+	if expected < 1000 {
+		expected = 1000
+	}
+	if maxMemory < 1 {
+		maxMemory = 1
+	}
+
+	// The usual sums for the best size and number of hashes for a bloom filter
+	m := math.Ceil(-float64(expected) * math.Log(falsePositives) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(expected) * math.Ln2)
+
+	return &Set{
+		bits:      make([]uint64, (uint64(m)+63)/64),
+		hashes:    uint64(k),
+		memory:    make(map[string]struct{}),
+		maxMemory: maxMemory,
+		dir:       dir,
+	}
+}
+
+/* Add: adds name, reporting whether it's new. Safe to call from more than one
+ * goroutine.
+ */
+func (s *Set) Add(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maybeSeen(name) {
+		seen, err := s.contains(name)
+		if seen || err != nil {
+			return false, err
+		}
+	}
+
+	s.remember(name)
+	s.memory[name] = struct{}{}
+	s.count++
+
+	if len(s.memory) >= s.maxMemory {
+		return true, s.spill()
+	}
+	return true, nil
+}
+
+/* Len: how many names are in the set.
+ */
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+/* Close: throws the set away, file and all.
+ */
+func (s *Set) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory = nil
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	if rmErr := os.Remove(s.path); err == nil {
+		err = rmErr
+	}
+	s.db = nil
+	return err
+}
+
+/* positions: where name's bits are in the filter. Two hashes are enough to
+ * make as many as we need (Kirsch and Mitzenmacher).
+ */
+func (s *Set) positions(name string) (uint64, uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31
+	h2 = h2*0x9e3779b97f4a7c15 | 1
+	return h1, h2, uint64(len(s.bits)) * 64
+}
+
+func (s *Set) maybeSeen(name string) bool {
+	h1, h2, m := s.positions(name)
+	for i := uint64(0); i < s.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		if s.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Set) remember(name string) {
+	h1, h2, m := s.positions(name)
+	for i := uint64(0); i < s.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		s.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+/* contains: whether name really is in the set, in memory or on disk.
+ */
+func (s *Set) contains(name string) (bool, error) {
+	if _, ok := s.memory[name]; ok {
+		return true, nil
+	}
+	if s.db == nil {
+		return false, nil
+	}
+
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(bucket).Get([]byte(name)) != nil
+		return nil
+	})
+	return found, err
+}
+
+/* spill: moves the names in memory to disk, creating the file the first time.
+ * Nothing about the set needs to survive a crash, so it's never synced.
+ */
+func (s *Set) spill() error {
+	if s.db == nil {
+		f, err := os.CreateTemp(s.dir, "sancrawler-dedup-*.db")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		s.path = f.Name()
+
+		db, err := bolt.Open(s.path, 0600, &bolt.Options{NoSync: true, NoFreelistSync: true})
+		if err != nil {
+			os.Remove(s.path)
+			return err
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			return err
+		})
+		if err != nil {
+			db.Close()
+			os.Remove(s.path)
+			return err
+		}
+		s.db = db
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for name := range s.memory {
+			if err := b.Put([]byte(name), []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.memory = make(map[string]struct{})
+	return nil
+}
@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  int
+		maxMemory int
+		names     int
+	}{
+		{"in memory", 1000, 1000, 500},
+		{"spills once", 1000, 300, 500},
+		{"spills over and over", 1000, 7, 500},
+		{"more than expected", 1000, 50, 20000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := New(tt.expected, tt.maxMemory, dir)
+			defer s.Close()
+
+			// Every name is new the first time, whichever side of a spill it's on
+			for i := 0; i < tt.names; i++ {
+				added, err := s.Add(fmt.Sprintf("host%d.example.com", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !added {
+					t.Fatalf("host%d.example.com wasn't new", i)
+				}
+			}
+
+			// And never again, whether it's now in memory or on disk
+			for i := 0; i < tt.names; i++ {
+				added, err := s.Add(fmt.Sprintf("host%d.example.com", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if added {
+					t.Fatalf("host%d.example.com was new twice", i)
+				}
+			}
+
+			if s.Len() != tt.names {
+				t.Errorf("Len() = %d, want %d", s.Len(), tt.names)
+			}
+
+			spilled := tt.names >= tt.maxMemory
+			if files, _ := filepath.Glob(filepath.Join(dir, "*")); (len(files) > 0) != spilled {
+				t.Errorf("%d files in %s, spilled should be %v", len(files), dir, spilled)
+			}
+		})
+	}
+}
+
+func TestFalsePositives(t *testing.T) {
+	s := New(10000, 100000, t.TempDir())
+	defer s.Close()
+
+	for i := 0; i < 10000; i++ {
+		s.Add(fmt.Sprintf("seen%d.example.com", i))
+	}
+
+	// The filter should be about as wrong as it was sized to be about names it
+	// hasn't seen
+	maybe := 0
+	for i := 0; i < 10000; i++ {
+		if s.maybeSeen(fmt.Sprintf("unseen%d.example.com", i)) {
+			maybe++
+		}
+	}
+	if rate := float64(maybe) / 10000; rate > 2*falsePositives {
+		t.Errorf("false positive rate %.3f, want around %.2f", rate, falsePositives)
+	}
+
+	// Add still has to get them right
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("unseen%d.example.com", i)
+		if added, _ := s.Add(name); !added {
+			t.Fatalf("%s wasn't new", name)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir := t.TempDir()
+	s := New(1000, 1, dir)
+	s.Add("a.example.com")
+	s.Add("b.example.com")
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Fatalf("%d files in %s, want 1", len(files), dir)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("%s still there after Close", files[0])
+	}
+
+	// Closing twice is fine
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,276 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the HTTPS front end of crt.sh.
+const DefaultAPIURL = "https://crt.sh/"
+
+// crt.sh exposes the subject attributes as their own search parameters, this
+// maps the certificate_identity name types onto them.
+var apiParams = map[string]string{
+	"":                       "q",
+	"commonName":             "CN",
+	"organizationName":       "O",
+	"organizationalUnitName": "OU",
+	"localityName":           "L",
+	"stateOrProvinceName":    "ST",
+	"countryName":            "C",
+	"emailAddress":           "E",
+}
+
+// APIBackend crawls crt.sh through its JSON output instead of postgres. It is a
+// lot slower and only sees the common name and the identities that matched the
+// query for each certificate, but it works when the guest database is overloaded
+// and only needs outbound HTTPS.
+type APIBackend struct {
+	// BaseURL is where crt.sh lives, mostly useful for pointing at a mirror.
+	BaseURL string
+	// Client is used for all requests, crt.sh can take a long time to answer
+	// large queries so the default timeout is generous.
+	Client *http.Client
+	// Limiter, if set, throttles the requests we send to crt.sh.
+	Limiter *Limiter
+	// KeepPrecerts turns off crt.sh's own deduplication of precertificates and
+	// their final certificates.
+	KeepPrecerts bool
+}
+
+// One certificate as returned by crt.sh's JSON output. name_value holds every
+// matching identity separated by newlines.
+type apiEntry struct {
+	ID         int    `json:"id"`
+	IssuerCAID int    `json:"issuer_ca_id"`
+	IssuerName string `json:"issuer_name"`
+	CommonName string `json:"common_name"`
+	NameValue  string `json:"name_value"`
+	NotBefore  string `json:"not_before"`
+	NotAfter   string `json:"not_after"`
+}
+
+/* apiTime: crt.sh hands back timestamps without a zone, they're UTC. Anything
+ * unparseable is left as the zero time.
+ */
+func apiTime(value string) time.Time {
+	t, _ := time.Parse("2006-01-02T15:04:05", value)
+	return t
+}
+
+/* NewAPIBackend: returns an APIBackend pointed at crt.sh.
+ */
+func NewAPIBackend() *APIBackend {
+	return &APIBackend{
+		BaseURL: DefaultAPIURL,
+		Client:  &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+/* Crawl: Get all the names on certificates selected by the query using a single
+ * request to the crt.sh JSON output.
+ */
+func (b *APIBackend) Crawl(ctx context.Context, q Query) (Results, error) {
+	param, ok := apiParams[q.NameType]
+	if !ok {
+		return nil, errors.New("name type not supported by the crt.sh API: " + q.NameType)
+	}
+
+	value, keep, err := apiSearch(q)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{param: {value}, "output": {"json"}}
+	if q.Filter.Expiry == ExpiryExclude {
+		values.Set("exclude", "expired")
+	}
+	if !b.KeepPrecerts {
+		values.Set("deduplicate", "Y")
+	}
+
+	body, err := b.get(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []apiEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, err
+	}
+
+	ret := make(Results)
+	seed := strings.ToLower(q.Value)
+
+	for _, entry := range entries {
+		if keep != nil && !keep(entry) {
+			continue
+		}
+
+		notAfter := apiTime(entry.NotAfter)
+
+		if entry.CommonName != "" {
+			ret.add(Result{
+				Name:          strings.ToLower(entry.CommonName),
+				CertificateID: entry.ID,
+				IssuerCAID:    entry.IssuerCAID,
+				IssuerName:    entry.IssuerName,
+				Field:         "CN",
+				Source:        "crt.sh",
+				NotBefore:     apiTime(entry.NotBefore),
+				NotAfter:      notAfter,
+				Expired:       expired(notAfter),
+			})
+		}
+
+		// The seed itself shows up as a matching identity, which is only interesting
+		// when it is actually a name (ie. a keyword search on a domain).
+		for _, name := range strings.Split(entry.NameValue, "\n") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" || (name == seed && (q.NameType != "" || strings.ContainsAny(name, " \t"))) {
+				continue
+			}
+
+			ret.add(Result{
+				Name:          name,
+				CertificateID: entry.ID,
+				IssuerCAID:    entry.IssuerCAID,
+				IssuerName:    entry.IssuerName,
+				Field:         "SAN",
+				Source:        "crt.sh",
+				NotBefore:     apiTime(entry.NotBefore),
+				NotAfter:      notAfter,
+				Expired:       expired(notAfter),
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+/* apiSearch: what to search crt.sh for, and which of the certificates that come
+ * back actually match (nil keeps them all). crt.sh can only do exact and LIKE
+ * matches, anything else gets narrowed down to a LIKE search on the longest bit
+ * of plain text and checked here.
+ */
+func apiSearch(q Query) (string, func(apiEntry) bool, error) {
+	switch q.Match {
+	case MatchExact, MatchLike:
+		return q.Value, nil, nil
+	case MatchRegex:
+		lit, err := regexLiteral(q.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		re, err := q.compile()
+		if err != nil {
+			return "", nil, err
+		}
+		return "%" + lit + "%", func(entry apiEntry) bool {
+			return re.MatchString(entry.NameValue) || re.MatchString(entry.CommonName)
+		}, nil
+	case MatchFuzzy:
+		word := longestWord(q.Value)
+		if word == "" {
+			return "", nil, errors.New("nothing to fuzzy match on: " + q.Value)
+		}
+		return "%" + word + "%", func(entry apiEntry) bool {
+			for _, identity := range append(strings.Split(entry.NameValue, "\n"), entry.CommonName) {
+				if Similarity(identity, q.Value) >= FuzzyThreshold {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	return "", nil, errors.New("unknown match: " + q.Match)
+}
+
+/* get: GETs a crt.sh URL with the given parameters and hands back the body.
+ */
+func (b *APIBackend) get(ctx context.Context, values url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", b.BaseURL+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "sancrawler")
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("crt.sh API returned %s", res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+/* LookupCertificates: finds certificates by fingerprint, serial number or public
+ * key. The fingerprint can be downloaded directly, the others have to be
+ * searched for first and then each match downloaded by ID.
+ */
+func (b *APIBackend) LookupCertificates(ctx context.Context, kind string, value string) ([]*x509.Certificate, error) {
+	var ids []string
+
+	switch kind {
+	case LookupFingerprint:
+		ids = []string{value}
+	case LookupSerial, LookupSPKI:
+		param := "serial"
+		if kind == LookupSPKI {
+			param = "spkisha256"
+		}
+
+		body, err := b.get(ctx, url.Values{param: {value}, "output": {"json"}})
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []apiEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			ids = append(ids, strconv.Itoa(entry.ID))
+		}
+	default:
+		return nil, errors.New("unknown lookup kind: " + kind)
+	}
+
+	var ders [][]byte
+	for _, id := range ids {
+		body, err := b.get(ctx, url.Values{"d": {id}})
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(body)
+		if block == nil {
+			return nil, errors.New("crt.sh did not return a PEM certificate for " + id)
+		}
+		ders = append(ders, block.Bytes)
+	}
+
+	return parseCertificates(ders), nil
+}
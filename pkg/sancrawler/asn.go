@@ -0,0 +1,217 @@
+package sancrawler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ASNInfo says who announces an address. Knowing which names land in the
+// target's own AS rather than some cloud provider is a big help when triaging.
+type ASNInfo struct {
+	IP     string `json:"ip"`
+	ASN    uint   `json:"asn"`
+	Org    string `json:"org,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// ASNLookup is anything that can map addresses onto the AS announcing them.
+// Addresses it knows nothing about are left out of the map.
+type ASNLookup interface {
+	LookupASN(ctx context.Context, ips []string) (map[string]ASNInfo, error)
+}
+
+/* EnrichASN: looks up the AS behind every address the results resolved to and
+ * stores it on their DNS records. Results that haven't been resolved are left
+ * alone. Returns how many addresses were looked up.
+ */
+func EnrichASN(ctx context.Context, results Results, lookup ASNLookup) (int, error) {
+	// Plenty of names share addresses, so only look each one up once
+
+	seen := make(map[string]bool)
+	var ips []string
+
+	for _, res := range results {
+		if res.DNS == nil {
+			continue
+		}
+		for _, ip := range append(res.DNS.A, res.DNS.AAAA...) {
+			if !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		return 0, nil
+	}
+
+	info, err := lookup.LookupASN(ctx, ips)
+	if err != nil {
+		return 0, err
+	}
+
+	for name, res := range results {
+		if res.DNS == nil {
+			continue
+		}
+		res.DNS.ASN = nil
+		for _, ip := range append(res.DNS.A, res.DNS.AAAA...) {
+			if asn, ok := info[ip]; ok {
+				res.DNS.ASN = append(res.DNS.ASN, asn)
+			}
+		}
+		results[name] = res
+	}
+
+	return len(ips), nil
+}
+
+// MaxMindASN looks addresses up in a local MaxMind GeoLite2/GeoIP2 ASN
+// database (or anything else in the same format, like IPinfo's ASN mmdb).
+type MaxMindASN struct {
+	db *maxminddb.Reader
+}
+
+// The fields we care about in a GeoLite2-ASN record
+type maxMindASNRecord struct {
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+/* OpenMaxMindASN: opens the .mmdb file at path.
+ */
+func OpenMaxMindASN(path string) (*MaxMindASN, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MaxMindASN{db: db}, nil
+}
+
+/* Close: closes the database.
+ */
+func (m *MaxMindASN) Close() error {
+	return m.db.Close()
+}
+
+/* LookupASN: looks every address up in the database.
+ */
+func (m *MaxMindASN) LookupASN(ctx context.Context, ips []string) (map[string]ASNInfo, error) {
+	ret := make(map[string]ASNInfo)
+
+	for _, ip := range ips {
+		if ctx.Err() != nil {
+			return ret, ctx.Err()
+		}
+
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+
+		var record maxMindASNRecord
+		network, ok, err := m.db.LookupNetwork(parsed, &record)
+		if err != nil {
+			return ret, err
+		}
+		if !ok || record.ASN == 0 {
+			continue
+		}
+
+		ret[ip] = ASNInfo{IP: ip, ASN: record.ASN, Org: record.Org, Prefix: network.String()}
+	}
+
+	return ret, nil
+}
+
+// DefaultCymruServer is Team Cymru's IP to ASN whois service.
+const DefaultCymruServer = "whois.cymru.com:43"
+
+// CymruASN looks addresses up using Team Cymru's whois service, which takes
+// them all in one go using its bulk mode. No database needed, but it does need
+// outbound access to port 43.
+type CymruASN struct {
+	Server  string
+	Timeout time.Duration
+	// Proxy, if set, is what the whois connection goes through.
+	Proxy *Proxy
+}
+
+/* LookupASN: sends every address to Team Cymru in a single bulk query.
+ */
+func (c *CymruASN) LookupASN(ctx context.Context, ips []string) (map[string]ASNInfo, error) {
+	server := c.Server
+	if server == "" {
+		server = DefaultCymruServer
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Minute
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := (&net.Dialer{}).DialContext
+	if c.Proxy != nil {
+		dial = c.Proxy.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Writing and reading at the same time keeps a big batch from deadlocking
+	// on full buffers.
+	writeErr := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(conn)
+		fmt.Fprintf(w, "begin\nverbose\n")
+		for _, ip := range ips {
+			fmt.Fprintf(w, "%s\n", ip)
+		}
+		fmt.Fprintf(w, "end\n")
+		writeErr <- w.Flush()
+	}()
+
+	ret := make(map[string]ASNInfo)
+	scanner := bufio.NewScanner(conn)
+
+	// Each answer looks like:
+	// AS | IP | BGP Prefix | CC | Registry | Allocated | AS Name
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 7 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		asn, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		ret[fields[1]] = ASNInfo{IP: fields[1], ASN: uint(asn), Org: fields[6], Prefix: fields[2]}
+	}
+
+	if err := <-writeErr; err != nil {
+		return ret, err
+	}
+	return ret, scanner.Err()
+}
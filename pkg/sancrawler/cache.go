@@ -0,0 +1,84 @@
+package sancrawler
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Cache keeps the results of earlier queries so repeated runs don't have to
+// pull them out of crt.sh all over again. Keys are opaque strings describing
+// everything about the query, see Crawler.cacheKey. A miss is (nil, false,
+// nil), expiring entries is up to the cache.
+type Cache interface {
+	Get(key string) (Results, bool, error)
+	Put(key string, results Results) error
+}
+
+/* cacheKey: the key a query's results are cached under. It covers the query,
+ * its filter and CacheScope, so anything that would change what comes back
+ * changes the key. Values match ignoring case, so they're keyed that way too.
+ * Relative windows like -since 90d come out a little different every run, so
+ * Since and Until only count down to the day.
+ */
+func (c *Crawler) cacheKey(q Query) string {
+	f := q.Filter
+	f.Since = f.Since.UTC().Truncate(24 * time.Hour)
+	f.Until = f.Until.UTC().Truncate(24 * time.Hour)
+
+	filter, _ := json.Marshal(f)
+	return strings.Join([]string{c.CacheScope, q.NameType, q.Match, strings.ToLower(q.Value), string(filter)}, "\x00")
+}
+
+/* cached: the query's results from the cache, if they're there. Cache errors
+ * are logged and treated as a miss, the crawl can always go to crt.sh.
+ */
+func (c *Crawler) cached(q Query) (Results, bool) {
+	if c.Cache == nil || c.RefreshCache {
+		return nil, false
+	}
+
+	ret, ok, err := c.Cache.Get(c.cacheKey(q))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Seed":  q.Value,
+			"Error": err,
+		}).Warn("Could not read cached results")
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	// Certificates may have expired since they were cached, and the window may
+	// have moved on by up to a day
+	for name, res := range ret {
+		res.Expired = expired(res.NotAfter)
+		ret[name] = res
+	}
+	ret = q.Filter.Filter(ret)
+
+	log.WithFields(log.Fields{
+		"Seed":  q.Value,
+		"Names": len(ret),
+	}).Info("Using cached results")
+
+	return ret, true
+}
+
+/* cache: stores the query's results, logging rather than failing if it can't.
+ */
+func (c *Crawler) cache(q Query, results Results) {
+	if c.Cache == nil {
+		return
+	}
+
+	if err := c.Cache.Put(c.cacheKey(q), results); err != nil {
+		log.WithFields(log.Fields{
+			"Seed":  q.Value,
+			"Error": err,
+		}).Warn("Could not cache results")
+	}
+}
@@ -0,0 +1,172 @@
+package sancrawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultCensysURL is the certificate search endpoint of the Censys v2 API.
+const DefaultCensysURL = "https://search.censys.io/api/v2/certificates/search"
+
+// Censys names the subject attributes differently, this maps the
+// certificate_identity name types onto their fields.
+var censysFields = map[string]string{
+	"commonName":             "parsed.subject.common_name",
+	"organizationName":       "parsed.subject.organization",
+	"organizationalUnitName": "parsed.subject.organizational_unit",
+	"localityName":           "parsed.subject.locality",
+	"stateOrProvinceName":    "parsed.subject.province",
+	"countryName":            "parsed.subject.country",
+	"emailAddress":           "parsed.subject.email_address",
+	"serialNumber":           "parsed.subject.serial_number",
+}
+
+// CensysBackend searches the Censys certificate dataset. Plenty of certificates
+// never make it into the CT logs crt.sh watches, so this is mostly useful as an
+// extra source alongside crt.sh rather than a replacement for it.
+type CensysBackend struct {
+	// BaseURL is the certificate search endpoint.
+	BaseURL string
+	// APIID and Secret are the Censys API credentials.
+	APIID  string
+	Secret string
+	// MaxPages caps how many pages of 100 hits get pulled, 0 means no limit.
+	// Censys accounts have query quotas so this is worth setting on big orgs.
+	MaxPages int
+	Client   *http.Client
+}
+
+type censysResponse struct {
+	Result struct {
+		Hits []struct {
+			Names       []string `json:"names"`
+			Fingerprint string   `json:"fingerprint_sha256"`
+			Parsed      struct {
+				IssuerDN string `json:"issuer_dn"`
+				Validity struct {
+					NotBefore time.Time `json:"not_before"`
+					NotAfter  time.Time `json:"not_after"`
+				} `json:"validity_period"`
+			} `json:"parsed"`
+		} `json:"hits"`
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"result"`
+	Error string `json:"error"`
+}
+
+/* NewCensysBackend: returns a CensysBackend using the given credentials.
+ */
+func NewCensysBackend(apiID string, secret string) *CensysBackend {
+	return &CensysBackend{
+		BaseURL: DefaultCensysURL,
+		APIID:   apiID,
+		Secret:  secret,
+		Client:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+/* censysQuery: turns a Query into the Censys search language.
+ */
+func censysQuery(q Query) (string, error) {
+	value := `"` + strings.Replace(q.Value, `"`, `\"`, -1) + `"`
+	switch q.Match {
+	case MatchExact:
+	case MatchRegex:
+		value = `/` + strings.Replace(q.Value, `/`, `\/`, -1) + `/`
+	case MatchLike:
+		// Censys wildcards can't be quoted, so spaces have to be escaped instead
+		value = strings.NewReplacer("%", "*", "_", "?", " ", `\ `, `"`, `\"`).Replace(q.Value)
+	default:
+		return "", errors.New("match not supported by Censys: " + q.Match)
+	}
+
+	if q.NameType == "" {
+		return value, nil
+	}
+
+	field, ok := censysFields[q.NameType]
+	if !ok {
+		return "", errors.New("name type not supported by Censys: " + q.NameType)
+	}
+
+	return field + ": " + value, nil
+}
+
+/* Crawl: Get all the names on certificates selected by the query, following the
+ * result cursor until Censys runs out of pages or MaxPages is hit.
+ */
+func (b *CensysBackend) Crawl(ctx context.Context, q Query) (Results, error) {
+	if b.APIID == "" || b.Secret == "" {
+		return nil, errors.New("Censys API ID and secret are required")
+	}
+
+	query, err := censysQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(Results)
+	cursor := ""
+
+	for page := 0; b.MaxPages == 0 || page < b.MaxPages; page++ {
+		values := url.Values{}
+		values.Set("q", query)
+		values.Set("per_page", "100")
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", b.BaseURL+"?"+values.Encode(), nil)
+		if err != nil {
+			return ret, err
+		}
+		req.SetBasicAuth(b.APIID, b.Secret)
+
+		res, err := b.Client.Do(req)
+		if err != nil {
+			return ret, err
+		}
+
+		var body censysResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return ret, fmt.Errorf("Censys API returned %s: %s", res.Status, body.Error)
+		}
+		if err != nil {
+			return ret, err
+		}
+
+		// Censys doesn't tell us which names came from the CN and which came from
+		// the SANs, they nearly always overlap anyway.
+		for _, hit := range body.Result.Hits {
+			for _, name := range hit.Names {
+				ret.add(Result{
+					Name:       strings.ToLower(name),
+					IssuerName: hit.Parsed.IssuerDN,
+					Field:      "SAN",
+					Source:     "censys",
+					NotBefore:  hit.Parsed.Validity.NotBefore,
+					NotAfter:   hit.Parsed.Validity.NotAfter,
+					Expired:    expired(hit.Parsed.Validity.NotAfter),
+				})
+			}
+		}
+
+		cursor = body.Result.Links.Next
+		if cursor == "" {
+			break
+		}
+	}
+
+	return ret, nil
+}
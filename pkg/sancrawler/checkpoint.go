@@ -0,0 +1,221 @@
+package sancrawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Checkpoint keeps track of how far the database crawlers got through each CA
+// along with the names they found on the way, so an interrupted crawl can pick
+// up where it left off instead of starting from scratch. It is safe for use by
+// multiple crawlers at once.
+type Checkpoint struct {
+	path string
+	mu   sync.Mutex
+
+	Queries map[string]*checkpointQuery `json:"queries"`
+}
+
+// Progress for a single query. Ranges holds the certificate ID ranges each CA
+// got split into, keyed by CA ID, so a resumed crawl splits them up the same
+// way. Cursors are keyed by field, CA ID and the start of the range, eg.
+// "SAN/1234/5678", and hold the lowest certificate ID read so far, the next
+// page starts below it. Ranges end at the newest certificate there was at the
+// time, so certificates logged since the checkpoint was written won't be picked
+// up by a resumed crawl, a fresh run will find them.
+type checkpointQuery struct {
+	Ranges  map[string][][2]int64 `json:"ranges"`
+	Cursors map[string]int64      `json:"cursors"`
+	Results Results               `json:"results"`
+}
+
+/* LoadCheckpoint: reads the checkpoint stored at path, a file that doesn't
+ * exist yet just gives an empty checkpoint which will be written there.
+ */
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		path:    path,
+		Queries: make(map[string]*checkpointQuery),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("could not parse checkpoint %s: %v", path, err)
+	}
+
+	return cp, nil
+}
+
+/* Save: writes the checkpoint out. It goes to a temporary file first and gets
+ * renamed into place so a crash half way through a write can't eat the file.
+ */
+func (cp *Checkpoint) Save() error {
+	cp.mu.Lock()
+	data, err := json.Marshal(cp)
+	cp.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, cp.path)
+}
+
+/* AutoSave: saves the checkpoint every interval until ctx is done. Errors are
+ * passed to onError (if set) rather than stopping the saves.
+ */
+func (cp *Checkpoint) AutoSave(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cp.Save(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+/* query: returns the progress for q, creating it if needed. A nil checkpoint
+ * gives a nil query, whose methods all do nothing.
+ */
+func (cp *Checkpoint) query(q Query) *checkpointQuery {
+	if cp == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	key := q.NameType + "/" + q.Value + q.Filter.key()
+	if q.Match != MatchExact {
+		key = q.Match + ":" + key
+	}
+	state, ok := cp.Queries[key]
+	if !ok {
+		state = &checkpointQuery{
+			Ranges:  make(map[string][][2]int64),
+			Cursors: make(map[string]int64),
+			Results: make(Results),
+		}
+		cp.Queries[key] = state
+	}
+
+	// Older checkpoints have offsets instead
+	if state.Ranges == nil {
+		state.Ranges = make(map[string][][2]int64)
+	}
+	if state.Cursors == nil {
+		state.Cursors = make(map[string]int64)
+	}
+
+	return state
+}
+
+func cursorKey(field string, data crawlerData) string {
+	return fmt.Sprintf("%s/%d/%d", field, data.caID, data.start)
+}
+
+/* ranges: the ranges to split a CA into. The first time round that's split,
+ * after that it's whatever got recorded then.
+ */
+func (cp *Checkpoint) ranges(state *checkpointQuery, data crawlerData, split []crawlerData) []crawlerData {
+	if state == nil {
+		return split
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	key := strconv.Itoa(data.caID)
+	recorded, ok := state.Ranges[key]
+	if !ok {
+		for _, r := range split {
+			state.Ranges[key] = append(state.Ranges[key], [2]int64{r.start, r.stop})
+		}
+		return split
+	}
+
+	// We've no idea how the certificates fall across the old ranges, so they get
+	// an even share each.
+	var ret []crawlerData
+	for i, r := range recorded {
+		tmpData := data
+		tmpData.start, tmpData.stop = r[0], r[1]
+		tmpData.certs = data.certs*(i+1)/len(recorded) - data.certs*i/len(recorded)
+		ret = append(ret, tmpData)
+	}
+	return ret
+}
+
+/* cursor: where a crawler should start on this field and range. Checkpoints
+ * written before cursors existed only have offsets, which get ignored, so those
+ * crawls read their pages again.
+ */
+func (cp *Checkpoint) cursor(state *checkpointQuery, field string, data crawlerData) int64 {
+	if state == nil {
+		return data.stop
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if cursor, ok := state.Cursors[cursorKey(field, data)]; ok && cursor < data.stop {
+		return cursor
+	}
+	return data.stop
+}
+
+/* record: remembers a page of results and the cursor for the next page. Both
+ * happen under the same lock so the file never has one without the other.
+ */
+func (cp *Checkpoint) record(state *checkpointQuery, field string, data crawlerData, next int64, page []Result) {
+	if state == nil {
+		return
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, res := range page {
+		state.Results.add(res)
+	}
+	state.Cursors[cursorKey(field, data)] = next
+}
+
+/* results: a copy of everything recorded for the query so far.
+ */
+func (cp *Checkpoint) results(state *checkpointQuery) Results {
+	ret := make(Results)
+	if state == nil {
+		return ret
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for name, res := range state.Results {
+		ret[name] = res
+	}
+	return ret
+}
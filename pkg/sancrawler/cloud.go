@@ -0,0 +1,201 @@
+package sancrawler
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+)
+
+// Cloud providers get recognized by where a name's CNAME points first, since
+// that's the most reliable sign, then by address range and finally by the name
+// of the AS announcing it (when EnrichASN has been run).
+var cloudCNAMEs = []struct {
+	suffix   string
+	provider string
+}{
+	{"cloudfront.net", "AWS"},
+	{"amazonaws.com", "AWS"},
+	{"awsglobalaccelerator.com", "AWS"},
+	{"azurewebsites.net", "Azure"},
+	{"cloudapp.net", "Azure"},
+	{"cloudapp.azure.com", "Azure"},
+	{"azureedge.net", "Azure"},
+	{"azurefd.net", "Azure"},
+	{"trafficmanager.net", "Azure"},
+	{"blob.core.windows.net", "Azure"},
+	{"googleusercontent.com", "GCP"},
+	{"appspot.com", "GCP"},
+	{"googlehosted.com", "GCP"},
+	{"run.app", "GCP"},
+	{"cdn.cloudflare.net", "Cloudflare"},
+	{"cloudflare.net", "Cloudflare"},
+	{"akamaiedge.net", "Akamai"},
+	{"akamaized.net", "Akamai"},
+	{"edgekey.net", "Akamai"},
+	{"edgesuite.net", "Akamai"},
+	{"akamai.net", "Akamai"},
+	{"fastly.net", "Fastly"},
+	{"fastlylb.net", "Fastly"},
+	{"herokuapp.com", "Heroku"},
+	{"herokudns.com", "Heroku"},
+	{"github.io", "GitHub"},
+	{"netlify.app", "Netlify"},
+	{"vercel-dns.com", "Vercel"},
+	{"digitaloceanspaces.com", "DigitalOcean"},
+	{"oraclecloud.com", "Oracle"},
+}
+
+// Bits of AS names that give away who they belong to
+var cloudASNs = []struct {
+	org      string
+	provider string
+}{
+	{"amazon", "AWS"},
+	{"microsoft", "Azure"},
+	{"google", "GCP"},
+	{"cloudflare", "Cloudflare"},
+	{"akamai", "Akamai"},
+	{"fastly", "Fastly"},
+	{"digitalocean", "DigitalOcean"},
+	{"oracle", "Oracle"},
+	{"linode", "Linode"},
+	{"ovh", "OVH"},
+	{"hetzner", "Hetzner"},
+}
+
+// Published ranges small enough to ship with. Anything else (AWS alone has
+// thousands) can be loaded with LoadCloudRanges.
+var defaultCloudRanges = map[string][]string{
+	"Cloudflare": {
+		"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+		"141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+		"197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+		"104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+		"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32",
+		"2405:8100::/32", "2a06:98c0::/29", "2c0f:f248::/32",
+	},
+	"Fastly": {
+		"151.101.0.0/16", "199.232.0.0/16",
+	},
+}
+
+type cloudRange struct {
+	network  *net.IPNet
+	provider string
+}
+
+// CloudClassifier tags names with the cloud provider (or CDN) they're hosted
+// on. The zero value isn't usable, use NewCloudClassifier.
+type CloudClassifier struct {
+	ranges []cloudRange
+}
+
+/* NewCloudClassifier: returns a classifier knowing about the built in ranges.
+ */
+func NewCloudClassifier() *CloudClassifier {
+	c := &CloudClassifier{}
+	for provider, cidrs := range defaultCloudRanges {
+		for _, cidr := range cidrs {
+			c.AddRange(provider, cidr)
+		}
+	}
+	return c
+}
+
+/* AddRange: adds a provider's CIDR range, bad ranges are ignored.
+ */
+func (c *CloudClassifier) AddRange(provider string, cidr string) {
+	_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return
+	}
+	c.ranges = append(c.ranges, cloudRange{network: network, provider: provider})
+}
+
+/* LoadCloudRanges: reads extra ranges from a file with one "provider cidr" pair
+ * per line. Blank lines and # comments are skipped.
+ */
+func (c *CloudClassifier) LoadCloudRanges(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			c.AddRange(fields[0], fields[1])
+		}
+	}
+
+	return scanner.Err()
+}
+
+/* Classify: works out which provider a resolved name is hosted on, or returns
+ * an empty string if it doesn't look like any of them.
+ */
+func (c *CloudClassifier) Classify(records *DNSRecords) string {
+	if records == nil {
+		return ""
+	}
+
+	if records.CNAME != "" {
+		cname := strings.ToLower(records.CNAME)
+		for _, known := range cloudCNAMEs {
+			if cname == known.suffix || strings.HasSuffix(cname, "."+known.suffix) {
+				return known.provider
+			}
+		}
+	}
+
+	for _, addr := range append(records.A, records.AAAA...) {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		for _, r := range c.ranges {
+			if r.network.Contains(ip) {
+				return r.provider
+			}
+		}
+	}
+
+	for _, asn := range records.ASN {
+		org := strings.ToLower(asn.Org)
+		for _, known := range cloudASNs {
+			if strings.Contains(org, known.org) {
+				return known.provider
+			}
+		}
+	}
+
+	return ""
+}
+
+/* ClassifyAll: tags every resolved result with its provider. Returns how many
+ * were found to be in the cloud.
+ */
+func (c *CloudClassifier) ClassifyAll(results Results) int {
+	cloud := 0
+
+	for name, res := range results {
+		if res.DNS == nil {
+			continue
+		}
+		res.DNS.Cloud = c.Classify(res.DNS)
+		if res.DNS.Cloud != "" {
+			cloud++
+		}
+		results[name] = res
+	}
+
+	return cloud
+}
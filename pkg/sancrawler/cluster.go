@@ -0,0 +1,151 @@
+package sancrawler
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cluster is a group of names tied together by the certificates they share.
+// Names that keep ending up on the same certificates tend to be the same
+// environment or product, eg. everything behind one load balancer. Label is
+// the parent domain most of the names sit under, or failing that their most
+// common apex.
+type Cluster struct {
+	Label        string   `json:"label"`
+	Names        []string `json:"names"`
+	Apexes       []string `json:"apexes"`
+	Certificates int      `json:"certificates"`
+}
+
+/* ClusterNames: groups names that share a certificate, directly or through
+ * other names. Certificates with more than maxNames names (0 for no limit) are
+ * left out, shared hosting and CDN certificates would glue everything into one
+ * big group otherwise. Names that don't share a certificate with anything come
+ * back on their own, biggest clusters first.
+ */
+func ClusterNames(results Results, maxNames int) ([]Cluster, []string) {
+	certNames := make(map[int][]string)
+	for name, res := range results {
+		for _, id := range mergeCertificates(nil, res) {
+			certNames[id] = append(certNames[id], name)
+		}
+	}
+
+	// Union-find over the names, every certificate joins up the names on it
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(name string) string {
+		p, ok := parent[name]
+		if !ok || p == name {
+			return name
+		}
+		root := find(p)
+		parent[name] = root
+		return root
+	}
+
+	for _, names := range certNames {
+		if len(names) < 2 || (maxNames > 0 && len(names) > maxNames) {
+			continue
+		}
+		root := find(names[0])
+		for _, name := range names[1:] {
+			if other := find(name); other != root {
+				parent[other] = root
+			}
+		}
+	}
+
+	groups := make(map[string][]string)
+	for name := range results {
+		root := find(name)
+		groups[root] = append(groups[root], name)
+	}
+
+	var clusters []Cluster
+	var alone []string
+	for _, names := range groups {
+		if len(names) == 1 {
+			alone = append(alone, names[0])
+			continue
+		}
+		sort.Strings(names)
+
+		certs := make(map[int]bool)
+		for _, name := range names {
+			for _, id := range mergeCertificates(nil, results[name]) {
+				certs[id] = true
+			}
+		}
+
+		label, apexes := clusterLabel(names)
+		clusters = append(clusters, Cluster{
+			Label:        label,
+			Names:        names,
+			Apexes:       apexes,
+			Certificates: len(certs),
+		})
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Names) != len(clusters[j].Names) {
+			return len(clusters[i].Names) > len(clusters[j].Names)
+		}
+		return clusters[i].Label < clusters[j].Label
+	})
+	sort.Strings(alone)
+
+	return clusters, alone
+}
+
+/* clusterLabel: what to call a cluster, along with the apexes its names are
+ * under, most names first.
+ */
+func clusterLabel(names []string) (string, []string) {
+	parents := make(map[string]int)
+	apexCounts := make(map[string]int)
+	for _, name := range names {
+		host := strings.TrimPrefix(name, "*.")
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+
+		apex, err := publicsuffix.EffectiveTLDPlusOne(host)
+		if err != nil {
+			continue
+		}
+		apexCounts[apex]++
+
+		// Only parents below the apex say anything the apex doesn't
+		if dot := strings.Index(host, "."); dot >= 0 && len(host[dot+1:]) > len(apex) {
+			parents[host[dot+1:]]++
+		}
+	}
+
+	apexes := make([]string, 0, len(apexCounts))
+	for apex := range apexCounts {
+		apexes = append(apexes, apex)
+	}
+	sort.Slice(apexes, func(i, j int) bool {
+		if apexCounts[apexes[i]] != apexCounts[apexes[j]] {
+			return apexCounts[apexes[i]] > apexCounts[apexes[j]]
+		}
+		return apexes[i] < apexes[j]
+	})
+
+	best, count := "", 0
+	for parent, n := range parents {
+		if n > count || (n == count && parent < best) {
+			best, count = parent, n
+		}
+	}
+	if count >= 2 && 2*count >= len(names) {
+		return best, apexes
+	}
+	if len(apexes) > 0 {
+		return apexes[0], apexes
+	}
+	return names[0], apexes
+}
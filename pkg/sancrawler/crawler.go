@@ -0,0 +1,338 @@
+/* Package sancrawler enumerates linked x509 certificates based on shared
+ * metadata. Traditional approaches to using x509 data focused on linking based
+ * on shared apex domain, but in practice, many different fields exist and are
+ * actively used by corporations. Two such approaches are implemented here:
+ * strict organization search, and general keyword searches matching on any field.
+ */
+package sancrawler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NameTypeOrganization is the certificate_identity name type used by crt.sh for
+// the Subject Organization field.
+const NameTypeOrganization = "organizationName"
+
+// SubjectFields maps the usual short names of subject attributes onto the name
+// types crt.sh uses for them. Corporations tend to fill these in just as
+// consistently as the organization.
+var SubjectFields = map[string]string{
+	"CN":           "commonName",
+	"O":            NameTypeOrganization,
+	"OU":           "organizationalUnitName",
+	"L":            "localityName",
+	"ST":           "stateOrProvinceName",
+	"C":            "countryName",
+	"E":            "emailAddress",
+	"serialNumber": "serialNumber",
+}
+
+/* NameTypeForField: turns a subject attribute, either its short name (OU) or
+ * the crt.sh name type (organizationalUnitName), into the crt.sh name type.
+ */
+func NameTypeForField(field string) (string, error) {
+	for short, nameType := range SubjectFields {
+		if strings.EqualFold(field, short) || strings.EqualFold(field, nameType) {
+			return nameType, nil
+		}
+	}
+	return "", errors.New("unknown subject field: " + field)
+}
+
+// Query describes which certificates a backend should pull names from.
+type Query struct {
+	// Value is the seed we're searching for, matched ignoring case.
+	Value string
+	// NameType restricts the match to a single identity type as crt.sh names
+	// them (eg. organizationName), empty matches any field.
+	NameType string
+	// Match is how Value is compared, eg. MatchExact or MatchRegex.
+	Match string
+	// Filter restricts which certificates names are pulled from. Crawler fills
+	// it in from its own Filter when left empty.
+	Filter CertFilter
+}
+
+// How a Query's Value gets matched against certificate identities, all ignoring
+// case. Regexes are POSIX, as postgres runs them. Like takes SQL LIKE patterns
+// (% for anything, _ for any one character) and fuzzy matches identities whose
+// trigram similarity to the value is at least FuzzyThreshold.
+const (
+	MatchExact = ""
+	MatchRegex = "regex"
+	MatchLike  = "like"
+	MatchFuzzy = "fuzzy"
+)
+
+/* ByRegex: Get all the names on certificates which have any identity field
+ * matching the regex, eg. `acme (corp|inc|ltd)`.
+ */
+func (c *Crawler) ByRegex(ctx context.Context, pattern string) (Results, error) {
+	return c.Crawl(ctx, Query{Value: pattern, Match: MatchRegex})
+}
+
+// Backend is anything that can turn a Query into a set of names. The postgres
+// and HTTPS interfaces to crt.sh both implement it.
+type Backend interface {
+	Crawl(ctx context.Context, q Query) (Results, error)
+}
+
+// Crawler holds the configuration for crawling crt.sh. The zero value isn't
+// usable, use New to get one with sensible defaults.
+type Crawler struct {
+	// Backend is where names are pulled from first.
+	Backend Backend
+	// Fallback, if set, gets used whenever Backend fails for any reason other
+	// than ctx being cancelled. Results from both are merged.
+	Fallback Backend
+	// Extra backends are always queried after Backend and their results merged
+	// in. A failing extra backend is logged and skipped rather than failing the
+	// whole crawl.
+	Extra []Backend
+	// Filter applies to every query that doesn't have its own.
+	Filter CertFilter
+	// Cache, if set, is checked before crawling and gets the results of every
+	// query that completes. CacheScope goes into every key, set it to anything
+	// about the backends that changes what a query returns (eg. which database
+	// they point at). RefreshCache skips the lookups but still stores.
+	Cache        Cache
+	CacheScope   string
+	RefreshCache bool
+	// Seen, if set, is what names streamed to found get checked against, and
+	// results aren't collected at all: CrawlAllStream hands back nothing and
+	// DBBackend should have Discard set. It's for crawls with more names than
+	// fit in memory, they only exist in the stream. Every query shares it, so
+	// a name only gets streamed once whichever seed finds it.
+	Seen NameSet
+	// Metrics, if set, counts the queries sent to each backend and the names
+	// they find. Give the DBBackend the same one to count pages too.
+	Metrics *Metrics
+
+	// How many times a backend failed and got skipped or fallen back from
+	failures int64
+}
+
+/* New: returns a Crawler pointed at the public crt.sh database, falling back to
+ * the crt.sh JSON API if the database is unavailable.
+ */
+func New() *Crawler {
+	return &Crawler{
+		Backend:  &DBBackend{ConnStr: DefaultConnStr},
+		Fallback: NewAPIBackend(),
+	}
+}
+
+/* Failures: how many times a backend has failed without failing the crawl,
+ * either an extra backend that was skipped or a primary that needed the
+ * fallback. If it isn't zero, the results may well be missing something.
+ */
+func (c *Crawler) Failures() int {
+	return int(atomic.LoadInt64(&c.failures))
+}
+
+/* Crawl: Get all the names on certificates selected by the query, each result
+ * is tagged with the query's value as its seed. Cancelling ctx stops any
+ * in-flight queries and returns the partial results along with ctx.Err().
+ */
+func (c *Crawler) Crawl(ctx context.Context, q Query) (Results, error) {
+	return c.CrawlStream(ctx, q, nil)
+}
+
+/* CrawlStream: Crawl, but every name is also handed to found (if it isn't nil)
+ * as soon as a backend turns it up, filtered and tagged the same way as the
+ * final results. Each name only goes to found once.
+ */
+func (c *Crawler) CrawlStream(ctx context.Context, q Query, found func(Result)) (Results, error) {
+	if q.Filter.IsZero() {
+		q.Filter = c.Filter
+	}
+
+	emit := streamTo(q, found, c.Seen)
+
+	// Not every backend can filter for us, so anything that slipped through
+	// gets dropped here.
+	var (
+		ret Results
+		err error
+	)
+	if cached, ok := c.cached(q); ok {
+		ret = cached
+		for _, res := range ret {
+			if emit != nil {
+				emit(res)
+			}
+		}
+	} else {
+		if q.NameType == NameTypeSPKI {
+			ret, err = c.crawlSPKI(ctx, q, emit)
+		} else {
+			ret, err = c.crawlSources(ctx, q, emit)
+		}
+
+		// Only complete results are worth keeping
+		if err == nil && c.Seen == nil {
+			c.cache(q, q.Filter.Filter(ret))
+		}
+	}
+	ret = q.Filter.Filter(ret)
+	c.Metrics.addNames(len(ret))
+
+	for name, res := range ret {
+		res.Seeds = []string{q.Value}
+		ret[name] = res
+	}
+
+	return ret, err
+}
+
+/* CrawlAll: Runs every query concurrently and merges the results, so names
+ * found by more than one seed list all of them. The first error to come back
+ * is returned along with whatever was collected.
+ */
+func (c *Crawler) CrawlAll(ctx context.Context, queries []Query) (Results, error) {
+	return c.CrawlAllStream(ctx, queries, nil)
+}
+
+/* CrawlAllStream: CrawlAll, streaming names to found as CrawlStream does. A
+ * name found by more than one seed only goes to found the first time, tagged
+ * with whichever seed got there first.
+ */
+func (c *Crawler) CrawlAllStream(ctx context.Context, queries []Query, found func(Result)) (Results, error) {
+	// With Seen each query's stream already checks against every other's
+	if c.Seen == nil {
+		found = streamOnce(found, nil)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	ret := make(Results)
+
+	for _, q := range queries {
+		wg.Add(1)
+		go func(q Query) {
+			defer wg.Done()
+			results, err := c.CrawlStream(ctx, q, found)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if c.Seen == nil {
+				ret.Merge(results)
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(q)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return ret, err
+	}
+
+	return ret, firstErr
+}
+
+/* crawlSources: runs the query against the primary backend and then every
+ * extra backend, merging the results.
+ */
+func (c *Crawler) crawlSources(ctx context.Context, q Query, emit func(Result)) (Results, error) {
+	ret, err := c.crawlPrimary(ctx, q, emit)
+	if ctx.Err() != nil || errors.Is(err, ErrStopped) {
+		return ret, err
+	}
+
+	if ret == nil {
+		ret = make(Results)
+	}
+
+	for _, extra := range c.Extra {
+		results, extraErr := crawlBackend(ctx, extra, q, emit, c.Metrics)
+
+		for _, res := range results {
+			ret.add(res)
+		}
+
+		if ctx.Err() != nil {
+			return ret, ctx.Err()
+		}
+		if errors.Is(extraErr, ErrStopped) {
+			return ret, extraErr
+		}
+
+		if extraErr != nil {
+			atomic.AddInt64(&c.failures, 1)
+			log.WithFields(log.Fields{
+				"Error": extraErr,
+			}).Warn("Extra backend failed, skipping it")
+		}
+	}
+
+	return ret, err
+}
+
+/* crawlPrimary: runs the query against Backend, and Fallback if that fails.
+ */
+func (c *Crawler) crawlPrimary(ctx context.Context, q Query, emit func(Result)) (Results, error) {
+	ret, err := crawlBackend(ctx, c.Backend, q, emit, c.Metrics)
+	if err == nil || c.Fallback == nil || ctx.Err() != nil || errors.Is(err, ErrStopped) {
+		return ret, err
+	}
+
+	atomic.AddInt64(&c.failures, 1)
+	log.WithFields(log.Fields{
+		"Error": err,
+	}).Warn("Primary backend failed, trying fallback")
+
+	fallback, fallbackErr := crawlBackend(ctx, c.Fallback, q, emit, c.Metrics)
+	if ret == nil {
+		return fallback, fallbackErr
+	}
+
+	for _, res := range fallback {
+		ret.add(res)
+	}
+
+	if fallbackErr != nil {
+		return ret, errors.Join(err, fallbackErr)
+	}
+
+	return ret, nil
+}
+
+/* ByKeyword: Get all the names on certificates which have any identity field
+ * (CN, SAN, organization, ...) matching the keyword.
+ */
+func (c *Crawler) ByKeyword(ctx context.Context, keyword string) (Results, error) {
+	return c.Crawl(ctx, Query{Value: keyword})
+}
+
+/* ByOrganization: Get all the names on certificates whose Subject Organization
+ * matches org exactly (ignoring case).
+ */
+func (c *Crawler) ByOrganization(ctx context.Context, org string) (Results, error) {
+	return c.Crawl(ctx, Query{Value: org, NameType: NameTypeOrganization})
+}
+
+/* ByField: Get all the names on certificates where the given subject field (see
+ * SubjectFields) matches value exactly (ignoring case).
+ */
+func (c *Crawler) ByField(ctx context.Context, field string, value string) (Results, error) {
+	nameType, err := NameTypeForField(field)
+	if err != nil {
+		return nil, err
+	}
+	return c.Crawl(ctx, Query{Value: value, NameType: nameType})
+}
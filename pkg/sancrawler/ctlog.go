@@ -0,0 +1,468 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultLogList is Google's list of the CT logs Chrome accepts SCTs from,
+// which between them see just about every publicly trusted certificate.
+const DefaultLogList = "https://www.gstatic.com/ct/log_list/v3/log_list.json"
+
+// Defaults for LogTailer, used when its fields are zero
+const (
+	DefaultTailInterval = 10 * time.Second
+	DefaultTailBatch    = 256
+)
+
+// LogTailer follows CT logs directly over the RFC 6962 API, picking up new
+// certificates within seconds of them being logged instead of waiting on crt.sh
+// to ingest them. Certificates are matched against Queries the way crt.sh would
+// match them against their identities, and against Domains, which match the
+// domain itself and any name under it. Only exact, LIKE, regex and fuzzy
+// queries on identity fields can be matched, there's nothing like a CA ID in a
+// log entry.
+type LogTailer struct {
+	// Logs are the base URLs of the logs to follow, eg.
+	// https://ct.googleapis.com/logs/us1/argon2025h2/
+	Logs   []string
+	Client *http.Client
+	// Interval is how long to wait between checking each log for new entries.
+	Interval time.Duration
+	// BatchSize is how many entries to ask for at once, logs can hand back
+	// fewer.
+	BatchSize int
+
+	Queries []Query
+	Domains []string
+
+	// SANTypes and SubjectEmails are as for DBBackend.
+	SANTypes      []string
+	SubjectEmails bool
+}
+
+// A compiled Query, matching a single identity value
+type identityMatcher struct {
+	seed     string
+	nameType string
+	match    func(string) bool
+}
+
+// The bits of a get-entries response we need. encoding/json takes care of the
+// base64.
+type ctEntry struct {
+	LeafInput []byte `json:"leaf_input"`
+	ExtraData []byte `json:"extra_data"`
+}
+
+// The bits of the v3 log list we need
+type logList struct {
+	Operators []struct {
+		Logs []struct {
+			URL              string                     `json:"url"`
+			State            map[string]json.RawMessage `json:"state"`
+			TemporalInterval *struct {
+				EndExclusive time.Time `json:"end_exclusive"`
+			} `json:"temporal_interval"`
+		} `json:"logs"`
+	} `json:"operators"`
+}
+
+/* UsableLogs: the URLs of the logs in the v3 log list at listURL that are
+ * usable and still taking new certificates. Logs sharded by expiry date stop
+ * getting anything once their shard is over, so those get left out too.
+ */
+func UsableLogs(ctx context.Context, client *http.Client, listURL string) ([]string, error) {
+	body, err := ctGet(ctx, client, listURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var list logList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, operator := range list.Operators {
+		for _, l := range operator.Logs {
+			if _, ok := l.State["usable"]; !ok {
+				continue
+			}
+			if l.TemporalInterval != nil && l.TemporalInterval.EndExclusive.Before(time.Now()) {
+				continue
+			}
+			ret = append(ret, l.URL)
+		}
+	}
+
+	if len(ret) == 0 {
+		return nil, errors.New("no usable logs in " + listURL)
+	}
+	return ret, nil
+}
+
+/* likeRegexp: a SQL LIKE pattern as an anchored, case insensitive regexp.
+ */
+func likeRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+/* newIdentityMatcher: compiles q into something that can be run against each
+ * identity on a certificate.
+ */
+func newIdentityMatcher(q Query) (identityMatcher, error) {
+	m := identityMatcher{seed: q.Value, nameType: q.NameType}
+
+	switch q.NameType {
+	case NameTypeIssuerCA, NameTypeSPKI:
+		return m, errors.New("can't match " + q.NameType + " in CT log entries")
+	}
+
+	switch q.Match {
+	case MatchExact:
+		m.match = func(identity string) bool { return strings.EqualFold(identity, q.Value) }
+	case MatchLike:
+		re, err := likeRegexp(q.Value)
+		if err != nil {
+			return m, err
+		}
+		m.match = re.MatchString
+	case MatchRegex:
+		re, err := q.compile()
+		if err != nil {
+			return m, err
+		}
+		m.match = re.MatchString
+	case MatchFuzzy:
+		m.match = func(identity string) bool { return Similarity(identity, q.Value) >= FuzzyThreshold }
+	default:
+		return m, errors.New("unknown match: " + q.Match)
+	}
+
+	return m, nil
+}
+
+/* certIdentities: the identities crt.sh would index the certificate under,
+ * keyed by name type.
+ */
+func certIdentities(cert *x509.Certificate) map[string][]string {
+	ret := map[string][]string{
+		"commonName":             {cert.Subject.CommonName},
+		NameTypeOrganization:     cert.Subject.Organization,
+		"organizationalUnitName": cert.Subject.OrganizationalUnit,
+		"localityName":           cert.Subject.Locality,
+		"stateOrProvinceName":    cert.Subject.Province,
+		"countryName":            cert.Subject.Country,
+		"emailAddress":           subjectEmails(cert.Subject),
+		"serialNumber":           {cert.Subject.SerialNumber},
+		"dNSName":                cert.DNSNames,
+		"rfc822Name":             cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		ret["iPAddress"] = append(ret["iPAddress"], ip.String())
+	}
+	return ret
+}
+
+/* inDomain: whether name is domain or anything under it, wildcards included.
+ */
+func inDomain(name string, domain string) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+/* seeds: every query and domain the certificate matches.
+ */
+func (t *LogTailer) seeds(cert *x509.Certificate, matchers []identityMatcher) []string {
+	var ret []string
+	identities := certIdentities(cert)
+
+	for _, m := range matchers {
+		matched := false
+		for nameType, values := range identities {
+			if m.nameType != "" && m.nameType != nameType {
+				continue
+			}
+			for _, v := range values {
+				if v != "" && m.match(v) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				break
+			}
+		}
+		if matched && !containsString(ret, m.seed) {
+			ret = append(ret, m.seed)
+		}
+	}
+
+	for _, domain := range t.Domains {
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		for _, name := range append(cert.DNSNames, cert.Subject.CommonName) {
+			if inDomain(name, domain) {
+				if !containsString(ret, domain) {
+					ret = append(ret, domain)
+				}
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+/* Tail: follows every log from its current end, handing the names on matching
+ * certificates to found as they turn up. found can be called from more than one
+ * goroutine at once. A log that can't be reached is logged and tried again next
+ * interval rather than giving up on it. Runs until ctx is done.
+ */
+func (t *LogTailer) Tail(ctx context.Context, found func(Result)) error {
+	if len(t.Logs) == 0 {
+		return errors.New("no logs to tail")
+	}
+	if len(t.Queries) == 0 && len(t.Domains) == 0 {
+		return errors.New("nothing to match log entries against")
+	}
+
+	var matchers []identityMatcher
+	for _, q := range t.Queries {
+		m, err := newIdentityMatcher(q)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers, m)
+	}
+
+	var wg sync.WaitGroup
+	for _, logURL := range t.Logs {
+		wg.Add(1)
+		go func(logURL string) {
+			defer wg.Done()
+			t.tailLog(ctx, strings.TrimSuffix(logURL, "/")+"/", matchers, found)
+		}(logURL)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+/* tailLog: follows a single log until ctx is done. The first tree head sets
+ * where we start, everything logged before then is crt.sh's job.
+ */
+func (t *LogTailer) tailLog(ctx context.Context, logURL string, matchers []identityMatcher, found func(Result)) {
+	interval, batch := t.Interval, int64(t.BatchSize)
+	if interval <= 0 {
+		interval = DefaultTailInterval
+	}
+	if batch <= 0 {
+		batch = DefaultTailBatch
+	}
+
+	source := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(logURL, "https://"), "http://"), "/")
+	next := int64(-1)
+
+	for {
+		size, err := t.treeSize(ctx, logURL)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.WithFields(log.Fields{
+				"Log":   source,
+				"Error": err,
+			}).Warn("Could not get CT log tree head")
+		case err == nil && next < 0:
+			next = size
+			log.WithFields(log.Fields{
+				"Log":      source,
+				"TreeSize": size,
+			}).Info("Tailing CT log")
+		}
+
+		for err == nil && next >= 0 && next < size && ctx.Err() == nil {
+			end := next + batch
+			if end > size {
+				end = size
+			}
+
+			var entries []ctEntry
+			entries, err = t.entries(ctx, logURL, next, end-1)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(log.Fields{
+						"Log":   source,
+						"Start": next,
+						"Error": err,
+					}).Warn("Could not get CT log entries")
+				}
+				break
+			}
+			if len(entries) == 0 {
+				break
+			}
+
+			for i, entry := range entries {
+				cert, err := parseEntry(entry)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"Log":   source,
+						"Index": next + int64(i),
+						"Error": err,
+					}).Debug("Skipping CT log entry")
+					continue
+				}
+
+				seeds := t.seeds(cert, matchers)
+				if len(seeds) == 0 {
+					continue
+				}
+
+				base := certificateResult(cert)
+				base.Source = source
+				base.Seeds = seeds
+				for _, res := range certificateNames(cert, base, t.SANTypes, t.SubjectEmails) {
+					found(res)
+				}
+			}
+
+			next += int64(len(entries))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+/* treeSize: how many entries the log has in it right now, from get-sth.
+ */
+func (t *LogTailer) treeSize(ctx context.Context, logURL string) (int64, error) {
+	body, err := ctGet(ctx, t.Client, logURL+"ct/v1/get-sth")
+	if err != nil {
+		return 0, err
+	}
+
+	var sth struct {
+		TreeSize int64 `json:"tree_size"`
+	}
+	if err := json.Unmarshal(body, &sth); err != nil {
+		return 0, err
+	}
+	return sth.TreeSize, nil
+}
+
+/* entries: the entries from start to end inclusive, from get-entries. Logs cap
+ * how many they hand back, so there can be fewer.
+ */
+func (t *LogTailer) entries(ctx context.Context, logURL string, start int64, end int64) ([]ctEntry, error) {
+	body, err := ctGet(ctx, t.Client, logURL+"ct/v1/get-entries?start="+strconv.FormatInt(start, 10)+"&end="+strconv.FormatInt(end, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Entries []ctEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+/* readCert: a certificate with a 3 byte length in front of it, as RFC 6962
+ * encodes ASN.1Cert.
+ */
+func readCert(b []byte) ([]byte, error) {
+	if len(b) < 3 {
+		return nil, errors.New("truncated certificate")
+	}
+	n := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	if len(b) < 3+n {
+		return nil, errors.New("truncated certificate")
+	}
+	return b[3 : 3+n], nil
+}
+
+/* parseEntry: the certificate in a log entry. For precertificates that's the
+ * precertificate itself from extra_data, which has the same names and subject
+ * as the certificate that will be issued.
+ */
+func parseEntry(entry ctEntry) (*x509.Certificate, error) {
+	leaf := entry.LeafInput
+
+	// MerkleTreeLeaf: version, leaf type, 8 byte timestamp and the entry type
+	if len(leaf) < 12 || leaf[0] != 0 || leaf[1] != 0 {
+		return nil, errors.New("unsupported leaf")
+	}
+
+	var der []byte
+	var err error
+	switch entryType := binary.BigEndian.Uint16(leaf[10:12]); entryType {
+	case 0:
+		der, err = readCert(leaf[12:])
+	case 1:
+		der, err = readCert(entry.ExtraData)
+	default:
+		return nil, fmt.Errorf("unknown entry type %d", entryType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+/* ctGet: GETs a URL from a log and hands back the body.
+ */
+func ctGet(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "sancrawler")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CT log returned %s", res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,1074 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// Lets hope this one works better than psycopg2
+	"github.com/lib/pq"
+)
+
+// DefaultConnStr points at the public guest interface of the postgres instance
+// run by crt.sh.
+//
+// https://blog.marin.qa/posts/2016/04/07/pgbouncer-problems-with-go/
+const DefaultConnStr = "host=crt.sh user=guest dbname=certwatch binary_parameters=yes"
+
+// DBBackend crawls crt.sh by talking directly to its postgres instance. This is
+// by far the fastest way to pull a large number of names, when it works.
+type DBBackend struct {
+	// ConnStr is the postgres connection string used to reach crt.sh.
+	ConnStr string
+	// Schema is which flavour of database we're talking to: SchemaFull for
+	// crt.sh itself or SchemaRaw for a mirror without crt.sh's x509 functions.
+	// Left empty it gets detected on first use.
+	Schema string
+	// Checkpoint, if set, records progress through each CA so that a crawl can
+	// be resumed later on.
+	Checkpoint *Checkpoint
+	// Limiter, if set, throttles the queries we send so crt.sh doesn't start
+	// throttling (or banning) the guest account for us.
+	Limiter *Limiter
+	// Retries is how many more times a failed query gets another go before the
+	// crawl is abandoned, the wait starts at RetryDelay and doubles each time.
+	Retries    int
+	RetryDelay time.Duration
+	// KeepPrecerts stops precertificates from being skipped when their final
+	// certificate was logged too. Otherwise every such pair gets read twice.
+	KeepPrecerts bool
+	// Progress, if set, gets told how many certificates there are to read and
+	// how many have been read so far.
+	Progress *Progress
+	// SANTypes lists which kinds of SAN to collect: dns, ip, uri and email.
+	// Empty means just dns.
+	SANTypes []string
+	// SubjectEmails also collects the emailAddress attribute of each
+	// certificate's Subject, as TypeEmail results.
+	SubjectEmails bool
+	// MaxOpenConns caps the connections in the pool every query shares, zero
+	// means no limit. MaxIdleConns and ConnMaxLifetime fall back to
+	// DefaultMaxIdleConns and DefaultConnMaxLifetime when zero.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// MaxCrawlers is the most crawlers there can be running at once, between
+	// every query going on at the time and on top of MaxOpenConns. Each query
+	// gets at least one however many the others have. DefaultMaxCrawlers when
+	// zero.
+	MaxCrawlers int
+	// TargetLatency is how long a page can take before crawlers stop being
+	// added and start being wound down. DefaultTargetLatency when zero.
+	TargetLatency time.Duration
+	// NoPrepare sends the crawl queries as they are rather than as prepared
+	// statements. It gets set by itself if the database loses them.
+	NoPrepare bool
+	// Proxy, if set, is what every connection to the database goes through.
+	Proxy *Proxy
+	// ShareCertificates lets queries share the certificates they read, one that
+	// another query already got through has its names copied rather than pulled
+	// out of the database again. It costs memory for every certificate read, so
+	// it's only worth it when crawling several seeds for the same target.
+	ShareCertificates bool
+	// Discard hands names to found without keeping any of them, CrawlStream
+	// returns nothing. found gets every name each time it comes across it, so
+	// it's up to the caller to weed out duplicates (see Crawler.Seen).
+	Discard bool
+	// Metrics, if set, counts the pages read, how long they took and how many
+	// crawlers are running.
+	Metrics *Metrics
+
+	poolMu sync.Mutex
+	db     *sql.DB
+	// Prepared statements by query, they belong to db
+	stmts map[string]*sql.Stmt
+
+	sharedOnce sync.Once
+	budget     *crawlerBudget
+	shared     *sharedCertificates
+
+	schemaMu sync.Mutex
+	// nil until we've checked whether pg_trgm is installed
+	hasTrigrams *bool
+}
+
+// The CT poison extension, only precertificates have it
+const precertPoison = "1.3.6.1.4.1.11129.2.4.3"
+
+// What the page queries select instead of c.CERTIFICATE, so the certificates
+// in $5 (already read by another query) don't get sent or picked apart
+const sharedCertificate = `CASE WHEN c.ID = ANY($5::bigint[]) THEN NULL ELSE c.CERTIFICATE END AS CERTIFICATE`
+
+// Only plain identifiers are allowed as name types since they get pasted into
+// the SQL.
+var nameTypeRegex = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// The SAN types crt.sh's x509_altNames understands, along with the Result.Type
+// each one ends up as.
+var sanTypes = map[string]struct {
+	number   int
+	nameType string
+}{
+	"dns":   {2, ""},
+	"ip":    {7, TypeIP},
+	"uri":   {6, TypeURI},
+	"email": {1, TypeEmail},
+}
+
+// A query for crawlers to page through, along with how to label what it finds.
+type crawlJob struct {
+	query    string
+	field    string
+	nameType string
+	// raw jobs pull whole certificates to be parsed here instead of names
+	raw bool
+	// How many certificates the job has read so far
+	read *int64
+}
+
+/* key: identifies the job in checkpoints. Plain DNS SANs keep the old key so
+ * older checkpoints still work.
+ */
+func (j crawlJob) key() string {
+	if j.raw {
+		return "raw"
+	}
+	if j.nameType == "" {
+		return j.field
+	}
+	return j.field + ":" + j.nameType
+}
+
+// Data format used by crawlers, tells them which CA they are working on and where the
+// bounds of their search are. start and stop are certificate IDs, start is the
+// lowest one to read and stop is just past the highest. A large CA gets split up
+// into several ranges so more than one crawler can work on it, certs is how many
+// certificates we expect to find in each.
+type crawlerData struct {
+	caID   int
+	caName string
+	start  int64
+	stop   int64
+	certs  int
+}
+
+/* compactQuery: squashes a multi-line query onto one line, purely to keep things
+ * tidy when they show up in logs.
+ */
+func compactQuery(query string) string {
+	space := regexp.MustCompile(`\s+`)
+	query = strings.Replace(query, "\n", " ", -1)
+	return space.ReplaceAllString(query, " ")
+}
+
+/* namesQuery: a job query pulling the names from names, a set returning
+ * function of c.CERTIFICATE, out of every certificate certs selects. The
+ * certificates get limited rather than the rows so a page never ends half way
+ * through one. A certificate without any names still gets a row.
+ */
+func namesQuery(names string, certs string) string {
+	return compactQuery(`
+	SELECT c.ID, n.NAME,
+		x509_notBefore(c.CERTIFICATE), x509_notAfter(c.CERTIFICATE),
+		x509_hasExtension(c.CERTIFICATE, '` + precertPoison + `', TRUE)
+	FROM (` + certs + `) c
+	LEFT JOIN LATERAL ` + names + ` n(NAME) ON TRUE
+	ORDER BY c.ID DESC;
+	`)
+}
+
+/* getNames: Retrieves the common names and subject alternative names (SANs)
+ * from the postgres instance run by crt.sh, you can find details about their
+ * complicated database schema here: https://github.com/crtsh/certwatch_db
+ *
+ * Keeps taking partitions off the scheduler until there are none left or it
+ * says there are too many crawlers, in which case whatever is left of the
+ * current partition goes back for another crawler to finish.
+ */
+func (b *DBBackend) getNames(ctx context.Context, seed string, state *checkpointQuery, sched *scheduler, outChan chan<- Result) error {
+	b.Metrics.addCrawlers(1)
+	defer b.Metrics.addCrawlers(-1)
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		sched.done()
+		return err
+	}
+
+	for {
+		p, ok := sched.next()
+		if !ok {
+			sched.done()
+			return nil
+		}
+
+		// Pages go newest certificate first, each one starting below the lowest
+		// certificate ID of the page before (the cursor). That's an index lookup
+		// for postgres wherever we are, where an OFFSET meant reading and
+		// throwing away every page before it.
+		for {
+			var certs int
+			next := p.cursor
+			began := time.Now()
+
+			// A page that fails part way through gets read again from the start,
+			// anything already sent gets deduplicated on the way in.
+			err = retry(ctx, b.Retries, b.RetryDelay, "Reading page", func() error {
+				attempt := time.Now()
+				certs, next, err = b.getPage(ctx, db, p.job, seed, state, p.data, p.cursor, outChan)
+				b.Metrics.page(time.Since(attempt), certs, err)
+				return err
+			})
+			if err != nil {
+				sched.done()
+				return err
+			}
+
+			// Bail out if we're done
+			if certs == 0 {
+				break
+			}
+			p.cursor = next
+
+			if !sched.observe(p, time.Since(began)) {
+				return nil
+			}
+		}
+	}
+}
+
+/* readRow: turns a row of one of the name queries into a result. Certificates
+ * without any of the names a job is after still get a row, with a NULL name,
+ * so that they count towards the page.
+ */
+func (b *DBBackend) readRow(rows *sql.Rows, job crawlJob, tmpData crawlerData) (int, []Result, error) {
+	var (
+		ID        int
+		name      sql.NullString
+		notBefore sql.NullTime
+		notAfter  sql.NullTime
+		precert   sql.NullBool
+	)
+
+	// Note: Some of these results may not be actual domains, recall these are
+	// just common names and SANs. They only have to be resolvable/accessible for
+	// whatever system is using them. This means you may find internal domain names
+	// as SANs that aren't fully qualified. You are very likely to encounter wildcard
+	// entires too.
+
+	if err := rows.Scan(&ID, &name, &notBefore, &notAfter, &precert); err != nil {
+		return 0, nil, err
+	}
+	// A certificate another query already read comes back empty too
+	if !name.Valid {
+		return ID, nil, nil
+	}
+
+	// Make sure to lowercase to avoid duplicates based on mixed cases
+
+	res := Result{
+		Name:          strings.ToLower(name.String),
+		CertificateID: ID,
+		IssuerCAID:    tmpData.caID,
+		IssuerName:    tmpData.caName,
+		Field:         job.field,
+		Type:          job.nameType,
+		Source:        "crt.sh",
+		NotBefore:     notBefore.Time,
+		NotAfter:      notAfter.Time,
+		Expired:       expired(notAfter.Time),
+		Precert:       precert.Bool,
+	}
+
+	return ID, []Result{res}, nil
+}
+
+/* getPage: Pulls a single page of names for a CA, from the certificates below
+ * cursor, and pushes them into outChan. Returns how many certificates were read
+ * and the cursor for the next page. The page only makes it into the checkpoint
+ * once all of it has been read.
+ */
+func (b *DBBackend) getPage(ctx context.Context, db *sql.DB, job crawlJob, seed string, state *checkpointQuery, tmpData crawlerData, cursor int64, outChan chan<- Result) (int, int64, error) {
+	var page []Result
+	certs, lastID := 0, 0
+
+	// Certificates another query already read get copied from what it found,
+	// and what this page finds goes to the others
+	shared := b.sharing()
+	skip := shared.between(job, tmpData.caID, tmpData.start, cursor)
+	skipIDs := make([]int64, 0, len(skip))
+	for id := range skip {
+		skipIDs = append(skipIDs, int64(id))
+	}
+	var read map[int][]Result
+	if shared != nil {
+		read = make(map[int][]Result)
+	}
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return 0, cursor, err
+	}
+	defer release()
+
+	rows, err := b.query(ctx, db, job.query, seed, tmpData.caID, cursor, tmpData.start, pq.Array(skipIDs))
+	if err != nil {
+		return 0, cursor, err
+	}
+	defer rows.Close()
+
+	// Scan through the records returned and keep track of the information we
+	// actually care about. We need ID since doing an ORDER BY on strings is slow
+	// and it's what we page on, it also gets passed along with the results. I
+	// also suck at SQL, so keep that in mind.
+	for rows.Next() {
+		var (
+			ID      int
+			results []Result
+		)
+
+		if job.raw {
+			ID, results, err = b.readRawRow(rows, tmpData)
+		} else {
+			ID, results, err = b.readRow(rows, job, tmpData)
+		}
+		if err != nil {
+			return certs, cursor, err
+		}
+
+		// Rows come out ordered by ID, one per name, so a new ID means a new
+		// certificate.
+		if ID != lastID {
+			certs++
+			lastID = ID
+
+			if names, ok := skip[ID]; ok {
+				results = append(results, names...)
+				shared.reuse()
+			} else if read != nil {
+				read[ID] = nil
+			}
+		}
+		if _, ok := read[ID]; ok {
+			read[ID] = append(read[ID], results...)
+		}
+
+		for _, res := range results {
+			select {
+			case outChan <- res:
+			case <-ctx.Done():
+				return certs, cursor, ctx.Err()
+			}
+		}
+
+		if state != nil {
+			page = append(page, results...)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return certs, cursor, err
+	}
+	if certs == 0 {
+		return 0, cursor, nil
+	}
+
+	shared.record(job, tmpData.caID, read)
+	b.Checkpoint.record(state, job.key(), tmpData, int64(lastID), page)
+	b.Progress.addDone(tmpData, certs)
+	atomic.AddInt64(job.read, int64(certs))
+	return certs, int64(lastID), nil
+}
+
+func (b *DBBackend) loadCrawlerData(ctx context.Context, filter string, seed string) ([]crawlerData, int, error) {
+	// We need to group all of the certificates by CA. Then we will partition those results
+	// into the blocks of crawler data that will get used by other functions.
+
+	var work []crawlerData
+	numTotalCerts := 0
+	numCrawlers := 0
+
+	query := compactQuery(`
+	SELECT ci.ISSUER_CA_ID, ca.NAME, count(DISTINCT ci.CERTIFICATE_ID),
+		min(ci.CERTIFICATE_ID), max(ci.CERTIFICATE_ID)
+	 FROM ca, certificate_identity ci
+	 WHERE ci.ISSUER_CA_ID = ca.ID AND
+				` + filter + `
+	 GROUP BY ci.ISSUER_CA_ID, ca.NAME;`)
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Pull the results
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+
+	rows, err := b.query(ctx, db, query, seed)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			caID     int
+			caName   string
+			numCerts int
+			minID    int64
+			maxID    int64
+		)
+
+		if err := rows.Scan(&caID, &caName, &numCerts, &minID, &maxID); err != nil {
+			return nil, 0, err
+		}
+
+		var tmpData crawlerData
+		tmpData.caID = caID
+		tmpData.caName = caName
+		tmpData.start = minID
+		tmpData.stop = maxID + 1
+		tmpData.certs = numCerts
+
+		work = append(work, tmpData)
+		numTotalCerts += numCerts
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// How many crawlers will we need for this run? This is only where the
+	// scheduler starts, for each job, it works out the rest as it goes.
+
+	if numTotalCerts < 10000 {
+		numCrawlers = 1
+	} else {
+		numCrawlers = (numTotalCerts / 10000)
+	}
+
+	return work, numCrawlers, nil
+}
+
+/* filter: builds the certificate_identity filter for a query. The seed value is
+ * always passed as $1, NameType is checked before it gets anywhere near the SQL.
+ */
+func (q Query) filter() (string, error) {
+	if q.NameType == NameTypeIssuerCA {
+		if _, err := strconv.Atoi(q.Value); err != nil {
+			return "", errors.New("invalid CA ID: " + q.Value)
+		}
+		return `ci.ISSUER_CA_ID = $1`, nil
+	}
+
+	// Regexes can't use the index on lower(NAME_VALUE), so they're a lot slower
+	match := `lower(ci.NAME_VALUE) = lower($1)`
+	switch q.Match {
+	case MatchExact:
+	case MatchRegex:
+		match = `ci.NAME_VALUE ~* $1`
+	case MatchLike:
+		match = `lower(ci.NAME_VALUE) LIKE lower($1)`
+	case MatchFuzzy:
+		match = `lower(ci.NAME_VALUE) % lower($1)`
+	default:
+		return "", errors.New("unknown match: " + q.Match)
+	}
+
+	if q.NameType == "" {
+		return match, nil
+	}
+
+	if !nameTypeRegex.MatchString(q.NameType) {
+		return "", errors.New("invalid name type: " + q.NameType)
+	}
+
+	return `ci.NAME_TYPE = '` + q.NameType + `' AND ` + match, nil
+}
+
+/* plan: the filter for q and the jobs that will crawl it, one for each kind of
+ * name we're after.
+ */
+func (b *DBBackend) plan(ctx context.Context, q Query) (string, []crawlJob, error) {
+	filter, err := q.filter()
+	if err != nil {
+		return "", nil, err
+	}
+
+	schema, err := b.schema(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if q.Match == MatchFuzzy {
+		ok, err := b.trigrams(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			return "", nil, errors.New("fuzzy matching needs the pg_trgm extension, which this database doesn't have")
+		}
+	}
+
+	// I have never liked SQL and these queries are probably shit, but they return
+	// results faster than any of the others I tried by *a lot* and I have no
+	// idea why.
+
+	// A precertificate and its final certificate share an issuer and serial, if
+	// the final one made it into the logs the precertificate adds nothing.
+
+	precerts := ""
+	if !b.KeepPrecerts {
+		precerts = ` AND NOT (x509_hasExtension(c.CERTIFICATE, '` + precertPoison + `', TRUE) AND EXISTS (
+			SELECT 1 FROM certificate c2
+			 WHERE c2.ISSUER_CA_ID = c.ISSUER_CA_ID AND c2.ID != c.ID AND
+				x509_serialNumber(c2.CERTIFICATE) = x509_serialNumber(c.CERTIFICATE)))`
+	}
+
+	// A page is the next pageSize certificates below the cursor and still in the
+	// range ($4 onwards), each job then pulls its names out of them. Those some
+	// other query already read ($5) are left empty.
+
+	certs := `
+	SELECT c.ID, ` + sharedCertificate + `
+	FROM certificate c WHERE c.ID IN (
+		SELECT DISTINCT ci.CERTIFICATE_ID
+		 FROM certificate_identity ci
+		 WHERE ci.ISSUER_CA_ID = $2 AND ` + filter + `
+	 )` + q.Filter.sql() + precerts + ` AND c.ID < $3 AND c.ID >= $4
+	ORDER BY c.ID DESC LIMIT ` + strconv.Itoa(pageSize)
+
+	// This is where this tool gets its name. The gorountines that read the SAN
+	// jobs are called "SANCrawlers". There's one job for each type of SAN we're
+	// after, the CN gets a job of its own.
+
+	var jobs []crawlJob
+
+	types := b.SANTypes
+	if len(types) == 0 {
+		types = []string{"dns"}
+	}
+
+	for _, t := range types {
+		sanType, ok := sanTypes[t]
+		if !ok {
+			return "", nil, errors.New("unknown SAN type: " + t)
+		}
+
+		jobs = append(jobs, crawlJob{field: "SAN", nameType: sanType.nameType,
+			query: namesQuery(`x509_altNames(c.CERTIFICATE, `+strconv.Itoa(sanType.number)+`, TRUE)`, certs)})
+	}
+
+	jobs = append(jobs, crawlJob{field: "CN",
+		query: namesQuery(`x509_nameAttributes(c.CERTIFICATE, 'commonName', TRUE)`, certs)})
+
+	// Email addresses also turn up in the Subject, often an admin contact
+
+	if b.SubjectEmails {
+		jobs = append(jobs, crawlJob{field: "Subject", nameType: TypeEmail,
+			query: namesQuery(`x509_nameAttributes(c.CERTIFICATE, 'emailAddress', TRUE)`, certs)})
+	}
+
+	// Without the x509 functions there's only the one job, it pulls the whole
+	// certificate and readRawRow gets everything out of it.
+
+	if schema == SchemaRaw {
+		jobs = []crawlJob{rawJob(filter)}
+	}
+
+	for i := range jobs {
+		jobs[i].read = new(int64)
+	}
+
+	return filter, jobs, nil
+}
+
+/* loadWork: the CAs with certificates matching filter, less any issuers q's
+ * filter leaves out, and how many crawlers to start with.
+ */
+func (b *DBBackend) loadWork(ctx context.Context, q Query, filter string) ([]crawlerData, int, error) {
+	var (
+		work        []crawlerData
+		numCrawlers int
+		err         error
+	)
+	err = retry(ctx, b.Retries, b.RetryDelay, "Counting certificates", func() error {
+		work, numCrawlers, err = b.loadCrawlerData(ctx, filter, q.Value)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The work is already split up by CA, so issuers we don't want never get
+	// crawled in the first place.
+	kept := work[:0]
+	for _, tmpData := range work {
+		if q.Filter.AllowsIssuer(tmpData.caName) {
+			kept = append(kept, tmpData)
+		}
+	}
+	return kept, numCrawlers, nil
+}
+
+/* maxCrawlers: the most crawlers a query gets, each connection is a crawler at
+ * most so never more than the pool will give us.
+ */
+func (b *DBBackend) maxCrawlers() int {
+	maxCrawlers := b.MaxCrawlers
+	if maxCrawlers <= 0 {
+		maxCrawlers = DefaultMaxCrawlers
+	}
+	if b.MaxOpenConns > 0 && maxCrawlers > b.MaxOpenConns {
+		maxCrawlers = b.MaxOpenConns
+	}
+	return maxCrawlers
+}
+
+/* crawlers: the crawler budget every query shares.
+ */
+func (b *DBBackend) crawlers() *crawlerBudget {
+	b.setupSharing()
+	return b.budget
+}
+
+/* sharing: the certificates queries are sharing, nil unless ShareCertificates
+ * is set.
+ */
+func (b *DBBackend) sharing() *sharedCertificates {
+	b.setupSharing()
+	return b.shared
+}
+
+func (b *DBBackend) setupSharing() {
+	b.sharedOnce.Do(func() {
+		b.budget = &crawlerBudget{max: b.maxCrawlers()}
+		if b.ShareCertificates {
+			b.shared = newSharedCertificates()
+		}
+	})
+}
+
+/* SharedCertificates: how many certificates one query got from another rather
+ * than reading them itself.
+ */
+func (b *DBBackend) SharedCertificates() int {
+	if shared := b.sharing(); shared != nil {
+		return int(atomic.LoadInt64(&shared.reused))
+	}
+	return 0
+}
+
+/* Crawl: Get all the names on certificates selected by the query. If ctx is
+ * cancelled part way through, whatever was collected so far is returned along
+ * with ctx.Err() so the caller can decide what to do with partial results.
+ */
+func (b *DBBackend) Crawl(ctx context.Context, q Query) (Results, error) {
+	return b.CrawlStream(ctx, q, nil)
+}
+
+/* CrawlStream: Crawl, but every new name is also handed to found (if it isn't
+ * nil) as soon as it turns up.
+ */
+func (b *DBBackend) CrawlStream(ctx context.Context, q Query, found func(Result)) (Results, error) {
+	// Anything a previous run already found gets carried over when resuming,
+	// the crawlers skip the pages it came from.
+	state := b.Checkpoint.query(q)
+	ret := b.Checkpoint.results(state)
+	seed := q.Value
+
+	if found != nil {
+		for _, res := range ret {
+			found(res)
+		}
+	}
+
+	filter, jobs, err := b.plan(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Crawlers take their work from the scheduler and put their discovered
+	// domains into domainChan until there is no work left. Once the last crawler
+	// finishes, domainChan gets closed which is how we know we're done.
+
+	work, numCrawlers, err := b.loadWork(ctx, q, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCrawlers := b.maxCrawlers()
+	targetLatency := b.TargetLatency
+	if targetLatency <= 0 {
+		targetLatency = DefaultTargetLatency
+	}
+
+	// Every job reads every certificate
+	for _, tmpData := range work {
+		b.Progress.addTotal(tmpData, len(jobs)*tmpData.certs)
+	}
+
+	// Large CAs get split into ranges of certificate IDs, then every job on
+	// every range is a partition of its own. A resumed crawl has to use the same
+	// ranges as before, which the checkpoint remembers. Each partition picks up
+	// from where the checkpoint got to.
+
+	var parts []partition
+	for _, tmpData := range work {
+		for _, r := range b.Checkpoint.ranges(state, tmpData, splitRange(tmpData, maxCrawlers)) {
+			for _, job := range jobs {
+				parts = append(parts, partition{
+					job:    job,
+					data:   r,
+					cursor: b.Checkpoint.cursor(state, job.key(), r),
+				})
+			}
+		}
+	}
+
+	domainChan := make(chan Result, 10000)
+
+	// The first crawler to hit an error cancels the rest of them, no point carrying
+	// on with a crawl we're going to throw away.
+
+	crawlCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		crawlErr error
+		sched    *scheduler
+	)
+
+	worker := func() {
+		defer wg.Done()
+		if err := b.getNames(crawlCtx, seed, state, sched, domainChan); err != nil {
+			errOnce.Do(func() {
+				crawlErr = err
+				// Being stopped isn't a failure, the others get to finish their pages
+				if !errors.Is(err, ErrStopped) {
+					cancel()
+				}
+			})
+		}
+	}
+
+	sched = newScheduler(parts, numCrawlers*len(jobs), maxCrawlers, targetLatency, b.crawlers(), func() {
+		wg.Add(1)
+		go worker()
+	})
+	sched.start()
+
+	go func() {
+		wg.Wait()
+		close(domainChan)
+	}()
+
+	for tmp := range domainChan {
+		if jobs[0].raw && !q.Filter.Allows(tmp) {
+			continue
+		}
+		if b.Discard {
+			if found != nil {
+				found(tmp)
+			}
+			continue
+		}
+		before := len(ret)
+		ret.add(tmp)
+		b.Progress.addNames(len(ret) - before)
+
+		if found != nil && len(ret) > before {
+			found(tmp)
+		}
+	}
+
+	// Every job reads the same certificates, so the one that got furthest says
+	// how many there were.
+	var scanned int64
+	for _, job := range jobs {
+		if *job.read > scanned {
+			scanned = *job.read
+		}
+	}
+	b.Progress.addScanned(scanned)
+
+	// If we were cancelled from above, report that rather than whatever error the
+	// crawlers tripped over on their way out.
+
+	if err := ctx.Err(); err != nil {
+		return ret, err
+	}
+
+	return ret, crawlErr
+}
+
+/* LookupCertificates: pulls raw certificates out of crt.sh by fingerprint,
+ * serial number or public key and parses them locally.
+ */
+func (b *DBBackend) LookupCertificates(ctx context.Context, kind string, value string) ([]*x509.Certificate, error) {
+	var query string
+
+	switch kind {
+	case LookupFingerprint:
+		query = `SELECT c.CERTIFICATE FROM certificate c WHERE digest(c.CERTIFICATE, 'sha256') = decode($1, 'hex');`
+	case LookupSerial:
+		query = `SELECT c.CERTIFICATE FROM certificate c WHERE x509_serialNumber(c.CERTIFICATE) = decode($1, 'hex');`
+	case LookupSPKI:
+		query = `SELECT c.CERTIFICATE FROM certificate c WHERE digest(x509_publicKey(c.CERTIFICATE), 'sha256') = decode($1, 'hex');`
+	default:
+		return nil, errors.New("unknown lookup kind: " + kind)
+	}
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ders [][]byte
+	for rows.Next() {
+		var der []byte
+		if err := rows.Scan(&der); err != nil {
+			return nil, err
+		}
+		ders = append(ders, der)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return parseCertificates(ders), nil
+}
+
+/* Organizations: counts the distinct Subject Organizations across a set of
+ * crt.sh certificate IDs. The IDs go over in batches so we don't end up with
+ * one enormous query.
+ */
+func (b *DBBackend) Organizations(ctx context.Context, certIDs []int) (map[string]int, error) {
+	query := compactQuery(`
+	SELECT ci.NAME_VALUE, count(DISTINCT ci.CERTIFICATE_ID)
+	 FROM certificate_identity ci
+	 WHERE ci.CERTIFICATE_ID = ANY($1) AND ci.NAME_TYPE = 'organizationName'
+	 GROUP BY ci.NAME_VALUE;`)
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := make(map[string]int)
+
+	for start := 0; start < len(certIDs); start += 1000 {
+		end := start + 1000
+		if end > len(certIDs) {
+			end = len(certIDs)
+		}
+
+		release, err := b.Limiter.acquire(ctx)
+		if err != nil {
+			return orgs, err
+		}
+
+		rows, err := b.query(ctx, db, query, pq.Array(certIDs[start:end]))
+		if err != nil {
+			release()
+			return orgs, err
+		}
+
+		for rows.Next() {
+			var (
+				org   string
+				count int
+			)
+			if err := rows.Scan(&org, &count); err != nil {
+				rows.Close()
+				release()
+				return orgs, err
+			}
+			orgs[org] += count
+		}
+
+		err = rows.Err()
+		rows.Close()
+		release()
+		if err != nil {
+			return orgs, err
+		}
+	}
+
+	return orgs, nil
+}
+
+/* MatchedOrganizations: counts the Subject Organizations on the certificates q
+ * matches, without crawling them. Certificates without an organization are
+ * counted under "".
+ */
+func (b *DBBackend) MatchedOrganizations(ctx context.Context, q Query) (map[string]int, error) {
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	query := compactQuery(`
+	SELECT o.NAME_VALUE, count(DISTINCT ci.CERTIFICATE_ID)
+	 FROM certificate_identity ci
+	 LEFT JOIN certificate_identity o
+		ON o.CERTIFICATE_ID = ci.CERTIFICATE_ID AND o.NAME_TYPE = 'organizationName'
+	 WHERE ` + filter + `
+	 GROUP BY o.NAME_VALUE;`)
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := b.query(ctx, db, query, q.Value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := make(map[string]int)
+	for rows.Next() {
+		var (
+			org   sql.NullString
+			count int
+		)
+		if err := rows.Scan(&org, &count); err != nil {
+			return orgs, err
+		}
+		orgs[org.String] += count
+	}
+
+	return orgs, rows.Err()
+}
+
+/* PrivateCAs: which of the CAs aren't trusted for anything by any of the root
+ * stores crt.sh tracks, along with their names.
+ */
+func (b *DBBackend) PrivateCAs(ctx context.Context, caIDs []int) (map[int]string, error) {
+	query := compactQuery(`
+	SELECT ca.ID, ca.NAME
+	 FROM ca
+	 WHERE ca.ID = ANY($1) AND NOT EXISTS (
+		SELECT 1 FROM ca_trust_purpose ctp WHERE ctp.CA_ID = ca.ID
+	 );`)
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(caIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cas := make(map[int]string)
+	for rows.Next() {
+		var (
+			caID   int
+			caName string
+		)
+		if err := rows.Scan(&caID, &caName); err != nil {
+			return nil, err
+		}
+		cas[caID] = caName
+	}
+
+	return cas, rows.Err()
+}
+
+/* DomainSubjects: counts the subject attributes (other than the CN) on every
+ * certificate with the domain or a wildcard for it. Only certificate_identity
+ * gets used, so this works on mirrors without the x509 functions too.
+ */
+func (b *DBBackend) DomainSubjects(ctx context.Context, domain string) ([]SubjectAttribute, error) {
+	var nameTypes []string
+	for _, nameType := range SubjectFields {
+		if nameType != "commonName" {
+			nameTypes = append(nameTypes, nameType)
+		}
+	}
+
+	query := compactQuery(`
+	SELECT ci2.NAME_TYPE, ci2.NAME_VALUE, count(DISTINCT ci2.CERTIFICATE_ID)
+	 FROM certificate_identity ci2
+	 WHERE ci2.NAME_TYPE = ANY($2) AND ci2.CERTIFICATE_ID IN (
+		SELECT ci.CERTIFICATE_ID
+		 FROM certificate_identity ci
+		 WHERE lower(ci.NAME_VALUE) IN (lower($1), '*.' || lower($1))
+	 )
+	 GROUP BY ci2.NAME_TYPE, ci2.NAME_VALUE;`)
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := b.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, domain, pq.Array(nameTypes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attrs []SubjectAttribute
+	for rows.Next() {
+		var (
+			nameType string
+			attr     SubjectAttribute
+		)
+		if err := rows.Scan(&nameType, &attr.Value, &attr.Certificates); err != nil {
+			return nil, err
+		}
+		attr.Field = fieldForNameType(nameType)
+		attrs = append(attrs, attr)
+	}
+
+	return attrs, rows.Err()
+}
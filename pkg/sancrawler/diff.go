@@ -0,0 +1,66 @@
+package sancrawler
+
+import (
+	"time"
+)
+
+// Diff is what changed between two sets of results.
+type Diff struct {
+	// New names that weren't in the old results at all.
+	New []Result `json:"new"`
+	// Removed names that were in the old results but not the new ones.
+	Removed []Result `json:"removed"`
+	// Reissued names now show up on a newer certificate than before.
+	Reissued []Result `json:"reissued"`
+	// Expired names had their latest known certificate expire since the old
+	// results were collected.
+	Expired []Result `json:"expired"`
+}
+
+/* Empty: whether nothing changed.
+ */
+func (d Diff) Empty() bool {
+	return len(d.New) == 0 && len(d.Removed) == 0 && len(d.Reissued) == 0 && len(d.Expired) == 0
+}
+
+/* Compare: works out what changed between the old and current results. since
+ * is when the old results were collected, and is used to tell a certificate
+ * that expired since then apart from one that had already expired.
+ */
+func Compare(old Results, current Results, since time.Time, now time.Time) Diff {
+	var diff Diff
+
+	expiredSince := func(r Result) bool {
+		return !r.NotAfter.IsZero() && r.NotAfter.After(since) && !r.NotAfter.After(now)
+	}
+
+	for _, res := range current.Sorted() {
+		before, ok := old[res.Name]
+		if !ok {
+			diff.New = append(diff.New, res)
+			continue
+		}
+
+		if res.CertificateID != before.CertificateID && res.NotBefore.After(before.NotBefore) {
+			diff.Reissued = append(diff.Reissued, res)
+		}
+
+		if expiredSince(res) {
+			diff.Expired = append(diff.Expired, res)
+		}
+	}
+
+	for _, res := range old.Sorted() {
+		if _, ok := current[res.Name]; ok {
+			continue
+		}
+
+		diff.Removed = append(diff.Removed, res)
+
+		if expiredSince(res) {
+			diff.Expired = append(diff.Expired, res)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,474 @@
+package sancrawler
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Record types dnsmessage doesn't know about
+const (
+	typeDS     = dnsmessage.Type(43)
+	typeDNSKEY = dnsmessage.Type(48)
+	typeCAA    = dnsmessage.Type(257)
+)
+
+// What DNSAudit.DNSSEC can be. Secure zones are signed and have a DS record in
+// their parent, islands are signed without one so nothing can validate them,
+// and broken ones have a DS record but no keys, which makes validating
+// resolvers refuse to answer for them at all.
+const (
+	DNSSECSecure   = "secure"
+	DNSSECUnsigned = "unsigned"
+	DNSSECIsland   = "island"
+	DNSSECBroken   = "broken"
+)
+
+// CAARecord is a CAA record as published, eg. 0 issue "letsencrypt.org".
+type CAARecord struct {
+	Flags uint8  `json:"flags"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// DNSAudit is how an apex domain's DNS stands up next to what CT says about
+// it. Issuers are the CAs behind the valid certificates we found for names
+// under it, and Unauthorized the ones among them its CAA records don't allow.
+type DNSAudit struct {
+	Apex         string      `json:"apex"`
+	CAA          []CAARecord `json:"caa,omitempty"`
+	DNSSEC       string      `json:"dnssec,omitempty"`
+	Issuers      []string    `json:"issuers"`
+	Unauthorized []string    `json:"unauthorized,omitempty"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// What to look for in the issuer names of CAs whose CAA identifiers don't give
+// them away, mostly CAs that have been bought or run several brands. Anything
+// else is matched on its identifier's first label, so letsencrypt.org matches
+// Let's Encrypt and buypass.com Buypass.
+var caaIssuers = map[string][]string{
+	"comodoca.com":       {"comodo", "sectigo", "usertrust"},
+	"sectigo.com":        {"sectigo", "comodo", "usertrust", "zerossl"},
+	"zerossl.com":        {"zerossl", "sectigo"},
+	"digicert.com":       {"digicert", "geotrust", "thawte", "rapidssl", "symantec", "encryptioneverywhere", "quovadis"},
+	"symantec.com":       {"symantec", "digicert", "geotrust", "thawte", "rapidssl"},
+	"geotrust.com":       {"geotrust", "digicert"},
+	"thawte.com":         {"thawte", "digicert"},
+	"rapidssl.com":       {"rapidssl", "digicert"},
+	"quovadisglobal.com": {"quovadis", "digicert"},
+	"pki.goog":           {"googletrustservices"},
+	"amazon.com":         {"amazon"},
+	"amazontrust.com":    {"amazon"},
+	"awstrust.com":       {"amazon"},
+	"amazonaws.com":      {"amazon"},
+	"godaddy.com":        {"godaddy", "starfield"},
+	"starfieldtech.com":  {"starfield", "godaddy"},
+	"entrust.net":        {"entrust", "affirmtrust"},
+	"globalsign.com":     {"globalsign", "alphassl"},
+	"harica.gr":          {"harica", "hellenicacademic"},
+	"certum.pl":          {"certum", "unizeto", "asseco"},
+	"ssl.com":            {"ssl.com", "sslcorporation"},
+}
+
+// DNSAuditor checks the CAA records and DNSSEC of apex domains against the
+// certificates CT has for them. It asks the servers itself rather than going
+// through the system resolver, which can't look up CAA or DNSSEC records.
+type DNSAuditor struct {
+	// Servers are the recursive DNS servers (host or host:port) to ask. Empty
+	// means the ones in /etc/resolv.conf.
+	Servers []string
+	// Concurrency is how many apexes get checked at once.
+	Concurrency int
+	// Timeout applies to each query.
+	Timeout time.Duration
+}
+
+/* AuditAll: audits every apex domain in results, the ones with unauthorized
+ * issuers first.
+ */
+func (a *DNSAuditor) AuditAll(ctx context.Context, results Results) []DNSAudit {
+	issuers := observedIssuers(results)
+
+	servers := a.Servers
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+
+	concurrency := a.Concurrency
+	if concurrency < 1 {
+		concurrency = 10
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ret []DNSAudit
+	)
+
+	apexes := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for apex := range apexes {
+				audit := a.audit(ctx, servers, apex, issuers[apex])
+				mu.Lock()
+				ret = append(ret, audit)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for apex := range issuers {
+		select {
+		case apexes <- apex:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(apexes)
+	wg.Wait()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i].Unauthorized) != len(ret[j].Unauthorized) {
+			return len(ret[i].Unauthorized) > len(ret[j].Unauthorized)
+		}
+		return ret[i].Apex < ret[j].Apex
+	})
+	return ret
+}
+
+// Whether an issuer was seen on wildcard names, plain ones or both, since CAA
+// can treat them differently
+type issuerUse struct {
+	wildcard bool
+	plain    bool
+}
+
+/* observedIssuers: the CAs behind each apex's valid certificates, going by the
+ * certificate covering each name the longest.
+ */
+func observedIssuers(results Results) map[string]map[string]*issuerUse {
+	ret := make(map[string]map[string]*issuerUse)
+	for _, res := range results {
+		if res.Type != "" {
+			continue
+		}
+		apex := resultApex(res)
+		if apex == "" {
+			continue
+		}
+		if ret[apex] == nil {
+			ret[apex] = make(map[string]*issuerUse)
+		}
+
+		last := latest(res.Latest, res)
+		if last == nil || last.IssuerName == "" || !last.NotAfter.After(time.Now()) {
+			continue
+		}
+
+		use := ret[apex][last.IssuerName]
+		if use == nil {
+			use = &issuerUse{}
+			ret[apex][last.IssuerName] = use
+		}
+		if strings.HasPrefix(res.Name, "*.") {
+			use.wildcard = true
+		} else {
+			use.plain = true
+		}
+	}
+	return ret
+}
+
+/* audit: looks up the apex's CAA records and DNSSEC status, and checks the
+ * issuers against the CAA records.
+ */
+func (a *DNSAuditor) audit(ctx context.Context, servers []string, apex string, issuers map[string]*issuerUse) DNSAudit {
+	audit := DNSAudit{Apex: apex, Issuers: []string{}}
+	for issuer := range issuers {
+		audit.Issuers = append(audit.Issuers, issuer)
+	}
+	sort.Strings(audit.Issuers)
+
+	caa, err := a.lookup(ctx, servers, apex, typeCAA)
+	if err != nil {
+		audit.Error = err.Error()
+		return audit
+	}
+	for _, data := range caa {
+		if record, ok := parseCAA(data); ok {
+			audit.CAA = append(audit.CAA, record)
+		}
+	}
+
+	ds, err := a.lookup(ctx, servers, apex, typeDS)
+	if err != nil {
+		audit.Error = err.Error()
+		return audit
+	}
+	keys, err := a.lookup(ctx, servers, apex, typeDNSKEY)
+	if err != nil {
+		audit.Error = err.Error()
+		return audit
+	}
+	switch {
+	case len(ds) > 0 && len(keys) > 0:
+		audit.DNSSEC = DNSSECSecure
+	case len(ds) > 0:
+		audit.DNSSEC = DNSSECBroken
+	case len(keys) > 0:
+		audit.DNSSEC = DNSSECIsland
+	default:
+		audit.DNSSEC = DNSSECUnsigned
+	}
+
+	for _, issuer := range audit.Issuers {
+		use := issuers[issuer]
+		if (use.plain && !caaAllows(audit.CAA, issuer, false)) || (use.wildcard && !caaAllows(audit.CAA, issuer, true)) {
+			audit.Unauthorized = append(audit.Unauthorized, issuer)
+		}
+	}
+
+	return audit
+}
+
+/* parseCAA: a CAA record's flags, tag and value out of its RDATA.
+ */
+func parseCAA(data []byte) (CAARecord, bool) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return CAARecord{}, false
+	}
+	tagLen := int(data[1])
+	return CAARecord{
+		Flags: data[0],
+		Tag:   strings.ToLower(string(data[2 : 2+tagLen])),
+		Value: string(data[2+tagLen:]),
+	}, true
+}
+
+/* caaAllows: whether the CAA records let the issuer issue, for wildcard names
+ * or otherwise. issuewild takes over from issue for wildcards when there is
+ * one, and no records of either kind means anyone can issue. A record without
+ * an identifier (issue ";") allows nobody.
+ */
+func caaAllows(records []CAARecord, issuer string, wildcard bool) bool {
+	var issue, issuewild []string
+	for _, r := range records {
+		switch r.Tag {
+		case "issue":
+			issue = append(issue, r.Value)
+		case "issuewild":
+			issuewild = append(issuewild, r.Value)
+		}
+	}
+
+	values := issue
+	if wildcard && len(issuewild) > 0 {
+		values = issuewild
+	}
+	if len(issue) == 0 && (!wildcard || len(issuewild) == 0) {
+		return true
+	}
+
+	for _, value := range values {
+		domain := strings.ToLower(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]))
+		if domain != "" && issuerMatches(domain, issuer) {
+			return true
+		}
+	}
+	return false
+}
+
+/* issuerMatches: whether a CAA identifier belongs to the CA with this issuer
+ * name. Comparing ignores case, spaces, hyphens and apostrophes.
+ */
+func issuerMatches(domain string, issuer string) bool {
+	squash := strings.NewReplacer(" ", "", "-", "", "'", "", "’", "")
+	issuer = squash.Replace(strings.ToLower(issuer))
+
+	keywords, ok := caaIssuers[domain]
+	if !ok {
+		label := domain
+		if suffix, _ := publicsuffix.PublicSuffix(domain); suffix != domain {
+			label = strings.TrimSuffix(domain, "."+suffix)
+			label = label[strings.LastIndex(label, ".")+1:]
+		}
+		keywords = []string{label}
+	}
+
+	for _, keyword := range keywords {
+		if strings.Contains(issuer, squash.Replace(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* systemNameservers: the nameservers in /etc/resolv.conf, or localhost if
+ * there are none, like the C library does.
+ */
+func systemNameservers() []string {
+	var servers []string
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, fields[1])
+			}
+		}
+		f.Close()
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1"}
+	}
+	return servers
+}
+
+/* lookup: the RDATA of every record of type qtype for name, trying each server
+ * in turn until one answers. A name that doesn't exist has no records.
+ */
+func (a *DNSAuditor) lookup(ctx context.Context, servers []string, name string, qtype dnsmessage.Type) ([][]byte, error) {
+	var err error
+	for _, server := range servers {
+		if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+
+		var msg *dnsmessage.Message
+		msg, err = a.exchange(ctx, server, name, qtype)
+		if err != nil {
+			continue
+		}
+
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+		default:
+			err = errors.New("DNS server answered " + msg.RCode.String())
+			continue
+		}
+
+		var ret [][]byte
+		for _, answer := range msg.Answers {
+			if answer.Header.Type != qtype {
+				continue
+			}
+			if body, ok := answer.Body.(*dnsmessage.UnknownResource); ok {
+				ret = append(ret, body.Data)
+			}
+		}
+		return ret, nil
+	}
+	return nil, err
+}
+
+/* exchange: sends a single query with the DNSSEC OK bit set, over UDP and then
+ * TCP if the answer didn't fit.
+ */
+func (a *DNSAuditor) exchange(ctx context.Context, server string, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+
+	id := uint16(rand.Intn(1 << 16))
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET})
+	b.StartAdditionals()
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true)
+	b.OPTResource(opt, dnsmessage.OPTResource{})
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		d   net.Dialer
+		msg *dnsmessage.Message
+	)
+	for _, network := range []string{"udp", "tcp"} {
+		conn, err := d.DialContext(ctx, network, server)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+
+		answer, err := dnsRoundTrip(conn, network, query)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		msg = &dnsmessage.Message{}
+		if err := msg.Unpack(answer); err != nil {
+			return nil, err
+		}
+		if msg.ID != id {
+			return nil, errors.New("DNS answer doesn't match the query")
+		}
+		if !msg.Truncated {
+			break
+		}
+	}
+	return msg, nil
+}
+
+/* dnsRoundTrip: writes the query and reads the answer, TCP messages going with
+ * their length in front.
+ */
+func dnsRoundTrip(conn net.Conn, network string, query []byte) ([]byte, error) {
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		return buf[:n], err
+	}
+
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+/* readTCPMessage: reads one DNS message off a TCP connection.
+ */
+func readTCPMessage(conn net.Conn) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err := io.ReadFull(conn, buf)
+	return buf, err
+}
@@ -0,0 +1,301 @@
+package sancrawler
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSEnumerator finds more names for the apex domains we already have by
+// asking DNS: their nameservers and mail servers, what their SPF and DMARC
+// records point at, and whole zones when a nameserver allows transfers.
+type DNSEnumerator struct {
+	// Resolver does the everyday lookups, NS, MX and TXT.
+	Resolver *Resolver
+	// Concurrency is how many apexes get enumerated at once.
+	Concurrency int
+	// Timeout applies to each zone transfer.
+	Timeout time.Duration
+}
+
+// ZoneTransfer is a nameserver that handed over a whole zone, which it almost
+// certainly shouldn't have.
+type ZoneTransfer struct {
+	Apex       string
+	Nameserver string
+	Records    int
+}
+
+/* EnumerateAll: the names DNS turns up for every apex domain in results, only
+ * keeping those under one of the apexes, and the zone transfers that worked.
+ * New names come from Source "dns" with Field saying which record they were
+ * in (NS, MX, SPF, DMARC or AXFR), and carry the seeds of the names under
+ * their apex.
+ */
+func (e *DNSEnumerator) EnumerateAll(ctx context.Context, results Results) (Results, []ZoneTransfer) {
+	seeds := make(map[string][]string)
+	for _, res := range results {
+		if apex := resultApex(res); apex != "" && res.Type == "" {
+			for _, seed := range res.Seeds {
+				if !containsString(seeds[apex], seed) {
+					seeds[apex] = append(seeds[apex], seed)
+				}
+			}
+			if seeds[apex] == nil {
+				seeds[apex] = []string{}
+			}
+		}
+	}
+
+	concurrency := e.Concurrency
+	if concurrency < 1 {
+		concurrency = 10
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		found     = make(Results)
+		transfers []ZoneTransfer
+	)
+
+	add := func(name string, field string) {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		apex := resultApex(Result{Name: name})
+		if _, ok := seeds[apex]; !ok {
+			return
+		}
+		if _, ok := results[name]; ok {
+			return
+		}
+
+		mu.Lock()
+		found.add(Result{Name: name, Field: field, Source: "dns", Seeds: seeds[apex]})
+		mu.Unlock()
+	}
+
+	apexes := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for apex := range apexes {
+				if transfer := e.enumerate(ctx, apex, add); transfer != nil {
+					mu.Lock()
+					transfers = append(transfers, *transfer)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for apex := range seeds {
+		select {
+		case apexes <- apex:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(apexes)
+	wg.Wait()
+
+	return found, transfers
+}
+
+/* enumerate: everything DNS knows about one apex, handed to add as it turns
+ * up. Returns the first zone transfer that worked, if any did.
+ */
+func (e *DNSEnumerator) enumerate(ctx context.Context, apex string, add func(string, string)) *ZoneTransfer {
+	res := e.Resolver.resolver()
+
+	var transfer *ZoneTransfer
+	if nss, err := res.LookupNS(ctx, apex); err == nil {
+		for _, ns := range nss {
+			add(ns.Host, "NS")
+			if transfer != nil {
+				continue
+			}
+
+			// Any nameserver that allows it hands over the same zone
+			if records, err := e.transfer(ctx, res, ns.Host, apex, add); err == nil {
+				transfer = &ZoneTransfer{Apex: apex, Nameserver: strings.TrimSuffix(ns.Host, "."), Records: records}
+			}
+		}
+	}
+
+	if mxs, err := res.LookupMX(ctx, apex); err == nil {
+		for _, mx := range mxs {
+			add(mx.Host, "MX")
+		}
+	}
+
+	if txts, err := res.LookupTXT(ctx, apex); err == nil {
+		for _, txt := range txts {
+			for _, host := range spfHosts(txt) {
+				add(host, "SPF")
+			}
+		}
+	}
+
+	if txts, err := res.LookupTXT(ctx, "_dmarc."+apex); err == nil {
+		for _, txt := range txts {
+			for _, host := range dmarcHosts(txt) {
+				add(host, "DMARC")
+			}
+		}
+	}
+
+	return transfer
+}
+
+/* spfHosts: the hosts an SPF record names in its a, mx, include, exists and
+ * redirect terms. Anything with macros in it is skipped.
+ */
+func spfHosts(txt string) []string {
+	fields := strings.Fields(strings.ToLower(txt))
+	if len(fields) == 0 || fields[0] != "v=spf1" {
+		return nil
+	}
+
+	var hosts []string
+	for _, term := range fields[1:] {
+		term = strings.TrimLeft(term, "+-~?")
+
+		var host string
+		for _, prefix := range []string{"include:", "a:", "mx:", "exists:", "redirect="} {
+			if strings.HasPrefix(term, prefix) {
+				host = strings.TrimPrefix(term, prefix)
+				break
+			}
+		}
+		if i := strings.Index(host, "/"); i >= 0 {
+			host = host[:i]
+		}
+		if host != "" && !strings.Contains(host, "%") {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+/* dmarcHosts: the domains of the mailto addresses reports get sent to.
+ */
+func dmarcHosts(txt string) []string {
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(txt)), "v=dmarc1") {
+		return nil
+	}
+
+	var hosts []string
+	for _, tag := range strings.Split(txt, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if !ok || (key != "rua" && key != "ruf") {
+			continue
+		}
+		for _, uri := range strings.Split(value, ",") {
+			uri = strings.TrimSpace(uri)
+			if i := strings.LastIndex(uri, "@"); i >= 0 && strings.HasPrefix(strings.ToLower(uri), "mailto:") {
+				host := uri[i+1:]
+				if j := strings.Index(host, "!"); j >= 0 {
+					host = host[:j]
+				}
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+/* transfer: asks a nameserver for the whole zone (AXFR), handing every owner
+ * name and target in it to add. Returns how many records came back.
+ */
+func (e *DNSEnumerator) transfer(ctx context.Context, res *net.Resolver, nameserver string, apex string, add func(string, string)) (int, error) {
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addrs, err := res.LookupHost(ctx, nameserver)
+	if err != nil || len(addrs) == 0 {
+		return 0, errors.New("could not resolve nameserver")
+	}
+
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(apex, ".") + ".")
+	if err != nil {
+		return 0, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeAXFR, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		return 0, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0], "53"))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return 0, err
+	}
+
+	// The zone comes back over as many messages as it takes, starting and
+	// ending with its SOA record
+	records, soas := 0, 0
+	for soas < 2 {
+		answer, err := readTCPMessage(conn)
+		if err != nil {
+			return 0, err
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(answer); err != nil {
+			return 0, err
+		}
+		if msg.ID != id || msg.RCode != dnsmessage.RCodeSuccess || len(msg.Answers) == 0 {
+			return 0, errors.New("zone transfer refused")
+		}
+
+		for _, rr := range msg.Answers {
+			records++
+			if rr.Header.Type == dnsmessage.TypeSOA {
+				soas++
+				continue
+			}
+			add(rr.Header.Name.String(), "AXFR")
+
+			switch body := rr.Body.(type) {
+			case *dnsmessage.CNAMEResource:
+				add(body.CNAME.String(), "AXFR")
+			case *dnsmessage.MXResource:
+				add(body.MX.String(), "AXFR")
+			case *dnsmessage.NSResource:
+				add(body.NS.String(), "AXFR")
+			case *dnsmessage.SRVResource:
+				add(body.Target.String(), "AXFR")
+			}
+		}
+	}
+
+	return records, nil
+}
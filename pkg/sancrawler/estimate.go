@@ -0,0 +1,127 @@
+package sancrawler
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Estimate is what crawling a query would take, worked out from counting its
+// certificates and timing a single page instead of reading all of them.
+type Estimate struct {
+	Seed string `json:"seed"`
+	// Certificates is how many certificates match, across every CA in CAs.
+	Certificates int          `json:"certificates"`
+	CAs          []EstimateCA `json:"cas"`
+	// Jobs is how many times every certificate gets read, once for each kind
+	// of name being collected.
+	Jobs int `json:"jobs"`
+	// Pages is how many pages the crawl will read, every job on every range of
+	// every CA.
+	Pages int `json:"pages"`
+	// Crawlers is how many crawlers the scheduler starts with and MaxCrawlers
+	// how many it can grow to.
+	Crawlers    int `json:"crawlers"`
+	MaxCrawlers int `json:"max_crawlers"`
+	// PageTime is how long the sample page took, zero if there was nothing to
+	// sample. Duration is PageTime for every page, shared between MaxCrawlers.
+	PageTime time.Duration `json:"-"`
+	Duration time.Duration `json:"-"`
+}
+
+// EstimateCA is one of the issuers a query's certificates came from.
+type EstimateCA struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Certificates int    `json:"certificates"`
+}
+
+/* Estimate: how many certificates and CAs crawling q would read, how many
+ * crawlers it would use and roughly how long it would take, without pulling
+ * the names. The time comes from reading one page of the biggest CA, so it
+ * assumes every page is like that one and that the crawlers never wait on each
+ * other. Take it as a ballpark, crt.sh's load changes by the minute.
+ */
+func (b *DBBackend) Estimate(ctx context.Context, q Query) (Estimate, error) {
+	est := Estimate{Seed: q.Value}
+
+	filter, jobs, err := b.plan(ctx, q)
+	if err != nil {
+		return est, err
+	}
+
+	work, numCrawlers, err := b.loadWork(ctx, q, filter)
+	if err != nil {
+		return est, err
+	}
+
+	sort.SliceStable(work, func(i, j int) bool {
+		return work[i].certs > work[j].certs
+	})
+
+	// The same ranges and partitions the crawl would use, see CrawlStream
+	maxCrawlers := b.maxCrawlers()
+	parts := 0
+	for _, tmpData := range work {
+		est.Certificates += tmpData.certs
+		est.CAs = append(est.CAs, EstimateCA{ID: tmpData.caID, Name: tmpData.caName, Certificates: tmpData.certs})
+
+		for _, r := range splitRange(tmpData, maxCrawlers) {
+			parts += len(jobs)
+			est.Pages += len(jobs) * ((r.certs + pageSize - 1) / pageSize)
+		}
+	}
+
+	est.Jobs = len(jobs)
+	est.MaxCrawlers = maxCrawlers
+	if est.MaxCrawlers > parts {
+		est.MaxCrawlers = parts
+	}
+	est.Crawlers = numCrawlers * len(jobs)
+	if est.Crawlers > est.MaxCrawlers {
+		est.Crawlers = est.MaxCrawlers
+	}
+
+	if len(work) == 0 {
+		return est, nil
+	}
+
+	est.PageTime, err = b.samplePage(ctx, q.Value, jobs[0], work[0])
+	if err != nil {
+		return est, err
+	}
+	est.Duration = est.PageTime * time.Duration(est.Pages) / time.Duration(est.MaxCrawlers)
+
+	return est, nil
+}
+
+/* samplePage: reads the newest page of tmpData for job, throwing the names
+ * away, and returns how long it took.
+ */
+func (b *DBBackend) samplePage(ctx context.Context, seed string, job crawlJob, tmpData crawlerData) (time.Duration, error) {
+	db, err := b.pool(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	outChan := make(chan Result, 100)
+	drained := make(chan struct{})
+	go func() {
+		for range outChan {
+		}
+		close(drained)
+	}()
+
+	var took time.Duration
+	err = retry(ctx, b.Retries, b.RetryDelay, "Sampling a page", func() error {
+		began := time.Now()
+		_, _, err := b.getPage(ctx, db, job, seed, nil, tmpData, tmpData.stop, outChan)
+		took = time.Since(began)
+		return err
+	})
+
+	close(outChan)
+	<-drained
+
+	return took, err
+}
@@ -0,0 +1,95 @@
+package sancrawler
+
+import (
+	"sort"
+	"time"
+)
+
+// ExpiringIssuer is a CA with certificates that run out soon, soonest first.
+type ExpiringIssuer struct {
+	IssuerCAID   int                   `json:"issuer_ca_id"`
+	IssuerName   string                `json:"issuer_name"`
+	Names        int                   `json:"names"`
+	Certificates []ExpiringCertificate `json:"certificates"`
+}
+
+// ExpiringCertificate is a certificate that runs out soon along with the names
+// nothing else covers for longer.
+type ExpiringCertificate struct {
+	ID       int       `json:"id"`
+	NotAfter time.Time `json:"not_after"`
+	Names    []string  `json:"names"`
+}
+
+/* ExpiringBefore: the results whose latest certificate is still valid but runs
+ * out before deadline. Names covered by a certificate that runs past it, like
+ * one that has already been renewed, are left out.
+ */
+func ExpiringBefore(results Results, deadline time.Time) Results {
+	now := time.Now()
+	ret := make(Results)
+	for name, res := range results {
+		last := latest(res.Latest, res)
+		if last == nil || !last.NotAfter.After(now) || last.NotAfter.After(deadline) {
+			continue
+		}
+		ret[name] = res
+	}
+	return ret
+}
+
+/* GroupExpiring: the results grouped by the CA and certificate that last
+ * covers each of them, the issuers with the soonest expiry first.
+ */
+func GroupExpiring(results Results) []ExpiringIssuer {
+	issuers := make(map[int]*ExpiringIssuer)
+	certs := make(map[int]*ExpiringCertificate)
+	certIssuer := make(map[int]int)
+
+	for _, res := range results.Sorted() {
+		last := latest(res.Latest, res)
+		if last == nil {
+			continue
+		}
+
+		issuer := issuers[last.IssuerCAID]
+		if issuer == nil {
+			issuer = &ExpiringIssuer{IssuerCAID: last.IssuerCAID, IssuerName: last.IssuerName}
+			issuers[last.IssuerCAID] = issuer
+		}
+		issuer.Names++
+
+		cert := certs[last.ID]
+		if cert == nil {
+			cert = &ExpiringCertificate{ID: last.ID, NotAfter: last.NotAfter}
+			certs[last.ID] = cert
+			certIssuer[last.ID] = last.IssuerCAID
+		}
+		cert.Names = append(cert.Names, res.Name)
+	}
+
+	for id, cert := range certs {
+		issuer := issuers[certIssuer[id]]
+		issuer.Certificates = append(issuer.Certificates, *cert)
+	}
+
+	var ret []ExpiringIssuer
+	for _, issuer := range issuers {
+		sort.Slice(issuer.Certificates, func(i, j int) bool {
+			a, b := issuer.Certificates[i], issuer.Certificates[j]
+			if !a.NotAfter.Equal(b.NotAfter) {
+				return a.NotAfter.Before(b.NotAfter)
+			}
+			return a.ID < b.ID
+		})
+		ret = append(ret, *issuer)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i].Certificates[0].NotAfter, ret[j].Certificates[0].NotAfter
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return ret[i].IssuerName < ret[j].IssuerName
+	})
+	return ret
+}
@@ -0,0 +1,230 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultFetchTimeout is how long FetchCertificate waits on the server when
+// FetchOptions.Timeout is zero.
+const DefaultFetchTimeout = 30 * time.Second
+
+// FetchOptions are how FetchCertificate connects to the server.
+type FetchOptions struct {
+	// Proxy, if set, is what the connection goes through.
+	Proxy *Proxy
+	// Insecure skips verifying the certificate, which is needed for self
+	// signed and internal ones. It's only being read, so nothing is lost.
+	Insecure bool
+	// ServerName is the SNI to send, the host being connected to when empty.
+	ServerName string
+	// Port overrides the port in the URL.
+	Port string
+	// Timeout covers connecting, the handshake and for URLs the request.
+	Timeout time.Duration
+	// StartTLS connects in plain text and upgrades, for mail and chat servers
+	// (smtp://, imap://, pop3://, ftp:// and xmpp:// URLs, or just a host and
+	// one of their usual ports).
+	StartTLS bool
+}
+
+/* ExtractOrganization: Attempts to automatically extract the organization field
+ * from any x509 certificates detected from trying a TLS connection to the URL
+ * specified. Only the first one if there are several, see ExtractOrganizations.
+ */
+func ExtractOrganization(ctx context.Context, url string, opts FetchOptions) (string, error) {
+	orgs, err := ExtractOrganizations(ctx, url, opts)
+	if err != nil {
+		return "", err
+	}
+	return orgs[0], nil
+}
+
+/* ExtractOrganizations: ExtractOrganization, but returns every organization on
+ * the certificate. Some have a few (a parent company and a subsidiary, or the
+ * same name in two languages) and there's no telling which is the useful one.
+ */
+func ExtractOrganizations(ctx context.Context, url string, opts FetchOptions) ([]string, error) {
+	cert, err := FetchCertificate(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgs []string
+	for _, o := range cert.Subject.Organization {
+		if o = strings.TrimSpace(o); o != "" && !containsString(orgs, o) {
+			orgs = append(orgs, o)
+		}
+	}
+
+	if len(orgs) < 1 {
+		return nil, errors.New("URL provided does not contain an organization")
+	}
+	return orgs, nil
+}
+
+/* tlsConfig: the TLS settings opts asks for.
+ */
+func (opts FetchOptions) tlsConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: opts.Insecure,
+		ServerName:         opts.ServerName,
+	}
+}
+
+/* FetchCertificate: connects to the URL and returns the certificate the server
+ * presented. Anything without a scheme (mail.example.com:465) is taken as a
+ * host and port to do a bare TLS handshake with, for services that don't speak
+ * HTTP, 443 if there's no port.
+ */
+func FetchCertificate(ctx context.Context, rawURL string, opts FetchOptions) (*x509.Certificate, error) {
+	chain, err := FetchChain(ctx, rawURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0th element is always the last certificate in the chain, which is the one that
+	// we want to examine.
+	return chain[0], nil
+}
+
+/* FetchChain: FetchCertificate, but returns the whole chain the server
+ * presented, leaf first.
+ */
+func FetchChain(ctx context.Context, rawURL string, opts FetchOptions) ([]*x509.Certificate, error) {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if opts.StartTLS {
+		return fetchStartTLS(ctx, rawURL, opts)
+	}
+
+	if !strings.Contains(rawURL, "://") {
+		host, port, err := net.SplitHostPort(rawURL)
+		if err != nil {
+			host, port = rawURL, "443"
+		}
+		if opts.Port != "" {
+			port = opts.Port
+		}
+		return handshake(ctx, net.JoinHostPort(host, port), host, "", opts)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Port != "" {
+		u.Host = net.JoinHostPort(u.Hostname(), opts.Port)
+	}
+
+	transport := opts.Proxy.Transport()
+	transport.TLSClientConfig = opts.tlsConfig()
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Akamai and other WAFs will block our requests if we aren't using a standard
+	// User-Agent string.
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.111 Safari/537.36")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New("could not connect to URL provided: " + err.Error())
+	}
+	res.Body.Close()
+
+	if res.TLS == nil || len(res.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("URL provided does not use TLS")
+	}
+
+	return res.TLS.PeerCertificates, nil
+}
+
+/* fetchStartTLS: FetchChain for servers that need STARTTLS, where the URL's
+ * scheme (or failing that its port) says which protocol to speak.
+ */
+func fetchStartTLS(ctx context.Context, rawURL string, opts FetchOptions) ([]*x509.Certificate, error) {
+	scheme, host, port := "", rawURL, ""
+	if strings.Contains(rawURL, "://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		scheme, host, port = u.Scheme, u.Hostname(), u.Port()
+	} else if h, p, err := net.SplitHostPort(rawURL); err == nil {
+		host, port = h, p
+	}
+	if opts.Port != "" {
+		port = opts.Port
+	}
+
+	proto, err := startTLSProtocol(scheme, port)
+	if err != nil {
+		return nil, err
+	}
+	if port == "" {
+		port = StartTLSPorts[proto]
+	}
+
+	return handshake(ctx, net.JoinHostPort(host, port), host, proto, opts)
+}
+
+/* handshake: connects to address and does a TLS handshake, nothing more, and
+ * returns the chain the server presented. With a STARTTLS protocol the
+ * connection is upgraded first.
+ */
+func handshake(ctx context.Context, address string, host string, proto string, opts FetchOptions) ([]*x509.Certificate, error) {
+	dial := (&net.Dialer{}).DialContext
+	if opts.Proxy != nil {
+		dial = opts.Proxy.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.New("could not connect to " + address + ": " + err.Error())
+	}
+	defer conn.Close()
+
+	if proto != "" {
+		if err := startTLS(ctx, conn, proto, host); err != nil {
+			return nil, errors.New(address + ": " + err.Error())
+		}
+	}
+
+	config := opts.tlsConfig()
+	if config.ServerName == "" && net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, errors.New("TLS handshake with " + address + " failed: " + err.Error())
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, errors.New(address + " presented no certificate")
+	}
+	return state.PeerCertificates, nil
+}
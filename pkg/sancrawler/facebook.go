@@ -0,0 +1,174 @@
+package sancrawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFacebookURL is the certificate search endpoint of Facebook's CT
+// Monitoring Graph API.
+const DefaultFacebookURL = "https://graph.facebook.com/v19.0/certificates"
+
+// FacebookBackend searches Facebook's Certificate Transparency Monitoring
+// dataset, which follows the CT logs independently of crt.sh. It can only
+// search by domain, and returns certificates for the domain and everything
+// under it, so it only does anything with exact DNS name queries.
+type FacebookBackend struct {
+	// BaseURL is the certificate search endpoint.
+	BaseURL string
+	// AccessToken is an app token, either one generated for the app or
+	// APPID|APPSECRET.
+	AccessToken string
+	// MaxPages caps how many pages of certificates get pulled, 0 means no
+	// limit.
+	MaxPages int
+	Client   *http.Client
+}
+
+// Facebook's timestamps have no colon in the offset (+0000), which
+// encoding/json won't take.
+type facebookTime struct {
+	time.Time
+}
+
+type facebookResponse struct {
+	Data []struct {
+		Domains    []string     `json:"domains"`
+		IssuerName string       `json:"issuer_name"`
+		NotBefore  facebookTime `json:"not_valid_before"`
+		NotAfter   facebookTime `json:"not_valid_after"`
+	} `json:"data"`
+	Paging struct {
+		Next string `json:"next"`
+	} `json:"paging"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+/* UnmarshalJSON: parses a Graph API timestamp.
+ */
+func (t *facebookTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+
+	parsed, err := time.Parse("2006-01-02T15:04:05-0700", s)
+	if err != nil {
+		if parsed, err = time.Parse(time.RFC3339, s); err != nil {
+			return err
+		}
+	}
+	t.Time = parsed
+	return nil
+}
+
+/* NewFacebookBackend: returns a FacebookBackend using the given app token.
+ */
+func NewFacebookBackend(accessToken string) *FacebookBackend {
+	return &FacebookBackend{
+		BaseURL:     DefaultFacebookURL,
+		AccessToken: accessToken,
+		Client:      &http.Client{Timeout: time.Minute},
+	}
+}
+
+/* searchDomain: the domain to search for, for sources that can only search by
+ * domain. Empty if the query isn't an exact match on a domain name.
+ */
+func searchDomain(q Query) string {
+	if q.Match != MatchExact || (q.NameType != "" && q.NameType != "dNSName") {
+		return ""
+	}
+
+	domain := strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(q.Value)), "*."), ".")
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, " \t/@") {
+		return ""
+	}
+	return domain
+}
+
+/* Crawl: Get all the names on certificates for the query's domain and its
+ * subdomains, following the paging links until Facebook runs out of pages or
+ * MaxPages is hit. Queries that aren't for a domain are skipped.
+ */
+func (b *FacebookBackend) Crawl(ctx context.Context, q Query) (Results, error) {
+	if b.AccessToken == "" {
+		return nil, errors.New("Facebook access token is required")
+	}
+
+	ret := make(Results)
+
+	domain := searchDomain(q)
+	if domain == "" {
+		log.WithFields(log.Fields{
+			"Seed": q.Value,
+		}).Debug("Facebook can only search for domains, skipping")
+		return ret, nil
+	}
+
+	values := url.Values{}
+	values.Set("query", domain)
+	values.Set("fields", "domains,issuer_name,not_valid_before,not_valid_after")
+	values.Set("limit", "500")
+	values.Set("access_token", b.AccessToken)
+	next := b.BaseURL + "?" + values.Encode()
+
+	for page := 0; next != "" && (b.MaxPages == 0 || page < b.MaxPages); page++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", next, nil)
+		if err != nil {
+			return ret, err
+		}
+
+		res, err := b.Client.Do(req)
+		if err != nil {
+			return ret, err
+		}
+
+		var body facebookResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			msg := ""
+			if body.Error != nil {
+				msg = body.Error.Message
+			}
+			return ret, fmt.Errorf("Facebook API returned %s: %s", res.Status, msg)
+		}
+		if err != nil {
+			return ret, err
+		}
+
+		// Like Censys, there's no telling the CN apart from the SANs
+		for _, cert := range body.Data {
+			for _, name := range cert.Domains {
+				ret.add(Result{
+					Name:       strings.ToLower(name),
+					IssuerName: cert.IssuerName,
+					Field:      "SAN",
+					Source:     "facebook",
+					NotBefore:  cert.NotBefore.Time,
+					NotAfter:   cert.NotAfter.Time,
+					Expired:    expired(cert.NotAfter.Time),
+				})
+			}
+		}
+
+		next = body.Paging.Next
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,168 @@
+package sancrawler
+
+import (
+	"strings"
+	"time"
+)
+
+// Which certificates to keep depending on whether they have expired
+const (
+	ExpiryAny = iota
+	ExpiryExclude
+	ExpiryOnly
+)
+
+// CertFilter narrows down which certificates names get pulled from. Backends
+// that can do the filtering on their end (like the database) do, anything
+// left over gets filtered once the results are back. The zero value lets
+// everything through.
+type CertFilter struct {
+	// Expiry is one of ExpiryAny, ExpiryExclude or ExpiryOnly.
+	Expiry int
+	// Since and Until bound when certificates were issued (their notBefore),
+	// either can be left at the zero time.
+	Since time.Time
+	Until time.Time
+	// IssuerInclude and IssuerExclude match the issuing CA's name, ignoring
+	// case, against substrings like "DigiCert" or "Let's Encrypt". When
+	// IssuerInclude is set only matching issuers are kept.
+	IssuerInclude []string
+	IssuerExclude []string
+}
+
+/* IsZero: whether the filter lets everything through.
+ */
+func (f CertFilter) IsZero() bool {
+	return f.Expiry == ExpiryAny && f.Since.IsZero() && f.Until.IsZero() &&
+		len(f.IssuerInclude) == 0 && len(f.IssuerExclude) == 0
+}
+
+/* AllowsIssuer: whether certificates from the named CA pass the filter. Names
+ * we don't know the issuer of only get through when there's no include list.
+ */
+func (f CertFilter) AllowsIssuer(issuer string) bool {
+	issuer = strings.ToLower(issuer)
+
+	for _, exclude := range f.IssuerExclude {
+		if issuer != "" && strings.Contains(issuer, strings.ToLower(exclude)) {
+			return false
+		}
+	}
+
+	if len(f.IssuerInclude) == 0 {
+		return true
+	}
+
+	for _, include := range f.IssuerInclude {
+		if issuer != "" && strings.Contains(issuer, strings.ToLower(include)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Allows: whether a result passes the filter. Results we don't know the expiry
+ * of are never counted as expired, and ones we don't know the issue date of
+ * are let through.
+ */
+func (f CertFilter) Allows(res Result) bool {
+	switch f.Expiry {
+	case ExpiryExclude:
+		if res.Expired {
+			return false
+		}
+	case ExpiryOnly:
+		if !res.Expired {
+			return false
+		}
+	}
+
+	if !f.AllowsIssuer(res.IssuerName) {
+		return false
+	}
+
+	// Same goes for when it was issued
+	if !res.NotBefore.IsZero() {
+		if !f.Since.IsZero() && res.NotBefore.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !res.NotBefore.Before(f.Until) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* Filter: returns only the results that pass the filter.
+ */
+func (f CertFilter) Filter(results Results) Results {
+	if f.IsZero() {
+		return results
+	}
+
+	ret := make(Results)
+	for name, res := range results {
+		if f.Allows(res) {
+			ret[name] = res
+		}
+	}
+	return ret
+}
+
+/* sql: the filter as a condition on the certificate table (aliased c), or an
+ * empty string if there's nothing to filter on.
+ */
+func (f CertFilter) sql() string {
+	var cond string
+
+	switch f.Expiry {
+	case ExpiryExclude:
+		cond += ` AND x509_notAfter(c.CERTIFICATE) >= now() AT TIME ZONE 'UTC'`
+	case ExpiryOnly:
+		cond += ` AND x509_notAfter(c.CERTIFICATE) < now() AT TIME ZONE 'UTC'`
+	}
+
+	// These are formatted by us so they're safe to paste in
+	if !f.Since.IsZero() {
+		cond += ` AND x509_notBefore(c.CERTIFICATE) >= '` + sqlTime(f.Since) + `'`
+	}
+	if !f.Until.IsZero() {
+		cond += ` AND x509_notBefore(c.CERTIFICATE) < '` + sqlTime(f.Until) + `'`
+	}
+
+	return cond
+}
+
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+/* key: a short description of the filter for telling checkpointed queries
+ * apart, empty for the zero filter so older checkpoints still match.
+ */
+func (f CertFilter) key() string {
+	var key string
+
+	switch f.Expiry {
+	case ExpiryExclude:
+		key += "/unexpired"
+	case ExpiryOnly:
+		key += "/expired"
+	}
+
+	if !f.Since.IsZero() {
+		key += "/since=" + f.Since.UTC().Format(time.RFC3339)
+	}
+	if !f.Until.IsZero() {
+		key += "/until=" + f.Until.UTC().Format(time.RFC3339)
+	}
+	if len(f.IssuerInclude) > 0 {
+		key += "/issuer=" + strings.Join(f.IssuerInclude, ",")
+	}
+	if len(f.IssuerExclude) > 0 {
+		key += "/not-issuer=" + strings.Join(f.IssuerExclude, ",")
+	}
+
+	return key
+}
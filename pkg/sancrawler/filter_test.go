@@ -0,0 +1,127 @@
+package sancrawler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterExpiry(t *testing.T) {
+	live := Result{Name: "live.example.com", Expired: false}
+	dead := Result{Name: "dead.example.com", Expired: true}
+
+	tests := []struct {
+		expiry int
+		live   bool
+		dead   bool
+		sql    string
+	}{
+		{ExpiryAny, true, true, ""},
+		{ExpiryExclude, true, false, "x509_notAfter(c.CERTIFICATE) >= now()"},
+		{ExpiryOnly, false, true, "x509_notAfter(c.CERTIFICATE) < now()"},
+	}
+
+	for _, tt := range tests {
+		f := CertFilter{Expiry: tt.expiry}
+		if got := f.Allows(live); got != tt.live {
+			t.Errorf("expiry %d: Allows(live) = %v, want %v", tt.expiry, got, tt.live)
+		}
+		if got := f.Allows(dead); got != tt.dead {
+			t.Errorf("expiry %d: Allows(dead) = %v, want %v", tt.expiry, got, tt.dead)
+		}
+		if sql := f.sql(); !strings.Contains(sql, tt.sql) || (tt.sql == "") != (sql == "") {
+			t.Errorf("expiry %d: sql() = %q, want it to have %q", tt.expiry, sql, tt.sql)
+		}
+
+		kept := f.Filter(Results{live.Name: live, dead.Name: dead})
+		if _, ok := kept[live.Name]; ok != tt.live {
+			t.Errorf("expiry %d: Filter kept live = %v, want %v", tt.expiry, ok, tt.live)
+		}
+		if _, ok := kept[dead.Name]; ok != tt.dead {
+			t.Errorf("expiry %d: Filter kept dead = %v, want %v", tt.expiry, ok, tt.dead)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	if expired(time.Time{}) {
+		t.Error("an unknown expiry counted as expired")
+	}
+	if !expired(time.Now().Add(-time.Hour)) {
+		t.Error("an hour ago didn't count as expired")
+	}
+	if expired(time.Now().Add(time.Hour)) {
+		t.Error("an hour from now counted as expired")
+	}
+}
+
+func TestAllowsIssuer(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		issuer  string
+		want    bool
+	}{
+		{"no lists", nil, nil, "C=US, O=Let's Encrypt, CN=R3", true},
+		{"no lists, unknown issuer", nil, nil, "", true},
+		{"included", []string{"let's encrypt"}, nil, "C=US, O=Let's Encrypt, CN=R3", true},
+		{"not included", []string{"DigiCert"}, nil, "C=US, O=Let's Encrypt, CN=R3", false},
+		{"one of several", []string{"DigiCert", "Sectigo"}, nil, "CN=Sectigo RSA Domain Validation", true},
+		{"unknown with an include list", []string{"DigiCert"}, nil, "", false},
+		{"excluded", nil, []string{"LET'S ENCRYPT"}, "C=US, O=Let's Encrypt, CN=R3", false},
+		{"not excluded", nil, []string{"DigiCert"}, "C=US, O=Let's Encrypt, CN=R3", true},
+		{"unknown with an exclude list", nil, []string{"DigiCert"}, "", true},
+		{"exclude wins", []string{"DigiCert"}, []string{"EV"}, "CN=DigiCert EV RSA CA G2", false},
+	}
+
+	for _, tt := range tests {
+		f := CertFilter{IssuerInclude: tt.include, IssuerExclude: tt.exclude}
+		if got := f.AllowsIssuer(tt.issuer); got != tt.want {
+			t.Errorf("%s: AllowsIssuer(%q) = %v, want %v", tt.name, tt.issuer, got, tt.want)
+		}
+		if got := f.Allows(Result{Name: "www.example.com", IssuerName: tt.issuer}); got != tt.want {
+			t.Errorf("%s: Allows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterWindow(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		want      bool
+	}{
+		{"unknown", time.Time{}, true},
+		{"before", since.Add(-time.Second), false},
+		{"on since", since, true},
+		{"inside", since.AddDate(0, 3, 0), true},
+		{"on until", until, false},
+		{"after", until.AddDate(0, 1, 0), false},
+	}
+
+	f := CertFilter{Since: since, Until: until}
+	for _, tt := range tests {
+		if got := f.Allows(Result{Name: "www.example.com", NotBefore: tt.notBefore}); got != tt.want {
+			t.Errorf("%s: Allows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	sql := f.sql()
+	for _, want := range []string{"x509_notBefore(c.CERTIFICATE) >= '2024-01-01 00:00:00'", "x509_notBefore(c.CERTIFICATE) < '2024-07-01 00:00:00'"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("sql() = %q, want it to have %q", sql, want)
+		}
+	}
+
+	// Either end can be left open
+	if !(CertFilter{Since: since}).Allows(Result{NotBefore: until.AddDate(10, 0, 0)}) {
+		t.Error("no Until still cut off later certificates")
+	}
+	if !(CertFilter{Until: until}).Allows(Result{NotBefore: since.AddDate(-10, 0, 0)}) {
+		t.Error("no Since still cut off earlier certificates")
+	}
+}
@@ -0,0 +1,97 @@
+package sancrawler
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+)
+
+// Problems with a certificate's crypto, or with what browsers and the CA/Browser
+// Forum allow of publicly trusted certificates. They can only be spotted when
+// certificates are parsed locally.
+const (
+	FindingWeakKey       = "weak-key"
+	FindingWeakSignature = "weak-signature"
+	FindingLongValidity  = "long-validity"
+	FindingNoSCTs        = "no-scts"
+)
+
+// What each finding means, for reports
+var FindingDescriptions = map[string]string{
+	FindingWeakKey:       "RSA key under 2048 bits or an elliptic curve under 256",
+	FindingWeakSignature: "Signed with SHA-1 or MD5",
+	FindingLongValidity:  "Valid for longer than allowed when it was issued",
+	FindingNoSCTs:        "No embedded SCTs, so browsers that require CT may reject it",
+}
+
+// The extension embedded SCTs go in, added once the precertificate is logged
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// When the longest validity the Baseline Requirements allow went down, newest
+// first. Certificates older than all of these aren't checked.
+var validityLimits = []struct {
+	from time.Time
+	days int
+}{
+	{time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), 398},
+	{time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), 825},
+	{time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC), 39 * 31},
+}
+
+// Chrome started requiring CT for every new certificate after this
+var ctRequired = time.Date(2018, 4, 30, 0, 0, 0, 0, time.UTC)
+
+/* certificateFindings: anything wrong with a leaf certificate. CAs and
+ * precertificates aren't held to the SCT rule, the former don't need them and
+ * the latter can't have them yet.
+ */
+func certificateFindings(cert *x509.Certificate) []string {
+	var findings []string
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			findings = append(findings, FindingWeakKey)
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize < 256 {
+			findings = append(findings, FindingWeakKey)
+		}
+	}
+
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1, x509.MD5WithRSA, x509.MD2WithRSA:
+		findings = append(findings, FindingWeakSignature)
+	}
+
+	if cert.IsCA {
+		return findings
+	}
+
+	days := int(cert.NotAfter.Sub(cert.NotBefore).Hours() / 24)
+	for _, limit := range validityLimits {
+		if !cert.NotBefore.Before(limit.from) {
+			if days > limit.days {
+				findings = append(findings, FindingLongValidity)
+			}
+			break
+		}
+	}
+
+	if !isPrecert(cert) && cert.NotBefore.After(ctRequired) && !hasExtension(cert, oidSCTList) {
+		findings = append(findings, FindingNoSCTs)
+	}
+
+	return findings
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package sancrawler
+
+import (
+	"strings"
+	"unicode"
+)
+
+// FuzzyThreshold is the trigram similarity MatchFuzzy needs, the same as
+// pg_trgm's default so every backend agrees on what matches.
+const FuzzyThreshold = 0.3
+
+/* trigrams: the set of trigrams pg_trgm would pull out of s. Each word is
+ * lowercased and padded with two spaces in front and one behind first.
+ */
+func trigrams(s string) map[string]bool {
+	ret := make(map[string]bool)
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			ret[string(padded[i:i+3])] = true
+		}
+	}
+
+	return ret
+}
+
+/* Similarity: how alike two strings are, from 0 to 1, worked out the same way
+ * as pg_trgm's similarity(). "Acme Inc" and "ACME, Inc." come out as 1.
+ */
+func Similarity(a string, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+/* longestWord: the longest word in s, what the API gets searched for when
+ * fuzzy matching.
+ */
+func longestWord(s string) string {
+	longest := ""
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > len(longest) {
+			longest = word
+		}
+	}
+	return longest
+}
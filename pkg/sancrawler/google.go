@@ -0,0 +1,183 @@
+package sancrawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultGoogleURL is the certificate search behind Google's Transparency
+// Report.
+const DefaultGoogleURL = "https://transparencyreport.google.com/transparencyreport/api/v3/httpsreport/ct/certsearch"
+
+// GoogleBackend searches the CT logs through Google's Transparency Report.
+// Everything goes over HTTPS to Google, so it works from networks that can't
+// reach crt.sh at all. Like Facebook it can only search by domain, returning
+// certificates for the domain and everything under it, and it only lists a
+// single name for each certificate. The API isn't documented, so it can change
+// under us.
+type GoogleBackend struct {
+	// BaseURL is the certificate search endpoint, pages come from BaseURL/page.
+	BaseURL string
+	// MaxPages caps how many pages of certificates get pulled, 0 means no
+	// limit.
+	MaxPages int
+	Client   *http.Client
+}
+
+/* NewGoogleBackend: returns a GoogleBackend with sensible defaults.
+ */
+func NewGoogleBackend() *GoogleBackend {
+	return &GoogleBackend{
+		BaseURL: DefaultGoogleURL,
+		Client:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+/* Crawl: Get the names of certificates for the query's domain and its
+ * subdomains, following the page tokens until Google runs out of pages or
+ * MaxPages is hit. Queries that aren't for a domain are skipped.
+ */
+func (b *GoogleBackend) Crawl(ctx context.Context, q Query) (Results, error) {
+	ret := make(Results)
+
+	domain := searchDomain(q)
+	if domain == "" {
+		log.WithFields(log.Fields{
+			"Seed": q.Value,
+		}).Debug("Google can only search for domains, skipping")
+		return ret, nil
+	}
+
+	values := url.Values{}
+	values.Set("domain", domain)
+	values.Set("include_expired", "true")
+	values.Set("include_subdomains", "true")
+
+	next := b.BaseURL + "?" + values.Encode()
+
+	for page := 0; next != "" && (b.MaxPages == 0 || page < b.MaxPages); page++ {
+		body, err := b.get(ctx, next)
+		if err != nil {
+			return ret, err
+		}
+
+		results, token, err := parseGoogleCT(body)
+		if err != nil {
+			return ret, err
+		}
+		for _, res := range results {
+			ret.add(res)
+		}
+
+		next = ""
+		if token != "" {
+			values.Set("p", token)
+			next = b.BaseURL + "/page?" + values.Encode()
+		}
+	}
+
+	return ret, nil
+}
+
+/* get: GETs a page of search results.
+ */
+func (b *GoogleBackend) get(ctx context.Context, pageURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "sancrawler")
+
+	res, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Google CT search returned %s", res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+/* parseGoogleCT: the results on a page of search results, along with the token
+ * for the next page (empty on the last one). The body is JSON behind an XSSI
+ * guard, made up of nested arrays:
+ *
+ *	[["https.ct.cdsr", [[null, name, issuer, notBefore, notAfter, hash, ...], ...],
+ *	  [issuers...], [previous, next, ..., page, pages]]]
+ *
+ * with the dates in milliseconds.
+ */
+func parseGoogleCT(body []byte) ([]Result, string, error) {
+	if i := bytes.IndexByte(body, '\n'); i >= 0 && bytes.HasPrefix(body, []byte(")]}'")) {
+		body = body[i+1:]
+	}
+
+	var outer []interface{}
+	if err := json.Unmarshal(body, &outer); err != nil {
+		return nil, "", err
+	}
+
+	data, ok := elem(outer, 0).([]interface{})
+	if !ok || elem(data, 0) != "https.ct.cdsr" {
+		return nil, "", errors.New("unexpected response from Google CT search")
+	}
+
+	millis := func(v interface{}) time.Time {
+		if ms, ok := v.(float64); ok {
+			return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()
+		}
+		return time.Time{}
+	}
+
+	var ret []Result
+	certs, _ := elem(data, 1).([]interface{})
+	for _, c := range certs {
+		cert, ok := c.([]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := elem(cert, 1).(string)
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		issuer, _ := elem(cert, 2).(string)
+		notAfter := millis(elem(cert, 4))
+
+		ret = append(ret, Result{
+			Name:       name,
+			IssuerName: issuer,
+			Field:      "SAN",
+			Source:     "google",
+			NotBefore:  millis(elem(cert, 3)),
+			NotAfter:   notAfter,
+			Expired:    expired(notAfter),
+		})
+	}
+
+	paging, _ := elem(data, 3).([]interface{})
+	token, _ := elem(paging, 1).(string)
+
+	return ret, token, nil
+}
+
+/* elem: a[i], or nil if a isn't that long.
+ */
+func elem(a []interface{}, i int) interface{} {
+	if i < len(a) {
+		return a[i]
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package sancrawler
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Characters from other scripts that look (close enough to) exactly like a
+// Latin letter or digit. Accents get stripped separately, so é never needs to
+// be in here.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i',
+	'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'о': 'o', 'р': 'p',
+	'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ѵ': 'v', 'ԝ': 'w', 'х': 'x',
+	'ү': 'y', 'ь': 'b', 'з': '3',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Latin letters that aren't just an accented ASCII letter
+	'ı': 'i', 'ȷ': 'j', 'ɑ': 'a', 'ɡ': 'g', 'ɩ': 'i', 'ʟ': 'l', 'ɪ': 'i',
+	'ł': 'l', 'đ': 'd', 'ħ': 'h', 'ø': 'o',
+}
+
+/* skeleton: what a label looks like with every confusable character swapped
+ * for the ASCII one it's mistaken for and the accents stripped off, so two
+ * labels that look alike have the same skeleton.
+ */
+func skeleton(label string) string {
+	var b strings.Builder
+
+	for _, r := range norm.NFD.String(strings.ToLower(label)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+
+	return norm.NFC.String(b.String())
+}
+
+/* isASCII: whether s is plain ASCII.
+ */
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+/* registrableLabel: the label of name that was actually registered, ie.
+ * example for www.example.co.uk, along with the registered domain.
+ */
+func registrableLabel(name string) (string, string) {
+	apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+	if err != nil {
+		return "", ""
+	}
+
+	if dot := strings.Index(apex, "."); dot > 0 {
+		return apex[:dot], apex
+	}
+	return apex, apex
+}
+
+/* MainDomains: the n plain ASCII registered domains with the most names, which
+ * is usually the organization's own.
+ */
+func MainDomains(results Results, n int) []string {
+	counts := make(map[string]int)
+	for name, res := range results {
+		if res.Type != "" || !isASCII(name) {
+			continue
+		}
+		if _, apex := registrableLabel(name); apex != "" {
+			counts[apex]++
+		}
+	}
+
+	var ret []string
+	for _, c := range SortCounts(counts) {
+		if len(ret) == n {
+			break
+		}
+		ret = append(ret, c.Name)
+	}
+	return ret
+}
+
+/* FlagHomoglyphs: marks every internationalized name whose registered domain
+ * looks like one of domains without being it, eg. аpple.com with a Cyrillic а
+ * for apple.com, whatever the TLD. These are worth a look as possible phishing
+ * infrastructure. Returns the names flagged, sorted.
+ */
+func FlagHomoglyphs(results Results, domains []string) []string {
+	targets := make(map[string]string)
+	for _, domain := range domains {
+		label, apex := registrableLabel(strings.ToLower(domain))
+		if label != "" {
+			targets[skeleton(label)] = apex
+		}
+	}
+
+	var flagged []string
+	for name, res := range results {
+		if res.Type != "" || isASCII(name) {
+			continue
+		}
+
+		label, apex := registrableLabel(name)
+		if label == "" || isASCII(label) {
+			continue
+		}
+
+		if target, ok := targets[skeleton(label)]; ok && target != apex {
+			res.Homoglyph = target
+			results[name] = res
+			flagged = append(flagged, name)
+		}
+	}
+
+	sort.Strings(flagged)
+	return flagged
+}
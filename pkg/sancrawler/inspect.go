@@ -0,0 +1,141 @@
+package sancrawler
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertificateInfo is everything worth knowing about a certificate when
+// deciding what to crawl on.
+type CertificateInfo struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	Serial         string    `json:"serial"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	KeyType        string    `json:"key_type"`
+	SPKI           string    `json:"spki_sha256"`
+	SHA256         string    `json:"sha256"`
+	CA             bool      `json:"ca"`
+}
+
+// Pivot is a value on a certificate that can be crawled on, along with the
+// query that does it. Field is a subject attribute's short name (O, OU, ...),
+// SAN or SPKI.
+type Pivot struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Query Query  `json:"-"`
+}
+
+// The order subject attributes are offered in, most useful first
+var pivotFields = []string{"O", "OU", "CN", "E", "L", "ST", "C", "serialNumber"}
+
+/* Describe: the details of a certificate, hashes in lowercase hex.
+ */
+func Describe(cert *x509.Certificate) CertificateInfo {
+	sum := sha256.Sum256(cert.Raw)
+
+	info := CertificateInfo{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		Serial:         hex.EncodeToString(cert.SerialNumber.Bytes()),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		KeyType:        keyType(cert),
+		SPKI:           SPKIHash(cert),
+		SHA256:         hex.EncodeToString(sum[:]),
+		CA:             cert.IsCA,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+
+	return info
+}
+
+/* subjectValues: the values of a subject attribute, by its short name.
+ */
+func subjectValues(cert *x509.Certificate, field string) []string {
+	s := cert.Subject
+	switch field {
+	case "O":
+		return s.Organization
+	case "OU":
+		return s.OrganizationalUnit
+	case "CN":
+		return []string{s.CommonName}
+	case "E":
+		return subjectEmails(s)
+	case "L":
+		return s.Locality
+	case "ST":
+		return s.Province
+	case "C":
+		return s.Country
+	case "serialNumber":
+		return []string{s.SerialNumber}
+	}
+	return nil
+}
+
+/* Pivots: every value on the certificate that could be crawled on, subject
+ * attributes first, then the SANs and last the public key.
+ */
+func Pivots(cert *x509.Certificate) []Pivot {
+	var ret []Pivot
+
+	for _, field := range pivotFields {
+		for _, v := range subjectValues(cert, field) {
+			if v = strings.TrimSpace(v); v != "" {
+				ret = append(ret, Pivot{Field: field, Value: v, Query: Query{Value: v, NameType: SubjectFields[field]}})
+			}
+		}
+	}
+
+	names := append([]string(nil), cert.DNSNames...)
+	sort.Strings(names)
+	for _, name := range names {
+		ret = append(ret, Pivot{Field: "SAN", Value: name, Query: Query{Value: name, NameType: "dNSName"}})
+	}
+
+	spki := SPKIHash(cert)
+	ret = append(ret, Pivot{Field: "SPKI", Value: spki, Query: Query{Value: spki, NameType: NameTypeSPKI}})
+
+	return ret
+}
+
+/* SelectPivots: the pivots whose field is one of fields, ignoring case.
+ */
+func SelectPivots(pivots []Pivot, fields []string) ([]Pivot, error) {
+	for _, f := range fields {
+		known := strings.EqualFold(f, "SAN") || strings.EqualFold(f, "SPKI")
+		for _, pf := range pivotFields {
+			known = known || strings.EqualFold(f, pf)
+		}
+		if !known {
+			return nil, errors.New("unknown pivot field: " + f)
+		}
+	}
+
+	var ret []Pivot
+	for _, p := range pivots {
+		for _, f := range fields {
+			if strings.EqualFold(p.Field, f) {
+				ret = append(ret, p)
+				break
+			}
+		}
+	}
+	return ret, nil
+}
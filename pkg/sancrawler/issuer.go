@@ -0,0 +1,85 @@
+package sancrawler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NameTypeIssuerCA marks a Query whose Value is a crt.sh CA ID, every
+// certificate that CA issued gets crawled. Only the database backend can do
+// this.
+const NameTypeIssuerCA = "issuerCAID"
+
+// PrivateCALookup is implemented by backends which can tell which crt.sh CAs
+// aren't trusted by any of the public root stores.
+type PrivateCALookup interface {
+	PrivateCAs(ctx context.Context, caIDs []int) (map[int]string, error)
+}
+
+/* ByIssuerCA: Get all the names on certificates issued by the CA with the given
+ * crt.sh ID.
+ */
+func (c *Crawler) ByIssuerCA(ctx context.Context, caID int) (Results, error) {
+	return c.Crawl(ctx, Query{Value: strconv.Itoa(caID), NameType: NameTypeIssuerCA})
+}
+
+/* PrivateCAs: the CAs behind the results that no public root store trusts,
+ * keyed by crt.sh ID, using the first backend that supports it.
+ */
+func (c *Crawler) PrivateCAs(ctx context.Context, results Results) (map[int]string, error) {
+	var caIDs []int
+	seen := make(map[int]bool)
+
+	for _, res := range results {
+		if res.IssuerCAID != 0 && !seen[res.IssuerCAID] {
+			seen[res.IssuerCAID] = true
+			caIDs = append(caIDs, res.IssuerCAID)
+		}
+	}
+
+	for _, backend := range []Backend{c.Backend, c.Fallback} {
+		if lookup, ok := backend.(PrivateCALookup); ok {
+			return lookup.PrivateCAs(ctx, caIDs)
+		}
+	}
+
+	return nil, errors.New("no backend supports CA lookups")
+}
+
+/* CrawlPrivateCAs: crawls everything issued by the private CAs behind the
+ * results. An internal CA leaking into CT usually means a lot more of the org's
+ * internal names are sitting there too. A nil scope keeps everything.
+ */
+func (c *Crawler) CrawlPrivateCAs(ctx context.Context, results Results, scope *Scope) (Results, error) {
+	return c.CrawlPrivateCAsStream(ctx, results, scope, nil)
+}
+
+/* CrawlPrivateCAsStream: CrawlPrivateCAs, streaming names to found as
+ * CrawlAllStream does. Scope isn't applied to what found gets.
+ */
+func (c *Crawler) CrawlPrivateCAsStream(ctx context.Context, results Results, scope *Scope, found func(Result)) (Results, error) {
+	cas, err := c.PrivateCAs(ctx, results)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []Query
+	for caID, name := range cas {
+		queries = append(queries, Query{Value: strconv.Itoa(caID), NameType: NameTypeIssuerCA})
+
+		log.WithFields(log.Fields{
+			"IssuerCAID": caID,
+			"Issuer":     name,
+		}).Info("Pivoting on private CA")
+	}
+
+	if len(queries) == 0 {
+		return make(Results), nil
+	}
+
+	ret, err := c.CrawlAllStream(ctx, queries, found)
+	return scope.Filter(ret), err
+}
@@ -0,0 +1,229 @@
+package sancrawler
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter keeps us polite towards crt.sh. It caps how many queries can be in
+// flight at once and how quickly new ones get started, and is meant to be
+// shared by every goroutine (and backend) talking to the same service. A nil
+// Limiter doesn't limit anything.
+type Limiter struct {
+	bucket *rate.Limiter
+
+	mu       sync.Mutex
+	maxConns int
+	running  int
+	paused   bool
+	// Closed and replaced whenever a query finishes, the limit changes or we
+	// get unpaused, so anything waiting for a turn looks again
+	wake chan struct{}
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// ErrStopped is what queries that didn't get started because of Limiter.Stop
+// fail with. Crawls that run into it hand back whatever they had found so far.
+var ErrStopped = errors.New("crawl stopped")
+
+/* NewLimiter: returns a Limiter allowing at most maxConns queries at once and qps
+ * new queries a second. Zero (or less) for either means no limit on that front.
+ */
+func NewLimiter(maxConns int, qps float64) *Limiter {
+	l := &Limiter{stopped: make(chan struct{}), wake: make(chan struct{})}
+
+	if maxConns > 0 {
+		l.maxConns = maxConns
+	}
+
+	// The bucket holds a single token so bursts can't go over qps either
+	if qps > 0 {
+		l.bucket = rate.NewLimiter(rate.Limit(qps), 1)
+	}
+
+	return l
+}
+
+/* Stop: winds crawls down gently. Queries already running carry on and their
+ * results still get collected, but no new ones are started, they fail with
+ * ErrStopped instead. There's no starting again afterwards.
+ */
+func (l *Limiter) Stop() {
+	if l == nil {
+		return
+	}
+	l.stopOnce.Do(func() { close(l.stopped) })
+}
+
+/* Stopped: whether Stop has been called.
+ */
+func (l *Limiter) Stopped() bool {
+	if l == nil {
+		return false
+	}
+	select {
+	case <-l.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+/* Pause: holds back new queries until Resume, the ones already running carry
+ * on. Stopping still works while paused.
+ */
+func (l *Limiter) Pause() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.paused = true
+	l.mu.Unlock()
+}
+
+/* Resume: lets queries start again after Pause.
+ */
+func (l *Limiter) Resume() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.paused = false
+	l.wakeUp()
+	l.mu.Unlock()
+}
+
+/* Paused: whether the limiter is paused.
+ */
+func (l *Limiter) Paused() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.paused
+}
+
+/* SetMaxConns: changes how many queries can be in flight at once, zero (or
+ * less) for no limit. Going lower doesn't interrupt anything, new queries just
+ * wait until enough of the running ones finish.
+ */
+func (l *Limiter) SetMaxConns(maxConns int) {
+	if l == nil {
+		return
+	}
+	if maxConns < 0 {
+		maxConns = 0
+	}
+	l.mu.Lock()
+	l.maxConns = maxConns
+	l.wakeUp()
+	l.mu.Unlock()
+}
+
+/* Conns: how many queries are in flight and the most there can be, zero for
+ * no limit.
+ */
+func (l *Limiter) Conns() (int, int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.running, l.maxConns
+}
+
+// wakeUp has to be called with mu held
+func (l *Limiter) wakeUp() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+type limiterKey struct{}
+
+/* WithLimiter: ctx carrying an extra Limiter, which every query made with it
+ * has to get past as well as the backend's own. The server gives each API key
+ * one, so a single team can't use up crt.sh for everyone else.
+ */
+func WithLimiter(ctx context.Context, l *Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+/* acquire: blocks until we are allowed to start another query, or ctx is done.
+ * The returned func has to be called once the query is finished with. Any
+ * Limiter ctx carries gets a say first.
+ */
+func (l *Limiter) acquire(ctx context.Context) (func(), error) {
+	extra, _ := ctx.Value(limiterKey{}).(*Limiter)
+	if extra == nil || extra == l {
+		return l.take(ctx)
+	}
+
+	releaseExtra, err := extra.take(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release, err := l.take(ctx)
+	if err != nil {
+		releaseExtra()
+		return nil, err
+	}
+	return func() {
+		release()
+		releaseExtra()
+	}, nil
+}
+
+/* take: acquire for this Limiter alone.
+ */
+func (l *Limiter) take(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	for {
+		select {
+		case <-l.stopped:
+			return nil, ErrStopped
+		default:
+		}
+
+		l.mu.Lock()
+		if !l.paused && (l.maxConns == 0 || l.running < l.maxConns) {
+			l.running++
+			l.mu.Unlock()
+			break
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-l.stopped:
+			return nil, ErrStopped
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	release := func() {
+		l.mu.Lock()
+		l.running--
+		l.wakeUp()
+		l.mu.Unlock()
+	}
+
+	if l.bucket != nil {
+		if err := l.bucket.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
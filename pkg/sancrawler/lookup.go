@@ -0,0 +1,93 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of certificate lookup, a SHA-256 fingerprint of the whole certificate,
+// its serial number or a SHA-256 of its public key (see SPKIHash). All are
+// given as hex.
+const (
+	LookupFingerprint = "sha256"
+	LookupSerial      = "serial"
+	LookupSPKI        = "spki"
+)
+
+// CertificateLookup is implemented by backends which can find specific
+// certificates, rather than just the names on them.
+type CertificateLookup interface {
+	LookupCertificates(ctx context.Context, kind string, value string) ([]*x509.Certificate, error)
+}
+
+/* NormalizeHex: strips the colons and spaces people tend to paste fingerprints
+ * and serials with, and checks what's left is actually hex.
+ */
+func NormalizeHex(value string) (string, error) {
+	value = strings.ToLower(value)
+	value = strings.NewReplacer(":", "", " ", "", "-", "").Replace(value)
+	value = strings.TrimPrefix(value, "0x")
+
+	if _, err := hex.DecodeString(value); err != nil || value == "" {
+		return "", errors.New("not a hex value: " + value)
+	}
+
+	return value, nil
+}
+
+/* LookupCertificates: finds certificates by fingerprint or serial number using
+ * the first backend that supports it, falling back the same way Crawl does.
+ */
+func (c *Crawler) LookupCertificates(ctx context.Context, kind string, value string) ([]*x509.Certificate, error) {
+	value, err := NormalizeHex(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error = errors.New("no backend supports certificate lookups")
+
+	for _, backend := range []Backend{c.Backend, c.Fallback} {
+		lookup, ok := backend.(CertificateLookup)
+		if !ok {
+			continue
+		}
+
+		certs, err := lookup.LookupCertificates(ctx, kind, value)
+		if err == nil {
+			return certs, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Warn("Certificate lookup failed, trying fallback")
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+/* parseCertificates: parses DER blobs, skipping the odd one Go refuses to
+ * parse rather than giving up on all of them.
+ */
+func parseCertificates(ders [][]byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Warn("Skipping certificate that could not be parsed")
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
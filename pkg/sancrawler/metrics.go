@@ -0,0 +1,218 @@
+package sancrawler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics counts what crawls get up to, for keeping an eye on SANCrawler when
+// it runs as a service. The same Metrics can be given to a Crawler and its
+// DBBackend, and a nil *Metrics doesn't count anything. WritePrometheus hands
+// it all over in Prometheus' text format.
+type Metrics struct {
+	mu       sync.Mutex
+	backends map[string]*backendMetrics
+	pages    *histogram
+
+	pageErrors   int64
+	certificates int64
+	names        int64
+	crawlers     int64
+}
+
+// A single backend's queries, how many failed and how long they took
+type backendMetrics struct {
+	queries  int64
+	errors   int64
+	duration *histogram
+}
+
+// Upper bounds of the histogram buckets, in seconds. A page should come back
+// within -target-latency, a whole crawl of a big organization can take hours.
+var (
+	pageBuckets  = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	crawlBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200}
+)
+
+/* NewMetrics: a Metrics with nothing counted yet.
+ */
+func NewMetrics() *Metrics {
+	return &Metrics{
+		backends: make(map[string]*backendMetrics),
+		pages:    newHistogram(pageBuckets),
+	}
+}
+
+/* backendName: what a backend is called in metrics.
+ */
+func backendName(backend Backend) string {
+	switch backend.(type) {
+	case *DBBackend:
+		return "crtsh-db"
+	case *APIBackend:
+		return "crtsh-api"
+	case *CensysBackend:
+		return "censys"
+	case *FacebookBackend:
+		return "facebook"
+	case *GoogleBackend:
+		return "google"
+	default:
+		return fmt.Sprintf("%T", backend)
+	}
+}
+
+/* query: counts a query against backend that took took, and failed if err
+ * isn't nil.
+ */
+func (m *Metrics) query(backend Backend, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	name := backendName(backend)
+
+	m.mu.Lock()
+	b, ok := m.backends[name]
+	if !ok {
+		b = &backendMetrics{duration: newHistogram(crawlBuckets)}
+		m.backends[name] = b
+	}
+	m.mu.Unlock()
+
+	atomic.AddInt64(&b.queries, 1)
+	if err != nil {
+		atomic.AddInt64(&b.errors, 1)
+	}
+	b.duration.observe(took.Seconds())
+}
+
+/* page: counts a page read from the database, certs of them in it.
+ */
+func (m *Metrics) page(took time.Duration, certs int, err error) {
+	if m == nil {
+		return
+	}
+	m.pages.observe(took.Seconds())
+	atomic.AddInt64(&m.certificates, int64(certs))
+	if err != nil {
+		atomic.AddInt64(&m.pageErrors, 1)
+	}
+}
+
+func (m *Metrics) addNames(n int) {
+	if m != nil {
+		atomic.AddInt64(&m.names, int64(n))
+	}
+}
+
+func (m *Metrics) addCrawlers(n int) {
+	if m != nil {
+		atomic.AddInt64(&m.crawlers, int64(n))
+	}
+}
+
+/* WritePrometheus: writes the metrics out in Prometheus' text exposition
+ * format.
+ */
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	m.mu.Lock()
+	names := make([]string, 0, len(m.backends))
+	for name := range m.backends {
+		names = append(names, name)
+	}
+	backends := make(map[string]*backendMetrics, len(m.backends))
+	for name, b := range m.backends {
+		backends[name] = b
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	writeHelp(out, "sancrawler_backend_queries_total", "counter", "Queries run against each backend.")
+	for _, name := range names {
+		fmt.Fprintf(out, "sancrawler_backend_queries_total{backend=%q} %d\n", name, atomic.LoadInt64(&backends[name].queries))
+	}
+	writeHelp(out, "sancrawler_backend_errors_total", "counter", "Queries against each backend that failed.")
+	for _, name := range names {
+		fmt.Fprintf(out, "sancrawler_backend_errors_total{backend=%q} %d\n", name, atomic.LoadInt64(&backends[name].errors))
+	}
+	writeHelp(out, "sancrawler_backend_query_duration_seconds", "histogram", "How long each query against a backend took, start to finish.")
+	for _, name := range names {
+		backends[name].duration.write(out, "sancrawler_backend_query_duration_seconds", fmt.Sprintf("backend=%q", name))
+	}
+
+	writeHelp(out, "sancrawler_db_page_duration_seconds", "histogram", "How long each page of certificates took to read from the database.")
+	m.pages.write(out, "sancrawler_db_page_duration_seconds", "")
+	writeHelp(out, "sancrawler_db_page_errors_total", "counter", "Pages that failed to read, retries included.")
+	fmt.Fprintf(out, "sancrawler_db_page_errors_total %d\n", atomic.LoadInt64(&m.pageErrors))
+	writeHelp(out, "sancrawler_db_certificates_scanned_total", "counter", "Certificates read from the database.")
+	fmt.Fprintf(out, "sancrawler_db_certificates_scanned_total %d\n", atomic.LoadInt64(&m.certificates))
+	writeHelp(out, "sancrawler_db_crawlers", "gauge", "Database crawlers running right now.")
+	fmt.Fprintf(out, "sancrawler_db_crawlers %d\n", atomic.LoadInt64(&m.crawlers))
+
+	writeHelp(out, "sancrawler_names_found_total", "counter", "Names found, once per query that found them.")
+	fmt.Fprintf(out, "sancrawler_names_found_total %d\n", atomic.LoadInt64(&m.names))
+
+	return out.Flush()
+}
+
+func writeHelp(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// histogram is a Prometheus histogram: how many observations fell at or under
+// each bound, plus their count and sum.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+/* write: the histogram's series, with labels (if any) added to each.
+ */
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
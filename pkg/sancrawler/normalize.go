@@ -0,0 +1,125 @@
+package sancrawler
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Result types other than plain DNS names
+const (
+	TypeIP    = "ip"
+	TypeURI   = "uri"
+	TypeEmail = "email"
+)
+
+// Normalizer cleans up names as they come off certificates so the same host
+// always ends up as the same string. Each rule can be turned off on its own,
+// names that end up the same are merged.
+type Normalizer struct {
+	// TrimDots strips trailing dots (www.example.com.)
+	TrimDots bool
+	// StripPorts strips port suffixes (www.example.com:8443)
+	StripPorts bool
+	// DecodeIDN turns punycode (xn--...) into Unicode so both spellings of an
+	// internationalized name collapse into one. Normalize keeps the punycode in
+	// Result.Punycode.
+	DecodeIDN bool
+	// SplitIPs marks IP addresses as TypeIP so they can be kept apart from the
+	// DNS names.
+	SplitIPs bool
+}
+
+/* DefaultNormalizer: every rule turned on.
+ */
+func DefaultNormalizer() Normalizer {
+	return Normalizer{TrimDots: true, StripPorts: true, DecodeIDN: true, SplitIPs: true}
+}
+
+/* stripPort: drops a numeric port off the end of name, leaving bare IPv6
+ * addresses alone.
+ */
+func stripPort(name string) string {
+	i := strings.LastIndexByte(name, ':')
+	if i < 0 {
+		return name
+	}
+
+	port := name[i+1:]
+	if port == "" || strings.Trim(port, "0123456789") != "" {
+		return name
+	}
+
+	host := name[:i]
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	if strings.Contains(host, ":") {
+		return name
+	}
+	return host
+}
+
+/* Name: applies the rules to a single name.
+ */
+func (n Normalizer) Name(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if n.StripPorts {
+		name = stripPort(name)
+	}
+
+	if n.TrimDots {
+		name = strings.TrimRight(name, ".")
+	}
+
+	if n.DecodeIDN && strings.Contains(name, "xn--") {
+		if decoded, err := idna.ToUnicode(name); err == nil {
+			name = strings.ToLower(decoded)
+		}
+	}
+
+	return name
+}
+
+/* isIP: whether name is an IP address, bracketed IPv6 included.
+ */
+func isIP(name string) bool {
+	return net.ParseIP(strings.Trim(name, "[]")) != nil
+}
+
+/* Normalize: runs every result through the rules, merging any that end up the
+ * same.
+ */
+func (n Normalizer) Normalize(results Results) Results {
+	ret := make(Results)
+
+	for _, res := range results {
+		// The rules are all about DNS names, URIs and the like just get tidied
+		if res.Type == "" || res.Type == TypeIP {
+			res.Name = n.Name(res.Name)
+		} else {
+			res.Name = strings.TrimSpace(res.Name)
+		}
+		if res.Name == "" {
+			continue
+		}
+
+		// Whichever way the name was spelled, hang on to the ASCII form of it
+		if n.DecodeIDN && res.Type == "" && !isASCII(res.Name) {
+			if ascii, err := idna.ToASCII(res.Name); err == nil {
+				res.Punycode = ascii
+			}
+		}
+
+		if n.SplitIPs && res.Type == "" && isIP(res.Name) {
+			res.Name = strings.Trim(res.Name, "[]")
+			res.Type = TypeIP
+		}
+
+		ret.Merge(Results{res.Name: res})
+	}
+
+	return ret
+}
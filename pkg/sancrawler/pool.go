@@ -0,0 +1,150 @@
+package sancrawler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults for the connection pool, used when the DBBackend fields are zero
+const (
+	DefaultMaxIdleConns    = 16
+	DefaultConnMaxLifetime = 30 * time.Minute
+)
+
+/* pool: the connection pool every query goes through, opened the first time
+ * it's needed. A fresh pool gets pinged before it's handed out, so a database
+ * that isn't there fails straight away rather than on the first real query.
+ */
+func (b *DBBackend) pool(ctx context.Context) (*sql.DB, error) {
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+
+	if b.db != nil {
+		return b.db, nil
+	}
+
+	connector, err := pq.NewConnector(b.ConnStr)
+	if err != nil {
+		return nil, err
+	}
+	if b.Proxy != nil {
+		connector.Dialer(b.Proxy)
+	}
+	db := sql.OpenDB(connector)
+
+	// database/sql only keeps 2 idle connections around by default, which with
+	// dozens of crawlers means connecting over and over again.
+	idle, lifetime := b.MaxIdleConns, b.ConnMaxLifetime
+	if idle == 0 {
+		idle = DefaultMaxIdleConns
+	}
+	if b.MaxOpenConns > 0 && idle > b.MaxOpenConns {
+		idle = b.MaxOpenConns
+	}
+	if lifetime == 0 {
+		lifetime = DefaultConnMaxLifetime
+	}
+
+	db.SetMaxOpenConns(b.MaxOpenConns)
+	db.SetMaxIdleConns(idle)
+	db.SetConnMaxLifetime(lifetime)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b.db = db
+	b.stmts = make(map[string]*sql.Stmt)
+	return db, nil
+}
+
+/* Ping: checks the database can be reached, opening the pool if it isn't
+ * already.
+ */
+func (b *DBBackend) Ping(ctx context.Context) error {
+	db, err := b.pool(ctx)
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
+/* Close: closes the prepared statements and the connection pool. The backend
+ * opens a new pool if it gets used again afterwards.
+ */
+func (b *DBBackend) Close() error {
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+
+	if b.db == nil {
+		return nil
+	}
+
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+	err := b.db.Close()
+	b.db, b.stmts = nil, nil
+	return err
+}
+
+/* query: runs one of the queries the crawl sends over and over, as a prepared
+ * statement unless NoPrepare is set. Connection poolers in transaction mode
+ * (like pgbouncer) lose prepared statements between transactions, so if the
+ * server says ours don't exist we give up on them for good and send plain
+ * queries instead.
+ */
+func (b *DBBackend) query(ctx context.Context, db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := b.prepare(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return db.QueryContext(ctx, query, args...)
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "26000" {
+		b.poolMu.Lock()
+		if !b.NoPrepare {
+			log.Warn("Database lost a prepared statement, sending plain queries from now on")
+			b.NoPrepare = true
+		}
+		b.poolMu.Unlock()
+
+		return db.QueryContext(ctx, query, args...)
+	}
+
+	return rows, err
+}
+
+/* prepare: the prepared statement for query, preparing it the first time.
+ * Returns nil without an error when prepared statements are turned off.
+ */
+func (b *DBBackend) prepare(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+
+	if b.NoPrepare || b.db != db {
+		return nil, nil
+	}
+
+	if stmt, ok := b.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	b.stmts[query] = stmt
+	return stmt, nil
+}
@@ -0,0 +1,167 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/tls"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPProbe is what we got back from a single request to a name.
+type HTTPProbe struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Server     string `json:"server,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Location   string `json:"location,omitempty"`
+}
+
+// Prober makes HTTP and HTTPS requests to discovered names, a bit like httpx.
+// Redirects aren't followed, they're recorded. The zero value is usable.
+type Prober struct {
+	// Concurrency is how many names get probed at once.
+	Concurrency int
+	// Timeout applies to each request.
+	Timeout time.Duration
+	// Proxy, if set, is what the requests go through.
+	Proxy *Proxy
+
+	once   sync.Once
+	client *http.Client
+}
+
+var titleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+func (p *Prober) httpClient() *http.Client {
+	p.once.Do(func() {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+
+		// Plenty of what we find has self signed or mismatched certificates, we
+		// still want to know what's there.
+		transport := p.Proxy.Transport()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		transport.DisableKeepAlives = true
+
+		p.client = &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	})
+	return p.client
+}
+
+/* probeURL: makes a single request, returning nil if nothing answered.
+ */
+func (p *Prober) probeURL(ctx context.Context, url string) *HTTPProbe {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", "sancrawler")
+
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil
+	}
+	defer res.Body.Close()
+
+	probe := &HTTPProbe{
+		URL:        url,
+		StatusCode: res.StatusCode,
+		Server:     res.Header.Get("Server"),
+		Location:   res.Header.Get("Location"),
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if m := titleRegex.FindSubmatch(body); m != nil {
+		probe.Title = strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	}
+
+	return probe
+}
+
+/* Probe: tries HTTPS and HTTP on name, returning whatever answered.
+ */
+func (p *Prober) Probe(ctx context.Context, name string) []HTTPProbe {
+	var probes []HTTPProbe
+
+	for _, scheme := range []string{"https://", "http://"} {
+		if probe := p.probeURL(ctx, scheme+name+"/"); probe != nil {
+			probes = append(probes, *probe)
+		}
+	}
+
+	return probes
+}
+
+/* ProbeAll: probes every name worth probing and stores what answered on the
+ * results. Wildcards are skipped, and so are names that have been resolved and
+ * found dead. Returns how many names answered.
+ */
+func (p *Prober) ProbeAll(ctx context.Context, results Results) int {
+	concurrency := p.Concurrency
+	if concurrency < 1 {
+		concurrency = 50
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		answered int
+	)
+
+	var pending []string
+	for name, res := range results {
+		if strings.HasPrefix(name, "*.") || (res.DNS != nil && !res.DNS.Live) {
+			continue
+		}
+		pending = append(pending, name)
+	}
+
+	names := make(chan string)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				probes := p.Probe(ctx, name)
+				if len(probes) == 0 {
+					continue
+				}
+
+				mu.Lock()
+				res := results[name]
+				res.HTTP = probes
+				results[name] = res
+				answered++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range pending {
+		select {
+		case names <- name:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(names)
+	wg.Wait()
+
+	return answered
+}
@@ -0,0 +1,152 @@
+package sancrawler
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress keeps count of how far through a crawl we are. The database backend
+// knows up front how many certificates each CA has, so it can tell us how much
+// work is left as well as how much got done. Safe to share between goroutines,
+// and a nil Progress just doesn't count anything.
+type Progress struct {
+	start   time.Time
+	total   int64
+	done    int64
+	names   int64
+	scanned int64
+
+	mu  sync.Mutex
+	cas map[int]*CAProgress
+}
+
+// CAProgress is how far through a single CA's certificates we are, counted
+// the same way as ProgressStats.
+type CAProgress struct {
+	ID           int
+	Name         string
+	Certificates int64
+	Done         int64
+}
+
+// ProgressStats is a point in time snapshot of a Progress.
+type ProgressStats struct {
+	// Certificates is how many certificates there are to read in total, Done is
+	// how many have been read so far. Every certificate gets read once for its
+	// CN and once for each type of SAN being collected, and each read counts.
+	Certificates int64
+	Done         int64
+	// Scanned is how many distinct certificates have been read, only counted
+	// once each query finishes.
+	Scanned int64
+	// Names is how many unique names have been found
+	Names   int64
+	Elapsed time.Duration
+	// Rate is certificates read per second
+	Rate float64
+	// ETA is zero until there is enough to go on
+	ETA time.Duration
+}
+
+/* NewProgress: starts the clock on a new Progress.
+ */
+func NewProgress() *Progress {
+	return &Progress{start: time.Now()}
+}
+
+func (p *Progress) addTotal(data crawlerData, n int) {
+	if p != nil {
+		atomic.AddInt64(&p.total, int64(n))
+		p.ca(data, func(ca *CAProgress) { ca.Certificates += int64(n) })
+	}
+}
+
+func (p *Progress) addDone(data crawlerData, n int) {
+	if p != nil {
+		atomic.AddInt64(&p.done, int64(n))
+		p.ca(data, func(ca *CAProgress) { ca.Done += int64(n) })
+	}
+}
+
+/* ca: calls update on the CA's progress, with the lock held.
+ */
+func (p *Progress) ca(data crawlerData, update func(*CAProgress)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cas == nil {
+		p.cas = make(map[int]*CAProgress)
+	}
+	ca, ok := p.cas[data.caID]
+	if !ok {
+		ca = &CAProgress{ID: data.caID, Name: data.caName}
+		p.cas[data.caID] = ca
+	}
+	update(ca)
+}
+
+/* CAs: snapshots the progress through each CA, the ones with the most
+ * certificates first.
+ */
+func (p *Progress) CAs() []CAProgress {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	ret := make([]CAProgress, 0, len(p.cas))
+	for _, ca := range p.cas {
+		ret = append(ret, *ca)
+	}
+	p.mu.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Certificates != ret[j].Certificates {
+			return ret[i].Certificates > ret[j].Certificates
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+func (p *Progress) addScanned(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.scanned, n)
+	}
+}
+
+func (p *Progress) addNames(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.names, int64(n))
+	}
+}
+
+/* Stats: snapshots the progress so far and works out the throughput and how
+ * long the rest should take at that rate.
+ */
+func (p *Progress) Stats() ProgressStats {
+	if p == nil {
+		return ProgressStats{}
+	}
+
+	stats := ProgressStats{
+		Certificates: atomic.LoadInt64(&p.total),
+		Done:         atomic.LoadInt64(&p.done),
+		Scanned:      atomic.LoadInt64(&p.scanned),
+		Names:        atomic.LoadInt64(&p.names),
+		Elapsed:      time.Since(p.start),
+	}
+
+	if stats.Elapsed > 0 {
+		stats.Rate = float64(stats.Done) / stats.Elapsed.Seconds()
+	}
+
+	if stats.Rate > 0 && stats.Certificates > stats.Done {
+		remaining := float64(stats.Certificates-stats.Done) / stats.Rate
+		stats.ETA = time.Duration(remaining * float64(time.Second))
+	}
+
+	return stats
+}
@@ -0,0 +1,240 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/asn1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Which flavour of certwatch database DBBackend is talking to
+const (
+	SchemaFull = "full"
+	SchemaRaw  = "raw"
+)
+
+var (
+	oidPrecertPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	oidEmailAddress  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+)
+
+/* schema: works out which flavour of database we're talking to, the first time
+ * it's needed. A slimmed down mirror usually keeps the tables but not the x509
+ * functions crt.sh adds to postgres, so that's what gets checked for.
+ */
+func (b *DBBackend) schema(ctx context.Context) (string, error) {
+	b.schemaMu.Lock()
+	defer b.schemaMu.Unlock()
+
+	if b.Schema != "" {
+		return b.Schema, nil
+	}
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var full bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_proc WHERE proname = 'x509_altnames');`).Scan(&full)
+	if err != nil {
+		return "", err
+	}
+
+	b.Schema = SchemaFull
+	if !full {
+		b.Schema = SchemaRaw
+		log.Info("Database has no x509 functions, parsing certificates locally")
+	}
+
+	return b.Schema, nil
+}
+
+/* trigrams: whether pg_trgm is installed, which fuzzy matching needs. Checked
+ * the first time it's needed, like the schema.
+ */
+func (b *DBBackend) trigrams(ctx context.Context) (bool, error) {
+	b.schemaMu.Lock()
+	defer b.schemaMu.Unlock()
+
+	if b.hasTrigrams != nil {
+		return *b.hasTrigrams, nil
+	}
+
+	db, err := b.pool(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm');`).Scan(&ok)
+	if err != nil {
+		return false, err
+	}
+
+	b.hasTrigrams = &ok
+	return ok, nil
+}
+
+/* rawJob: a job pulling whole certificates for readRawRow to pick apart. None
+ * of the SQL side filtering works without the x509 functions, Crawler filters
+ * the results afterwards instead.
+ */
+func rawJob(filter string) crawlJob {
+	return crawlJob{raw: true, field: "SAN", query: compactQuery(`
+	SELECT c.ID, ` + sharedCertificate + `
+	FROM certificate c WHERE c.ID IN (
+		SELECT DISTINCT ci.CERTIFICATE_ID
+		 FROM certificate_identity ci
+		 WHERE ci.ISSUER_CA_ID = $2 AND ` + filter + `
+	 ) AND c.ID < $3 AND c.ID >= $4
+	ORDER BY c.ID DESC LIMIT ` + strconv.Itoa(pageSize) + `;
+	`)}
+}
+
+/* readRawRow: parses the certificate in a row of a raw job and pulls out the
+ * same names the crt.sh functions would have given us.
+ */
+func (b *DBBackend) readRawRow(rows *sql.Rows, tmpData crawlerData) (int, []Result, error) {
+	var (
+		ID  int
+		der []byte
+	)
+
+	if err := rows.Scan(&ID, &der); err != nil {
+		return 0, nil, err
+	}
+	if der == nil {
+		return ID, nil, nil
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"CertificateID": ID,
+			"Error":         err,
+		}).Warn("Skipping certificate that could not be parsed")
+		return ID, nil, nil
+	}
+
+	base := certificateResult(cert)
+	base.CertificateID = ID
+	base.IssuerCAID = tmpData.caID
+	base.IssuerName = tmpData.caName
+	base.Source = "crt.sh"
+
+	return ID, certificateNames(cert, base, b.SANTypes, b.SubjectEmails), nil
+}
+
+/* certificateResult: a Result with everything we know about the certificate
+ * filled in, ready to have names added to it.
+ */
+func certificateResult(cert *x509.Certificate) Result {
+	return Result{
+		IssuerName: cert.Issuer.String(),
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		Expired:    expired(cert.NotAfter),
+		Precert:    isPrecert(cert),
+
+		SubjectKeyID:   hex.EncodeToString(cert.SubjectKeyId),
+		AuthorityKeyID: hex.EncodeToString(cert.AuthorityKeyId),
+		KeyType:        keyType(cert),
+		Findings:       certificateFindings(cert),
+	}
+}
+
+/* certificateNames: every name on a parsed certificate as a copy of base, the
+ * SAN types and Subject emails picked the same way DBBackend does.
+ */
+func certificateNames(cert *x509.Certificate, base Result, types []string, withSubjectEmails bool) []Result {
+	var ret []Result
+	add := func(name string, field string, nameType string) {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			return
+		}
+		res := base
+		res.Name, res.Field, res.Type = name, field, nameType
+		ret = append(ret, res)
+	}
+
+	if len(types) == 0 {
+		types = []string{"dns"}
+	}
+
+	for _, t := range types {
+		switch t {
+		case "dns":
+			for _, name := range cert.DNSNames {
+				add(name, "SAN", "")
+			}
+		case "ip":
+			for _, ip := range cert.IPAddresses {
+				add(ip.String(), "SAN", TypeIP)
+			}
+		case "uri":
+			for _, uri := range cert.URIs {
+				add(uri.String(), "SAN", TypeURI)
+			}
+		case "email":
+			for _, email := range cert.EmailAddresses {
+				add(email, "SAN", TypeEmail)
+			}
+		}
+	}
+
+	add(cert.Subject.CommonName, "CN", "")
+
+	if withSubjectEmails {
+		for _, email := range subjectEmails(cert.Subject) {
+			add(email, "Subject", TypeEmail)
+		}
+	}
+
+	return ret
+}
+
+/* isPrecert: whether the certificate carries the CT poison extension.
+ */
+func isPrecert(cert *x509.Certificate) bool {
+	return hasExtension(cert, oidPrecertPoison)
+}
+
+/* keyType: a short description of the certificate's public key, eg. RSA-2048
+ * or ECDSA-P256.
+ */
+func keyType(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA-" + strconv.Itoa(key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+/* subjectEmails: the emailAddress attributes of a Subject, which Go doesn't
+ * break out for us.
+ */
+func subjectEmails(subject pkix.Name) []string {
+	var emails []string
+	for _, attr := range subject.Names {
+		if attr.Type.Equal(oidEmailAddress) {
+			if email, ok := attr.Value.(string); ok {
+				emails = append(emails, email)
+			}
+		}
+	}
+	return emails
+}
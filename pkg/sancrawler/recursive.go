@@ -0,0 +1,134 @@
+package sancrawler
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OrganizationLookup is implemented by backends which can tell us which Subject
+// Organizations appear on a set of crt.sh certificates.
+type OrganizationLookup interface {
+	Organizations(ctx context.Context, certIDs []int) (map[string]int, error)
+}
+
+/* Organizations: counts the Subject Organizations seen on the certificates the
+ * results came from, using the first backend that supports it.
+ */
+func (c *Crawler) Organizations(ctx context.Context, results Results) (map[string]int, error) {
+	var certIDs []int
+	seen := make(map[int]bool)
+
+	for _, res := range results {
+		if res.CertificateID != 0 && !seen[res.CertificateID] {
+			seen[res.CertificateID] = true
+			certIDs = append(certIDs, res.CertificateID)
+		}
+	}
+
+	for _, backend := range []Backend{c.Backend, c.Fallback} {
+		if lookup, ok := backend.(OrganizationLookup); ok {
+			return lookup.Organizations(ctx, certIDs)
+		}
+	}
+
+	return nil, errors.New("no backend supports organization lookups")
+}
+
+// OrganizationMatcher is implemented by backends which can count the Subject
+// Organizations on the certificates a query matches without crawling them.
+type OrganizationMatcher interface {
+	MatchedOrganizations(ctx context.Context, q Query) (map[string]int, error)
+}
+
+/* MatchedOrganizations: counts the Subject Organizations on the certificates q
+ * matches, using the first backend that supports it. Certificates without one
+ * are counted under "". Handy for seeing whether a keyword is catching more
+ * than it should before crawling it.
+ */
+func (c *Crawler) MatchedOrganizations(ctx context.Context, q Query) (map[string]int, error) {
+	for _, backend := range []Backend{c.Backend, c.Fallback} {
+		if matcher, ok := backend.(OrganizationMatcher); ok {
+			return matcher.MatchedOrganizations(ctx, q)
+		}
+	}
+
+	return nil, errors.New("no backend supports organization lookups")
+}
+
+/* CrawlRecursive: crawls the queries, then pulls every organization seen on the
+ * certificates that turned up in scope names and crawls those too, up to depth
+ * rounds of pivoting. Organizations are only ever crawled once. A nil scope
+ * keeps everything.
+ */
+func (c *Crawler) CrawlRecursive(ctx context.Context, queries []Query, depth int, scope *Scope) (Results, error) {
+	return c.CrawlRecursiveStream(ctx, queries, depth, scope, nil)
+}
+
+/* CrawlRecursiveStream: CrawlRecursive, streaming names to found as
+ * CrawlAllStream does. Scope isn't applied to what found gets, and a name can
+ * go to it again in a later round.
+ */
+func (c *Crawler) CrawlRecursiveStream(ctx context.Context, queries []Query, depth int, scope *Scope, found func(Result)) (Results, error) {
+	crawled := make(map[string]bool)
+	for _, q := range queries {
+		if q.NameType == NameTypeOrganization {
+			crawled[strings.ToLower(q.Value)] = true
+		}
+	}
+
+	all, err := c.CrawlAllStream(ctx, queries, found)
+	all = scope.Filter(all)
+	if err != nil {
+		return all, err
+	}
+
+	// Only the names that are new each round get pivoted on, everything else has
+	// already had its organizations looked at.
+	frontier := all
+
+	for round := 1; round <= depth; round++ {
+		orgs, err := c.Organizations(ctx, frontier)
+		if err != nil {
+			return all, err
+		}
+
+		var next []Query
+		for org := range orgs {
+			if crawled[strings.ToLower(org)] {
+				continue
+			}
+			crawled[strings.ToLower(org)] = true
+			next = append(next, Query{Value: org, NameType: NameTypeOrganization})
+
+			log.WithFields(log.Fields{
+				"Round":        round,
+				"Organization": org,
+				"Certificates": orgs[org],
+			}).Info("Pivoting on discovered organization")
+		}
+
+		if len(next) == 0 {
+			break
+		}
+
+		results, err := c.CrawlAllStream(ctx, next, found)
+		results = scope.Filter(results)
+
+		frontier = make(Results)
+		for name, res := range results {
+			if _, ok := all[name]; !ok {
+				frontier[name] = res
+			}
+		}
+		all.Merge(results)
+
+		if err != nil {
+			return all, err
+		}
+	}
+
+	return all, nil
+}
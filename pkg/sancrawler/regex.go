@@ -0,0 +1,54 @@
+package sancrawler
+
+import (
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+/* compile: the query's Value as a case insensitive Go regexp, for backends that
+ * have to do the matching themselves. RE2 and POSIX agree on everything people
+ * tend to write in a seed. Multi-line since crt.sh hands back identities one
+ * per line.
+ */
+func (q Query) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("(?im)" + q.Value)
+}
+
+/* regexLiteral: the longest piece of plain text every match of the pattern has
+ * to contain. Backends that can't run a regex use it to narrow the search down
+ * before matching the rest client-side.
+ */
+func regexLiteral(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	longest := ""
+	var walk func(re *syntax.Regexp)
+	walk = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpLiteral:
+			if lit := string(re.Rune); len(lit) > len(longest) {
+				longest = lit
+			}
+		case syntax.OpConcat, syntax.OpCapture, syntax.OpPlus:
+			for _, sub := range re.Sub {
+				walk(sub)
+			}
+		case syntax.OpRepeat:
+			if re.Min > 0 {
+				walk(re.Sub[0])
+			}
+		}
+	}
+	walk(re.Simplify())
+
+	longest = strings.TrimSpace(longest)
+	if longest == "" {
+		return "", errors.New("regex has no literal text to search for: " + pattern)
+	}
+	return longest, nil
+}
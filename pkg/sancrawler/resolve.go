@@ -0,0 +1,159 @@
+package sancrawler
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DNSRecords holds what a name resolved to. Live is set if the name resolved to
+// at least one address, everything else is dead or internal only. ASN is only
+// filled in by EnrichASN and Cloud by a CloudClassifier.
+type DNSRecords struct {
+	A     []string  `json:"a,omitempty"`
+	AAAA  []string  `json:"aaaa,omitempty"`
+	CNAME string    `json:"cname,omitempty"`
+	Live  bool      `json:"live"`
+	ASN   []ASNInfo `json:"asn,omitempty"`
+	Cloud string    `json:"cloud,omitempty"`
+}
+
+// Resolver resolves discovered names concurrently. The zero value uses the
+// system resolver with a sensible amount of concurrency.
+type Resolver struct {
+	// Servers is a list of DNS servers (host or host:port) to spread lookups
+	// over. Empty means use the system resolver.
+	Servers []string
+	// Concurrency is how many lookups run at once.
+	Concurrency int
+	// Timeout applies to each individual name.
+	Timeout time.Duration
+
+	next uint32
+}
+
+/* dial: spreads queries round robin across the configured servers.
+ */
+func (r *Resolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	server := r.Servers[atomic.AddUint32(&r.next, 1)%uint32(len(r.Servers))]
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, network, server)
+}
+
+func (r *Resolver) resolver() *net.Resolver {
+	if len(r.Servers) == 0 {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{PreferGo: true, Dial: r.dial}
+}
+
+/* Resolve: looks up A, AAAA and CNAME records for a single name. Wildcards
+ * can't be resolved so they always come back dead.
+ */
+func (r *Resolver) Resolve(ctx context.Context, name string) *DNSRecords {
+	records := &DNSRecords{}
+	if strings.HasPrefix(name, "*.") {
+		return records
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := r.resolver()
+
+	// A missing CNAME just gives back the name itself
+	if cname, err := res.LookupCNAME(ctx, name); err == nil {
+		cname = strings.TrimSuffix(strings.ToLower(cname), ".")
+		if cname != name {
+			records.CNAME = cname
+		}
+	}
+
+	addrs, err := res.LookupIPAddr(ctx, name)
+	if err != nil {
+		return records
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			records.A = append(records.A, addr.IP.String())
+		} else {
+			records.AAAA = append(records.AAAA, addr.IP.String())
+		}
+	}
+	records.Live = len(addrs) > 0
+
+	return records
+}
+
+/* ResolveAll: resolves every DNS name in results and stores the records on
+ * each result. Returns how many names were live.
+ */
+func (r *Resolver) ResolveAll(ctx context.Context, results Results) int {
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 50
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		live int
+	)
+
+	names := make(chan string)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				records := r.Resolve(ctx, name)
+
+				mu.Lock()
+				res := results[name]
+				res.DNS = records
+				results[name] = res
+				if records.Live {
+					live++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Reading the map while the workers write to it is a race, so grab the names
+	// up front.
+	// Only DNS names can be resolved.
+	pending := make([]string, 0, len(results))
+	for name, res := range results {
+		if res.Type == "" {
+			pending = append(pending, name)
+		}
+	}
+
+	for _, name := range pending {
+		select {
+		case names <- name:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(names)
+	wg.Wait()
+
+	return live
+}
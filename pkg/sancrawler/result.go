@@ -0,0 +1,238 @@
+package sancrawler
+
+import (
+	"sort"
+	"time"
+)
+
+// Result is a single name pulled out of a certificate along with where we found
+// it. Most of it describes the first certificate the name turned up on, the
+// rest gets filled in as the results go through post-processing.
+type Result struct {
+	Name string `json:"name"`
+	// crt.sh IDs, left at 0 for names that came from somewhere else.
+	// Certificates lists every certificate the name turned up on, lowest first.
+	CertificateID int   `json:"certificate_id"`
+	Certificates  []int `json:"certificates,omitempty"`
+	IssuerCAID    int   `json:"issuer_ca_id"`
+	// The issuing CA's distinguished name
+	IssuerName string `json:"issuer_name,omitempty"`
+	// "CN", "SAN" or "Subject" depending on which crawler produced it
+	Field string `json:"field"`
+	// Empty for DNS names, otherwise what the name is (eg. TypeIP)
+	Type string `json:"type,omitempty"`
+	// Where the name came from, and every seed that turned it up
+	Source string   `json:"source"`
+	Seeds  []string `json:"seeds"`
+	// The ASCII form of an internationalized name, which Name holds decoded
+	Punycode string `json:"punycode,omitempty"`
+	// The domain the name looks like, once it's been through FlagHomoglyphs
+	Homoglyph string `json:"homoglyph_of,omitempty"`
+	// Validity of the first certificate, when known. FirstSeen is the earliest
+	// NotBefore of all of them, Latest the one that runs the longest.
+	NotBefore time.Time          `json:"not_before,omitzero"`
+	NotAfter  time.Time          `json:"not_after,omitzero"`
+	FirstSeen time.Time          `json:"first_seen,omitzero"`
+	Latest    *LatestCertificate `json:"latest_certificate,omitempty"`
+	// Whether the certificate had expired when we found it
+	Expired bool `json:"expired"`
+	// Set when the name has only been seen on a precertificate
+	Precert bool `json:"precert,omitempty"`
+	// The certificate's key and what's wrong with it (eg. FindingWeakKey), only
+	// known when the certificate was parsed locally
+	SubjectKeyID   string   `json:"subject_key_id,omitempty"`
+	AuthorityKeyID string   `json:"authority_key_id,omitempty"`
+	KeyType        string   `json:"key_type,omitempty"`
+	Findings       []string `json:"findings,omitempty"`
+	// Only filled in once the results have been through a Resolver,
+	// GroupWildcards, a TakeoverChecker, a Prober and a Scorer respectively
+	DNS       *DNSRecords `json:"dns,omitempty"`
+	CoveredBy string      `json:"covered_by,omitempty"`
+	Takeover  *Takeover   `json:"takeover,omitempty"`
+	HTTP      []HTTPProbe `json:"http,omitempty"`
+	Score     *Score      `json:"score,omitempty"`
+}
+
+/* expired: whether a certificate valid until notAfter has expired by now. An
+ * unknown expiry counts as not expired.
+ */
+func expired(notAfter time.Time) bool {
+	return !notAfter.IsZero() && time.Now().After(notAfter)
+}
+
+// Results are keyed by name, only the first certificate we see a name on gets
+// its details recorded. The exception is precertificates, which give way to the
+// final certificate as soon as we see it since that's the one actually deployed.
+// Every certificate's ID still ends up in Certificates.
+type Results map[string]Result
+
+func (r Results) add(res Result) {
+	existing, ok := r[res.Name]
+	if !ok {
+		res.Certificates = mergeCertificates(nil, res)
+		res.FirstSeen = earliest(res.FirstSeen, res.NotBefore)
+		res.Latest = latest(nil, res)
+		r[res.Name] = res
+		return
+	}
+
+	certs := mergeCertificates(existing.Certificates, res)
+	first := earliest(earliest(existing.FirstSeen, existing.NotBefore), earliest(res.FirstSeen, res.NotBefore))
+	last := latest(latest(nil, existing), res)
+	if existing.Precert && !res.Precert {
+		existing = res
+	}
+	existing.Certificates = certs
+	existing.FirstSeen = first
+	existing.Latest = last
+	r[res.Name] = existing
+}
+
+// LatestCertificate is the certificate covering a name for the longest, which
+// is when the name really runs out rather than when the first certificate we
+// saw it on does.
+type LatestCertificate struct {
+	ID         int       `json:"id"`
+	IssuerCAID int       `json:"issuer_ca_id"`
+	IssuerName string    `json:"issuer_name,omitempty"`
+	NotAfter   time.Time `json:"not_after"`
+	precert    bool
+}
+
+/* latest: whichever of cert and the certificates res knows about runs the
+ * longest. Final certificates win ties against precertificates.
+ */
+func latest(cert *LatestCertificate, res Result) *LatestCertificate {
+	better := func(other *LatestCertificate) bool {
+		if other == nil || other.NotAfter.IsZero() {
+			return false
+		}
+		if cert == nil || other.NotAfter.After(cert.NotAfter) {
+			return true
+		}
+		return other.NotAfter.Equal(cert.NotAfter) && cert.precert && !other.precert
+	}
+
+	if better(res.Latest) {
+		cert = res.Latest
+	}
+	own := &LatestCertificate{
+		ID:         res.CertificateID,
+		IssuerCAID: res.IssuerCAID,
+		IssuerName: res.IssuerName,
+		NotAfter:   res.NotAfter,
+		precert:    res.Precert,
+	}
+	if better(own) {
+		cert = own
+	}
+	return cert
+}
+
+/* earliest: whichever of a and b comes first, ignoring unknown (zero) times.
+ */
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+/* mergeCertificates: adds the certificates res was seen on to certs, skipping
+ * the ones already there. certs are kept sorted so that's a binary search rather
+ * than a scan, names on thousands of certificates (wildcards on a big org) would
+ * make every add slower otherwise. It never writes over certs, other results can
+ * share them.
+ */
+func mergeCertificates(certs []int, res Result) []int {
+	// A whole list at once (eg. from Merge) gets sorted in together
+	if len(res.Certificates) > 0 {
+		merged := make([]int, 0, len(certs)+len(res.Certificates)+1)
+		merged = append(merged, certs...)
+		merged = append(merged, res.Certificates...)
+		merged = append(merged, res.CertificateID)
+		sort.Ints(merged)
+
+		ret := merged[:0]
+		for _, id := range merged {
+			if id != 0 && (len(ret) == 0 || ret[len(ret)-1] != id) {
+				ret = append(ret, id)
+			}
+		}
+		return ret
+	}
+
+	id := res.CertificateID
+	i := sort.SearchInts(certs, id)
+	if id == 0 || (i < len(certs) && certs[i] == id) {
+		return certs
+	}
+
+	// IDs mostly turn up in order, the database pages through them that way
+	if i == len(certs) {
+		return append(certs, id)
+	}
+	ret := make([]int, 0, len(certs)+1)
+	ret = append(ret, certs[:i]...)
+	ret = append(ret, id)
+	return append(ret, certs[i:]...)
+}
+
+/* Merge: adds everything in other to r. Names already in r keep their first
+ * certificate (unless it was a precertificate) but pick up any new seeds.
+ */
+func (r Results) Merge(other Results) {
+	for name, res := range other {
+		existing, ok := r[name]
+		if !ok {
+			res.Certificates = mergeCertificates(nil, res)
+			res.FirstSeen = earliest(res.FirstSeen, res.NotBefore)
+			res.Latest = latest(nil, res)
+			r[name] = res
+			continue
+		}
+
+		certs := mergeCertificates(existing.Certificates, res)
+		first := earliest(earliest(existing.FirstSeen, existing.NotBefore), earliest(res.FirstSeen, res.NotBefore))
+		last := latest(latest(nil, existing), res)
+		if existing.Precert && !res.Precert {
+			existing, res = res, existing
+		}
+		existing.Certificates = certs
+		existing.FirstSeen = first
+		existing.Latest = last
+
+		for _, seed := range res.Seeds {
+			if !containsString(existing.Seeds, seed) {
+				existing.Seeds = append(existing.Seeds, seed)
+			}
+		}
+		r[name] = existing
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+/* Sorted: the results ordered by name, handy for output that should be stable
+ * between runs.
+ */
+func (r Results) Sorted() []Result {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := make([]Result, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, r[name])
+	}
+	return ret
+}
@@ -0,0 +1,52 @@
+package sancrawler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeCertificates(t *testing.T) {
+	tests := []struct {
+		certs []int
+		res   Result
+		want  []int
+	}{
+		{nil, Result{}, nil},
+		{nil, Result{CertificateID: 5}, []int{5}},
+		{[]int{1, 5}, Result{CertificateID: 5}, []int{1, 5}},
+		{[]int{1, 5}, Result{CertificateID: 9}, []int{1, 5, 9}},
+		{[]int{1, 5}, Result{CertificateID: 3}, []int{1, 3, 5}},
+		{[]int{1, 5}, Result{CertificateID: 7, Certificates: []int{9, 5, 2, 9}}, []int{1, 2, 5, 7, 9}},
+		{nil, Result{Certificates: []int{4, 0, 4}}, []int{4}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeCertificates(tt.certs, tt.res); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeCertificates(%v, %d/%v) = %v, want %v", tt.certs, tt.res.CertificateID, tt.res.Certificates, got, tt.want)
+		}
+	}
+
+	// Other results can share the slice, it mustn't change under them
+	shared := []int{1, 5, 9}
+	mergeCertificates(shared[:2], Result{CertificateID: 3})
+	mergeCertificates(shared[:2], Result{Certificates: []int{2}})
+	if !reflect.DeepEqual(shared, []int{1, 5, 9}) {
+		t.Errorf("shared certificates changed to %v", shared)
+	}
+}
+
+func TestResultsAddCertificates(t *testing.T) {
+	r := make(Results)
+	for _, id := range []int{3, 1, 2, 3, 1} {
+		r.add(Result{Name: "www.example.com", CertificateID: id})
+	}
+	if got := r["www.example.com"].Certificates; !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("Certificates = %v, want [1 2 3]", got)
+	}
+
+	other := Results{"www.example.com": {Name: "www.example.com", CertificateID: 4, Certificates: []int{4, 2}}}
+	r.Merge(other)
+	if got := r["www.example.com"].Certificates; !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Errorf("Certificates after Merge = %v, want [1 2 3 4]", got)
+	}
+}
@@ -0,0 +1,42 @@
+package sancrawler
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/* retry: runs fn until it succeeds, giving up after retries extra attempts. The
+ * wait doubles after every failure starting from delay, with up to half of it
+ * again added as jitter so a pile of crawlers that failed together don't all
+ * come back at the same moment. Context errors and ErrStopped are never
+ * retried.
+ */
+func retry(ctx context.Context, retries int, delay time.Duration, what string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || ctx.Err() != nil || errors.Is(err, ErrStopped) {
+			return err
+		}
+
+		wait := delay << uint(attempt)
+		if wait > 0 {
+			wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+		}
+
+		log.WithFields(log.Fields{
+			"Attempt": attempt + 1,
+			"Wait":    wait,
+			"Error":   err,
+		}).Warn(what + " failed, retrying")
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
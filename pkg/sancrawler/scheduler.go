@@ -0,0 +1,212 @@
+package sancrawler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults for the crawler scheduler, used when the DBBackend fields are zero
+const (
+	DefaultMaxCrawlers   = 32
+	DefaultTargetLatency = 10 * time.Second
+)
+
+// How many certificates a crawler reads at a time
+const pageSize = 2000
+
+// How many certificates a CA needs before it gets split into ranges, ten pages
+// worth
+const partitionSize = 10 * pageSize
+
+// A single job's worth of work on a single range of a CA, along with how far
+// through it we've got.
+type partition struct {
+	job    crawlJob
+	data   crawlerData
+	cursor int64
+}
+
+// scheduler hands partitions out to the crawlers and decides how many of them
+// there should be. Any crawler can take any partition, so one that runs out of
+// work takes over whatever is still waiting rather than sitting idle while the
+// others grind through a big CA. The number of crawlers starts out at a guess
+// and then follows how quickly pages come back: while they stay under
+// targetLatency another crawler gets added, once they go over it half of them
+// are wound down. It never goes over max, and every crawler past the first
+// has to come out of budget, which is shared with every other query running.
+type scheduler struct {
+	mu sync.Mutex
+
+	queue         []partition
+	max           int
+	target        int
+	running       int
+	targetLatency time.Duration
+	budget        *crawlerBudget
+
+	// Moving average of how long a page takes, and how many pages have come
+	// back since the number of crawlers last changed.
+	latency     time.Duration
+	sinceChange int
+
+	// Starts another crawler, which has to call done when it exits
+	spawn func()
+}
+
+/* newScheduler: a scheduler for the partitions, biggest first so a large CA
+ * doesn't get left until the end. It doesn't start anything until start is
+ * called.
+ */
+func newScheduler(parts []partition, initial int, max int, targetLatency time.Duration, budget *crawlerBudget, spawn func()) *scheduler {
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].data.certs > parts[j].data.certs
+	})
+
+	if initial > max {
+		initial = max
+	}
+	if initial > len(parts) {
+		initial = len(parts)
+	}
+	if initial < 1 {
+		initial = 1
+	}
+
+	return &scheduler{
+		queue:         parts,
+		max:           max,
+		target:        initial,
+		targetLatency: targetLatency,
+		budget:        budget,
+		spawn:         spawn,
+	}
+}
+
+/* splitRange: splits the CA into ranges of certificate IDs so more than one
+ * crawler can work on it, at most maxParts of them. The ranges are all the same
+ * width, which only splits the certificates evenly if the CA issued them at a
+ * steady rate, but they get spread across the crawlers either way.
+ */
+func splitRange(data crawlerData, maxParts int) []crawlerData {
+	n := (data.certs + partitionSize - 1) / partitionSize
+	if n > maxParts {
+		n = maxParts
+	}
+
+	width := data.stop - data.start
+	if width < int64(n) {
+		n = int(width)
+	}
+	if n <= 1 {
+		return []crawlerData{data}
+	}
+
+	var ret []crawlerData
+	for i := 0; i < n; i++ {
+		r := data
+		r.start = data.start + width*int64(i)/int64(n)
+		r.stop = data.start + width*int64(i+1)/int64(n)
+		r.certs = data.certs*(i+1)/n - data.certs*i/n
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+/* start: starts the initial crawlers.
+ */
+func (s *scheduler) start() {
+	s.mu.Lock()
+	n := s.budget.take(s.target, 1)
+	s.target = n
+	s.running = n
+	s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.spawn()
+	}
+}
+
+/* next: the next partition to crawl, false once there's none left.
+ */
+func (s *scheduler) next() (partition, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return partition{}, false
+	}
+
+	p := s.queue[0]
+	s.queue = s.queue[1:]
+	return p, true
+}
+
+/* done: a crawler is exiting.
+ */
+func (s *scheduler) done() {
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+	s.budget.give(1)
+}
+
+/* observe: records how long a page took and adjusts the number of crawlers to
+ * match. Returns false when there are too many crawlers, the one asking should
+ * hand p back and exit.
+ */
+func (s *scheduler) observe(p partition, took time.Duration) bool {
+	s.mu.Lock()
+
+	if s.latency == 0 {
+		s.latency = took
+	} else {
+		s.latency = (4*s.latency + took) / 5
+	}
+	s.sinceChange++
+
+	// Give every crawler a chance to report back before changing things again,
+	// otherwise a single slow page could halve them over and over.
+	grow := false
+	if s.sinceChange >= s.running {
+		switch {
+		case s.latency > s.targetLatency && s.target > 1:
+			s.target /= 2
+			s.sinceChange = 0
+			s.logChange()
+		case s.latency <= s.targetLatency && s.target < s.max && len(s.queue) > 0 && s.budget.take(1, 0) == 1:
+			s.target++
+			s.running++
+			s.sinceChange = 0
+			grow = true
+			s.logChange()
+		}
+	}
+
+	if s.running > s.target {
+		s.running--
+		s.queue = append([]partition{p}, s.queue...)
+		s.mu.Unlock()
+		s.budget.give(1)
+		return false
+	}
+
+	s.mu.Unlock()
+
+	if grow {
+		s.spawn()
+	}
+	return true
+}
+
+/* logChange: logs the number of crawlers changing, s.mu has to be held.
+ */
+func (s *scheduler) logChange() {
+	log.WithFields(log.Fields{
+		"Crawlers": s.target,
+		"Latency":  s.latency.Round(time.Millisecond),
+		"Waiting":  len(s.queue),
+	}).Info("Scaling crawlers")
+}
@@ -0,0 +1,149 @@
+package sancrawler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     crawlerData
+		maxParts int
+		parts    int
+	}{
+		{"small CA", crawlerData{start: 0, stop: 1000, certs: 500}, 32, 1},
+		{"one partition's worth", crawlerData{start: 0, stop: 1000000, certs: partitionSize}, 32, 1},
+		{"split by size", crawlerData{start: 100, stop: 1000100, certs: 5*partitionSize + 1}, 32, 6},
+		{"capped", crawlerData{start: 0, stop: 1000000, certs: 100 * partitionSize}, 8, 8},
+		{"narrower than the parts", crawlerData{start: 10, stop: 13, certs: 100 * partitionSize}, 32, 3},
+		{"nothing to split", crawlerData{start: 10, stop: 10, certs: 100 * partitionSize}, 32, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitRange(tt.data, tt.maxParts)
+			if len(parts) != tt.parts {
+				t.Fatalf("%d parts, want %d", len(parts), tt.parts)
+			}
+
+			// The ranges have to cover the CA exactly, without gaps or overlaps,
+			// and share out all of its certificates
+			certs := 0
+			next := tt.data.start
+			for _, p := range parts {
+				if p.start != next {
+					t.Errorf("part starts at %d, want %d", p.start, next)
+				}
+				if p.stop <= p.start && tt.data.stop > tt.data.start {
+					t.Errorf("empty part %d-%d", p.start, p.stop)
+				}
+				next = p.stop
+				certs += p.certs
+			}
+			if next != tt.data.stop {
+				t.Errorf("parts stop at %d, want %d", next, tt.data.stop)
+			}
+			if certs != tt.data.certs {
+				t.Errorf("parts have %d certificates, want %d", certs, tt.data.certs)
+			}
+		})
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	parts := []partition{
+		{data: crawlerData{caID: 1, certs: 10}},
+		{data: crawlerData{caID: 2, certs: 300}},
+		{data: crawlerData{caID: 3, certs: 20}},
+	}
+
+	tests := []struct {
+		name    string
+		initial int
+		max     int
+		target  int
+	}{
+		{"initial", 2, 8, 2},
+		{"no more than max", 8, 2, 2},
+		{"no more than there are partitions", 8, 32, 3},
+		{"at least one", 0, 32, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(append([]partition(nil), parts...), tt.initial, tt.max, time.Second, nil, func() {})
+			if s.target != tt.target {
+				t.Errorf("target = %d, want %d", s.target, tt.target)
+			}
+
+			// Biggest first
+			for _, want := range []int{2, 3, 1} {
+				p, ok := s.next()
+				if !ok || p.data.caID != want {
+					t.Fatalf("next() = CA %d, want %d", p.data.caID, want)
+				}
+			}
+			if _, ok := s.next(); ok {
+				t.Error("next() handed out more partitions than there are")
+			}
+		})
+	}
+}
+
+func TestSchedulerScaling(t *testing.T) {
+	var parts []partition
+	for i := 0; i < 10; i++ {
+		parts = append(parts, partition{data: crawlerData{caID: i, certs: 1}})
+	}
+
+	spawned := 0
+	s := newScheduler(parts, 1, 3, time.Second, nil, func() { spawned++ })
+	s.start()
+	if spawned != 1 {
+		t.Fatalf("started %d crawlers, want 1", spawned)
+	}
+
+	// Fast pages add a crawler each time every crawler has reported back, up
+	// to max
+	for i := 0; i < 10 && s.running < 3; i++ {
+		p, _ := s.next()
+		if !s.observe(p, 100*time.Millisecond) {
+			t.Fatal("a fast page stopped a crawler")
+		}
+	}
+	if s.running != 3 || spawned != 3 {
+		t.Fatalf("%d crawlers running, %d started, want 3 of each", s.running, spawned)
+	}
+	p, _ := s.next()
+	s.observe(p, 100*time.Millisecond)
+	if spawned != 3 {
+		t.Errorf("went over max, %d started", spawned)
+	}
+
+	// Slow pages halve them, the crawler told to stop hands its partition back
+	waiting := len(s.queue)
+	stopped := 0
+	for i := 0; i < 10 && stopped == 0; i++ {
+		p, _ := s.next()
+		if !s.observe(p, 10*time.Second) {
+			stopped++
+			if s.queue[0].data.caID != p.data.caID {
+				t.Error("partition wasn't handed back to the front of the queue")
+			}
+		}
+		waiting--
+	}
+	if stopped == 0 || s.target != 1 {
+		t.Fatalf("target = %d after slow pages, want 1", s.target)
+	}
+	if len(s.queue) != waiting+1 {
+		t.Errorf("%d partitions waiting, want %d", len(s.queue), waiting+1)
+	}
+
+	running := s.running
+	s.done()
+	if s.running != running-1 {
+		t.Errorf("done() left %d running, want %d", s.running, running-1)
+	}
+}
@@ -0,0 +1,110 @@
+package sancrawler
+
+import (
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Scope decides which names are in scope based on their apex (eTLD+1) domain.
+// An empty include list allows everything that isn't excluded. Anchors are apex
+// domains known to be the target's, they are in scope and so is anything sharing
+// a certificate with them, whatever it looks like.
+type Scope struct {
+	include map[string]bool
+	exclude map[string]bool
+	anchors map[string]bool
+}
+
+/* NewScope: builds a scope from lists of apex domains.
+ */
+func NewScope(include []string, exclude []string, anchors []string) *Scope {
+	s := &Scope{
+		include: make(map[string]bool),
+		exclude: make(map[string]bool),
+		anchors: make(map[string]bool),
+	}
+
+	for _, d := range include {
+		if d = normalizeApex(d); d != "" {
+			s.include[d] = true
+		}
+	}
+	for _, d := range anchors {
+		if d = normalizeApex(d); d != "" {
+			s.anchors[d] = true
+		}
+	}
+	for _, d := range exclude {
+		if d = normalizeApex(d); d != "" {
+			s.exclude[d] = true
+		}
+	}
+
+	return s
+}
+
+func normalizeApex(d string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+/* Allows: whether name falls under an allowed apex. Names without a sensible
+ * eTLD+1 (internal names, bare TLDs) only get through if there's no include
+ * list.
+ */
+func (s *Scope) Allows(name string) bool {
+	if s == nil {
+		return true
+	}
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+	if err != nil {
+		return len(s.include) == 0
+	}
+
+	if s.exclude[apex] {
+		return false
+	}
+
+	return len(s.include) == 0 || s.include[apex] || s.anchors[apex]
+}
+
+/* Filter: returns only the in scope results. A nil scope keeps everything, and
+ * so does any scope for results that aren't DNS names since they don't have an
+ * apex to go by. Names on the same certificate as an anchor are kept unless
+ * their apex is excluded.
+ */
+func (s *Scope) Filter(results Results) Results {
+	if s == nil {
+		return results
+	}
+
+	anchored := make(map[int]bool)
+	if len(s.anchors) > 0 {
+		for name, res := range results {
+			apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+			if err == nil && s.anchors[apex] && res.CertificateID != 0 {
+				anchored[res.CertificateID] = true
+			}
+		}
+	}
+
+	ret := make(Results)
+	for name, res := range results {
+		if res.Type != "" || s.Allows(name) || (anchored[res.CertificateID] && !s.Excludes(name)) {
+			ret[name] = res
+		}
+	}
+	return ret
+}
+
+/* Excludes: whether name falls under an excluded apex.
+ */
+func (s *Scope) Excludes(name string) bool {
+	if s == nil {
+		return false
+	}
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+	return err == nil && s.exclude[apex]
+}
@@ -0,0 +1,279 @@
+package sancrawler
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Score is how likely a name is to belong to the target, from 0 to 100, along
+// with what went into it.
+type Score struct {
+	Value   int      `json:"value"`
+	Reasons []string `json:"reasons"`
+}
+
+// What each thing that goes into a score is worth. Names start off with how
+// much the seed that found them says about the certificate: an organization or
+// key is on the certificate itself, a keyword could be anywhere.
+const (
+	scoreExactSeed    = 40
+	scorePivotedSeed  = 25
+	scoreKeywordSeed  = 10
+	scoreSeedApex     = 40
+	scoreKnownApex    = 30
+	scoreLinkedApex   = 15
+	scoreSharedCert   = 15
+	scoreIssuer       = 10
+	scoreManySeeds    = 10
+	scoreWildcard     = -10
+	scoreHostingCert  = -20
+	hostingCertApexes = 10
+)
+
+// Words that say nothing about who an organization is, so names containing
+// them don't count as matching it
+var seedStopWords = map[string]bool{
+	"the": true, "and": true, "group": true, "holding": true, "holdings": true,
+	"international": true, "global": true, "services": true, "service": true,
+	"company": true, "systems": true, "solutions": true, "technologies": true,
+	"technology": true, "bank": true, "www": true, "com": true, "net": true, "org": true,
+}
+
+// Scorer rates each name on how likely it is to belong to the target, going by
+// the queries that found it, whether its apex domain (eTLD+1) looks like a seed
+// or is already known, what else was on its certificate, which CA issued it and
+// whether it's a wildcard. Keyword seeds turn up a lot of names that have
+// nothing to do with the target, this is for telling them apart.
+type Scorer struct {
+	// Queries are the seeds that were crawled. Seeds that aren't among them,
+	// like organizations -recursive found, count as pivots.
+	Queries []Query
+	// Apexes are apex domains already known to belong to the target, eg. the
+	// scope's include list.
+	Apexes []string
+}
+
+/* ScoreAll: scores every result in place.
+ */
+func (s *Scorer) ScoreAll(results Results) {
+	seeds := make(map[string]Query)
+	for _, q := range s.Queries {
+		seeds[q.Value] = q
+	}
+
+	// Apexes that look like a seed, or are already known, are the target's.
+	// Anything sharing a certificate with one of their names is probably the
+	// target's too.
+	var tokens []string
+	for _, q := range s.Queries {
+		tokens = append(tokens, seedTokens(q)...)
+	}
+
+	known := make(map[string]string)
+	for _, apex := range s.Apexes {
+		known[strings.ToLower(strings.TrimPrefix(apex, "*."))] = "known apex"
+	}
+
+	apexes := make(map[string]string)
+	certApexes := make(map[int]map[string]bool)
+	for name, res := range results {
+		apex := resultApex(res)
+		apexes[name] = apex
+		if apex == "" {
+			continue
+		}
+		if _, ok := known[apex]; !ok && apexMatches(apex, tokens) {
+			known[apex] = "apex looks like a seed"
+		}
+		if res.CertificateID != 0 {
+			if certApexes[res.CertificateID] == nil {
+				certApexes[res.CertificateID] = make(map[string]bool)
+			}
+			certApexes[res.CertificateID][apex] = true
+		}
+	}
+
+	goodCerts := make(map[int]int)
+	linked := make(map[string]bool)
+	issuers := make(map[int]bool)
+	for name, res := range results {
+		if _, ok := known[apexes[name]]; !ok || res.CertificateID == 0 {
+			continue
+		}
+		goodCerts[res.CertificateID]++
+		issuers[res.IssuerCAID] = true
+		for apex := range certApexes[res.CertificateID] {
+			linked[apex] = true
+		}
+	}
+
+	for name, res := range results {
+		apex := apexes[name]
+		score := &Score{}
+		add := func(points int, reason string) {
+			score.Value += points
+			score.Reasons = append(score.Reasons, reason)
+		}
+
+		// The best of the seeds that found it
+		base, baseReason := 0, ""
+		for _, seed := range res.Seeds {
+			points, reason := scorePivotedSeed, "found by a pivot"
+			if q, ok := seeds[seed]; ok {
+				switch {
+				case q.Match == MatchExact && q.NameType != "":
+					points, reason = scoreExactSeed, "found by an exact seed"
+				case q.Match == MatchExact:
+					points, reason = scoreKeywordSeed, "found by a keyword"
+				default:
+					points, reason = scoreKeywordSeed, "found by a pattern"
+				}
+			}
+			if points > base {
+				base, baseReason = points, reason
+			}
+		}
+		if base > 0 {
+			add(base, baseReason)
+		}
+		if len(res.Seeds) > 1 {
+			add(scoreManySeeds, "found by more than one seed")
+		}
+
+		reason, isKnown := known[apex]
+		if isKnown {
+			if reason == "known apex" {
+				add(scoreKnownApex, reason)
+			} else {
+				add(scoreSeedApex, reason)
+			}
+		} else if linked[apex] {
+			add(scoreLinkedApex, "apex shares a certificate with the target's")
+		}
+
+		// A name can't vouch for itself, and the CA only matters for names we
+		// aren't sure of yet
+		others := goodCerts[res.CertificateID]
+		if isKnown {
+			others--
+		}
+		if others > 0 {
+			add(scoreSharedCert, "on a certificate with the target's names")
+		}
+		if !isKnown && res.IssuerCAID != 0 && issuers[res.IssuerCAID] {
+			add(scoreIssuer, "issued by one of the target's CAs")
+		}
+
+		if strings.HasPrefix(res.Name, "*.") && !linked[apex] {
+			add(scoreWildcard, "wildcard")
+		}
+		if len(certApexes[res.CertificateID]) > hostingCertApexes {
+			add(scoreHostingCert, "on a certificate shared by many domains")
+		}
+
+		if score.Value < 0 {
+			score.Value = 0
+		}
+		if score.Value > 100 {
+			score.Value = 100
+		}
+
+		res.Score = score
+		results[name] = res
+	}
+}
+
+/* FilterScore: only the results scoring at least min, along with how many
+ * were dropped. Results that haven't been scored are kept.
+ */
+func FilterScore(results Results, min int) (Results, int) {
+	ret := make(Results)
+	dropped := 0
+	for name, res := range results {
+		if res.Score != nil && res.Score.Value < min {
+			dropped++
+			continue
+		}
+		ret[name] = res
+	}
+	return ret, dropped
+}
+
+/* resultApex: the apex domain of a result's host, whatever kind of name it is.
+ * IP addresses don't have one.
+ */
+func resultApex(res Result) string {
+	host := strings.TrimPrefix(res.Name, "*.")
+	switch res.Type {
+	case TypeIP:
+		return ""
+	case TypeEmail:
+		host = host[strings.LastIndex(host, "@")+1:]
+	case TypeURI:
+		u, err := url.Parse(res.Name)
+		if err != nil {
+			return ""
+		}
+		host = u.Hostname()
+	}
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host))
+	if err != nil {
+		return ""
+	}
+	return apex
+}
+
+/* seedTokens: the parts of a seed that would show up in the target's domain
+ * names. "Acme Widgets, Inc." gives acmewidgets, acme and widgets. Patterns
+ * and hashes don't give anything.
+ */
+func seedTokens(q Query) []string {
+	switch q.Match {
+	case MatchRegex, MatchLike:
+		return nil
+	}
+	switch q.NameType {
+	case NameTypeSPKI, NameTypeIssuerCA:
+		return nil
+	}
+
+	// A seed that's already a domain gives its apex's label
+	value := strings.ToLower(q.Value)
+	if apex, err := publicsuffix.EffectiveTLDPlusOne(value); err == nil && strings.Contains(value, ".") {
+		suffix, _ := publicsuffix.PublicSuffix(apex)
+		return []string{strings.TrimSuffix(apex, "."+suffix)}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(baseOrganization(q.Value)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var tokens []string
+	if len(words) > 1 {
+		tokens = append(tokens, strings.Join(words, ""))
+	}
+	for _, word := range words {
+		if len(word) >= 3 && !seedStopWords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+/* apexMatches: whether the apex's label, without the public suffix, contains
+ * any of the tokens.
+ */
+func apexMatches(apex string, tokens []string) bool {
+	suffix, _ := publicsuffix.PublicSuffix(apex)
+	label := strings.ReplaceAll(strings.TrimSuffix(apex, "."+suffix), "-", "")
+	for _, token := range tokens {
+		if strings.Contains(label, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package sancrawler
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// crawlerBudget is how many crawlers every query running on a DBBackend gets
+// between them. Each query's scheduler takes crawlers out of it and hands them
+// back, so ten seeds crawled at once don't get ten times the crawlers one would.
+// A nil budget never runs out.
+type crawlerBudget struct {
+	mu      sync.Mutex
+	max     int
+	running int
+}
+
+/* take: up to n crawlers, however many are left but never fewer than min. A
+ * query always gets its first crawler even when the budget is spent, otherwise
+ * it would never get started.
+ */
+func (c *crawlerBudget) take(n int, min int) int {
+	if c == nil {
+		return n
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if free := c.max - c.running; n > free {
+		n = free
+	}
+	if n < min {
+		n = min
+	}
+	c.running += n
+	return n
+}
+
+/* give: hands n crawlers back.
+ */
+func (c *crawlerBudget) give(n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.running -= n
+	c.mu.Unlock()
+}
+
+// sharedCertificates remembers the names every job found on every certificate
+// it read, so that when another query comes across the same certificate it can
+// skip pulling it out of the database again and use these. Seeds for the same
+// target tend to match a lot of the same certificates.
+type sharedCertificates struct {
+	mu sync.RWMutex
+	// Job key, then CA, then certificate ID
+	names map[string]map[int]map[int][]Result
+	// How many certificates got copied rather than read
+	reused int64
+}
+
+func newSharedCertificates() *sharedCertificates {
+	return &sharedCertificates{names: make(map[string]map[int]map[int][]Result)}
+}
+
+/* between: the certificates from a CA already read for a job, with IDs from
+ * start up to (not including) stop. These are the ones a page below stop can
+ * leave out.
+ */
+func (s *sharedCertificates) between(job crawlJob, caID int, start int64, stop int64) map[int][]Result {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ret := make(map[int][]Result)
+	for id, names := range s.names[job.key()][caID] {
+		if int64(id) >= start && int64(id) < stop {
+			ret[id] = names
+		}
+	}
+	return ret
+}
+
+/* record: the names found on a page of certificates from a CA, once the whole
+ * page is read so nobody else picks up half a certificate.
+ */
+func (s *sharedCertificates) record(job crawlJob, caID int, page map[int][]Result) {
+	if s == nil || len(page) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byCA, ok := s.names[job.key()]
+	if !ok {
+		byCA = make(map[int]map[int][]Result)
+		s.names[job.key()] = byCA
+	}
+	certs, ok := byCA[caID]
+	if !ok {
+		certs = make(map[int][]Result)
+		byCA[caID] = certs
+	}
+	for id, names := range page {
+		certs[id] = names
+	}
+}
+
+/* reuse: counts a certificate that didn't need reading.
+ */
+func (s *sharedCertificates) reuse() {
+	atomic.AddInt64(&s.reused, 1)
+}
@@ -0,0 +1,54 @@
+package sancrawler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// NameTypeSPKI marks a Query whose Value is the SHA-256 of a public key (see
+// SPKIHash) rather than a name. Crawler looks up every certificate using that
+// key instead of asking its backends to match on certificate_identity.
+const NameTypeSPKI = "spkiSHA256"
+
+/* SPKIHash: the hex SHA-256 of the certificate's Subject Public Key Info, the
+ * same hash crt.sh searches on.
+ */
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+/* BySPKI: Get all the names on certificates using the public key with the given
+ * SHA-256. Orgs reuse keys across hostnames a lot more than they should, so this
+ * often turns up names that share nothing else with the seed.
+ */
+func (c *Crawler) BySPKI(ctx context.Context, spki string) (Results, error) {
+	return c.Crawl(ctx, Query{Value: spki, NameType: NameTypeSPKI})
+}
+
+/* crawlSPKI: looks up the certificates for a NameTypeSPKI query and pulls the
+ * names out of them locally.
+ */
+func (c *Crawler) crawlSPKI(ctx context.Context, q Query, emit func(Result)) (Results, error) {
+	certs, err := c.LookupCertificates(ctx, LookupSPKI, q.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(Results)
+	for _, cert := range certs {
+		base := certificateResult(cert)
+		base.Source = "crt.sh"
+
+		for _, res := range certificateNames(cert, base, nil, false) {
+			ret.add(res)
+			if emit != nil {
+				emit(res)
+			}
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,169 @@
+package sancrawler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// StartTLSPorts are the ports each protocol FetchOptions.StartTLS understands
+// listens on by default, and what it's guessed from when there's no scheme.
+var StartTLSPorts = map[string]string{
+	"smtp": "25",
+	"imap": "143",
+	"pop3": "110",
+	"ftp":  "21",
+	"xmpp": "5222",
+}
+
+/* startTLSProtocol: which protocol to speak to get to STARTTLS, going by the
+ * scheme and failing that the port.
+ */
+func startTLSProtocol(scheme string, port string) (string, error) {
+	scheme = strings.ToLower(scheme)
+	if scheme == "submission" {
+		scheme = "smtp"
+	}
+	if _, ok := StartTLSPorts[scheme]; ok {
+		return scheme, nil
+	}
+	if scheme != "" {
+		return "", errors.New("STARTTLS isn't supported for " + scheme)
+	}
+
+	switch port {
+	case "25", "587":
+		return "smtp", nil
+	}
+	for proto, p := range StartTLSPorts {
+		if p == port {
+			return proto, nil
+		}
+	}
+	return "", errors.New("can't tell which protocol to STARTTLS with on port " + port + ", give a scheme like smtp://")
+}
+
+/* startTLS: does whatever the protocol needs before the server will start a
+ * TLS handshake on conn. What the server sent is only read as far as needed,
+ * which is everything since nothing comes before the handshake.
+ */
+func startTLS(ctx context.Context, conn net.Conn, proto string, host string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	send := func(line string) error {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err
+	}
+
+	var err error
+	switch proto {
+	case "smtp":
+		if _, _, err = r.ReadResponse(220); err != nil {
+			break
+		}
+		if err = send("EHLO sancrawler"); err != nil {
+			break
+		}
+		if _, _, err = r.ReadResponse(250); err != nil {
+			break
+		}
+		if err = send("STARTTLS"); err != nil {
+			break
+		}
+		_, _, err = r.ReadResponse(220)
+
+	case "ftp":
+		if _, _, err = r.ReadResponse(220); err != nil {
+			break
+		}
+		if err = send("AUTH TLS"); err != nil {
+			break
+		}
+		_, _, err = r.ReadResponse(234)
+
+	case "imap":
+		if err = expectLine(r, "* OK"); err != nil {
+			break
+		}
+		if err = send("a1 STARTTLS"); err != nil {
+			break
+		}
+		err = expectLine(r, "a1 OK")
+
+	case "pop3":
+		if err = expectLine(r, "+OK"); err != nil {
+			break
+		}
+		if err = send("STLS"); err != nil {
+			break
+		}
+		err = expectLine(r, "+OK")
+
+	case "xmpp":
+		_, err = fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+			"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", host)
+		if err != nil {
+			break
+		}
+		if err = readUntil(r.R, "</stream:features>"); err != nil {
+			break
+		}
+		if _, err = conn.Write([]byte("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")); err != nil {
+			break
+		}
+		err = readUntil(r.R, "<proceed")
+
+	default:
+		err = errors.New("STARTTLS isn't supported for " + proto)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s STARTTLS failed: %w", proto, err)
+	}
+	return nil
+}
+
+/* expectLine: reads a line and fails unless it starts with prefix. IMAP servers
+ * can send untagged lines first, those are skipped.
+ */
+func expectLine(r *textproto.Reader, prefix string) error {
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, prefix) {
+			return nil
+		}
+		if !strings.HasPrefix(line, "* ") || prefix == "* OK" {
+			return errors.New("server said: " + line)
+		}
+	}
+}
+
+/* readUntil: reads until marker has gone past. XMPP doesn't do lines, and all
+ * that matters is that the server got to the point of saying it.
+ */
+func readUntil(r *bufio.Reader, marker string) error {
+	var seen strings.Builder
+	for !strings.Contains(seen.String(), marker) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		seen.WriteByte(b)
+		if strings.Contains(seen.String(), "<failure") || strings.Contains(seen.String(), "<stream:error") {
+			return errors.New("server refused: " + seen.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package sancrawler
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Statistics sums up a set of results. Certificates is how many distinct
+// certificates were read to get them, when the backend knows, and Runtime is
+// how long that took. Domains counts names by eTLD+1, with names that don't
+// have one counted in Unparsed. Issuers counts names by the CA that issued the
+// certificate they were first seen on. Both are sorted most names first, and
+// ApexDomains and IssuerCount say how long they were before any Top. Expired
+// and Valid split the names by whether that certificate has expired, names
+// whose certificate's validity isn't known are in neither.
+type Statistics struct {
+	Certificates   int64   `json:"certificates_scanned"`
+	Names          int     `json:"unique_names"`
+	Wildcards      int     `json:"wildcards"`
+	IPs            int     `json:"ip_sans"`
+	Expired        int     `json:"expired"`
+	Valid          int     `json:"valid"`
+	ApexDomains    int     `json:"apex_domains"`
+	Domains        []Count `json:"domains"`
+	Unparsed       int     `json:"unparsed"`
+	IssuerCount    int     `json:"issuer_count"`
+	Issuers        []Count `json:"issuers"`
+	Runtime        float64 `json:"runtime_seconds"`
+	CertsPerSecond float64 `json:"certificates_per_second"`
+	NamesPerSecond float64 `json:"names_per_second"`
+}
+
+// Count is how many names something has
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+/* SortCounts: the counts, highest first. Ties are broken by name so the order
+ * is always the same.
+ */
+func SortCounts(counts map[string]int) []Count {
+	ret := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		ret = append(ret, Count{Name: name, Count: count})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/* DomainStatistics: counts which top level domains (eTLD+1) occur the most
+ * frequently in a set of results. Can be useful in helping to remove false
+ * positives, or gain insight into subdomain distribution. Names that can't be
+ * parsed are counted in the second return value.
+ */
+func DomainStatistics(results Results) (map[string]int, int) {
+	domains := make(map[string]int)
+	failed := 0
+
+	for k := range results {
+		d, err := publicsuffix.EffectiveTLDPlusOne(k)
+
+		if err != nil {
+			failed++
+		} else {
+			domains[d]++
+		}
+	}
+
+	return domains, failed
+}
+
+/* NewStatistics: works out the statistics for a set of results, which took
+ * runtime to find by reading certs certificates.
+ */
+func NewStatistics(results Results, certs int64, runtime time.Duration) Statistics {
+	stats := Statistics{
+		Certificates: certs,
+		Names:        len(results),
+		Runtime:      runtime.Seconds(),
+	}
+	domains := make(map[string]int)
+	issuers := make(map[string]int)
+
+	for name, res := range results {
+		if strings.HasPrefix(name, "*.") {
+			stats.Wildcards++
+		}
+		if res.Type == TypeIP {
+			stats.IPs++
+		}
+
+		if res.Expired {
+			stats.Expired++
+		} else if !res.NotAfter.IsZero() {
+			stats.Valid++
+		}
+
+		// Only DNS names have a registrable domain, the wildcard doesn't count
+		if res.Type == "" {
+			d, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+			if err != nil {
+				stats.Unparsed++
+			} else {
+				domains[d]++
+			}
+		}
+
+		issuer := res.IssuerName
+		if issuer == "" {
+			issuer = "unknown"
+		}
+		issuers[issuer]++
+	}
+
+	stats.Domains, stats.ApexDomains = SortCounts(domains), len(domains)
+	stats.Issuers, stats.IssuerCount = SortCounts(issuers), len(issuers)
+
+	if stats.Runtime > 0 {
+		stats.CertsPerSecond = float64(certs) / stats.Runtime
+		stats.NamesPerSecond = float64(stats.Names) / stats.Runtime
+	}
+
+	return stats
+}
+
+/* Top: the statistics with only the n domains and issuers with the most names,
+ * or all of them if n is zero or less.
+ */
+func (s Statistics) Top(n int) Statistics {
+	if n > 0 && len(s.Domains) > n {
+		s.Domains = s.Domains[:n]
+	}
+	if n > 0 && len(s.Issuers) > n {
+		s.Issuers = s.Issuers[:n]
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package sancrawler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Streamer is implemented by backends which can hand names over as they find
+// them, rather than all at once when the crawl is done. The database backend
+// is the only one where that's worth doing.
+type Streamer interface {
+	CrawlStream(ctx context.Context, q Query, found func(Result)) (Results, error)
+}
+
+// NameSet remembers which names have been seen, for crawls with more of them
+// than fit in a map (see Crawler.Seen). Add reports whether name is new.
+type NameSet interface {
+	Add(name string) (bool, error)
+}
+
+/* crawlBackend: runs the query against backend, streaming to emit if there's
+ * anywhere to stream to. Backends that can't stream have their results emitted
+ * when they finish. How it went is counted in m.
+ */
+func crawlBackend(ctx context.Context, backend Backend, q Query, emit func(Result), m *Metrics) (ret Results, err error) {
+	began := time.Now()
+	defer func() { m.query(backend, time.Since(began), err) }()
+
+	if emit == nil {
+		return backend.Crawl(ctx, q)
+	}
+
+	if streamer, ok := backend.(Streamer); ok {
+		return streamer.CrawlStream(ctx, q, emit)
+	}
+
+	ret, err = backend.Crawl(ctx, q)
+	for _, res := range ret {
+		emit(res)
+	}
+	return ret, err
+}
+
+/* streamTo: wraps found so that it only sees the names the query's filter
+ * allows, tagged with the query's value as the seed. Nil stays nil.
+ */
+func streamTo(q Query, found func(Result), seen NameSet) func(Result) {
+	if found == nil {
+		return nil
+	}
+
+	once := streamOnce(found, seen)
+	return func(res Result) {
+		if q.Filter.Allows(res) {
+			res.Seeds = []string{q.Value}
+			once(res)
+		}
+	}
+}
+
+/* streamOnce: wraps found so that it's only called once per name, and never
+ * from more than one goroutine at a time. Names are remembered in seen, or a
+ * map if it's nil. Nil stays nil.
+ */
+func streamOnce(found func(Result), seen NameSet) func(Result) {
+	if found == nil {
+		return nil
+	}
+	if seen == nil {
+		seen = make(nameMap)
+	}
+
+	var mu sync.Mutex
+
+	return func(res Result) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Better to hand over a name twice than lose it
+		added, err := seen.Add(res.Name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Name":  res.Name,
+				"Error": err,
+			}).Warn("Could not check whether name was already found")
+		}
+		if added || err != nil {
+			found(res)
+		}
+	}
+}
+
+// nameMap is the NameSet for crawls that fit in memory
+type nameMap map[string]bool
+
+func (m nameMap) Add(name string) (bool, error) {
+	if m[name] {
+		return false, nil
+	}
+	m[name] = true
+	return true, nil
+}
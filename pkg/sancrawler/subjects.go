@@ -0,0 +1,74 @@
+package sancrawler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SubjectAttribute is one value of one subject field, along with how many
+// certificates it was seen on. Field is the short name (eg. O), see
+// SubjectFields.
+type SubjectAttribute struct {
+	Field        string `json:"field"`
+	Value        string `json:"value"`
+	Certificates int    `json:"certificates"`
+}
+
+// SubjectLookup is implemented by backends which can tell us what subject
+// metadata the certificates covering a domain carry.
+type SubjectLookup interface {
+	DomainSubjects(ctx context.Context, domain string) ([]SubjectAttribute, error)
+}
+
+/* DomainSubjects: the subject metadata on every certificate covering the domain
+ * (or a wildcard for it), most common first. It's where a crawl usually starts,
+ * the organizations in particular make good seeds.
+ */
+func (c *Crawler) DomainSubjects(ctx context.Context, domain string) ([]SubjectAttribute, error) {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if domain == "" {
+		return nil, errors.New("no domain given")
+	}
+
+	for _, backend := range []Backend{c.Backend, c.Fallback} {
+		if lookup, ok := backend.(SubjectLookup); ok {
+			attrs, err := lookup.DomainSubjects(ctx, domain)
+			if err != nil {
+				return nil, err
+			}
+			SortSubjects(attrs)
+			return attrs, nil
+		}
+	}
+
+	return nil, errors.New("no backend supports subject lookups")
+}
+
+/* SortSubjects: most certificates first, then by field and value so the order
+ * is stable.
+ */
+func SortSubjects(attrs []SubjectAttribute) {
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].Certificates != attrs[j].Certificates {
+			return attrs[i].Certificates > attrs[j].Certificates
+		}
+		if attrs[i].Field != attrs[j].Field {
+			return attrs[i].Field < attrs[j].Field
+		}
+		return attrs[i].Value < attrs[j].Value
+	})
+}
+
+/* fieldForNameType: the short name of a subject field, the other way round to
+ * NameTypeForField.
+ */
+func fieldForNameType(nameType string) string {
+	for short, t := range SubjectFields {
+		if t == nameType {
+			return short
+		}
+	}
+	return nameType
+}
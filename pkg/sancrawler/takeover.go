@@ -0,0 +1,239 @@
+package sancrawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TakeoverFingerprint describes a service whose names can be claimed by anyone
+// once the customer is gone. A CNAME pointing under one of CNAME is a candidate
+// when the target no longer resolves (for NXDomain services) or the page served
+// contains Fingerprint.
+type TakeoverFingerprint struct {
+	Service     string   `json:"service"`
+	CNAME       []string `json:"cname"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	NXDomain    bool     `json:"nxdomain,omitempty"`
+}
+
+// DefaultTakeoverFingerprints is a small set of well known vulnerable services,
+// mostly from https://github.com/EdOverflow/can-i-take-over-xyz
+var DefaultTakeoverFingerprints = []TakeoverFingerprint{
+	{Service: "AWS S3", CNAME: []string{"s3.amazonaws.com", "s3-website.amazonaws.com"}, Fingerprint: "NoSuchBucket"},
+	{Service: "AWS Elastic Beanstalk", CNAME: []string{"elasticbeanstalk.com"}, NXDomain: true},
+	{Service: "Azure", CNAME: []string{"azurewebsites.net", "cloudapp.net", "cloudapp.azure.com", "trafficmanager.net", "blob.core.windows.net", "azureedge.net", "azure-api.net"}, NXDomain: true},
+	{Service: "GitHub Pages", CNAME: []string{"github.io"}, Fingerprint: "There isn't a GitHub Pages site here."},
+	{Service: "Heroku", CNAME: []string{"herokuapp.com", "herokudns.com"}, Fingerprint: "No such app"},
+	{Service: "Shopify", CNAME: []string{"myshopify.com"}, Fingerprint: "Sorry, this shop is currently unavailable."},
+	{Service: "Fastly", CNAME: []string{"fastly.net"}, Fingerprint: "Fastly error: unknown domain"},
+	{Service: "Pantheon", CNAME: []string{"pantheonsite.io"}, Fingerprint: "The gods are wise, but do not know of the site which you seek."},
+	{Service: "Tumblr", CNAME: []string{"domains.tumblr.com"}, Fingerprint: "Whatever you were looking for doesn't currently exist at this address"},
+	{Service: "Ghost", CNAME: []string{"ghost.io"}, Fingerprint: "The thing you were looking for is no longer here, or never was"},
+	{Service: "Surge", CNAME: []string{"surge.sh"}, Fingerprint: "project not found"},
+	{Service: "Bitbucket", CNAME: []string{"bitbucket.io"}, Fingerprint: "Repository not found"},
+	{Service: "Zendesk", CNAME: []string{"zendesk.com"}, Fingerprint: "Help Center Closed"},
+	{Service: "Help Scout", CNAME: []string{"helpscoutdocs.com"}, Fingerprint: "No settings were found for this company:"},
+	{Service: "Readme.io", CNAME: []string{"readme.io"}, Fingerprint: "Project doesnt exist... yet!"},
+	{Service: "Agile CRM", CNAME: []string{"agilecrm.com"}, Fingerprint: "Sorry, this page is no longer available."},
+	{Service: "Unbounce", CNAME: []string{"unbouncepages.com"}, Fingerprint: "The requested URL was not found on this server."},
+}
+
+// Takeover explains why a name looks like it can be taken over. Service is
+// empty when the CNAME dangles but we don't know what it points at.
+type Takeover struct {
+	Service string `json:"service,omitempty"`
+	CNAME   string `json:"cname"`
+	Reason  string `json:"reason"`
+}
+
+// TakeoverChecker looks for dangling CNAMEs among resolved names. The zero
+// value uses DefaultTakeoverFingerprints and the system resolver.
+type TakeoverChecker struct {
+	Fingerprints []TakeoverFingerprint
+	Resolver     *Resolver
+	Client       *http.Client
+	// Concurrency is how many names get checked at once.
+	Concurrency int
+}
+
+/* LoadTakeoverFingerprints: reads fingerprints from a JSON file holding a list
+ * of objects in the same shape as TakeoverFingerprint.
+ */
+func LoadTakeoverFingerprints(path string) ([]TakeoverFingerprint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints []TakeoverFingerprint
+	if err := json.Unmarshal(data, &fingerprints); err != nil {
+		return nil, err
+	}
+	return fingerprints, nil
+}
+
+func (t *TakeoverChecker) fingerprint(cname string) *TakeoverFingerprint {
+	fingerprints := t.Fingerprints
+	if fingerprints == nil {
+		fingerprints = DefaultTakeoverFingerprints
+	}
+
+	for i, fp := range fingerprints {
+		for _, suffix := range fp.CNAME {
+			suffix = strings.ToLower(suffix)
+			if cname == suffix || strings.HasSuffix(cname, "."+suffix) {
+				return &fingerprints[i]
+			}
+		}
+	}
+	return nil
+}
+
+/* dangling: whether the CNAME target has gone away entirely.
+ */
+func (t *TakeoverChecker) dangling(ctx context.Context, cname string) bool {
+	resolver := t.Resolver
+	if resolver == nil {
+		resolver = &Resolver{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := resolver.resolver().LookupHost(ctx, cname)
+
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+/* body: fetches the page served for name, trying HTTPS before HTTP. Only the
+ * start of the page is read, fingerprints are near the top.
+ */
+func (t *TakeoverChecker) body(ctx context.Context, name string) string {
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	for _, scheme := range []string{"https://", "http://"} {
+		req, err := http.NewRequestWithContext(ctx, "GET", scheme+name+"/", nil)
+		if err != nil {
+			return ""
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		return string(body)
+	}
+
+	return ""
+}
+
+/* Check: looks at a single resolved name, returning nil unless it looks like
+ * it can be taken over.
+ */
+func (t *TakeoverChecker) Check(ctx context.Context, name string, records *DNSRecords) *Takeover {
+	if records == nil || records.CNAME == "" {
+		return nil
+	}
+
+	cname := strings.ToLower(records.CNAME)
+	fp := t.fingerprint(cname)
+
+	// A CNAME to nowhere is worth a look whatever it points at, for some
+	// services it's all it takes.
+	if t.dangling(ctx, cname) {
+		takeover := &Takeover{CNAME: cname, Reason: "CNAME target does not resolve"}
+		if fp != nil {
+			takeover.Service = fp.Service
+		}
+		return takeover
+	}
+
+	if fp == nil || fp.Fingerprint == "" {
+		return nil
+	}
+
+	if strings.Contains(t.body(ctx, name), fp.Fingerprint) {
+		return &Takeover{Service: fp.Service, CNAME: cname, Reason: "response matches fingerprint"}
+	}
+
+	return nil
+}
+
+/* CheckAll: checks every resolved name with a CNAME and records the candidates
+ * on the results. Returns how many candidates were found.
+ */
+func (t *TakeoverChecker) CheckAll(ctx context.Context, results Results) int {
+	concurrency := t.Concurrency
+	if concurrency < 1 {
+		concurrency = 20
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		candidates int
+	)
+
+	// Grab everything worth checking before the workers start writing to the map
+	var pending []string
+	for name, res := range results {
+		if res.DNS != nil && res.DNS.CNAME != "" {
+			pending = append(pending, name)
+		}
+	}
+
+	names := make(chan string)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				mu.Lock()
+				records := results[name].DNS
+				mu.Unlock()
+
+				takeover := t.Check(ctx, name, records)
+				if takeover == nil {
+					continue
+				}
+
+				mu.Lock()
+				res := results[name]
+				res.Takeover = takeover
+				results[name] = res
+				candidates++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range pending {
+		select {
+		case names <- name:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(names)
+	wg.Wait()
+
+	return candidates
+}
@@ -0,0 +1,111 @@
+package sancrawler
+
+import (
+	"sort"
+	"time"
+)
+
+// How long each step of a Timeline is
+const (
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+	PeriodYear  = "year"
+)
+
+// Timeline is when names under an apex domain turned up on certificates, which
+// says a lot about when the org spun up new products and environments. Names
+// counts every name under the apex, including the ones we have no dates for.
+type Timeline struct {
+	Apex    string           `json:"apex"`
+	Names   int              `json:"names"`
+	Periods []TimelinePeriod `json:"periods"`
+}
+
+// TimelinePeriod is one step of a Timeline with anything in it. NewNames are the
+// names first seen on a certificate issued in it, and Certificates is how many
+// of the certificates the names were taken from were issued in it.
+type TimelinePeriod struct {
+	Start        time.Time `json:"start"`
+	Certificates int       `json:"certificates"`
+	NewNames     []string  `json:"new_names"`
+}
+
+/* NewTimelines: a timeline for each apex domain, going by the certificates'
+ * notBefore dates, apexes with the most names first. Periods with nothing
+ * issued are left out.
+ */
+func NewTimelines(results Results, period string) []Timeline {
+	type bucket struct {
+		certs map[int]bool
+		names []string
+	}
+	buckets := make(map[string]map[time.Time]*bucket)
+	names := make(map[string]int)
+
+	get := func(apex string, start time.Time) *bucket {
+		if buckets[apex] == nil {
+			buckets[apex] = make(map[time.Time]*bucket)
+		}
+		b := buckets[apex][start]
+		if b == nil {
+			b = &bucket{certs: make(map[int]bool)}
+			buckets[apex][start] = b
+		}
+		return b
+	}
+
+	for _, res := range results.Sorted() {
+		apex := resultApex(res)
+		if apex == "" {
+			continue
+		}
+		names[apex]++
+
+		if first := earliest(res.FirstSeen, res.NotBefore); !first.IsZero() {
+			b := get(apex, PeriodStart(first, period))
+			b.names = append(b.names, res.Name)
+		}
+		if !res.NotBefore.IsZero() && res.CertificateID != 0 {
+			get(apex, PeriodStart(res.NotBefore, period)).certs[res.CertificateID] = true
+		}
+	}
+
+	var timelines []Timeline
+	for apex, count := range names {
+		t := Timeline{Apex: apex, Names: count}
+		for start, b := range buckets[apex] {
+			t.Periods = append(t.Periods, TimelinePeriod{
+				Start:        start,
+				Certificates: len(b.certs),
+				NewNames:     b.names,
+			})
+		}
+		sort.Slice(t.Periods, func(i, j int) bool {
+			return t.Periods[i].Start.Before(t.Periods[j].Start)
+		})
+		timelines = append(timelines, t)
+	}
+
+	sort.Slice(timelines, func(i, j int) bool {
+		if timelines[i].Names != timelines[j].Names {
+			return timelines[i].Names > timelines[j].Names
+		}
+		return timelines[i].Apex < timelines[j].Apex
+	})
+	return timelines
+}
+
+/* PeriodStart: the start of the period t falls in, in UTC. Weeks start on
+ * Monday.
+ */
+func PeriodStart(t time.Time, period string) time.Time {
+	t = t.UTC()
+	switch period {
+	case PeriodWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PeriodYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
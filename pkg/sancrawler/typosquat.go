@@ -0,0 +1,307 @@
+package sancrawler
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Typosquat is a domain that could be mistaken for another, along with the
+// fuzzer that came up with it (eg. "omission" for exmple.com).
+type Typosquat struct {
+	Domain string `json:"domain"`
+	Fuzzer string `json:"fuzzer"`
+}
+
+// TyposquatHit is a typosquat that has had certificates issued for it.
+// Certificates counts the distinct certificates the names were first seen on,
+// FirstSeen and LastSeen are the earliest and latest of their NotBefore.
+type TyposquatHit struct {
+	Typosquat
+	Certificates int       `json:"certificates"`
+	Names        []string  `json:"names"`
+	Issuers      []string  `json:"issuers"`
+	FirstSeen    time.Time `json:"first_seen,omitzero"`
+	LastSeen     time.Time `json:"last_seen,omitzero"`
+}
+
+// Fuzzers lists every way Typosquats has of coming up with permutations
+var Fuzzers = []string{"addition", "bitsquatting", "homoglyph", "hyphenation", "insertion", "omission", "repetition", "replacement", "subdomain", "transposition", "vowel-swap", "tld-swap"}
+
+// Keys next to each other on a QWERTY keyboard, what fat fingers hit instead
+var keyboard = map[rune]string{
+	'1': "2q", '2': "3wq1", '3': "4ew2", '4': "5re3", '5': "6tr4", '6': "7yt5",
+	'7': "8uy6", '8': "9iu7", '9': "0oi8", '0': "po9",
+	'q': "12wa", 'w': "3esaq2", 'e': "4rdsw3", 'r': "5tfde4", 't': "6ygfr5",
+	'y': "7uhgt6", 'u': "8ijhy7", 'i': "9okju8", 'o': "0plki9", 'p': "lo0",
+	'a': "qwsz", 's': "edxzaw", 'd': "rfcxse", 'f': "tgvcdr", 'g': "yhbvft",
+	'h': "ujnbgy", 'j': "ikmnhu", 'k': "olmji", 'l': "kop",
+	'z': "asx", 'x': "zsdc", 'c': "xdfv", 'v': "cfgb", 'b': "vghn",
+	'n': "bhjm", 'm': "njk",
+}
+
+// Plain ASCII lookalikes, the IDN ones come from confusables
+var asciiGlyphs = map[string][]string{
+	"a": {"4"}, "b": {"d", "lb"}, "c": {"e"}, "d": {"b", "cl"}, "e": {"c"},
+	"g": {"q"}, "h": {"lh"}, "i": {"1", "l"}, "k": {"lk", "ik"}, "l": {"1", "i"},
+	"m": {"n", "nn", "rn"}, "n": {"m", "r"}, "o": {"0"}, "q": {"g"},
+	"u": {"v"}, "v": {"u"}, "w": {"vv"}, "z": {"2"},
+	"rn": {"m"}, "vv": {"w"}, "cl": {"d"},
+}
+
+// TLDs people are most likely to register a lookalike under
+var squatTLDs = []string{"com", "net", "org", "info", "biz", "co", "io", "us", "uk", "co.uk", "de", "online", "site", "app", "xyz"}
+
+const vowels = "aeiou"
+
+/* validLabel: whether label can be registered as a DNS label.
+ */
+func validLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+/* Typosquats: every permutation of domain's registered label that the fuzzers
+ * come up with, à la dnstwist. The TLD stays the same except for tld-swap, which
+ * only changes the TLD. Homoglyphs from other scripts come back as punycode.
+ * Only the fuzzers listed are used, all of them when there are none.
+ */
+func Typosquats(domain string, fuzzers []string) ([]Typosquat, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	apex, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return nil, err
+	}
+	if apex != domain {
+		return nil, errors.New("not a registered domain, try " + apex)
+	}
+
+	dot := strings.Index(apex, ".")
+	label, suffix := apex[:dot], apex[dot+1:]
+	if !validLabel(label) {
+		return nil, errors.New("can only permute plain ASCII domains: " + domain)
+	}
+
+	for _, f := range fuzzers {
+		if !containsString(Fuzzers, f) {
+			return nil, errors.New("unknown fuzzer: " + f)
+		}
+	}
+	use := func(f string) bool {
+		return len(fuzzers) == 0 || containsString(fuzzers, f)
+	}
+
+	var ret []Typosquat
+	seen := map[string]bool{apex: true}
+
+	// Takes the new label (or name, for subdomain and tld-swap) as is and puts
+	// the suffix back on, skipping anything that can't be registered.
+	add := func(fuzzer string, name string) {
+		if !strings.Contains(name, ".") {
+			if !validLabel(name) {
+				return
+			}
+			name += "." + suffix
+		}
+		if !seen[name] {
+			seen[name] = true
+			ret = append(ret, Typosquat{Domain: name, Fuzzer: fuzzer})
+		}
+	}
+
+	if use("addition") {
+		for _, c := range "abcdefghijklmnopqrstuvwxyz0123456789" {
+			add("addition", label+string(c))
+		}
+	}
+
+	if use("bitsquatting") {
+		for i := 0; i < len(label); i++ {
+			for bit := 0; bit < 8; bit++ {
+				c := label[i] ^ (1 << bit)
+				if c >= 'A' && c <= 'Z' {
+					continue
+				}
+				add("bitsquatting", label[:i]+string(c)+label[i+1:])
+			}
+		}
+	}
+
+	if use("homoglyph") {
+		for from, tos := range asciiGlyphs {
+			for i := 0; i+len(from) <= len(label); i++ {
+				if label[i:i+len(from)] != from {
+					continue
+				}
+				for _, to := range tos {
+					add("homoglyph", label[:i]+to+label[i+len(from):])
+				}
+			}
+		}
+
+		// One character at a time from another script, the ones crt.sh would
+		// have are punycode.
+		runes := []rune(label)
+		for i, r := range runes {
+			for glyph, ascii := range confusables {
+				if ascii != r || glyph < 0x400 || glyph > 0x4ff {
+					continue
+				}
+				idn := string(runes[:i]) + string(glyph) + string(runes[i+1:])
+				if encoded, err := idna.ToASCII(idn + "." + suffix); err == nil {
+					add("homoglyph", encoded)
+				}
+			}
+		}
+	}
+
+	if use("hyphenation") {
+		for i := 1; i < len(label); i++ {
+			add("hyphenation", label[:i]+"-"+label[i:])
+		}
+	}
+
+	if use("insertion") {
+		for i, r := range label {
+			for _, k := range keyboard[r] {
+				add("insertion", label[:i]+string(k)+label[i:])
+				add("insertion", label[:i+1]+string(k)+label[i+1:])
+			}
+		}
+	}
+
+	if use("omission") {
+		for i := range label {
+			add("omission", label[:i]+label[i+1:])
+		}
+	}
+
+	if use("repetition") {
+		for i := range label {
+			add("repetition", label[:i+1]+label[i:])
+		}
+	}
+
+	if use("replacement") {
+		for i, r := range label {
+			for _, k := range keyboard[r] {
+				add("replacement", label[:i]+string(k)+label[i+1:])
+			}
+		}
+	}
+
+	if use("subdomain") {
+		for i := 1; i < len(label); i++ {
+			if label[i-1] != '-' && label[i] != '-' {
+				add("subdomain", label[:i]+"."+label[i:]+"."+suffix)
+			}
+		}
+	}
+
+	if use("transposition") {
+		for i := 0; i+1 < len(label); i++ {
+			if label[i] != label[i+1] {
+				add("transposition", label[:i]+string(label[i+1])+string(label[i])+label[i+2:])
+			}
+		}
+	}
+
+	if use("vowel-swap") {
+		for i, r := range label {
+			if !strings.ContainsRune(vowels, r) {
+				continue
+			}
+			for _, v := range vowels {
+				if v != r {
+					add("vowel-swap", label[:i]+string(v)+label[i+1:])
+				}
+			}
+		}
+	}
+
+	if use("tld-swap") {
+		for _, tld := range squatTLDs {
+			if tld != suffix {
+				add("tld-swap", label+"."+tld)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Domain < ret[j].Domain })
+	return ret, nil
+}
+
+/* TyposquatHits: which of the typosquats turned up in results, going by the
+ * seeds each name was found with. Sorted by when certificates were last issued
+ * for them, most recent first.
+ */
+func TyposquatHits(typosquats []Typosquat, results Results) []TyposquatHit {
+	hits := make(map[string]*TyposquatHit)
+	certs := make(map[string]map[int]bool)
+	issuers := make(map[string]map[string]bool)
+
+	for _, t := range typosquats {
+		hits[t.Domain] = &TyposquatHit{Typosquat: t}
+		certs[t.Domain] = make(map[int]bool)
+		issuers[t.Domain] = make(map[string]bool)
+	}
+
+	for name, res := range results {
+		for _, seed := range res.Seeds {
+			hit, ok := hits[seed]
+			if !ok {
+				continue
+			}
+
+			hit.Names = append(hit.Names, name)
+			if res.CertificateID != 0 {
+				certs[seed][res.CertificateID] = true
+			}
+			if res.IssuerName != "" && !issuers[seed][res.IssuerName] {
+				issuers[seed][res.IssuerName] = true
+				hit.Issuers = append(hit.Issuers, res.IssuerName)
+			}
+
+			if !res.NotBefore.IsZero() {
+				if hit.FirstSeen.IsZero() || res.NotBefore.Before(hit.FirstSeen) {
+					hit.FirstSeen = res.NotBefore
+				}
+				if res.NotBefore.After(hit.LastSeen) {
+					hit.LastSeen = res.NotBefore
+				}
+			}
+		}
+	}
+
+	var ret []TyposquatHit
+	for domain, hit := range hits {
+		if len(hit.Names) == 0 {
+			continue
+		}
+		hit.Certificates = len(certs[domain])
+		sort.Strings(hit.Names)
+		sort.Strings(hit.Issuers)
+		ret = append(ret, *hit)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].LastSeen.Equal(ret[j].LastSeen) {
+			return ret[i].LastSeen.After(ret[j].LastSeen)
+		}
+		return ret[i].Domain < ret[j].Domain
+	})
+
+	return ret
+}
@@ -0,0 +1,145 @@
+package sancrawler
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTyposquats(t *testing.T) {
+	tests := []struct {
+		fuzzer string
+		want   []string
+		not    []string
+	}{
+		{"addition", []string{"examplea.com", "example9.com"}, nil},
+		{"bitsquatting", []string{"dxample.com", "examplm.com"}, []string{"Example.com"}},
+		{"homoglyph", []string{"examp1e.com", "exarnple.com", "xn--xample-2of.com"}, nil},
+		{"hyphenation", []string{"e-xample.com", "exampl-e.com"}, []string{"-example.com", "example-.com"}},
+		{"insertion", []string{"exsample.com", "examplre.com"}, nil},
+		{"omission", []string{"xample.com", "exmple.com", "exampl.com"}, nil},
+		{"repetition", []string{"eexample.com", "examplee.com"}, nil},
+		{"replacement", []string{"wxample.com", "exampke.com"}, nil},
+		{"subdomain", []string{"e.xample.com", "exam.ple.com"}, nil},
+		{"transposition", []string{"xeample.com", "exampel.com"}, nil},
+		{"vowel-swap", []string{"axample.com", "exampla.com"}, []string{"exbmple.com"}},
+		{"tld-swap", []string{"example.net", "example.co.uk"}, []string{"example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fuzzer, func(t *testing.T) {
+			squats, err := Typosquats("Example.com.", []string{tt.fuzzer})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]bool)
+			for _, s := range squats {
+				if s.Fuzzer != tt.fuzzer {
+					t.Errorf("%s came from %s", s.Domain, s.Fuzzer)
+				}
+				got[s.Domain] = true
+			}
+			for _, want := range tt.want {
+				if !got[want] {
+					t.Errorf("missing %s", want)
+				}
+			}
+			for _, not := range tt.not {
+				if got[not] {
+					t.Errorf("shouldn't have %s", not)
+				}
+			}
+		})
+	}
+}
+
+func TestTyposquatsAll(t *testing.T) {
+	squats, err := Typosquats("example.co.uk", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sort.SliceIsSorted(squats, func(i, j int) bool { return squats[i].Domain < squats[j].Domain }) {
+		t.Error("not sorted")
+	}
+
+	fuzzers := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, s := range squats {
+		if s.Domain == "example.co.uk" {
+			t.Error("the domain itself came back")
+		}
+		if seen[s.Domain] {
+			t.Errorf("%s came back twice", s.Domain)
+		}
+		seen[s.Domain] = true
+		fuzzers[s.Fuzzer] = true
+	}
+	if len(fuzzers) != len(Fuzzers) {
+		t.Errorf("%d fuzzers came up with something, want %d", len(fuzzers), len(Fuzzers))
+	}
+
+	// The suffix is kept whole
+	if !seen["exmple.co.uk"] || seen["example.co.k"] {
+		t.Error("permuted the public suffix")
+	}
+}
+
+func TestTyposquatsErrors(t *testing.T) {
+	for _, tt := range []struct {
+		domain  string
+		fuzzers []string
+	}{
+		{"www.example.com", nil},
+		{"com", nil},
+		{"exa_mple.com", nil},
+		{"example.com", []string{"nonsense"}},
+	} {
+		if _, err := Typosquats(tt.domain, tt.fuzzers); err == nil {
+			t.Errorf("Typosquats(%q, %v) didn't fail", tt.domain, tt.fuzzers)
+		}
+	}
+}
+
+func TestTyposquatHits(t *testing.T) {
+	squats := []Typosquat{
+		{Domain: "exmple.com", Fuzzer: "omission"},
+		{Domain: "examp1e.com", Fuzzer: "homoglyph"},
+		{Domain: "exampel.com", Fuzzer: "transposition"},
+	}
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	results := Results{
+		"exmple.com":       {Name: "exmple.com", Seeds: []string{"exmple.com"}, CertificateID: 1, IssuerName: "R3", NotBefore: day(1)},
+		"www.exmple.com":   {Name: "www.exmple.com", Seeds: []string{"exmple.com"}, CertificateID: 2, IssuerName: "R3", NotBefore: day(5)},
+		"mail.exmple.com":  {Name: "mail.exmple.com", Seeds: []string{"exmple.com"}, CertificateID: 2, IssuerName: "E1", NotBefore: day(5)},
+		"examp1e.com":      {Name: "examp1e.com", Seeds: []string{"examp1e.com"}, CertificateID: 3, NotBefore: day(9)},
+		"unrelated.com":    {Name: "unrelated.com", Seeds: []string{"unrelated.com"}, CertificateID: 4},
+		"shop.examp1e.com": {Name: "shop.examp1e.com", Seeds: []string{"examp1e.com", "exmple.com"}},
+	}
+
+	hits := TyposquatHits(squats, results)
+	if len(hits) != 2 {
+		t.Fatalf("%d hits, want 2", len(hits))
+	}
+
+	// Most recently issued first
+	if hits[0].Domain != "examp1e.com" || hits[1].Domain != "exmple.com" {
+		t.Fatalf("hits in the wrong order: %s, %s", hits[0].Domain, hits[1].Domain)
+	}
+
+	h := hits[1]
+	if h.Certificates != 2 {
+		t.Errorf("Certificates = %d, want 2", h.Certificates)
+	}
+	if len(h.Names) != 4 || h.Names[0] != "exmple.com" {
+		t.Errorf("Names = %v", h.Names)
+	}
+	if len(h.Issuers) != 2 || h.Issuers[0] != "E1" {
+		t.Errorf("Issuers = %v", h.Issuers)
+	}
+	if !h.FirstSeen.Equal(day(1)) || !h.LastSeen.Equal(day(5)) {
+		t.Errorf("seen %v to %v, want %v to %v", h.FirstSeen, h.LastSeen, day(1), day(5))
+	}
+}
@@ -0,0 +1,75 @@
+package sancrawler
+
+import (
+	"strings"
+)
+
+// The legal entity suffixes organizations tend to put after their name, the
+// way they're usually written, most common first.
+var entitySuffixes = []string{
+	"Inc.", "Inc", "LLC", "Ltd.", "Ltd", "Corporation", "Corp.", "Corp",
+	"Limited", "Incorporated", "Company", "Co.", "GmbH", "AG", "PLC", "LLP",
+	"LP", "S.A.", "SA", "SAS", "SARL", "B.V.", "BV", "N.V.", "NV", "Pty Ltd",
+	"Pty. Ltd.", "AB", "AS", "Oy", "S.p.A.", "SpA", "S.r.l.", "Srl", "K.K.",
+	"L.L.C.",
+}
+
+// Only the American style suffixes commonly get a comma in front of them
+var commaSuffixes = map[string]bool{"Inc.": true, "Inc": true, "LLC": true, "Ltd.": true, "Ltd": true}
+
+/* baseOrganization: the organization name with any legal entity suffixes and
+ * the punctuation before them taken off, eg. "Acme, Inc." gives "Acme".
+ */
+func baseOrganization(org string) string {
+	base := strings.TrimSpace(org)
+
+	// The longest suffix that matches wins, otherwise "Pty Ltd" would only lose
+	// its "Ltd".
+	for {
+		longest := ""
+		for _, suffix := range entitySuffixes {
+			lower, lowerSuffix := strings.ToLower(base), strings.ToLower(suffix)
+			if len(suffix) > len(longest) && (strings.HasSuffix(lower, " "+lowerSuffix) || strings.HasSuffix(lower, ","+lowerSuffix)) {
+				longest = suffix
+			}
+		}
+
+		if longest == "" {
+			return base
+		}
+		base = strings.TrimRight(base[:len(base)-len(longest)], " ,")
+	}
+}
+
+/* OrgVariants: the common ways of writing an organization's name with legal
+ * entity suffixes, "Acme" gives "Acme", "Acme Inc", "Acme, Inc.", "Acme GmbH"
+ * and so on. The name as given always comes first, and variants that only
+ * differ by case are dropped since crt.sh matches ignoring case anyway.
+ */
+func OrgVariants(org string) []string {
+	org = strings.TrimSpace(org)
+	base := baseOrganization(org)
+	if base == "" {
+		return []string{org}
+	}
+
+	var ret []string
+	seen := make(map[string]bool)
+	add := func(variant string) {
+		if key := strings.ToLower(variant); !seen[key] {
+			seen[key] = true
+			ret = append(ret, variant)
+		}
+	}
+
+	add(org)
+	add(base)
+	for _, suffix := range entitySuffixes {
+		add(base + " " + suffix)
+		if commaSuffixes[suffix] {
+			add(base + ", " + suffix)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,138 @@
+package sancrawler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+/* NormalizeWildcard: cleans up the odd forms wildcards turn up in on real
+ * certificates (repeated "*." prefixes, trailing dots, stray whitespace) so the
+ * same wildcard always ends up as the same string.
+ */
+func NormalizeWildcard(name string) string {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+
+	if !strings.HasPrefix(name, "*.") {
+		return name
+	}
+
+	for strings.HasPrefix(name, "*.") {
+		name = name[2:]
+	}
+	return "*." + name
+}
+
+/* wildcardParent: the name a wildcard covers the children of, ie. example.com
+ * for *.example.com. Returns "" for anything that isn't a wildcard.
+ */
+func wildcardParent(name string) string {
+	if strings.HasPrefix(name, "*.") {
+		return name[2:]
+	}
+	return ""
+}
+
+/* NormalizeWildcards: runs NormalizeWildcard over every result, merging any
+ * that end up the same.
+ */
+func NormalizeWildcards(results Results) Results {
+	ret := make(Results)
+	for _, res := range results {
+		res.Name = NormalizeWildcard(res.Name)
+		ret.Merge(Results{res.Name: res})
+	}
+	return ret
+}
+
+/* StripWildcards: turns *.example.com into example.com, merging it into any
+ * existing result for the parent.
+ */
+func StripWildcards(results Results) Results {
+	ret := make(Results)
+	for _, res := range results {
+		if parent := wildcardParent(res.Name); parent != "" {
+			res.Name = parent
+		}
+		ret.Merge(Results{res.Name: res})
+	}
+	return ret
+}
+
+/* GroupWildcards: marks every name that is covered by a wildcard also present
+ * in the results, and returns the groups keyed by wildcard. A wildcard only
+ * covers a single label, so *.example.com covers www.example.com but not
+ * a.b.example.com.
+ */
+func GroupWildcards(results Results) map[string][]string {
+	groups := make(map[string][]string)
+
+	for name, res := range results {
+		dot := strings.Index(name, ".")
+		if dot < 0 || strings.HasPrefix(name, "*.") {
+			continue
+		}
+
+		wildcard := "*" + name[dot:]
+		if _, ok := results[wildcard]; ok {
+			res.CoveredBy = wildcard
+			results[name] = res
+			groups[wildcard] = append(groups[wildcard], name)
+		}
+	}
+
+	return groups
+}
+
+/* ExpandWildcards: tries every word in the wordlist as a label under each
+ * wildcard in the results and returns the ones that resolve. Wildcards whose
+ * parent also has wildcard DNS are skipped since every guess would resolve.
+ */
+func ExpandWildcards(ctx context.Context, results Results, wordlist []string, resolver *Resolver) Results {
+	found := make(Results)
+	candidates := make(Results)
+
+	for name, res := range results {
+		parent := wildcardParent(name)
+		if parent == "" {
+			continue
+		}
+
+		// Something that nobody would ever name a host, if it resolves then so
+		// will everything else.
+		probe := fmt.Sprintf("sancrawler-%d.%s", rand.Int63(), parent)
+		if resolver.Resolve(ctx, probe).Live {
+			continue
+		}
+
+		for _, word := range wordlist {
+			candidate := strings.ToLower(strings.TrimSpace(word)) + "." + parent
+			if _, ok := results[candidate]; ok {
+				continue
+			}
+
+			// Guesses inherit everything from the wildcard certificate
+			guess := res
+			guess.Name = candidate
+			guess.Source = "wordlist"
+			guess.CoveredBy = name
+			guess.DNS = nil
+			candidates[candidate] = guess
+		}
+	}
+
+	if len(candidates) == 0 {
+		return found
+	}
+
+	resolver.ResolveAll(ctx, candidates)
+
+	for name, res := range candidates {
+		if res.DNS != nil && res.DNS.Live {
+			found[name] = res
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,308 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: sancrawler.proto
+
+package sancrawlerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CrawlRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keywords      []string               `protobuf:"bytes,1,rep,name=keywords,proto3" json:"keywords,omitempty"`
+	Organizations []string               `protobuf:"bytes,2,rep,name=organizations,proto3" json:"organizations,omitempty"`
+	Field         string                 `protobuf:"bytes,3,opt,name=field,proto3" json:"field,omitempty"`
+	Values        []string               `protobuf:"bytes,4,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CrawlRequest) Reset() {
+	*x = CrawlRequest{}
+	mi := &file_sancrawler_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CrawlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlRequest) ProtoMessage() {}
+
+func (x *CrawlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sancrawler_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlRequest.ProtoReflect.Descriptor instead.
+func (*CrawlRequest) Descriptor() ([]byte, []int) {
+	return file_sancrawler_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CrawlRequest) GetKeywords() []string {
+	if x != nil {
+		return x.Keywords
+	}
+	return nil
+}
+
+func (x *CrawlRequest) GetOrganizations() []string {
+	if x != nil {
+		return x.Organizations
+	}
+	return nil
+}
+
+func (x *CrawlRequest) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *CrawlRequest) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Field         string                 `protobuf:"bytes,3,opt,name=field,proto3" json:"field,omitempty"`
+	CertificateId int64                  `protobuf:"varint,4,opt,name=certificate_id,json=certificateId,proto3" json:"certificate_id,omitempty"`
+	IssuerCaId    int64                  `protobuf:"varint,5,opt,name=issuer_ca_id,json=issuerCaId,proto3" json:"issuer_ca_id,omitempty"`
+	IssuerName    string                 `protobuf:"bytes,6,opt,name=issuer_name,json=issuerName,proto3" json:"issuer_name,omitempty"`
+	Source        string                 `protobuf:"bytes,7,opt,name=source,proto3" json:"source,omitempty"`
+	Seeds         []string               `protobuf:"bytes,8,rep,name=seeds,proto3" json:"seeds,omitempty"`
+	NotBefore     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter      *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	Expired       bool                   `protobuf:"varint,11,opt,name=expired,proto3" json:"expired,omitempty"`
+	Precert       bool                   `protobuf:"varint,12,opt,name=precert,proto3" json:"precert,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_sancrawler_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_sancrawler_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_sancrawler_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Result) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Result) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Result) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *Result) GetCertificateId() int64 {
+	if x != nil {
+		return x.CertificateId
+	}
+	return 0
+}
+
+func (x *Result) GetIssuerCaId() int64 {
+	if x != nil {
+		return x.IssuerCaId
+	}
+	return 0
+}
+
+func (x *Result) GetIssuerName() string {
+	if x != nil {
+		return x.IssuerName
+	}
+	return ""
+}
+
+func (x *Result) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Result) GetSeeds() []string {
+	if x != nil {
+		return x.Seeds
+	}
+	return nil
+}
+
+func (x *Result) GetNotBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotBefore
+	}
+	return nil
+}
+
+func (x *Result) GetNotAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotAfter
+	}
+	return nil
+}
+
+func (x *Result) GetExpired() bool {
+	if x != nil {
+		return x.Expired
+	}
+	return false
+}
+
+func (x *Result) GetPrecert() bool {
+	if x != nil {
+		return x.Precert
+	}
+	return false
+}
+
+var File_sancrawler_proto protoreflect.FileDescriptor
+
+const file_sancrawler_proto_rawDesc = "" +
+	"\n" +
+	"\x10sancrawler.proto\x12\rsancrawler.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"~\n" +
+	"\fCrawlRequest\x12\x1a\n" +
+	"\bkeywords\x18\x01 \x03(\tR\bkeywords\x12$\n" +
+	"\rorganizations\x18\x02 \x03(\tR\rorganizations\x12\x14\n" +
+	"\x05field\x18\x03 \x01(\tR\x05field\x12\x16\n" +
+	"\x06values\x18\x04 \x03(\tR\x06values\"\x86\x03\n" +
+	"\x06Result\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
+	"\x05field\x18\x03 \x01(\tR\x05field\x12%\n" +
+	"\x0ecertificate_id\x18\x04 \x01(\x03R\rcertificateId\x12 \n" +
+	"\fissuer_ca_id\x18\x05 \x01(\x03R\n" +
+	"issuerCaId\x12\x1f\n" +
+	"\vissuer_name\x18\x06 \x01(\tR\n" +
+	"issuerName\x12\x16\n" +
+	"\x06source\x18\a \x01(\tR\x06source\x12\x14\n" +
+	"\x05seeds\x18\b \x03(\tR\x05seeds\x129\n" +
+	"\n" +
+	"not_before\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tnotBefore\x127\n" +
+	"\tnot_after\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\bnotAfter\x12\x18\n" +
+	"\aexpired\x18\v \x01(\bR\aexpired\x12\x18\n" +
+	"\aprecert\x18\f \x01(\bR\aprecert2K\n" +
+	"\n" +
+	"SANCrawler\x12=\n" +
+	"\x05Crawl\x12\x1b.sancrawler.v1.CrawlRequest\x1a\x15.sancrawler.v1.Result0\x01B2Z0github.com/cramppet/sancrawler2/pkg/sancrawlerpbb\x06proto3"
+
+var (
+	file_sancrawler_proto_rawDescOnce sync.Once
+	file_sancrawler_proto_rawDescData []byte
+)
+
+func file_sancrawler_proto_rawDescGZIP() []byte {
+	file_sancrawler_proto_rawDescOnce.Do(func() {
+		file_sancrawler_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sancrawler_proto_rawDesc), len(file_sancrawler_proto_rawDesc)))
+	})
+	return file_sancrawler_proto_rawDescData
+}
+
+var file_sancrawler_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_sancrawler_proto_goTypes = []any{
+	(*CrawlRequest)(nil),          // 0: sancrawler.v1.CrawlRequest
+	(*Result)(nil),                // 1: sancrawler.v1.Result
+	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+}
+var file_sancrawler_proto_depIdxs = []int32{
+	2, // 0: sancrawler.v1.Result.not_before:type_name -> google.protobuf.Timestamp
+	2, // 1: sancrawler.v1.Result.not_after:type_name -> google.protobuf.Timestamp
+	0, // 2: sancrawler.v1.SANCrawler.Crawl:input_type -> sancrawler.v1.CrawlRequest
+	1, // 3: sancrawler.v1.SANCrawler.Crawl:output_type -> sancrawler.v1.Result
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_sancrawler_proto_init() }
+func file_sancrawler_proto_init() {
+	if File_sancrawler_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sancrawler_proto_rawDesc), len(file_sancrawler_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sancrawler_proto_goTypes,
+		DependencyIndexes: file_sancrawler_proto_depIdxs,
+		MessageInfos:      file_sancrawler_proto_msgTypes,
+	}.Build()
+	File_sancrawler_proto = out.File
+	file_sancrawler_proto_goTypes = nil
+	file_sancrawler_proto_depIdxs = nil
+}
@@ -0,0 +1,124 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: sancrawler.proto
+
+package sancrawlerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SANCrawler_Crawl_FullMethodName = "/sancrawler.v1.SANCrawler/Crawl"
+)
+
+// SANCrawlerClient is the client API for SANCrawler service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SANCrawlerClient interface {
+	Crawl(ctx context.Context, in *CrawlRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Result], error)
+}
+
+type sANCrawlerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSANCrawlerClient(cc grpc.ClientConnInterface) SANCrawlerClient {
+	return &sANCrawlerClient{cc}
+}
+
+func (c *sANCrawlerClient) Crawl(ctx context.Context, in *CrawlRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Result], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SANCrawler_ServiceDesc.Streams[0], SANCrawler_Crawl_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CrawlRequest, Result]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SANCrawler_CrawlClient = grpc.ServerStreamingClient[Result]
+
+// SANCrawlerServer is the server API for SANCrawler service.
+// All implementations must embed UnimplementedSANCrawlerServer
+// for forward compatibility.
+type SANCrawlerServer interface {
+	Crawl(*CrawlRequest, grpc.ServerStreamingServer[Result]) error
+	mustEmbedUnimplementedSANCrawlerServer()
+}
+
+// UnimplementedSANCrawlerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSANCrawlerServer struct{}
+
+func (UnimplementedSANCrawlerServer) Crawl(*CrawlRequest, grpc.ServerStreamingServer[Result]) error {
+	return status.Errorf(codes.Unimplemented, "method Crawl not implemented")
+}
+func (UnimplementedSANCrawlerServer) mustEmbedUnimplementedSANCrawlerServer() {}
+func (UnimplementedSANCrawlerServer) testEmbeddedByValue()                    {}
+
+// UnsafeSANCrawlerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SANCrawlerServer will
+// result in compilation errors.
+type UnsafeSANCrawlerServer interface {
+	mustEmbedUnimplementedSANCrawlerServer()
+}
+
+func RegisterSANCrawlerServer(s grpc.ServiceRegistrar, srv SANCrawlerServer) {
+	// If the following call pancis, it indicates UnimplementedSANCrawlerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SANCrawler_ServiceDesc, srv)
+}
+
+func _SANCrawler_Crawl_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CrawlRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SANCrawlerServer).Crawl(m, &grpc.GenericServerStream[CrawlRequest, Result]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SANCrawler_CrawlServer = grpc.ServerStreamingServer[Result]
+
+// SANCrawler_ServiceDesc is the grpc.ServiceDesc for SANCrawler service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SANCrawler_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sancrawler.v1.SANCrawler",
+	HandlerType: (*SANCrawlerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Crawl",
+			Handler:       _SANCrawler_Crawl_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sancrawler.proto",
+}
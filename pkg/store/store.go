@@ -0,0 +1,267 @@
+/* Package store persists SANCrawler results in a local SQLite database, so
+ * results from many runs can be compared and queried with plain SQL afterwards.
+ * It lives outside of pkg/sancrawler since the SQLite driver needs cgo.
+ */
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SchemaVersion is stored in PRAGMA user_version. Bump it whenever the schema
+// below changes in a way queries against it would notice.
+const SchemaVersion = 2
+
+// Every name keeps the certificate it was first seen on, along with when (and
+// in which run) it was first and last seen. Seeds that found a name build up
+// across runs in name_seeds.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at  TIMESTAMP NOT NULL,
+	finished_at TIMESTAMP NOT NULL,
+	names       INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS names (
+	name           TEXT PRIMARY KEY,
+	field          TEXT NOT NULL,
+	certificate_id INTEGER NOT NULL,
+	issuer_ca_id   INTEGER NOT NULL,
+	issuer_name    TEXT,
+	source         TEXT NOT NULL,
+	not_before     TIMESTAMP,
+	not_after      TIMESTAMP,
+	first_seen     TIMESTAMP NOT NULL,
+	last_seen      TIMESTAMP NOT NULL,
+	first_run      INTEGER NOT NULL REFERENCES runs(id),
+	last_run       INTEGER NOT NULL REFERENCES runs(id)
+);
+
+CREATE TABLE IF NOT EXISTS name_seeds (
+	name TEXT NOT NULL REFERENCES names(name),
+	seed TEXT NOT NULL,
+	PRIMARY KEY (name, seed)
+);
+
+CREATE INDEX IF NOT EXISTS names_last_run ON names(last_run);
+`
+
+// Store is an open results database.
+type Store struct {
+	db *sql.DB
+}
+
+/* Open: opens (creating if needed) the results database at path.
+ */
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version > SchemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("%s was written by a newer version of SANCrawler (schema %d)", path, version)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrate(db, version); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Changes needed to bring a database written at version i up to version i+1.
+// Fresh databases get the current schema straight away and skip all of these.
+var migrations = []string{
+	1: `ALTER TABLE names ADD COLUMN issuer_name TEXT`,
+}
+
+/* migrate: runs whatever migrations a database at version needs. Version 0 is
+ * either empty or older than user_version, either way schema has it covered.
+ */
+func migrate(db *sql.DB, version int) error {
+	if version == 0 {
+		return nil
+	}
+
+	for ; version < SchemaVersion; version++ {
+		if _, err := db.Exec(migrations[version]); err != nil {
+			return fmt.Errorf("migrating schema from version %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+/* Close: closes the database.
+ */
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+/* SaveRun: records a run and every result from it in a single transaction.
+ * Names already in the store just have their last seen time bumped. Returns
+ * the ID of the run and how many of the names hadn't been seen before.
+ */
+func (s *Store) SaveRun(started time.Time, results sancrawler.Results) (int64, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	res, err := tx.Exec(`INSERT INTO runs (started_at, finished_at, names) VALUES (?, ?, ?)`,
+		started.UTC(), now, len(results))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	insertName, err := tx.Prepare(`
+	INSERT INTO names (name, field, certificate_id, issuer_ca_id, issuer_name, source, not_before, not_after,
+		first_seen, last_seen, first_run, last_run)
+	 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	 ON CONFLICT(name) DO UPDATE SET last_seen = excluded.last_seen, last_run = excluded.last_run`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer insertName.Close()
+
+	insertSeed, err := tx.Prepare(`INSERT OR IGNORE INTO name_seeds (name, seed) VALUES (?, ?)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer insertSeed.Close()
+
+	newNames := 0
+
+	for name, r := range results {
+		if _, err := insertName.Exec(name, r.Field, r.CertificateID, r.IssuerCAID, r.IssuerName, r.Source,
+			nullTime(r.NotBefore), nullTime(r.NotAfter), now, now, runID, runID); err != nil {
+			return 0, 0, err
+		}
+
+		for _, seed := range r.Seeds {
+			if _, err := insertSeed.Exec(name, seed); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	// Anything whose first run is this one is new
+	if err := tx.QueryRow(`SELECT count(*) FROM names WHERE first_run = ?`, runID).Scan(&newNames); err != nil {
+		return 0, 0, err
+	}
+
+	return runID, newNames, tx.Commit()
+}
+
+/* LastRun: loads the names seen by the most recent run along with when that run
+ * finished. An empty store gives no results and the zero time.
+ */
+func (s *Store) LastRun() (sancrawler.Results, time.Time, error) {
+	var (
+		runID    sql.NullInt64
+		finished time.Time
+	)
+
+	ret := make(sancrawler.Results)
+
+	if err := s.db.QueryRow(`SELECT max(id) FROM runs`).Scan(&runID); err != nil {
+		return nil, finished, err
+	}
+	if !runID.Valid {
+		return ret, finished, nil
+	}
+
+	if err := s.db.QueryRow(`SELECT finished_at FROM runs WHERE id = ?`, runID.Int64).Scan(&finished); err != nil {
+		return nil, finished, err
+	}
+
+	rows, err := s.db.Query(`
+	SELECT n.name, n.field, n.certificate_id, n.issuer_ca_id, coalesce(n.issuer_name, ''), n.source, n.not_before, n.not_after,
+		coalesce(group_concat(ns.seed, char(10)), '')
+	 FROM names n LEFT JOIN name_seeds ns ON ns.name = n.name
+	 WHERE n.last_run = ?
+	 GROUP BY n.name`, runID.Int64)
+	if err != nil {
+		return nil, finished, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			r                   sancrawler.Result
+			notBefore, notAfter sql.NullTime
+			seeds               string
+		)
+
+		if err := rows.Scan(&r.Name, &r.Field, &r.CertificateID, &r.IssuerCAID, &r.IssuerName, &r.Source, &notBefore, &notAfter, &seeds); err != nil {
+			return nil, finished, err
+		}
+
+		r.NotBefore, r.NotAfter = notBefore.Time, notAfter.Time
+		r.Expired = !r.NotAfter.IsZero() && finished.After(r.NotAfter)
+		if seeds != "" {
+			r.Seeds = strings.Split(seeds, "\n")
+		}
+		ret[r.Name] = r
+	}
+
+	return ret, finished, rows.Err()
+}
+
+/* IsDatabase: whether the file at path is a SQLite database, going by the
+ * magic string every one of them starts with.
+ */
+func IsDatabase(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, 16)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == "SQLite format 3\x00"
+}
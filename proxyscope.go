@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+// Burp's target scope, as saved from Project options > Target > Scope with
+// advanced scope control on
+type burpConfig struct {
+	Target struct {
+		Scope burpScope `json:"scope"`
+	} `json:"target"`
+}
+
+type burpScope struct {
+	AdvancedMode bool        `json:"advanced_mode"`
+	Include      []burpEntry `json:"include"`
+	Exclude      []burpEntry `json:"exclude"`
+}
+
+type burpEntry struct {
+	Enabled  bool   `json:"enabled"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	File     string `json:"file"`
+}
+
+/* scopeHosts: the hosts to put in a proxy's scope, as regular expressions for
+ * the host alone, IPv6 addresses in brackets if inURL. Wildcards match anything
+ * under them, and names that were resolved and came back dead are left out.
+ */
+func scopeHosts(results []sancrawler.Result, inURL bool) []string {
+	var hosts []string
+	for _, res := range results {
+		if res.Type != "" && res.Type != sancrawler.TypeIP {
+			continue
+		}
+		if res.DNS != nil && !res.DNS.Live {
+			continue
+		}
+
+		host := regexp.QuoteMeta(res.Name)
+		if strings.HasPrefix(res.Name, "*.") {
+			host = `[^/:]+\.` + regexp.QuoteMeta(strings.TrimPrefix(res.Name, "*."))
+		} else if inURL && strings.Contains(res.Name, ":") {
+			host = `\[` + host + `\]`
+		}
+		if !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+/* excludedHosts: host regular expressions for the -exclude-domains apexes and
+ * everything under them.
+ */
+func excludedHosts(opts *options) ([]string, error) {
+	apexes, err := listOrFile(opts.excludeDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, apex := range apexes {
+		hosts = append(hosts, `([^/:]+\.)?`+regexp.QuoteMeta(strings.ToLower(apex)))
+	}
+	return hosts, nil
+}
+
+/* writeBurpScope: the results as a Burp Suite project options file with just
+ * the target scope in it, to load with Project > Project options > Load.
+ */
+func writeBurpScope(w *bufio.Writer, opts *options, results []sancrawler.Result) error {
+	var config burpConfig
+	config.Target.Scope = burpScope{AdvancedMode: true, Include: []burpEntry{}, Exclude: []burpEntry{}}
+
+	for _, host := range scopeHosts(results, false) {
+		config.Target.Scope.Include = append(config.Target.Scope.Include, burpEntry{Enabled: true, Protocol: "any", Host: "^" + host + "$", File: "^/.*"})
+	}
+
+	excluded, err := excludedHosts(opts)
+	if err != nil {
+		return err
+	}
+	for _, host := range excluded {
+		config.Target.Scope.Exclude = append(config.Target.Scope.Exclude, burpEntry{Enabled: true, Protocol: "any", Host: "^" + host + "$", File: "^/.*"})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
+
+/* writeZAPContext: the results as an OWASP ZAP context, to load with File >
+ * Import Context. ZAP's regular expressions match the whole URL.
+ */
+func writeZAPContext(w *bufio.Writer, opts *options, results []sancrawler.Result) error {
+	excluded, err := excludedHosts(opts)
+	if err != nil {
+		return err
+	}
+
+	url := func(host string) string {
+		return `https?://` + host + `(:[0-9]+)?([/?#].*)?`
+	}
+
+	w.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n")
+	w.WriteString("<configuration>\n<context>\n")
+	w.WriteString("<name>SANCrawler</name>\n")
+	w.WriteString("<desc>Names found by SANCrawler</desc>\n")
+	w.WriteString("<inscope>true</inscope>\n")
+	for _, host := range scopeHosts(results, true) {
+		w.WriteString("<incregexes>" + xmlEscape(url(host)) + "</incregexes>\n")
+	}
+	for _, host := range excluded {
+		w.WriteString("<excregexes>" + xmlEscape(url(host)) + "</excregexes>\n")
+	}
+	w.WriteString("</context>\n</configuration>\n")
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Everything that goes into a -report
+type report struct {
+	Seeds     []string
+	Generated time.Time
+	Stats     sancrawler.Statistics
+	Domains   []reportBar
+	Issuers   []reportBar
+	Apexes    []reportApex
+	Findings  []reportFinding
+	Diff      *sancrawler.Diff
+}
+
+// A row of a bar chart, Percent being of the longest bar
+type reportBar struct {
+	Name    string
+	Count   int
+	Percent float64
+}
+
+// The certificates with one kind of problem, only known for certificates that
+// were parsed locally
+type reportFinding struct {
+	Name         string
+	Description  string
+	Certificates []reportCertificate
+}
+
+type reportCertificate struct {
+	ID         int
+	IssuerName string
+	NotBefore  time.Time
+	NotAfter   time.Time
+	KeyType    string
+	Names      []string
+}
+
+// The names under one apex domain. IPs and names without one end up under
+// "(other)".
+type reportApex struct {
+	Name  string
+	Names []sancrawler.Result
+}
+
+/* newReport: puts together the report for a run. Charts get the top -stats-top
+ * domains and issuers, the tables have every name.
+ */
+func newReport(opts *options, queries []sancrawler.Query, subdomains sancrawler.Results, stats sancrawler.Statistics, diff *sancrawler.Diff) report {
+	r := report{Generated: time.Now().UTC(), Stats: stats, Diff: diff}
+
+	for _, q := range queries {
+		if !containsString(r.Seeds, q.Value) {
+			r.Seeds = append(r.Seeds, q.Value)
+		}
+	}
+
+	top := stats.Top(opts.statsTop)
+	r.Domains = reportBars(top.Domains)
+	r.Issuers = reportBars(top.Issuers)
+
+	apexes := make(map[string][]sancrawler.Result)
+	for _, res := range subdomains.Sorted() {
+		apex := "(other)"
+		if res.Type == "" {
+			if d, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(res.Name, "*.")); err == nil {
+				apex = d
+			}
+		}
+		apexes[apex] = append(apexes[apex], res)
+	}
+	for name, names := range apexes {
+		r.Apexes = append(r.Apexes, reportApex{Name: name, Names: names})
+	}
+
+	// Biggest first, like the statistics, with (other) always last
+	sort.Slice(r.Apexes, func(i, j int) bool {
+		a, b := r.Apexes[i], r.Apexes[j]
+		if (a.Name == "(other)") != (b.Name == "(other)") {
+			return b.Name == "(other)"
+		}
+		if len(a.Names) != len(b.Names) {
+			return len(a.Names) > len(b.Names)
+		}
+		return a.Name < b.Name
+	})
+
+	r.Findings = reportFindings(subdomains)
+
+	return r
+}
+
+/* reportFindings: the certificates with findings, grouped by finding, most
+ * certificates first.
+ */
+func reportFindings(subdomains sancrawler.Results) []reportFinding {
+	certs := make(map[string]map[int]*reportCertificate)
+	for _, res := range subdomains.Sorted() {
+		for _, finding := range res.Findings {
+			if certs[finding] == nil {
+				certs[finding] = make(map[int]*reportCertificate)
+			}
+			cert := certs[finding][res.CertificateID]
+			if cert == nil {
+				cert = &reportCertificate{
+					ID:         res.CertificateID,
+					IssuerName: res.IssuerName,
+					NotBefore:  res.NotBefore,
+					NotAfter:   res.NotAfter,
+					KeyType:    res.KeyType,
+				}
+				certs[finding][res.CertificateID] = cert
+			}
+			cert.Names = append(cert.Names, res.Name)
+		}
+	}
+
+	var ret []reportFinding
+	for name, byID := range certs {
+		f := reportFinding{Name: name, Description: sancrawler.FindingDescriptions[name]}
+		for _, cert := range byID {
+			f.Certificates = append(f.Certificates, *cert)
+		}
+		sort.Slice(f.Certificates, func(i, j int) bool {
+			return f.Certificates[i].ID > f.Certificates[j].ID
+		})
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i].Certificates) != len(ret[j].Certificates) {
+			return len(ret[i].Certificates) > len(ret[j].Certificates)
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+func reportBars(counts []sancrawler.Count) []reportBar {
+	var ret []reportBar
+	for _, c := range counts {
+		ret = append(ret, reportBar{Name: c.Name, Count: c.Count, Percent: 100 * float64(c.Count) / float64(counts[0].Count)})
+	}
+	return ret
+}
+
+/* writeReport: writes the report to path, as Markdown if it ends in .md and
+ * HTML otherwise. The HTML has no outside dependencies, so it can be attached
+ * to an email or dropped into a deliverable as it is.
+ */
+func writeReport(path string, r report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		err = markdownReport.Execute(f, r)
+	default:
+		err = htmlReport.Execute(f, r)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var reportFuncs = map[string]interface{}{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02")
+	},
+	"join": strings.Join,
+	"bar": func(percent float64) string {
+		return strings.Repeat("█", int(percent/5+0.5))
+	},
+	"md": func(s string) string {
+		return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+	},
+	"runtime": func(seconds float64) string {
+		return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+	},
+}
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>SANCrawler report: {{join .Seeds ", "}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1100px; color: #222; padding: 0 1em; }
+h1 { margin-bottom: 0.2em; }
+.meta { color: #666; margin-top: 0; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; margin: 1.5em 0; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: 0.8em 1.2em; min-width: 8em; }
+.card b { display: block; font-size: 1.6em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1.5em; font-size: 0.9em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { background: #f6f6f6; }
+.chart td.label { width: 40%; word-break: break-all; }
+.chart td.count { width: 5em; text-align: right; }
+.bar { background: #4a7bd0; height: 1em; border-radius: 2px; }
+.expired { color: #b33; }
+.new { color: #282; }
+details { margin-bottom: 0.5em; }
+summary { cursor: pointer; font-weight: bold; padding: 0.3em 0; }
+</style>
+</head>
+<body>
+<h1>SANCrawler report</h1>
+<p class="meta">Seeds: {{join .Seeds ", "}}<br>Generated {{.Generated.Format "2006-01-02 15:04 MST"}}</p>
+
+<div class="cards">
+<div class="card"><b>{{.Stats.Names}}</b>unique names</div>
+<div class="card"><b>{{.Stats.ApexDomains}}</b>apex domains</div>
+<div class="card"><b>{{.Stats.Wildcards}}</b>wildcards</div>
+<div class="card"><b>{{.Stats.IPs}}</b>IP addresses</div>
+<div class="card"><b>{{.Stats.Valid}} / {{.Stats.Expired}}</b>valid / expired</div>
+<div class="card"><b>{{.Stats.IssuerCount}}</b>issuers</div>
+{{- if .Stats.Certificates}}
+<div class="card"><b>{{.Stats.Certificates}}</b>certificates scanned</div>
+{{- end}}
+<div class="card"><b>{{runtime .Stats.Runtime}}</b>runtime</div>
+</div>
+{{with .Diff}}
+<h2>Changes since the last run</h2>
+<div class="cards">
+<div class="card new"><b>{{len .New}}</b>new</div>
+<div class="card"><b>{{len .Removed}}</b>removed</div>
+<div class="card"><b>{{len .Reissued}}</b>reissued</div>
+<div class="card expired"><b>{{len .Expired}}</b>expired</div>
+</div>
+{{- if .New}}
+<h3>Newly seen names</h3>
+<table>
+<tr><th>Name</th><th>Issuer</th><th>Not before</th><th>Not after</th><th>Seeds</th></tr>
+{{- range .New}}
+<tr><td>{{.Name}}</td><td>{{.IssuerName}}</td><td>{{date .NotBefore}}</td><td>{{date .NotAfter}}</td><td>{{join .Seeds ", "}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+{{- if .Findings}}
+
+<h2>Findings</h2>
+{{- range .Findings}}
+<details{{if lt (len .Certificates) 20}} open{{end}}>
+<summary>{{.Name}}: {{.Description}} ({{len .Certificates}})</summary>
+<table>
+<tr><th>Certificate</th><th>Issuer</th><th>Key</th><th>Not before</th><th>Not after</th><th>Names</th></tr>
+{{- range .Certificates}}
+<tr><td>{{.ID}}</td><td>{{.IssuerName}}</td><td>{{.KeyType}}</td><td>{{date .NotBefore}}</td><td>{{date .NotAfter}}</td><td>{{join .Names ", "}}</td></tr>
+{{- end}}
+</table>
+</details>
+{{- end}}
+{{- end}}
+
+<h2>Apex domains</h2>
+<table class="chart">
+{{- range .Domains}}
+<tr><td class="label">{{.Name}}</td><td class="count">{{.Count}}</td><td><div class="bar" style="width: {{printf "%.1f" .Percent}}%"></div></td></tr>
+{{- end}}
+</table>
+
+<h2>Issuers</h2>
+<table class="chart">
+{{- range .Issuers}}
+<tr><td class="label">{{.Name}}</td><td class="count">{{.Count}}</td><td><div class="bar" style="width: {{printf "%.1f" .Percent}}%"></div></td></tr>
+{{- end}}
+</table>
+
+<h2>Names by apex domain</h2>
+{{- range .Apexes}}
+<details{{if lt (len .Names) 50}} open{{end}}>
+<summary>{{.Name}} ({{len .Names}})</summary>
+<table>
+<tr><th>Name</th><th>Issuer</th><th>Not before</th><th>Not after</th><th>Seeds</th></tr>
+{{- range .Names}}
+<tr{{if .Expired}} class="expired"{{end}}><td>{{.Name}}</td><td>{{.IssuerName}}</td><td>{{date .NotBefore}}</td><td>{{date .NotAfter}}</td><td>{{join .Seeds ", "}}</td></tr>
+{{- end}}
+</table>
+</details>
+{{- end}}
+</body>
+</html>
+`))
+
+var markdownReport = template.Must(template.New("report").Funcs(reportFuncs).Parse(`# SANCrawler report
+
+Seeds: {{md (join .Seeds ", ")}}
+
+Generated {{.Generated.Format "2006-01-02 15:04 MST"}}
+
+## Summary
+
+| | |
+|---|---:|
+| Unique names | {{.Stats.Names}} |
+| Apex domains | {{.Stats.ApexDomains}} |
+| Wildcards | {{.Stats.Wildcards}} |
+| IP addresses | {{.Stats.IPs}} |
+| Valid / expired | {{.Stats.Valid}} / {{.Stats.Expired}} |
+| Issuers | {{.Stats.IssuerCount}} |
+{{- if .Stats.Certificates}}
+| Certificates scanned | {{.Stats.Certificates}} |
+{{- end}}
+| Runtime | {{runtime .Stats.Runtime}} |
+{{with .Diff}}
+## Changes since the last run
+
+| New | Removed | Reissued | Expired |
+|---:|---:|---:|---:|
+| {{len .New}} | {{len .Removed}} | {{len .Reissued}} | {{len .Expired}} |
+{{- if .New}}
+
+### Newly seen names
+
+| Name | Issuer | Not before | Not after | Seeds |
+|---|---|---|---|---|
+{{- range .New}}
+| {{md .Name}} | {{md .IssuerName}} | {{date .NotBefore}} | {{date .NotAfter}} | {{md (join .Seeds ", ")}} |
+{{- end}}
+{{- end}}
+{{end}}
+{{- with .Findings}}
+## Findings
+{{range .}}
+### {{.Name}}: {{.Description}} ({{len .Certificates}})
+
+| Certificate | Issuer | Key | Not before | Not after | Names |
+|---|---|---|---|---|---|
+{{- range .Certificates}}
+| {{.ID}} | {{md .IssuerName}} | {{.KeyType}} | {{date .NotBefore}} | {{date .NotAfter}} | {{md (join .Names ", ")}} |
+{{- end}}
+{{end}}
+{{- end}}
+## Apex domains
+
+| Domain | Names | |
+|---|---:|---|
+{{- range .Domains}}
+| {{md .Name}} | {{.Count}} | {{bar .Percent}} |
+{{- end}}
+
+## Issuers
+
+| Issuer | Names | |
+|---|---:|---|
+{{- range .Issuers}}
+| {{md .Name}} | {{.Count}} | {{bar .Percent}} |
+{{- end}}
+
+## Names by apex domain
+{{range .Apexes}}
+### {{md .Name}} ({{len .Names}})
+
+| Name | Issuer | Not before | Not after | Expired | Seeds |
+|---|---|---|---|---|---|
+{{- range .Names}}
+| {{md .Name}} | {{md .IssuerName}} | {{date .NotBefore}} | {{date .NotAfter}} | {{if .Expired}}yes{{end}} | {{md (join .Seeds ", ")}} |
+{{- end}}
+{{end -}}
+`))
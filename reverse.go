@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* reverseDomain: the -domain mode. Rather than crawling, list the subject
+ * metadata on the domain's certificates so there's something to crawl with.
+ */
+func reverseDomain(ctx context.Context, crawler *sancrawler.Crawler, opts *options) {
+	log.WithFields(log.Fields{
+		"Domain": opts.domain,
+	}).Info("Looking up subject metadata for domain")
+
+	attrs, err := crawler.DomainSubjects(ctx, opts.domain)
+	if err != nil {
+		log.Fatal("Could not look up domain: ", err)
+	}
+
+	orgs := 0
+	for _, attr := range attrs {
+		if attr.Field == "O" {
+			orgs++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Attributes":    len(attrs),
+		"Organizations": orgs,
+	}).Info("Found subject metadata, crawl the organizations with -s")
+
+	fHandle, err := openOutput(opts.outfile, false)
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	w := bufio.NewWriter(fHandle)
+	if err := writeSubjects(w, opts.format, attrs); err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+}
+
+/* writeSubjects: one attribute per line (or row, or object), most common first.
+ */
+func writeSubjects(w *bufio.Writer, format string, attrs []sancrawler.SubjectAttribute) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(attrs)
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"field", "value", "certificates"})
+		for _, attr := range attrs {
+			out.Write([]string{attr.Field, attr.Value, strconv.Itoa(attr.Certificates)})
+		}
+		out.Flush()
+		return out.Error()
+	}
+
+	for _, attr := range attrs {
+		w.WriteString(strconv.Itoa(attr.Certificates) + "\t" + attr.Field + "\t" + attr.Value + "\n")
+	}
+	return nil
+}
@@ -0,0 +1,1190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/cache"
+	"github.com/cramppet/sancrawler2/pkg/neo4j"
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	"github.com/cramppet/sancrawler2/pkg/store"
+	log "github.com/sirupsen/logrus"
+)
+
+/* buildCrawler: sets up the crawler with whichever backends were asked for,
+ * along with the limiter they all share.
+ */
+func buildCrawler(opts *options) (*sancrawler.Crawler, *sancrawler.Limiter) {
+	crawler := sancrawler.New()
+
+	// Commands without the source flags leave these empty, which means auto
+
+	switch opts.backend {
+	case "", "auto":
+	case "db":
+		crawler.Fallback = nil
+	case "api":
+		crawler.Backend = sancrawler.NewAPIBackend()
+		crawler.Fallback = nil
+	case "google":
+		crawler.Backend = sancrawler.NewGoogleBackend()
+		crawler.Fallback = nil
+	default:
+		log.Fatal("Unknown backend: ", opts.backend)
+	}
+
+	switch {
+	case opts.excludeExpired && opts.onlyExpired:
+		log.Fatal("-exclude-expired and -only-expired can't be used together")
+	case opts.expiringWithin != "" && opts.onlyExpired:
+		log.Fatal("-expiring-within and -only-expired can't be used together")
+	case opts.excludeExpired, opts.expiringWithin != "":
+		crawler.Filter.Expiry = sancrawler.ExpiryExclude
+	case opts.onlyExpired:
+		crawler.Filter.Expiry = sancrawler.ExpiryOnly
+	}
+
+	if opts.expiringWithin != "" {
+		if _, err := parseSpan(opts.expiringWithin); err != nil {
+			log.Fatal("Bad -expiring-within: ", err)
+		}
+	}
+
+	if opts.since != "" {
+		since, err := parseWhen(opts.since)
+		if err != nil {
+			log.Fatal("Bad -since: ", err)
+		}
+		crawler.Filter.Since = since
+	}
+	if opts.until != "" {
+		until, err := parseWhen(opts.until)
+		if err != nil {
+			log.Fatal("Bad -until: ", err)
+		}
+		crawler.Filter.Until = until
+	}
+
+	var err error
+	if crawler.Filter.IssuerInclude, err = listOrFile(opts.issuerInclude); err != nil {
+		log.Fatal("Could not read -issuer-include: ", err)
+	}
+	if crawler.Filter.IssuerExclude, err = listOrFile(opts.issuerExclude); err != nil {
+		log.Fatal("Could not read -issuer-exclude: ", err)
+	}
+
+	var sanTypes []string
+	for _, t := range strings.Split(opts.sanTypes, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case "":
+		case "dns", "ip", "uri", "email":
+			sanTypes = append(sanTypes, t)
+		default:
+			log.Fatal("Unknown SAN type: ", t)
+		}
+	}
+
+	if opts.emails && !containsString(sanTypes, "email") {
+		sanTypes = append(sanTypes, "email")
+	}
+
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok {
+		db.Retries, db.RetryDelay = opts.retries, opts.retryDelay
+		db.SANTypes = sanTypes
+		db.SubjectEmails = opts.emails
+	} else if len(sanTypes) != 1 || sanTypes[0] != "dns" {
+		log.Warn("Only the db backend can collect SANs other than DNS names")
+	}
+
+	for _, backend := range []sancrawler.Backend{crawler.Backend, crawler.Fallback} {
+		switch b := backend.(type) {
+		case *sancrawler.DBBackend:
+			b.KeepPrecerts = !opts.dedupePrecerts
+		case *sancrawler.APIBackend:
+			b.KeepPrecerts = !opts.dedupePrecerts
+		}
+	}
+
+	// Every crt.sh backend shares the one limiter so the limits hold for the whole
+	// run, not per goroutine. There always is one, it's also how a crawl gets
+	// stopped gently.
+
+	limiter := sancrawler.NewLimiter(opts.maxConns, opts.qps)
+	for _, backend := range []sancrawler.Backend{crawler.Backend, crawler.Fallback} {
+		switch b := backend.(type) {
+		case *sancrawler.DBBackend:
+			b.Limiter = limiter
+		case *sancrawler.APIBackend:
+			b.Limiter = limiter
+		}
+	}
+
+	// Point the database backend somewhere other than the public crt.sh, eg. a
+	// mirror or a caching proxy.
+
+	dsn := opts.dsn
+	if dsn == "" {
+		dsn = os.Getenv("SANCRAWLER_DSN")
+	}
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok && dsn != "" {
+		db.ConnStr = dsn
+	}
+
+	// Every query shares one pool of connections, by default there's no point
+	// having more of them than queries we're allowed to run at once.
+
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok {
+		db.MaxOpenConns = opts.maxConns
+		if opts.dbMaxConns > 0 {
+			db.MaxOpenConns = opts.dbMaxConns
+		}
+		db.NoPrepare = opts.noPrepare
+		db.MaxCrawlers = opts.maxCrawlers
+		db.TargetLatency = opts.targetLatency
+	}
+
+	if opts.parseLocal {
+		if opts.schema == sancrawler.SchemaFull {
+			log.Fatal("-parse-local can't be used with -schema full")
+		}
+		opts.schema = sancrawler.SchemaRaw
+	}
+
+	switch opts.schema {
+	case "", "auto":
+	case sancrawler.SchemaFull, sancrawler.SchemaRaw:
+		if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok {
+			db.Schema = opts.schema
+		}
+	default:
+		log.Fatal("Unknown database schema: ", opts.schema)
+	}
+
+	if opts.censys {
+		apiID, secret := os.Getenv("CENSYS_API_ID"), os.Getenv("CENSYS_API_SECRET")
+		if apiID == "" || secret == "" {
+			log.Fatal("-censys needs CENSYS_API_ID and CENSYS_API_SECRET to be set")
+		}
+		crawler.Extra = append(crawler.Extra, sancrawler.NewCensysBackend(apiID, secret))
+	}
+
+	if opts.google {
+		crawler.Extra = append(crawler.Extra, sancrawler.NewGoogleBackend())
+	}
+
+	// An app ID and secret work as a token too, so there's no need to go and
+	// generate one.
+
+	if opts.facebook {
+		token := os.Getenv("FACEBOOK_ACCESS_TOKEN")
+		if appID, secret := os.Getenv("FACEBOOK_APP_ID"), os.Getenv("FACEBOOK_APP_SECRET"); token == "" && appID != "" && secret != "" {
+			token = appID + "|" + secret
+		}
+		if token == "" {
+			log.Fatal("-facebook needs FACEBOOK_ACCESS_TOKEN, or FACEBOOK_APP_ID and FACEBOOK_APP_SECRET, to be set")
+		}
+		crawler.Extra = append(crawler.Extra, sancrawler.NewFacebookBackend(token))
+	}
+
+	// Everything that talks to a data source goes through -proxy, postgres
+	// included.
+
+	if proxy := buildProxy(opts); proxy != nil {
+		for _, backend := range append([]sancrawler.Backend{crawler.Backend, crawler.Fallback}, crawler.Extra...) {
+			switch b := backend.(type) {
+			case *sancrawler.DBBackend:
+				b.Proxy = proxy
+			case *sancrawler.APIBackend:
+				b.Client.Transport = proxy.Transport()
+			case *sancrawler.CensysBackend:
+				b.Client.Transport = proxy.Transport()
+			case *sancrawler.FacebookBackend:
+				b.Client.Transport = proxy.Transport()
+			case *sancrawler.GoogleBackend:
+				b.Client.Transport = proxy.Transport()
+			}
+		}
+	}
+
+	// The cache stays open until we exit. Everything that changes what the
+	// backends hand back goes into the keys, so changing any of it is a miss.
+
+	if opts.cachePath != "" {
+		c, err := cache.Open(opts.cachePath, opts.cacheTTL)
+		if err != nil {
+			log.Fatal("Could not open cache: ", err)
+		}
+
+		purged, err := c.Purge()
+		if err != nil {
+			log.Warn("Could not purge stale cache entries: ", err)
+		}
+
+		log.WithFields(log.Fields{
+			"Cache":  opts.cachePath,
+			"TTL":    opts.cacheTTL,
+			"Purged": purged,
+		}).Info("Using cache")
+
+		crawler.Cache = c
+		crawler.CacheScope = fmt.Sprint(opts.backend, dsn, opts.schema, opts.sanTypes, opts.emails, opts.dedupePrecerts, opts.censys, opts.facebook, opts.google)
+		crawler.RefreshCache = opts.cacheRefresh
+	}
+
+	return crawler, limiter
+}
+
+/* logEmails: sums up the email addresses found by the domain they're at, which
+ * is usually enough to spot the naming convention.
+ */
+func logEmails(subdomains sancrawler.Results) {
+	domains := make(map[string]int)
+	total := 0
+
+	for _, res := range subdomains {
+		if res.Type != sancrawler.TypeEmail {
+			continue
+		}
+		total++
+		if at := strings.LastIndex(res.Name, "@"); at >= 0 {
+			domains[res.Name[at+1:]]++
+		}
+	}
+
+	for domain, count := range domains {
+		log.WithFields(log.Fields{
+			"Domain": domain,
+			"Emails": count,
+		}).Info(" . . . ")
+	}
+
+	log.WithFields(log.Fields{
+		"Emails": total,
+	}).Info("Harvested email addresses")
+}
+
+/* flagHomoglyphs: flags the internationalized names that look like the org's
+ * own domains, those in -include-domains or else the ones with the most names,
+ * and logs every one of them.
+ */
+func flagHomoglyphs(opts *options, subdomains sancrawler.Results) {
+	domains, err := listOrFile(opts.includeDomains)
+	if err != nil {
+		log.Fatal("Could not read included domains: ", err)
+	}
+	if len(domains) == 0 {
+		domains = sancrawler.MainDomains(subdomains, 5)
+	}
+
+	flagged := sancrawler.FlagHomoglyphs(subdomains, domains)
+
+	for _, name := range flagged {
+		log.WithFields(log.Fields{
+			"Name":     name,
+			"Punycode": subdomains[name].Punycode,
+			"Looks":    subdomains[name].Homoglyph,
+		}).Warn("Possible homoglyph of the organization's domain")
+	}
+
+	log.WithFields(log.Fields{
+		"Domains": strings.Join(domains, ","),
+		"Flagged": len(flagged),
+	}).Info("Checked for homoglyphs")
+}
+
+/* scoreNames: rates every name on how likely it is to be the target's, counting
+ * the -include-domains and -anchor-domains as the target's own, and drops those
+ * under -min-score.
+ */
+func scoreNames(opts *options, queries []sancrawler.Query, subdomains sancrawler.Results) sancrawler.Results {
+	domains, err := listOrFile(opts.includeDomains)
+	if err != nil {
+		log.Fatal("Could not read included domains: ", err)
+	}
+	anchors, err := listOrFile(opts.anchorDomains)
+	if err != nil {
+		log.Fatal("Could not read anchor domains: ", err)
+	}
+	domains = append(domains, anchors...)
+
+	scorer := &sancrawler.Scorer{Queries: queries, Apexes: domains}
+	scorer.ScoreAll(subdomains)
+
+	if opts.minScore <= 0 {
+		return subdomains
+	}
+
+	kept, dropped := sancrawler.FilterScore(subdomains, opts.minScore)
+
+	log.WithFields(log.Fields{
+		"MinScore": opts.minScore,
+		"Kept":     len(kept),
+		"Dropped":  dropped,
+	}).Info("Dropped names scoring under -min-score")
+
+	return kept
+}
+
+/* logFindings: how many certificates have each finding, which only the ones
+ * parsed locally can have. The -report lists them.
+ */
+func logFindings(subdomains sancrawler.Results) {
+	certs := make(map[string]map[int]bool)
+	for _, res := range subdomains {
+		for _, finding := range res.Findings {
+			if certs[finding] == nil {
+				certs[finding] = make(map[int]bool)
+			}
+			certs[finding][res.CertificateID] = true
+		}
+	}
+
+	for finding, ids := range certs {
+		log.WithFields(log.Fields{
+			"Finding":      finding,
+			"Certificates": len(ids),
+		}).Warn(sancrawler.FindingDescriptions[finding])
+	}
+}
+
+/* logSeedMatches: how many names each seed turned up, so it's obvious which
+ * seeds (or generated variants) are actually worth keeping.
+ */
+func logSeedMatches(queries []sancrawler.Query, subdomains sancrawler.Results) {
+	counts := make(map[string]int)
+	for _, res := range subdomains {
+		for _, seed := range res.Seeds {
+			counts[seed]++
+		}
+	}
+
+	matched := 0
+	for _, q := range queries {
+		if counts[q.Value] == 0 {
+			continue
+		}
+		matched++
+		log.WithFields(log.Fields{
+			"Seed":  q.Value,
+			"Names": counts[q.Value],
+		}).Info(" . . . ")
+	}
+
+	log.WithFields(log.Fields{
+		"Seeds":   len(queries),
+		"Matched": matched,
+	}).Info("Seeds that matched")
+}
+
+/* buildNormalizer: turns -normalize into the rules to apply.
+ */
+func buildNormalizer(rules string) sancrawler.Normalizer {
+	var n sancrawler.Normalizer
+
+	for _, rule := range strings.Split(rules, ",") {
+		switch strings.ToLower(strings.TrimSpace(rule)) {
+		case "", "none":
+		case "dots":
+			n.TrimDots = true
+		case "ports":
+			n.StripPorts = true
+		case "idn":
+			n.DecodeIDN = true
+		case "ips":
+			n.SplitIPs = true
+		default:
+			log.Fatal("Unknown normalization rule: ", rule)
+		}
+	}
+
+	return n
+}
+
+/* parseWhen: turns a -since/-until value into a time. Takes a date, an RFC 3339
+ * timestamp, or how long ago as either a Go duration or a number of days (90d).
+ */
+func parseWhen(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	ago, err := parseSpan(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q isn't a date or a duration", value)
+	}
+	return time.Now().Add(-ago), nil
+}
+
+/* parseSpan: a Go duration or a number of days (30d), never negative.
+ */
+func parseSpan(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	span, err := time.ParseDuration(value)
+	if err != nil || span < 0 {
+		return 0, fmt.Errorf("%q isn't a duration", value)
+	}
+	return span, nil
+}
+
+/* buildQueries: works out what to crawl from the seeds on the command line,
+ * including the ones that first need looking up (URLs, fingerprints, serials).
+ */
+func buildQueries(ctx context.Context, crawler *sancrawler.Crawler, opts *options) []sancrawler.Query {
+	keywords, orgs := opts.keywords, opts.orgs
+
+	// Whatever -pivot-field picks off seed certificates
+	var pivoted []sancrawler.Query
+
+	var spkis []string
+	if opts.spki != "" {
+		spki, err := sancrawler.NormalizeHex(opts.spki)
+		if err != nil {
+			log.Fatal("Bad -spki: ", err)
+		}
+		spkis = append(spkis, spki)
+	}
+
+	// Seeds from files just get tacked on to whatever was passed with -k and -s
+
+	for _, seedFile := range []struct {
+		path  string
+		seeds *seedList
+	}{{opts.keywordFile, &keywords}, {opts.orgFile, &orgs}} {
+		if seedFile.path == "" {
+			continue
+		}
+
+		seeds, err := readLines(seedFile.path)
+		if err != nil {
+			log.Fatal("Could not read seed file: ", err)
+		}
+		*seedFile.seeds = append(*seedFile.seeds, seeds...)
+	}
+
+	// If we want to try the auto extraction, then we are implictly choosing to
+	// use the organization mode, unless -pivot-field says otherwise. -uf does the
+	// same for a whole list of hosts.
+
+	for _, seed := range fetchSeedChains(ctx, opts) {
+		if opts.pivotField != "" {
+			for _, cert := range seed.chain {
+				info := sancrawler.Describe(cert)
+				log.WithFields(log.Fields{
+					"URL":       seed.url,
+					"Subject":   info.Subject,
+					"Issuer":    info.Issuer,
+					"Serial":    info.Serial,
+					"SANs":      strings.Join(info.DNSNames, ","),
+					"SPKI":      info.SPKI,
+					"NotBefore": info.NotBefore,
+					"NotAfter":  info.NotAfter,
+				}).Info("Found certificate")
+			}
+
+			pivoted = append(pivoted, choosePivots(opts, seed.chain[0])...)
+			continue
+		}
+
+		if opts.spkiPivot {
+			if spki := sancrawler.SPKIHash(seed.chain[0]); !containsString(spkis, spki) {
+				spkis = append(spkis, spki)
+				log.WithFields(log.Fields{
+					"URL":  seed.url,
+					"SPKI": spki,
+				}).Info("Using the URL's public key as seed")
+			}
+			continue
+		}
+
+		// Every organization on the certificate gets crawled. Each name keeps the
+		// seed that found it, so which organization it came from isn't lost.
+		extracted := seed.chain[0].Subject.Organization
+		if len(extracted) == 0 {
+			if opts.urlFile == "" {
+				log.Fatal("URL provided does not contain an organization. Quitting.")
+			}
+			log.WithFields(log.Fields{
+				"URL": seed.url,
+			}).Warn("Certificate has no organization, skipping")
+			continue
+		}
+
+		for _, o := range extracted {
+			if o = strings.TrimSpace(o); o != "" && !containsString(orgs, o) {
+				orgs = append(orgs, o)
+				log.WithFields(log.Fields{
+					"URL":          seed.url,
+					"Organization": o,
+					"Of":           len(extracted),
+				}).Info("Using extracted organization as seed")
+			}
+		}
+	}
+
+	// Same idea when all we have is a certificate hash or serial, find it in crt.sh
+	// and pivot on whatever organizations it has (or its key with -spki-pivot).
+
+	for _, lookup := range []struct {
+		kind  string
+		value string
+	}{{sancrawler.LookupFingerprint, opts.fingerprint}, {sancrawler.LookupSerial, opts.serial}} {
+		if lookup.value == "" {
+			continue
+		}
+
+		certs, err := crawler.LookupCertificates(ctx, lookup.kind, lookup.value)
+		if err != nil {
+			log.Fatal("Could not look up certificate: ", err)
+		}
+		if len(certs) == 0 {
+			log.Fatal("No certificate found for ", lookup.kind, " ", lookup.value, ". Quitting.")
+		}
+
+		for _, cert := range certs {
+			log.WithFields(log.Fields{
+				"Subject":   cert.Subject.String(),
+				"Issuer":    cert.Issuer.String(),
+				"SANs":      strings.Join(cert.DNSNames, ","),
+				"NotBefore": cert.NotBefore,
+				"NotAfter":  cert.NotAfter,
+			}).Info("Found certificate")
+
+			if opts.pivotField != "" {
+				pivoted = append(pivoted, choosePivots(opts, cert)...)
+				continue
+			}
+
+			if opts.spkiPivot {
+				if spki := sancrawler.SPKIHash(cert); !containsString(spkis, spki) {
+					spkis = append(spkis, spki)
+					log.WithFields(log.Fields{
+						"SPKI": spki,
+					}).Info("Using certificate public key as seed")
+				}
+				continue
+			}
+
+			for _, o := range cert.Subject.Organization {
+				if !containsString(orgs, o) {
+					orgs = append(orgs, o)
+					log.WithFields(log.Fields{
+						"Organization": o,
+					}).Info("Using certificate organization as seed")
+				}
+			}
+		}
+	}
+
+	// Every mode that was asked for gets crawled, all at the same time, and the
+	// results merged. Each name keeps the seeds that found it.
+
+	var queries []sancrawler.Query
+	add := func(q sancrawler.Query) {
+		for _, existing := range queries {
+			if existing.Value == q.Value && existing.NameType == q.NameType && existing.Match == q.Match {
+				return
+			}
+		}
+		queries = append(queries, q)
+	}
+
+	// Org names in particular vary a lot ("Acme Inc", "ACME, INC."), -fuzzy
+	// catches the variations on exact seeds.
+	match := sancrawler.MatchExact
+	if opts.fuzzy {
+		match = sancrawler.MatchFuzzy
+	}
+
+	// -field picks the field for -value and narrows -regex down
+	fieldType := ""
+	if opts.field != "" {
+		var err error
+		if fieldType, err = sancrawler.NameTypeForField(opts.field); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, q := range pivoted {
+		add(q)
+	}
+
+	caIDs, err := listOrFile(opts.issuerCAID)
+	if err != nil {
+		log.Fatal("Could not read CA IDs: ", err)
+	}
+	for _, caID := range caIDs {
+		add(sancrawler.Query{Value: caID, NameType: sancrawler.NameTypeIssuerCA})
+	}
+
+	for _, pattern := range opts.regexes {
+		add(sancrawler.Query{Value: pattern, NameType: fieldType, Match: sancrawler.MatchRegex})
+	}
+
+	for _, pattern := range opts.likes {
+		add(sancrawler.Query{Value: pattern, Match: sancrawler.MatchLike})
+	}
+
+	for _, spki := range spkis {
+		add(sancrawler.Query{Value: spki, NameType: sancrawler.NameTypeSPKI})
+	}
+
+	// A keyword can match all sorts of unrelated organizations, -disambiguate
+	// and -auto-top crawl the right ones instead of everything.
+	for _, k := range keywords {
+		q := sancrawler.Query{Value: k, Match: match}
+		if !opts.disambiguate && opts.autoTop <= 0 {
+			add(q)
+			continue
+		}
+
+		picked := disambiguate(ctx, crawler, opts, q)
+		if picked == nil {
+			add(q)
+		}
+		for _, o := range picked {
+			add(sancrawler.Query{Value: o, NameType: sancrawler.NameTypeOrganization})
+		}
+	}
+
+	if opts.orgVariants && len(orgs) > 0 {
+		var variants []string
+		for _, o := range orgs {
+			for _, v := range sancrawler.OrgVariants(o) {
+				if !containsString(variants, v) {
+					variants = append(variants, v)
+				}
+			}
+		}
+
+		log.WithFields(log.Fields{
+			"Organizations": len(orgs),
+			"Variants":      len(variants),
+		}).Info("Generated organization name variants")
+		orgs = variants
+	}
+
+	for _, o := range orgs {
+		add(sancrawler.Query{Value: o, NameType: sancrawler.NameTypeOrganization, Match: match})
+	}
+
+	if fieldType != "" {
+		for _, v := range opts.fieldValues {
+			add(sancrawler.Query{Value: v, NameType: fieldType, Match: match})
+		}
+	}
+
+	return queries
+}
+
+/* buildProxy: the -proxy to send traffic through, nil if there isn't one.
+ */
+func buildProxy(opts *options) *sancrawler.Proxy {
+	if opts.proxy == "" {
+		return nil
+	}
+
+	proxy, err := sancrawler.NewProxy(opts.proxy)
+	if err != nil {
+		log.Fatal("Bad -proxy: ", err)
+	}
+	return proxy
+}
+
+/* fetchOptions: how to connect to -u.
+ */
+func fetchOptions(opts *options) sancrawler.FetchOptions {
+	return sancrawler.FetchOptions{
+		Proxy:      buildProxy(opts),
+		Insecure:   opts.insecure,
+		ServerName: opts.sni,
+		Port:       opts.urlPort,
+		Timeout:    opts.urlTimeout,
+		StartTLS:   opts.startTLS,
+	}
+}
+
+/* buildScope: the scope is needed up front since recursive crawls only pivot
+ * on names that are in scope. No filters gives a nil scope, which keeps
+ * everything.
+ */
+func buildScope(opts *options) *sancrawler.Scope {
+	if opts.includeDomains == "" && opts.excludeDomains == "" {
+		return nil
+	}
+
+	include, err := listOrFile(opts.includeDomains)
+	if err != nil {
+		log.Fatal("Could not read included domains: ", err)
+	}
+	exclude, err := listOrFile(opts.excludeDomains)
+	if err != nil {
+		log.Fatal("Could not read excluded domains: ", err)
+	}
+	anchors, err := listOrFile(opts.anchorDomains)
+	if err != nil {
+		log.Fatal("Could not read anchor domains: ", err)
+	}
+
+	return sancrawler.NewScope(include, exclude, anchors)
+}
+
+/* reportProgress: logs how the crawl is getting on every interval until ctx is
+ * done.
+ */
+func reportProgress(ctx context.Context, progress *sancrawler.Progress, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats := progress.Stats()
+		fields := log.Fields{
+			"Certificates": fmt.Sprintf("%d/%d", stats.Done, stats.Certificates),
+			"Names":        stats.Names,
+			"Rate":         fmt.Sprintf("%.1f/s", stats.Rate),
+		}
+		if stats.Certificates > 0 {
+			fields["Percent"] = fmt.Sprintf("%.1f%%", 100*float64(stats.Done)/float64(stats.Certificates))
+		}
+		if stats.ETA > 0 {
+			fields["ETA"] = stats.ETA.Round(time.Second)
+		}
+
+		log.WithFields(fields).Info("Crawling ...")
+	}
+}
+
+/* crawl: does a full crawl of the queries along with all of the post-processing
+ * asked for (scope, wildcards, resolution). Being interrupted isn't fatal, we
+ * just carry on with whatever was found, and neither is a backend failing, but
+ * then failed comes back true. Names also go to found (if it isn't nil) as soon
+ * as they turn up.
+ */
+func crawl(ctx context.Context, crawler *sancrawler.Crawler, opts *options, queries []sancrawler.Query, scope *sancrawler.Scope, found func(sancrawler.Result)) (subdomains sancrawler.Results, failed bool) {
+	// Checkpointing only makes sense for the database crawlers, the other backends
+	// grab everything in one go.
+
+	var checkpoint *sancrawler.Checkpoint
+
+	if opts.resume != "" {
+		db, ok := crawler.Backend.(*sancrawler.DBBackend)
+		if !ok {
+			log.Fatal("-resume only works with the db backend")
+		}
+
+		var err error
+		checkpoint, err = sancrawler.LoadCheckpoint(opts.resume)
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.Checkpoint = checkpoint
+
+		saveCtx, stopSaving := context.WithCancel(ctx)
+		defer stopSaving()
+		go checkpoint.AutoSave(saveCtx, 30*time.Second, func(err error) {
+			log.Warn("Could not save checkpoint: ", err)
+		})
+	}
+
+	// Big crawls can go quiet for a long time, so keep reporting how far along we
+	// are. Only the database backend knows how much work there is to do, it also
+	// counts the certificates read for the statistics.
+
+	var progress *sancrawler.Progress
+
+	// Seeds for the same target match a lot of the same certificates, there's no
+	// point reading them out of crt.sh more than once.
+
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok && !opts.noShare {
+		db.ShareCertificates = len(queries) > 1 || opts.recursive || opts.issuerPivot
+	}
+
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok {
+		db.Progress = sancrawler.NewProgress()
+		progress = db.Progress
+
+		if opts.progress > 0 && !opts.tui {
+			progressCtx, stopProgress := context.WithCancel(ctx)
+			defer stopProgress()
+			go reportProgress(progressCtx, db.Progress, opts.progress)
+		}
+	}
+
+	// With -tui the progress log is replaced with something easier to follow,
+	// that stays up until the crawling is done.
+
+	var ui *tui
+
+	if opts.tui {
+		var err error
+		if ui, err = startTUI(progress, backendLimiter(crawler.Backend), len(queries)); err != nil {
+			log.Fatal(err)
+		}
+		found = ui.wrap(found)
+	}
+
+	var err error
+
+	if opts.recursive {
+		subdomains, err = crawler.CrawlRecursiveStream(ctx, queries, opts.depth, scope, found)
+	} else {
+		subdomains, err = crawler.CrawlAllStream(ctx, queries, found)
+	}
+
+	if opts.issuerPivot && err == nil {
+		var pivoted sancrawler.Results
+		pivoted, err = crawler.CrawlPrivateCAsStream(ctx, subdomains, scope, found)
+		subdomains.Merge(pivoted)
+	}
+
+	if ui != nil {
+		ui.close()
+	}
+
+	if checkpoint != nil {
+		if err := checkpoint.Save(); err != nil {
+			log.Warn("Could not save checkpoint: ", err)
+		}
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sancrawler.ErrStopped) {
+		log.WithFields(log.Fields{
+			"Reason": err,
+			"Found":  len(subdomains),
+		}).Warn("Crawl interrupted, keeping partial results")
+	} else if err != nil {
+		// Whatever turned up before the backend gave out is still worth having
+		failed = true
+		log.WithFields(log.Fields{
+			"Error": err,
+			"Found": len(subdomains),
+		}).Error("Crawl failed, keeping partial results")
+	}
+
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok && db.SharedCertificates() > 0 {
+		log.WithFields(log.Fields{
+			"Certificates": db.SharedCertificates(),
+		}).Info("Skipped reading certificates another seed already had")
+	}
+
+	if len(queries) > 1 {
+		logSeedMatches(queries, subdomains)
+	}
+
+	// Clean the names up before anything else looks at them, a lot of what ends
+	// up on certificates is messy.
+
+	before := len(subdomains)
+	subdomains = buildNormalizer(opts.normalize).Normalize(subdomains)
+
+	log.WithFields(log.Fields{
+		"Names":  len(subdomains),
+		"Merged": before - len(subdomains),
+	}).Info("Normalized names")
+
+	// Names we've only ever seen on precertificates may never have been deployed,
+	// worth knowing about.
+
+	precertOnly := 0
+	for _, res := range subdomains {
+		if res.Precert {
+			precertOnly++
+		}
+	}
+	if precertOnly > 0 {
+		log.WithFields(log.Fields{
+			"Names": precertOnly,
+		}).Info("Some names were only seen on precertificates")
+	}
+
+	logFindings(subdomains)
+
+	if opts.emails {
+		logEmails(subdomains)
+	}
+
+	// Lookalikes of the org's own domains are never in scope, so they have to be
+	// spotted before the scope filter gets rid of them.
+
+	if opts.homoglyphs {
+		flagHomoglyphs(opts, subdomains)
+	}
+
+	// Throw away anything out of scope before spending time on it
+
+	var dropped sancrawler.Results
+
+	if scope != nil {
+		before := len(subdomains)
+		kept := scope.Filter(subdomains)
+
+		if opts.targetsExclude != "" {
+			dropped = make(sancrawler.Results)
+			for name, res := range subdomains {
+				if _, ok := kept[name]; !ok {
+					dropped[name] = res
+				}
+			}
+		}
+		subdomains = kept
+
+		log.WithFields(log.Fields{
+			"Kept":    len(subdomains),
+			"Dropped": before - len(subdomains),
+		}).Info("Applied scope filter")
+	}
+
+	// Clean up the wildcards. Guessing names under them has to happen before they
+	// get stripped, otherwise we'd have no idea which names were wildcards.
+
+	subdomains = sancrawler.NormalizeWildcards(subdomains)
+
+	resolver := &sancrawler.Resolver{Concurrency: opts.resolveThreads}
+	if opts.resolvers != "" {
+		resolver.Servers = strings.Split(opts.resolvers, ",")
+	}
+
+	if opts.wordlist != "" && ctx.Err() == nil {
+		words, err := readLines(opts.wordlist)
+		if err != nil {
+			log.Fatal("Could not read wordlist: ", err)
+		}
+
+		log.WithFields(log.Fields{
+			"Words": len(words),
+		}).Info("Guessing names under wildcards")
+
+		guessed := sancrawler.ExpandWildcards(ctx, subdomains, words, resolver)
+		subdomains.Merge(guessed)
+
+		log.WithFields(log.Fields{
+			"Found": len(guessed),
+		}).Info("Finished guessing names under wildcards")
+	}
+
+	if opts.dnsExtra && ctx.Err() == nil {
+		enumerateDNS(ctx, resolver, scope, subdomains)
+	}
+
+	// Score names while we still know which were wildcards, keyword seeds
+	// especially turn up plenty that aren't the target's
+
+	subdomains = scoreNames(opts, queries, subdomains)
+
+	if opts.stripWildcards {
+		subdomains = sancrawler.StripWildcards(subdomains)
+	} else {
+		sancrawler.GroupWildcards(subdomains)
+	}
+
+	if opts.expiringWithin != "" {
+		subdomains = expiringNames(opts, subdomains)
+	}
+
+	// Resolve everything we found if asked to, this can take a while on big orgs
+	// so it gets its own log line.
+
+	if opts.resolve && ctx.Err() == nil {
+		log.WithFields(log.Fields{
+			"Names": len(subdomains),
+		}).Info("Resolving discovered names")
+
+		live := resolver.ResolveAll(ctx, subdomains)
+
+		log.WithFields(log.Fields{
+			"Live": live,
+			"Dead": len(subdomains) - live,
+		}).Info("Finished resolving names")
+	}
+
+	if opts.targetsExclude != "" && ctx.Err() == nil {
+		excludeTargets(ctx, opts.targetsExclude, resolver, dropped)
+	}
+
+	if containsString(opts.enrichments, "asn") && ctx.Err() == nil {
+		enrichASN(ctx, opts, subdomains)
+	}
+
+	if opts.probe && ctx.Err() == nil {
+		log.WithFields(log.Fields{
+			"Names": len(subdomains),
+		}).Info("Probing discovered names over HTTP")
+
+		prober := &sancrawler.Prober{Concurrency: opts.probeThreads, Proxy: buildProxy(opts)}
+		answered := prober.ProbeAll(ctx, subdomains)
+
+		log.WithFields(log.Fields{
+			"Answered": answered,
+		}).Info("Finished probing names")
+	}
+
+	if opts.takeoverCheck && ctx.Err() == nil {
+		checkTakeovers(ctx, opts, resolver, subdomains)
+	}
+
+	if opts.dnsAudit && ctx.Err() == nil {
+		auditDNS(ctx, resolver, subdomains)
+	}
+
+	// Cloud detection goes last since it can make use of the ASNs
+
+	if containsString(opts.enrichments, "cloud") {
+		subdomains = classifyCloud(opts, subdomains)
+	}
+
+	return subdomains, failed
+}
+
+/* enrichASN: tags every resolved address with the AS announcing it, using the
+ * -asn-db database when there is one and Team Cymru otherwise.
+ */
+func enrichASN(ctx context.Context, opts *options, subdomains sancrawler.Results) {
+	var lookup sancrawler.ASNLookup = &sancrawler.CymruASN{Proxy: buildProxy(opts)}
+
+	if opts.asnDB != "" {
+		db, err := sancrawler.OpenMaxMindASN(opts.asnDB)
+		if err != nil {
+			log.Fatal("Could not open ASN database: ", err)
+		}
+		defer db.Close()
+		lookup = db
+	}
+
+	addresses, err := sancrawler.EnrichASN(ctx, subdomains, lookup)
+	if err != nil {
+		log.Warn("Could not look up ASNs: ", err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Addresses": addresses,
+	}).Info("Finished looking up ASNs")
+}
+
+/* checkTakeovers: flags names that look like they can be taken over, logging
+ * each one since they're worth knowing about straight away.
+ */
+func checkTakeovers(ctx context.Context, opts *options, resolver *sancrawler.Resolver, subdomains sancrawler.Results) {
+	checker := &sancrawler.TakeoverChecker{Resolver: resolver}
+	if proxy := buildProxy(opts); proxy != nil {
+		checker.Client = &http.Client{Timeout: 10 * time.Second, Transport: proxy.Transport()}
+	}
+
+	if opts.takeoverFPs != "" {
+		fingerprints, err := sancrawler.LoadTakeoverFingerprints(opts.takeoverFPs)
+		if err != nil {
+			log.Fatal("Could not read takeover fingerprints: ", err)
+		}
+		checker.Fingerprints = fingerprints
+	}
+
+	candidates := checker.CheckAll(ctx, subdomains)
+
+	for _, res := range subdomains.Sorted() {
+		if res.Takeover == nil {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"Name":    res.Name,
+			"CNAME":   res.Takeover.CNAME,
+			"Service": res.Takeover.Service,
+			"Reason":  res.Takeover.Reason,
+		}).Warn("Possible subdomain takeover")
+	}
+
+	log.WithFields(log.Fields{
+		"Candidates": candidates,
+	}).Info("Finished checking for subdomain takeovers")
+}
+
+/* classifyCloud: tags resolved names with their cloud provider, then applies
+ * -cloud-only or -no-cloud.
+ */
+func classifyCloud(opts *options, subdomains sancrawler.Results) sancrawler.Results {
+	classifier := sancrawler.NewCloudClassifier()
+	if opts.cloudRanges != "" {
+		if err := classifier.LoadCloudRanges(opts.cloudRanges); err != nil {
+			log.Fatal("Could not read cloud ranges: ", err)
+		}
+	}
+
+	cloud := classifier.ClassifyAll(subdomains)
+
+	log.WithFields(log.Fields{
+		"Cloud": cloud,
+	}).Info("Finished classifying cloud hosted names")
+
+	if !opts.cloudOnly && !opts.noCloud {
+		return subdomains
+	}
+
+	kept := make(sancrawler.Results)
+	for name, res := range subdomains {
+		inCloud := res.DNS != nil && res.DNS.Cloud != ""
+		if inCloud == opts.cloudOnly {
+			kept[name] = res
+		}
+	}
+	return kept
+}
+
+/* saveToStore: adds the results of a run to the -sqlite database.
+ */
+func saveToStore(path string, started time.Time, subdomains sancrawler.Results) {
+	db, err := store.Open(path)
+	if err != nil {
+		log.Fatal("Could not open SQLite database: ", err)
+	}
+
+	runID, newNames, err := db.SaveRun(started, subdomains)
+	db.Close()
+	if err != nil {
+		log.Fatal("Could not save results to SQLite database: ", err)
+	}
+
+	log.WithFields(log.Fields{
+		"Database": path,
+		"Run":      runID,
+		"New":      newNames,
+	}).Info("Saved results to SQLite database")
+}
+
+/* saveToNeo4j: merges the results of a run into the -neo4j graph. Being a
+ * remote service it gets a warning rather than taking a -watch down with it.
+ */
+func saveToNeo4j(ctx context.Context, rawURL string, started time.Time, subdomains sancrawler.Results) {
+	client, err := neo4j.New(rawURL)
+	if err != nil {
+		log.Fatal("Bad -neo4j URL: ", err)
+	}
+
+	if err := client.Save(ctx, started, subdomains); err != nil {
+		log.Error("Could not save results to Neo4j: ", err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Endpoint": client.Endpoint,
+		"Names":    len(subdomains),
+	}).Info("Saved results to Neo4j")
+}
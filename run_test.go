@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-03-01T12:30:00Z", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"90d", now.Add(-90 * 24 * time.Hour)},
+		{"0d", now},
+		{"36h", now.Add(-36 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWhen(tt.value)
+		if err != nil {
+			t.Errorf("parseWhen(%q): %v", tt.value, err)
+			continue
+		}
+		// Relative ones are from whenever parseWhen ran
+		if d := got.Sub(tt.want); d < -time.Minute || d > time.Minute {
+			t.Errorf("parseWhen(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "yesterday", "-5d", "-1h", "2024-13-01", "d"} {
+		if _, err := parseWhen(bad); err == nil {
+			t.Errorf("parseWhen(%q) didn't fail", bad)
+		}
+	}
+}
@@ -1,460 +1,377 @@
 package main
 
 /* SANCrawler is a tool designed to enumerate linked x509 certificates based
- * on shared metadata. Traditional approaches to using x509 data focused on
- * linking based on shared apex domain, but in practice, many different fields
- * exist and are actively used by corporations. SANCrawler implements two such
- * approaches: strict organization search, and general keyword searches matching
- * on any field.
+ * on shared metadata. This is just the command line wrapper, all of the actual
+ * crawling lives in pkg/sancrawler so it can be embedded in other tools.
  */
 
 import (
-	"bufio"
-	"database/sql"
-	"flag"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 	"time"
 
-	// Lets hope this one works better than psycopg2
-	_ "github.com/lib/pq"
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/net/publicsuffix"
 )
 
-// Data format used by crawlers, tells them which CA they are working on and where the
-// bounds of their search are. start and stop usually only come into effect when the
-// company is large.
-type crawlerData struct {
-	caID  int
-	start int
-	stop  int
-}
-
-/* getNames: Retrieves the common names and subject alternative names (SANs)
- * from the postgres instance run by crt.sh, you can find details about their
- * complicated database schema here: https://github.com/crtsh/certwatch_db
+/* handleSignals: stops the limiter on the first SIGINT or SIGTERM and calls
+ * cancel on the next, or straight away if there's no limiter. One more after
+ * that and we die the usual way.
  */
-func getNames(query string, org string, inChan chan crawlerData, outChan chan string, stopChan chan bool) {
-	// https://blog.marin.qa/posts/2016/04/07/pgbouncer-problems-with-go/
-	connStr := "host=crt.sh user=guest dbname=certwatch binary_parameters=yes"
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatal(err)
-		panic(err)
-	}
-
-	for {
-		select {
-		case <-stopChan:
-			db.Close()
-			return
-		case tmpData := <-inChan:
-			// offset determines pagination of records from crt.sh.
-			// count is how many records we actually read each time.
-			for offset, count := tmpData.start, 0; ; offset += count {
-				count = 0
-
-				rows, err := db.Query(query, tmpData.caID, org, offset)
-				if err != nil {
-					log.Fatal(err)
-					panic(err)
-				}
-
-				// Scan through the records returned and keep track of the information we
-				// actually care about. We don't care about ID, but need it since doing an
-				// ORDER BY on strings is slow and we need an ORDER BY so we can use LIMIT
-				// and OFFSET. I also suck at SQL, so keep that in mind.
-				for rows.Next() {
-					var (
-						ID   int
-						name string
-					)
-
-					// Note: Some of these results may not be actual domains, recall these are
-					// just common names and SANs. They only have to be resolvable/accessible for
-					// whatever system is using them. This means you may find internal domain names
-					// as SANs that aren't fully qualified. You are very likely to encounter wildcard
-					// entires too.
-
-					if err := rows.Scan(&ID, &name); err != nil {
-						log.Fatal(err)
-					}
-
-					count++
-
-					// Make sure to lowercase to avoid duplicates based on mixed cases
-
-					outChan <- strings.ToLower(name)
-				}
-
-				// Bail out if we're done
-				if count == 0 {
-					break
-				}
-			}
-			break
-		default:
-			continue
+func handleSignals(cancel context.CancelFunc, limiter *sancrawler.Limiter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		if limiter != nil {
+			log.Warn("Stopping, letting the queries in flight finish. Interrupt again to give up on them")
+			limiter.Stop()
+			<-signals
 		}
-	}
+
+		log.Warn("Cancelling everything in flight")
+		cancel()
+		signal.Stop(signals)
+	}()
 }
 
-func loadCrawlerData(orgname string, sanChan chan crawlerData, cnChan chan crawlerData) int {
-	// We need to group all of the certificates by CA. Then we will partition those results
-	// into the blocks of crawler data that will get used by other functions.
+/* ayy */
+func printASCIIArt(major int, minor int) {
+	art := `
+  __________
+  \\        | SAN CRAWLER v%d.%d: Uncle Rico's Time Machine
+   \\       |    @cramppet
+    \\@@@@@@|   
+	`
+	fmt.Fprintf(os.Stderr, art+"\n", major, minor)
+}
 
-	numTotalCerts := 0
-	numCrawlers := 0
+// Exit codes, so scripts can tell how a run went without reading the logs.
+// log.Fatal takes care of exitFatal, exitPartial is only for backend failures.
+const (
+	exitOK        = 0
+	exitFatal     = 1
+	exitNoResults = 2
+	exitPartial   = 3
+)
 
-	query := `
-	SELECT ci.ISSUER_CA_ID, count(DISTINCT ci.CERTIFICATE_ID)
-	 FROM ca, certificate_identity ci
-	 WHERE ci.ISSUER_CA_ID = ca.ID AND
-				lower(ci.NAME_VALUE) = lower($1)
-	 GROUP BY ci.ISSUER_CA_ID;`
+func main() {
+	os.Exit(sancrawl())
+}
 
-	space := regexp.MustCompile(`\s+`)
-	query = strings.Replace(query, "\n", " ", -1)
-	query = space.ReplaceAllString(query, " ")
+/* sancrawl: everything main does, returning the exit code rather than exiting
+ * so the deferred cleanup still gets to run.
+ */
+func sancrawl() int {
+	start := time.Now()
 
-	// Make database connection
+	opts := parseFlags(os.Args[1:])
+	setupLogging(opts)
 
-	connStr := "host=crt.sh user=guest dbname=certwatch"
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatal(err)
-		panic(err)
-	}
+	// Commands without the output or notification flags leave them empty
 
-	// Pull the results
+	switch opts.format {
+	case "", "text", "json", "csv":
+	case "graphml", "dot", "xlsx", "stix", "misp", "nmap-targets", "burp", "zap", "subfinder", "amass":
+		if opts.watch {
+			log.Fatal("-format ", opts.format, " can't be used with -watch")
+		}
+	default:
+		log.Fatal("Unknown output format: ", opts.format)
+	}
+	streamable := map[string]bool{"text": true, "json": true, "csv": true, "subfinder": true, "amass": true}
+	if opts.stream && (opts.watch || opts.diffPath != "" || !streamable[opts.format]) {
+		log.Fatal("-stream only works with text, json, csv, subfinder or amass output and can't be used with -watch or -diff")
+	}
+	if opts.lowMemory && !opts.stream {
+		log.Fatal("-low-memory needs -stream")
+	}
+	if conflicts := lowMemoryConflicts(opts); len(conflicts) > 0 {
+		log.Fatal("-low-memory doesn't keep the names needed by ", strings.Join(conflicts, ", "))
+	}
+	switch opts.timelinePeriod {
+	case "", sancrawler.PeriodWeek, sancrawler.PeriodMonth, sancrawler.PeriodYear:
+	default:
+		log.Fatal("Unknown timeline period: ", opts.timelinePeriod)
+	}
+	switch opts.statsFormat {
+	case "", "table", "json", "csv":
+	default:
+		log.Fatal("Unknown statistics format: ", opts.statsFormat)
+	}
+	if opts.watch && opts.resume != "" {
+		log.Fatal("-resume can't be used with -watch")
+	}
+	if opts.notifyFormat != "" && opts.notifyFormat != "json" && opts.notifyFormat != "slack" {
+		log.Fatal("Unknown notification format: ", opts.notifyFormat)
+	}
+	if opts.targetsExclude != "" && opts.includeDomains == "" && opts.excludeDomains == "" {
+		log.Fatal("-targets-exclude needs -include-domains or -exclude-domains")
+	}
+	if opts.watch && (opts.maxResults > 0 || opts.maxRuntime > 0) {
+		log.Fatal("-max-results and -max-runtime can't be used with -watch")
+	}
+	if opts.minScore < 0 || opts.minScore > 100 {
+		log.Fatal("-min-score has to be between 0 and 100")
+	}
+	if opts.watch && opts.tui {
+		log.Fatal("-tui can't be used with -watch")
+	}
+	if opts.notifyURL != "" && !opts.watch && opts.diffPath == "" {
+		log.Fatal("-notify-url needs -watch or -diff")
+	}
 
-	rows, err := db.Query(query, orgname)
-	if err != nil {
-		log.Fatal(err)
-		panic(err)
+	// Every typosquat is a query of its own, hundreds of them all at once would
+	// get the crt.sh guest account throttled in no time.
+	if opts.brand != "" && opts.maxConns == 0 {
+		opts.maxConns = 8
 	}
 
-	for rows.Next() {
-		var (
-			caID     int
-			numCerts int
-		)
+	crawler, limiter := buildCrawler(opts)
 
-		if err := rows.Scan(&caID, &numCerts); err != nil {
-			log.Fatal(err)
-		}
+	// The first Ctrl-C stops new queries and lets the ones in flight finish, a
+	// second one or the timeout expiring cancels everything. Either way we still
+	// hang around long enough to write out whatever we found up to that point.
+	// Servers, tail and -watch just shut down on the first, and when watching the
+	// timeout applies to each round instead.
 
-		var tmpData crawlerData
-		tmpData.caID = caID
-		tmpData.start = 0
-		tmpData.stop = numCerts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		sanChan <- tmpData
-		cnChan <- tmpData
-		numTotalCerts += numCerts
+	if opts.command == "serve" || opts.command == "serve-maltego" || opts.command == "tail" || opts.watch {
+		handleSignals(cancel, nil)
+	} else {
+		handleSignals(cancel, limiter)
 	}
 
-	// How many crawlers will we need for this run? Note this will always
-	// be an even number since we have 1 crawler for each name type: SAN, CN.
-
-	if numTotalCerts < 10000 {
-		numCrawlers = 1
-	} else {
-		numCrawlers = (numTotalCerts / 10000)
+	runCtx := ctx
+	if opts.timeout > 0 && !opts.watch {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
 	}
 
-	db.Close()
-	return numCrawlers
-}
+	if showBanner(opts) {
+		printASCIIArt(2, 1)
+	}
 
-/* getDomainsByKeyword: Get all the names belonging to a certain organization.
- */
-func getDomainsByKeyword(orgname string) map[string]int {
-	ret := make(map[string]int)
-
-	// I have never liked SQL and these queries are probably shit, but they return
-	// results faster than any of the others I tried by *a lot* and I have no
-	// idea why.
-
-	// This is where this tool gets its name. The gorountines that read from the
-	// sanChan are called "SANCrawlers".
-
-	sanQuery := `
-	SELECT c.ID, x509_altNames(c.CERTIFICATE, 2, TRUE)
-	FROM certificate c WHERE c.ID IN (
-		SELECT DISTINCT ci.CERTIFICATE_ID
-		 FROM certificate_identity ci
-		 WHERE ci.ISSUER_CA_ID = $1 AND lower(ci.NAME_VALUE) = lower($2)
-	 )
-	ORDER BY c.ID DESC OFFSET $3 LIMIT 2000;
-	`
+	log.Info("SANCrawler running")
 
-	cnQuery := `
-	SELECT c.ID, x509_nameAttributes(c.CERTIFICATE, 'commonName', TRUE)
-	FROM certificate c WHERE c.ID IN (
-		SELECT DISTINCT ci.CERTIFICATE_ID
-		 FROM certificate_identity ci
-		 WHERE ci.ISSUER_CA_ID = $1 AND lower(ci.NAME_VALUE) = lower($2)
-	 )
-	ORDER BY c.ID DESC OFFSET $3 LIMIT 2000;
-	`
+	// Check if we are running in debug mode, enable CPU profiling now if we are
 
-	space := regexp.MustCompile(`\s+`)
-	sanQuery = strings.Replace(sanQuery, "\n", " ", -1)
-	sanQuery = space.ReplaceAllString(sanQuery, " ")
-	cnQuery = strings.Replace(cnQuery, "\n", " ", -1)
-	cnQuery = space.ReplaceAllString(cnQuery, " ")
-
-	// Channels for I/O between goroutines. Goroutines will read from either sanChan or
-	// cnChan and then put their discovered domains into domainChan. They will begin
-	// terminating when doneChan becomes populated.
-
-	sanChan := make(chan crawlerData, 10000)
-	cnChan := make(chan crawlerData, 10000)
-	domainChan := make(chan string, 10000)
-	numCrawlers := loadCrawlerData(orgname, sanChan, cnChan)
-	doneChan := make(chan bool, numCrawlers*2)
-
-	for i := 0; i < numCrawlers; i++ {
-		go getNames(sanQuery, orgname, sanChan, domainChan, doneChan)
-		go getNames(cnQuery, orgname, cnChan, domainChan, doneChan)
-	}
-
-	// Keep waiting until both input channels drain.
-	// Keep track of the values spewing out.
-
-	for len(sanChan) > 0 || len(cnChan) > 0 {
-		select {
-		case tmp := <-domainChan:
-			ret[tmp] = 0
-			break
-		default:
-			continue
+	if opts.debugMode {
+		cpuProfFile, err := os.Create("sancrawler2.cpu")
+		if err != nil {
+			log.Fatal("could not create CPU profile: ", err)
+		}
+		if err := pprof.StartCPUProfile(cpuProfFile); err != nil {
+			log.Fatal("could not start CPU profile: ", err)
 		}
+		defer pprof.StopCPUProfile()
 	}
 
-	// Allow for goroutines to start exiting
-
-	for i := 0; i < numCrawlers*2; i++ {
-		doneChan <- true
+	switch opts.command {
+	case "serve":
+		serveAPI(ctx, crawler, opts)
+		return exitOK
+	case "serve-maltego":
+		serveMaltego(ctx, crawler, opts)
+		return exitOK
 	}
 
-	// Read until both of the other channels finish draining
-
-	for len(doneChan) > 0 || len(domainChan) > 0 {
-		select {
-		case tmp := <-domainChan:
-			ret[tmp] = 0
-			break
-		default:
-			continue
-		}
+	if opts.command == "inspect" {
+		inspect(runCtx, opts)
+		return exitOK
 	}
 
-	return ret
-}
-
-/* tryExtractOrg: Attempts to automatically extract the organization field from
- * any x509 certificates detected from trying a TLS connection to the URL specified.
- */
-func tryExtractOrg(url string) string {
-	org := ""
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+	if opts.command == "tail" {
+		tail(runCtx, opts)
+		log.Info("SANCrawler shutting down")
+		return exitOK
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Fatal(err)
+	if opts.command == "domain" || opts.domain != "" {
+		reverseDomain(runCtx, crawler, opts)
+		return exitOK
 	}
 
-	// Akamai and other WAFs will block our requests if we aren't using a standard
-	// User-Agent string.
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/47.0.2526.111 Safari/537.36")
-
-	res, err := client.Do(req)
-	if err != nil {
-		log.Fatal("Could not connect to URL provided. Quitting.")
+	if opts.brand != "" {
+		brandMonitor(runCtx, crawler, opts)
+		return exitOK
 	}
 
-	if res.TLS != nil {
-		// 0th element is always the last certificate in the chain, which is the one that
-		// we want to examine.
-		cert := res.TLS.PeerCertificates[0]
-		orgs := cert.Subject.Organization
+	queries := buildQueries(runCtx, crawler, opts)
+	scope := buildScope(opts)
 
-		if len(orgs) < 1 {
-			log.Fatal("URL provided does not contain an organization. Quitting.")
-		} else {
-			// This may cause some bugs later on if there is more than 1 organization name
-			// within the certificate
-			org = orgs[0]
-		}
-	} else {
-		log.Fatal("URL provided does not use TLS. Quitting.")
+	if opts.estimate {
+		estimate(runCtx, crawler, opts, queries)
+		return exitOK
 	}
 
-	return org
-}
-
-/* printStatistics: prints statistics about which top level domains occur the most
- * frequently. Can be useful in helping to remove false positives, or gain insight
- * into subdomain distribution. Probably will add more useful stats later.
- */
-func printStatistics(subdomains *map[string]int) {
-	domains := make(map[string]int)
+	if opts.watch {
+		watch(ctx, crawler, opts, queries, scope)
+		log.Info("SANCrawler shutting down")
+		return exitOK
+	}
 
-	for k := range *subdomains {
-		d, err := publicsuffix.EffectiveTLDPlusOne(k)
+	// Plain names headed for stdout get written as they're found, so we can be
+	// piped into other tools without them waiting for the whole crawl.
 
+	var (
+		found  func(sancrawler.Result)
+		stream *nameStream
+	)
+	if opts.stream {
+		streamFile, err := openOutput(opts.outfile, false)
 		if err != nil {
-			log.Warn("printStatistics: Failed to parse subdomain name")
-		} else {
-			if _, ok := domains[d]; ok {
-				domains[d]++
-			} else {
-				domains[d] = 1
-			}
+			log.Fatal("Could not write output: ", err)
+		}
+		if streamFile != os.Stdout {
+			defer streamFile.Close()
 		}
+		stream = newNameStream(streamFile, opts, scope)
+		defer stream.close()
+		found = stream.found
+	} else if streamsToStdout(opts) && !opts.tui {
+		found = newNameStream(os.Stdout, opts, scope).found
 	}
 
-	for domain, occurances := range domains {
-		log.WithFields(log.Fields{
-			"Occurances": occurances,
-			"Domain":     domain,
-		}).Info(" . . . ")
+	// Organizations with millions of names don't fit in memory, so the names
+	// only go to the stream and get deduplicated on disk
+
+	var stopLowMemory func() int
+	if opts.lowMemory {
+		stopLowMemory = useLowMemory(crawler, opts, stream)
 	}
-}
 
-/* ayy */
-func printASCIIArt(major int, minor int) {
-	art := `
-  __________
-  \\        | SAN CRAWLER v%d.%d: Uncle Rico's Time Machine
-   \\       |    @cramppet
-    \\@@@@@@|   
-	`
-	fmt.Printf(art+"\n", major, minor)
-}
+	found, stopLimits := limitCrawl(opts, limiter, found)
+	subdomains, failed := crawl(runCtx, crawler, opts, queries, scope, found)
+	stopLimits()
 
-func main() {
-	var print = flag.Bool("p", false, "")
-	var debugMode = flag.Bool("d", false, "")
-	var keyword = flag.String("k", "", "")
-	var org = flag.String("s", "", "")
-	var outfile = flag.String("o", "", "")
-	var autoURL = flag.String("u", "", "")
-	var subdomains map[string]int
-
-	flag.Usage = func() {
-		out := flag.CommandLine.Output()
-		fmt.Fprintf(out, "SANCrawler: reverses x509 metadata using CT logs\n\n")
-		fmt.Fprintf(out, "Example: ./sancrawler -u https://example.com/ -o example.out\n\n")
-		fmt.Fprintf(out, "Discovery modes:\n")
-		fmt.Fprintf(out, "  -k  Keyword to match on.\n")
-		fmt.Fprintf(out, "  -u  URL; attempt auto-extraction of x509 Subject's Organization field.\n")
-		fmt.Fprintf(out, "Output:\n")
-		fmt.Fprintf(out, "  -o  Use this output file.\n")
-		fmt.Fprintf(out, "Auxiliary:\n")
-		fmt.Fprintf(out, "  -p  Print domain statistics (ie. subdomain distribution) to stdout.\n")
-		fmt.Fprintf(out, "Debugging:\n")
-		fmt.Fprintf(out, "  -d  Generate profiling files and debugging output\n")
+	// Nothing hangs on to the names with -low-memory, all there is to go on is
+	// how many were streamed
+	names := len(subdomains)
+	if stopLowMemory != nil {
+		names = stopLowMemory()
 	}
 
-	start := time.Now()
+	// Why not show this bad motherfucker off?
 
-	flag.Parse()
-	printASCIIArt(2, 1)
+	elapsed := time.Since(start)
 
-	log.Info("SANCrawler running")
+	// Are we only interested in what changed? This has to happen before the results
+	// get saved in case -diff and -sqlite are the same database.
 
-	// Check if we are running in debug mode, enable CPU profiling now if we are
+	var diff *sancrawler.Diff
 
-	if *debugMode {
-		cpuProfFile, err := os.Create("sancrawler2.cpu")
+	if opts.diffPath != "" {
+		previous, since, err := loadPrevious(opts.diffPath)
 		if err != nil {
-			log.Fatal("could not create CPU profile: ", err)
+			log.Fatal("Could not load previous results: ", err)
 		}
-		if err := pprof.StartCPUProfile(cpuProfFile); err != nil {
-			log.Fatal("could not start CPU profile: ", err)
-		}
-		defer pprof.StopCPUProfile()
-	}
 
-	// If we want to try the auto extraction, then we are implictly choosing to
-	// use the organization mode.
+		d := sancrawler.Compare(previous, subdomains, since, time.Now())
+		diff = &d
 
-	if *autoURL != "" {
 		log.WithFields(log.Fields{
-			"URL": *autoURL,
-		}).Info("Attempting auto-extraction from URL")
+			"New":      len(diff.New),
+			"Removed":  len(diff.Removed),
+			"Reissued": len(diff.Reissued),
+			"Expired":  len(diff.Expired),
+		}).Info("Compared against previous results")
+
+		if opts.notifyURL != "" && len(diff.New) > 0 {
+			if err := notify(opts, queries, diff.New); err != nil {
+				log.Error("Could not send notification: ", err)
+			}
+		}
+	}
+
+	// Do we want statistics? They get printed for people and written out as JSON
+	// for everything else, and go into the -report too.
 
-		*org = tryExtractOrg(*autoURL)
+	if opts.print || opts.statsPath != "" || opts.reportPath != "" {
+		var certs int64
+		if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok {
+			certs = db.Progress.Stats().Scanned
+		}
+		all := sancrawler.NewStatistics(subdomains, certs, elapsed)
+		stats := all.Top(opts.statsTop)
 
-		if *org != "" {
+		if opts.print {
+			format := opts.statsFormat
+			if format == "" {
+				format = "table"
+			}
+			if err := writeStatistics(os.Stderr, stats, format); err != nil {
+				log.Fatal("Could not print statistics: ", err)
+			}
+		}
+		if opts.statsPath != "" {
+			format := opts.statsFormat
+			if format == "" {
+				format = "json"
+			}
+			if err := saveStatistics(opts.statsPath, stats, format); err != nil {
+				log.Fatal("Could not write statistics: ", err)
+			}
+		}
+		if opts.reportPath != "" {
+			if err := writeReport(opts.reportPath, newReport(opts, queries, subdomains, all, diff)); err != nil {
+				log.Fatal("Could not write report: ", err)
+			}
 			log.WithFields(log.Fields{
-				"Organization": *org,
-			}).Info("Using extracted organization as seed")
+				"Report": opts.reportPath,
+			}).Info("Wrote report")
 		}
 	}
 
-	// Switch between the different possible modes, first one we see is the one
-	// we end up doing. Passing multiple modes doesn't make a lot of sense, unless
-	// we want to combine results or something.
+	// Carving the names up by when they turned up and the certificates they
+	// share makes a big flat list a lot easier to get through.
 
-	if *keyword != "" {
-		subdomains = getDomainsByKeyword(*keyword)
-	} else if *org != "" {
-		subdomains = getDomainsByKeyword(*org)
+	if opts.timelinePath != "" {
+		if err := writeTimeline(opts.timelinePath, subdomains, opts.timelinePeriod); err != nil {
+			log.Fatal("Could not write timeline: ", err)
+		}
 	}
 
-	// Why not show this bad motherfucker off?
-
-	elapsed := time.Since(start)
-
-	// Do we want to print the stats to standard out?
-
-	if *print {
-		log.Info("Printing domains statistics ...")
-		printStatistics(&subdomains)
+	if opts.clustersPath != "" {
+		if err := writeClusters(opts.clustersPath, subdomains, opts.clusterMax); err != nil {
+			log.Fatal("Could not write clusters: ", err)
+		}
 	}
 
-	// Do we want to write to an output file?
+	// Do we want to write to an output file? Structured output and diffs without an
+	// output file go to stdout so they can be piped straight into other tools, as
+	// do plain names that -tui held back. With -stream it's all been written out
+	// already.
 
-	if *outfile != "" {
-		log.WithFields(log.Fields{
-			"Outfile": *outfile,
-		}).Info("Writing results to output file")
-
-		fHandle, err := os.Create(*outfile)
-
-		if err != nil {
-			panic(err)
+	if !opts.stream && (opts.outfile != "" || opts.format != "text" || diff != nil || opts.tui) {
+		if err := writeOutput(opts, subdomains, diff); err != nil {
+			log.Fatal("Could not write output: ", err)
 		}
+	}
 
-		bufWriter := bufio.NewWriter(fHandle)
-		newLine := []byte("\n")
-		defer fHandle.Close()
+	// Do we want to keep the results in a database as well?
 
-		for k := range subdomains {
-			bufWriter.WriteString(k)
-			bufWriter.Write(newLine)
-		}
+	if opts.sqlitePath != "" {
+		saveToStore(opts.sqlitePath, start, subdomains)
+	}
 
-		bufWriter.Flush()
+	if opts.neo4jURL != "" {
+		saveToNeo4j(ctx, opts.neo4jURL, start, subdomains)
 	}
 
 	// Before we finish, check if we need to output memory usage stats for debug mode
 
-	if *debugMode {
+	if opts.debugMode {
 		memProfFile, err := os.Create("sancrawler2.mem")
 		if err != nil {
 			log.Fatal("Could not create memory profile: ", err)
@@ -466,7 +383,26 @@ func main() {
 		memProfFile.Close()
 	}
 
+	// A backend failing means names may be missing that a rerun would find, which
+	// matters more to whoever's running us than whether there were any. Being
+	// interrupted, timed out or capped was asked for, so what's left is all there
+	// was going to be.
+
+	interrupted := runCtx.Err() != nil || limiter.Stopped()
+
 	log.WithFields(log.Fields{
-		"Runtime": elapsed,
+		"Names":       names,
+		"Runtime":     elapsed,
+		"Interrupted": interrupted,
+		"Failed":      failed,
+		"Failures":    crawler.Failures(),
 	}).Info("SANCrawler shutting down")
+
+	switch {
+	case failed || crawler.Failures() > 0:
+		return exitPartial
+	case names == 0:
+		return exitNoResults
+	}
+	return exitOK
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// How many -uf hosts get connected to at once
+const seedFetchWorkers = 8
+
+// The certificate chain a -u or -uf host presented
+type seedChain struct {
+	url   string
+	chain []*x509.Certificate
+}
+
+// seedList is a flag that can be passed more than once. Values are deliberately
+// not split on commas since plenty of organization names have one in them
+// ("Acme, Inc.").
+type seedList []string
+
+func (s *seedList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *seedList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+/* listOrFile: a flag value that is either a comma separated list or the path of
+ * a file with one entry per line.
+ */
+func listOrFile(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		return readLines(value)
+	}
+
+	var ret []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret, nil
+}
+
+/* readLines: reads one entry (seed, word, ...) per line from path, or stdin if
+ * path is -. Blank lines and lines starting with # are skipped.
+ */
+func readLines(path string) ([]string, error) {
+	var seeds []string
+
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
+	}
+
+	return seeds, scanner.Err()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+/* fetchSeedChains: fetches the certificate chains of -u and every host in -uf,
+ * in the order given. A -u that can't be fetched is fatal, -uf hosts that can't
+ * be are just warned about, since out of a long list some are bound to be down.
+ */
+func fetchSeedChains(ctx context.Context, opts *options) []seedChain {
+	var urls []string
+	if opts.autoURL != "" {
+		urls = append(urls, opts.autoURL)
+	}
+	if opts.urlFile != "" {
+		lines, err := readLines(opts.urlFile)
+		if err != nil {
+			log.Fatal("Could not read -uf: ", err)
+		}
+		for _, line := range lines {
+			if !containsString(urls, line) {
+				urls = append(urls, line)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"URLs": len(urls),
+	}).Info("Attempting auto-extraction from URL")
+
+	chains := make([][]*x509.Certificate, len(urls))
+	errs := make([]error, len(urls))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, seedFetchWorkers)
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			chains[i], errs[i] = sancrawler.FetchChain(ctx, u, fetchOptions(opts))
+		}(i, u)
+	}
+	wg.Wait()
+
+	var ret []seedChain
+	for i, u := range urls {
+		if errs[i] != nil {
+			if u == opts.autoURL {
+				log.Fatal(errs[i], ". Quitting.")
+			}
+			log.WithFields(log.Fields{
+				"URL":   u,
+				"Error": errs[i],
+			}).Warn("Could not fetch certificate, skipping")
+			continue
+		}
+		ret = append(ret, seedChain{url: u, chain: chains[i]})
+	}
+
+	if len(ret) == 0 {
+		log.Fatal("Could not fetch a certificate from any of the -uf hosts. Quitting.")
+	}
+	return ret
+}
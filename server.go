@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// What POST /crawl takes, the same seeds as the command line. Jobs with a
+// higher priority jump the queue.
+type crawlRequest struct {
+	Keywords      []string `json:"keywords,omitempty"`
+	Organizations []string `json:"organizations,omitempty"`
+	Field         string   `json:"field,omitempty"`
+	Values        []string `json:"values,omitempty"`
+	Priority      int      `json:"priority,omitempty"`
+}
+
+/* queries: the request as queries to crawl.
+ */
+func (req crawlRequest) queries() ([]sancrawler.Query, error) {
+	var queries []sancrawler.Query
+
+	for _, k := range req.Keywords {
+		queries = append(queries, sancrawler.Query{Value: k})
+	}
+	for _, o := range req.Organizations {
+		queries = append(queries, sancrawler.Query{Value: o, NameType: sancrawler.NameTypeOrganization})
+	}
+
+	if len(req.Values) > 0 {
+		nameType, err := sancrawler.NameTypeForField(req.Field)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range req.Values {
+			queries = append(queries, sancrawler.Query{Value: v, NameType: nameType})
+		}
+	}
+
+	if len(queries) == 0 {
+		return nil, errors.New("nothing to crawl")
+	}
+	return queries, nil
+}
+
+// The states a job goes through
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// A crawl submitted to the server. Everything but results is what GET
+// /jobs/{id} hands back.
+type job struct {
+	ID       string       `json:"id"`
+	Status   string       `json:"status"`
+	Request  crawlRequest `json:"request"`
+	Created  time.Time    `json:"created"`
+	Started  time.Time    `json:"started,omitzero"`
+	Finished time.Time    `json:"finished,omitzero"`
+	Names    int          `json:"names"`
+	Error    string       `json:"error,omitempty"`
+	// The API key's name, with -api-keys only its owner can see the job
+	Owner string `json:"owner,omitempty"`
+
+	queries []sancrawler.Query
+	results sancrawler.Results
+	// Stops the crawl while it's running, cancelled says it was asked to
+	cancel    context.CancelFunc
+	cancelled bool
+	tenant    *tenant
+}
+
+/* finished: whether the job has stopped for good.
+ */
+func (j *job) finished() bool {
+	return j.Status == jobDone || j.Status == jobFailed || j.Status == jobCancelled
+}
+
+// jobServer runs crawls for API clients. Everyone shares the one crawler, so
+// -max-connections and -qps apply to the server as a whole.
+type jobServer struct {
+	crawler   *sancrawler.Crawler
+	metrics   *sancrawler.Metrics
+	store     *jobStore
+	tenants   *tenants
+	timeout   time.Duration
+	queueSize int
+	// How many finished jobs to hang on to, the oldest go first
+	keepJobs int
+	// Each running crawl holds a slot, there are -workers of them
+	slots chan struct{}
+	// Nudges idle workers when a job gets queued
+	wake chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	// Jobs waiting for a worker, highest priority and then oldest first
+	pending []*job
+}
+
+/* serveAPI: the serve subcommand. Runs the REST API on opts.listen until ctx is
+ * cancelled, or it's been idle for opts.exitAfterIdle, with opts.workers crawls
+ * running at once and up to opts.queueSize waiting for a turn.
+ */
+func serveAPI(ctx context.Context, crawler *sancrawler.Crawler, opts *options) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &jobServer{
+		crawler:   crawler,
+		metrics:   useMetrics(crawler),
+		timeout:   opts.timeout,
+		queueSize: opts.queueSize,
+		keepJobs:  opts.keepJobs,
+		slots:     make(chan struct{}, opts.workers),
+		wake:      make(chan struct{}, opts.workers),
+		jobs:      make(map[string]*job),
+	}
+
+	if opts.apiKeys != "" {
+		var err error
+		if s.tenants, err = loadTenants(opts.apiKeys); err != nil {
+			log.Fatal("Could not load API keys: ", err)
+		}
+	}
+
+	if opts.jobsDB != "" {
+		store, err := openJobStore(opts.jobsDB)
+		if err != nil {
+			log.Fatal("Could not open job store: ", err)
+		}
+		defer store.close()
+		s.store = store
+
+		if err := s.restore(); err != nil {
+			log.Fatal("Could not load jobs: ", err)
+		}
+	}
+
+	if opts.grpcListen != "" {
+		go serveGRPC(ctx, crawler, opts, s.slots, s.tenants)
+	}
+
+	// Running jobs have to get saved before the store closes
+	var workers sync.WaitGroup
+	defer workers.Wait()
+
+	for i := 0; i < opts.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.worker(ctx)
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /crawl", s.tenants.require(s.handleCrawl))
+	mux.HandleFunc("GET /jobs", s.tenants.require(s.handleJobs))
+	mux.HandleFunc("GET /jobs/{id}", s.tenants.require(s.handleJob))
+	mux.HandleFunc("DELETE /jobs/{id}", s.tenants.require(s.handleCancel))
+	mux.HandleFunc("GET /jobs/{id}/results", s.tenants.require(s.handleResults))
+	mux.HandleFunc("GET /metrics", s.tenants.require(s.handleMetrics))
+	mux.Handle("GET /", dashboard())
+	handleHealth(mux, func() error {
+		if ctx.Err() != nil {
+			return errors.New("shutting down")
+		}
+		return s.ready()
+	})
+
+	if opts.exitAfterIdle > 0 {
+		go s.exitWhenIdle(ctx, opts.exitAfterIdle, cancel)
+	}
+
+	// Requests in flight get to finish before the store closes too
+	server := &http.Server{Addr: opts.listen, Handler: mux}
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnDone(ctx, server)
+		close(stopped)
+	}()
+
+	log.WithFields(log.Fields{
+		"Listen":  opts.listen,
+		"Workers": opts.workers,
+	}).Info("Serving REST API")
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal("REST API server failed: ", err)
+	}
+	<-stopped
+	log.Info("REST API stopped")
+}
+
+/* ready: whether the server should be sent more crawls, which it shouldn't
+ * while its queue is full.
+ */
+func (s *jobServer) ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) >= s.queueSize {
+		return errors.New("job queue is full")
+	}
+	return nil
+}
+
+/* busy: whether any crawl is queued or running, REST or gRPC.
+ */
+func (s *jobServer) busy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) > 0 || len(s.slots) > 0
+}
+
+/* exitWhenIdle: calls stop once nothing has been queued or running for idle,
+ * so a server started for a batch of crawls goes away when they're done. The
+ * clock starts when the server does.
+ */
+func (s *jobServer) exitWhenIdle(ctx context.Context, idle time.Duration, stop context.CancelFunc) {
+	tick := idle / 10
+	if tick > 5*time.Second {
+		tick = 5 * time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	lastBusy := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if s.busy() {
+				lastBusy = now
+			} else if now.Sub(lastBusy) >= idle {
+				log.WithFields(log.Fields{
+					"Idle": idle,
+				}).Info("Nothing to do, shutting down")
+				stop()
+				return
+			}
+		}
+	}
+}
+
+/* restore: picks up the jobs from the store. Whatever was queued or running
+ * when the server stopped goes back in the queue, running ones start over.
+ */
+func (s *jobServer) restore() error {
+	jobs, err := s.store.load()
+	if err != nil {
+		return err
+	}
+
+	// Oldest first, so they go back in the queue in the order they arrived
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].Created.Before(jobs[b].Created)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requeued := 0
+	for _, j := range jobs {
+		s.jobs[j.ID] = j
+		if j.finished() {
+			continue
+		}
+
+		j.tenant = s.tenants.byName(j.Owner)
+		if s.tenants != nil && j.tenant == nil {
+			j.Status, j.Error, j.Finished = jobFailed, "its API key is gone", time.Now()
+			s.persist(j)
+			continue
+		}
+
+		if j.queries, err = j.Request.queries(); err != nil {
+			j.Status, j.Error, j.Finished = jobFailed, err.Error(), time.Now()
+			s.persist(j)
+			continue
+		}
+		j.Status, j.Started, j.Names = jobQueued, time.Time{}, 0
+		s.push(j)
+		requeued++
+	}
+
+	s.prune()
+
+	log.WithFields(log.Fields{
+		"Jobs":     len(jobs),
+		"Requeued": requeued,
+	}).Info("Loaded jobs")
+	return nil
+}
+
+/* prune: forgets the oldest finished jobs, results and all, once there are
+ * more than s.keepJobs of them. s.mu has to be held.
+ */
+func (s *jobServer) prune() {
+	if s.keepJobs <= 0 {
+		return
+	}
+
+	var finished []*job
+	for _, j := range s.jobs {
+		if j.finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= s.keepJobs {
+		return
+	}
+
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].Finished.Before(finished[b].Finished)
+	})
+	for _, j := range finished[:len(finished)-s.keepJobs] {
+		delete(s.jobs, j.ID)
+		if err := s.store.remove(j.ID); err != nil {
+			log.WithFields(log.Fields{
+				"Job":   j.ID,
+				"Error": err,
+			}).Warn("Could not remove old job")
+		}
+	}
+}
+
+/* push: queues a job behind everything with the same or a higher priority,
+ * s.mu has to be held.
+ */
+func (s *jobServer) push(j *job) {
+	i := sort.Search(len(s.pending), func(i int) bool {
+		return s.pending[i].Request.Priority < j.Request.Priority
+	})
+	s.pending = append(s.pending, nil)
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = j
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+/* next: takes the next job off the queue and marks it running, nil if there
+ * isn't one. Jobs whose API key is already running all it's allowed get
+ * skipped over. It's running as soon as it's off the queue so that cancelling
+ * it from then on goes through run.
+ */
+func (s *jobServer) next() *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, j := range s.pending {
+		if s.tenants.tryStart(j.tenant) == nil {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			j.Status, j.Started = jobRunning, time.Now()
+			s.persist(j)
+			return j
+		}
+	}
+	return nil
+}
+
+/* persist: saves the job to the store if there is one, s.mu has to be held.
+ */
+func (s *jobServer) persist(j *job) {
+	if err := s.store.save(j); err != nil {
+		log.WithFields(log.Fields{
+			"Job":   j.ID,
+			"Error": err,
+		}).Warn("Could not save job")
+	}
+}
+
+/* worker: runs queued jobs one at a time until ctx is cancelled. The slot
+ * comes first so that the job picked is the best one waiting when a slot
+ * frees up.
+ */
+func (s *jobServer) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s.slots <- struct{}{}:
+		}
+
+		j := s.next()
+		if j == nil {
+			<-s.slots
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			continue
+		}
+
+		s.run(ctx, j)
+		<-s.slots
+	}
+}
+
+/* run: crawls a job and records how it went.
+ */
+func (s *jobServer) run(ctx context.Context, j *job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Jobs held back by this key's limit may be able to go now
+	defer func() {
+		s.tenants.finish(j.tenant)
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	// It may have been cancelled between coming off the queue and getting here
+	s.mu.Lock()
+	if j.cancelled {
+		j.Status, j.Finished = jobCancelled, time.Now()
+		s.persist(j)
+		s.mu.Unlock()
+		return
+	}
+	j.cancel = cancel
+	s.mu.Unlock()
+
+	jobCtx = j.tenant.crawlContext(jobCtx)
+
+	log.WithFields(log.Fields{
+		"Job":   j.ID,
+		"Seeds": len(j.queries),
+	}).Info("Starting job")
+
+	if s.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		jobCtx, cancelTimeout = context.WithTimeout(jobCtx, s.timeout)
+		defer cancelTimeout()
+	}
+
+	// Names gets bumped as they come in, so pollers can watch it climb
+	results, err := s.crawler.CrawlAllStream(jobCtx, j.queries, func(sancrawler.Result) {
+		s.mu.Lock()
+		j.Names++
+		s.mu.Unlock()
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.cancel = nil
+
+	// The server shutting down isn't the job's fault, with a store it gets
+	// crawled again from the start next time
+	if ctx.Err() != nil && !j.cancelled && s.store != nil {
+		j.Status, j.Started, j.Names = jobQueued, time.Time{}, 0
+		s.persist(j)
+		return
+	}
+
+	j.Finished, j.results, j.Names = time.Now(), results, len(results)
+	switch {
+	case j.cancelled:
+		j.Status = jobCancelled
+	case err != nil:
+		j.Status, j.Error = jobFailed, err.Error()
+	default:
+		j.Status = jobDone
+	}
+
+	// Once they're in the store there's no need to keep the results in memory
+	// too, handleResults reads them back from there
+	if err := s.store.saveResults(j.ID, results); err != nil {
+		log.WithFields(log.Fields{
+			"Job":   j.ID,
+			"Error": err,
+		}).Warn("Could not save job results")
+	} else if s.store != nil {
+		j.results = nil
+	}
+	s.persist(j)
+	s.prune()
+
+	log.WithFields(log.Fields{
+		"Job":    j.ID,
+		"Status": j.Status,
+		"Names":  j.Names,
+	}).Info("Finished job")
+}
+
+/* handleCrawl: POST /crawl queues a new job, answering 202 with the job so the
+ * client knows where to poll.
+ */
+func (s *jobServer) handleCrawl(w http.ResponseWriter, r *http.Request) {
+	var req crawlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad request: "+err.Error())
+		return
+	}
+
+	queries, err := req.queries()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t := tenantFrom(r.Context())
+	j := &job{ID: newJobID(), Status: jobQueued, Request: req, Created: time.Now(), Owner: t.owner(), queries: queries, tenant: t}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) >= s.queueSize {
+		writeAPIError(w, http.StatusServiceUnavailable, "job queue is full, try again later")
+		return
+	}
+	if t != nil && t.MaxQueued > 0 {
+		queued := 0
+		for _, pending := range s.pending {
+			if pending.tenant == t {
+				queued++
+			}
+		}
+		if queued >= t.MaxQueued {
+			writeAPIError(w, http.StatusTooManyRequests, "too many jobs queued for this API key, try again later")
+			return
+		}
+	}
+	s.jobs[j.ID] = j
+	s.persist(j)
+	s.push(j)
+
+	writeAPIJSON(w, http.StatusAccepted, j)
+}
+
+/* handleJobs: GET /jobs lists every job the server knows about, newest first.
+ * status=... only lists the jobs in that state.
+ */
+func (s *jobServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := r.URL.Query().Get("status")
+	t := tenantFrom(r.Context())
+
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		if t.owns(j) && (status == "" || j.Status == status) {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].Created.After(jobs[b].Created)
+	})
+
+	writeAPIJSON(w, http.StatusOK, jobs)
+}
+
+/* handleJob: GET /jobs/{id} reports how a job is getting on.
+ */
+func (s *jobServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[r.PathValue("id")]
+	if !ok || !tenantFrom(r.Context()).owns(j) {
+		writeAPIError(w, http.StatusNotFound, "no such job")
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, j)
+}
+
+/* handleCancel: DELETE /jobs/{id} cancels a job. A queued one comes off the
+ * queue, a running one is stopped and keeps what it found so far.
+ */
+func (s *jobServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[r.PathValue("id")]
+	if !ok || !tenantFrom(r.Context()).owns(j) {
+		writeAPIError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	if j.finished() {
+		writeAPIError(w, http.StatusConflict, "job is already "+j.Status)
+		return
+	}
+
+	j.cancelled = true
+	if j.Status == jobQueued {
+		for i, queued := range s.pending {
+			if queued == j {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+		j.Status, j.Finished = jobCancelled, time.Now()
+		s.persist(j)
+	} else if j.cancel != nil {
+		j.cancel()
+	}
+
+	log.WithFields(log.Fields{
+		"Job": j.ID,
+	}).Info("Cancelled job")
+
+	writeAPIJSON(w, http.StatusOK, j)
+}
+
+/* handleResults: GET /jobs/{id}/results hands back the names a finished job
+ * found, as JSON (the default), CSV with format=csv or one per line with
+ * format=txt. Failed and cancelled jobs still have whatever they found before
+ * they stopped. Jobs from before a restart get their results from the store.
+ */
+func (s *jobServer) handleResults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	ok = ok && tenantFrom(r.Context()).owns(j)
+	var (
+		status   string
+		finished bool
+		results  sancrawler.Results
+	)
+	if ok {
+		status, finished, results = j.Status, j.finished(), j.results
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "no such job")
+		return
+	}
+	if !finished {
+		writeAPIError(w, http.StatusConflict, "job is still "+status)
+		return
+	}
+
+	if results == nil {
+		var err error
+		if results, err = s.store.results(j.ID); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load results: "+err.Error())
+			return
+		}
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeAPIJSON(w, http.StatusOK, results.Sorted())
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+r.PathValue("id")+`.csv"`)
+		out := bufio.NewWriter(w)
+		writeCSV(out, results)
+		out.Flush()
+	case "txt":
+		w.Header().Set("Content-Type", "text/plain")
+		for _, res := range results.Sorted() {
+			w.Write([]byte(res.Name + "\n"))
+		}
+	default:
+		writeAPIError(w, http.StatusBadRequest, "format must be json, csv or txt")
+	}
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]string{"error": message})
+}
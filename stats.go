@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+/* writeStatistics: writes the statistics in the format asked for, table, json
+ * or csv.
+ */
+func writeStatistics(w io.Writer, stats sancrawler.Statistics, format string) error {
+	switch format {
+	case "table":
+		return writeStatsTable(w, stats)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case "csv":
+		return writeStatsCSV(w, stats)
+	default:
+		return errors.New("unknown statistics format: " + format)
+	}
+}
+
+/* writeStatsTable: the statistics in a form meant for people. Which domains
+ * occur the most can be useful in helping to remove false positives, or gain
+ * insight into subdomain distribution.
+ */
+func writeStatsTable(w io.Writer, stats sancrawler.Statistics) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if stats.Certificates > 0 {
+		fmt.Fprintf(tw, "Certificates scanned\t%d\n", stats.Certificates)
+	}
+	fmt.Fprintf(tw, "Unique names\t%d\n", stats.Names)
+	fmt.Fprintf(tw, "Wildcards\t%d\n", stats.Wildcards)
+	fmt.Fprintf(tw, "IP addresses\t%d\n", stats.IPs)
+	fmt.Fprintf(tw, "Valid / expired\t%d / %d\n", stats.Valid, stats.Expired)
+	fmt.Fprintf(tw, "Runtime\t%s\n", time.Duration(stats.Runtime*float64(time.Second)).Round(time.Millisecond))
+	if stats.Certificates > 0 {
+		fmt.Fprintf(tw, "Throughput\t%.1f certificates/s, %.1f names/s\n", stats.CertsPerSecond, stats.NamesPerSecond)
+	} else {
+		fmt.Fprintf(tw, "Throughput\t%.1f names/s\n", stats.NamesPerSecond)
+	}
+
+	fmt.Fprintf(tw, "\nDomain\tNames\n")
+	printCounts(tw, stats.Domains, stats.ApexDomains)
+	if stats.Unparsed > 0 {
+		fmt.Fprintf(tw, "(unparsed)\t%d\n", stats.Unparsed)
+	}
+
+	fmt.Fprintf(tw, "\nIssuer\tNames\n")
+	printCounts(tw, stats.Issuers, stats.IssuerCount)
+
+	return tw.Flush()
+}
+
+/* printCounts: one line per count, saying how many got left out by -stats-top
+ * at the end.
+ */
+func printCounts(w io.Writer, counts []sancrawler.Count, total int) {
+	for _, c := range counts {
+		fmt.Fprintf(w, "%s\t%d\n", c.Name, c.Count)
+	}
+	if total > len(counts) {
+		fmt.Fprintf(w, "(%d more)\t\n", total-len(counts))
+	}
+}
+
+/* writeStatsCSV: one row per figure, with the section it belongs to so the
+ * domains and issuers can be pulled out on their own.
+ */
+func writeStatsCSV(w io.Writer, stats sancrawler.Statistics) error {
+	bufWriter := bufio.NewWriter(w)
+	out := csv.NewWriter(bufWriter)
+	out.Write([]string{"section", "name", "value"})
+
+	summary := []struct {
+		name  string
+		value string
+	}{
+		{"certificates_scanned", strconv.FormatInt(stats.Certificates, 10)},
+		{"unique_names", strconv.Itoa(stats.Names)},
+		{"wildcards", strconv.Itoa(stats.Wildcards)},
+		{"ip_sans", strconv.Itoa(stats.IPs)},
+		{"valid", strconv.Itoa(stats.Valid)},
+		{"expired", strconv.Itoa(stats.Expired)},
+		{"apex_domains", strconv.Itoa(stats.ApexDomains)},
+		{"unparsed", strconv.Itoa(stats.Unparsed)},
+		{"issuer_count", strconv.Itoa(stats.IssuerCount)},
+		{"runtime_seconds", strconv.FormatFloat(stats.Runtime, 'f', 3, 64)},
+		{"certificates_per_second", strconv.FormatFloat(stats.CertsPerSecond, 'f', 1, 64)},
+		{"names_per_second", strconv.FormatFloat(stats.NamesPerSecond, 'f', 1, 64)},
+	}
+	for _, s := range summary {
+		out.Write([]string{"summary", s.name, s.value})
+	}
+
+	for _, c := range stats.Domains {
+		out.Write([]string{"domain", c.Name, strconv.Itoa(c.Count)})
+	}
+	for _, c := range stats.Issuers {
+		out.Write([]string{"issuer", c.Name, strconv.Itoa(c.Count)})
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+/* saveStatistics: writes the statistics to path.
+ */
+func saveStatistics(path string, stats sancrawler.Statistics, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := writeStatistics(f, stats, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
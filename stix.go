@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+// The namespace STIX 2.1 derives the IDs of cyber observables from, so the same
+// domain always gets the same ID no matter who exports it
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// A STIX 2.1 bundle of cyber observables
+type stixBundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// domain-name, ipv4-addr, ipv6-addr and email-addr objects
+type stixAddress struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+type stixCertificate struct {
+	Type              string              `json:"type"`
+	SpecVersion       string              `json:"spec_version"`
+	ID                string              `json:"id"`
+	Issuer            string              `json:"issuer,omitempty"`
+	ValidityNotBefore string              `json:"validity_not_before,omitempty"`
+	ValidityNotAfter  string              `json:"validity_not_after,omitempty"`
+	Extensions        *stixX509Extensions `json:"x509_v3_extensions,omitempty"`
+	CrtshID           int                 `json:"x_crtsh_id,omitempty"`
+}
+
+type stixX509Extensions struct {
+	SubjectAlternativeName string `json:"subject_alternative_name,omitempty"`
+}
+
+/* stixID: the ID for an observable. Observables with something to identify
+ * them by get a UUIDv5 of it, the rest a random UUIDv4, as the spec says.
+ */
+func stixID(kind string, contributing map[string]interface{}) string {
+	if contributing == nil {
+		var u [16]byte
+		rand.Read(u[:])
+		u[6] = (u[6] & 0x0f) | 0x40
+		u[8] = (u[8] & 0x3f) | 0x80
+		return kind + "--" + formatUUID(u)
+	}
+
+	// encoding/json sorts map keys and with HTML escaping off is the same as
+	// the JSON canonicalization the spec asks for, for the strings we have
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(contributing)
+
+	h := sha1.New()
+	h.Write(stixNamespace[:])
+	h.Write(bytes.TrimSpace(buf.Bytes()))
+
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return kind + "--" + formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+/* indicatorValue: what a result is as an indicator, and which kind. Wildcards
+ * lose the *. since neither STIX nor MISP take them, and URIs aren't included.
+ */
+func indicatorValue(res sancrawler.Result) (string, string) {
+	switch res.Type {
+	case "":
+		return "domain", strings.TrimPrefix(res.Name, "*.")
+	case sancrawler.TypeIP:
+		if ip := net.ParseIP(res.Name); ip != nil && ip.To4() == nil {
+			return "ipv6", res.Name
+		}
+		return "ipv4", res.Name
+	case sancrawler.TypeEmail:
+		return "email", res.Name
+	}
+	return "", ""
+}
+
+/* certificateNames: the names found on each crt.sh certificate, with the
+ * result to take the certificate's details from.
+ */
+func certificateNames(results []sancrawler.Result) ([]int, map[int][]sancrawler.Result) {
+	certs := make(map[int][]sancrawler.Result)
+	var ids []int
+	for _, res := range results {
+		if res.CertificateID == 0 {
+			continue
+		}
+		if _, ok := certs[res.CertificateID]; !ok {
+			ids = append(ids, res.CertificateID)
+		}
+		certs[res.CertificateID] = append(certs[res.CertificateID], res)
+	}
+	sort.Ints(ids)
+	return ids, certs
+}
+
+/* writeSTIX: the results as a STIX 2.1 bundle of domain-name, ipv4-addr,
+ * ipv6-addr, email-addr and x509-certificate objects. Which names are on a
+ * certificate is in its subject alternative name extension.
+ */
+func writeSTIX(w *bufio.Writer, results []sancrawler.Result) error {
+	bundle := stixBundle{Type: "bundle", ID: stixID("bundle", nil), Objects: []interface{}{}}
+
+	kinds := map[string]string{"domain": "domain-name", "ipv4": "ipv4-addr", "ipv6": "ipv6-addr", "email": "email-addr"}
+	seen := make(map[string]bool)
+	for _, res := range results {
+		kind, value := indicatorValue(res)
+		if kind == "" || seen[kind+value] {
+			continue
+		}
+		seen[kind+value] = true
+
+		bundle.Objects = append(bundle.Objects, stixAddress{
+			Type:        kinds[kind],
+			SpecVersion: "2.1",
+			ID:          stixID(kinds[kind], map[string]interface{}{"value": value}),
+			Value:       value,
+		})
+	}
+
+	ids, certs := certificateNames(results)
+	for _, id := range ids {
+		first := certs[id][0]
+
+		var san []string
+		for _, res := range certs[id] {
+			switch res.Type {
+			case "":
+				san = append(san, "DNS:"+res.Name)
+			case sancrawler.TypeIP:
+				san = append(san, "IP Address:"+res.Name)
+			case sancrawler.TypeEmail:
+				san = append(san, "email:"+res.Name)
+			case sancrawler.TypeURI:
+				san = append(san, "URI:"+res.Name)
+			}
+		}
+
+		cert := stixCertificate{
+			Type:        "x509-certificate",
+			SpecVersion: "2.1",
+			ID:          stixID("x509-certificate", nil),
+			Issuer:      first.IssuerName,
+			CrtshID:     id,
+			Extensions:  &stixX509Extensions{SubjectAlternativeName: strings.Join(san, ", ")},
+		}
+		if !first.NotBefore.IsZero() {
+			cert.ValidityNotBefore = first.NotBefore.UTC().Format(time.RFC3339)
+		}
+		if !first.NotAfter.IsZero() {
+			cert.ValidityNotAfter = first.NotAfter.UTC().Format(time.RFC3339)
+		}
+		bundle.Objects = append(bundle.Objects, cert)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bundle)
+}
+
+// A MISP event, in the form MISP imports and its API takes
+type mispEvent struct {
+	Event mispEventBody `json:"Event"`
+}
+
+type mispEventBody struct {
+	UUID          string          `json:"uuid"`
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	Timestamp     string          `json:"timestamp"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Attribute     []mispAttribute `json:"Attribute"`
+	Object        []mispObject    `json:"Object"`
+}
+
+type mispAttribute struct {
+	Type           string `json:"type"`
+	Category       string `json:"category,omitempty"`
+	Value          string `json:"value"`
+	ObjectRelation string `json:"object_relation,omitempty"`
+	ToIDS          bool   `json:"to_ids"`
+	Comment        string `json:"comment,omitempty"`
+}
+
+type mispObject struct {
+	Name         string          `json:"name"`
+	MetaCategory string          `json:"meta-category"`
+	Comment      string          `json:"comment,omitempty"`
+	Attribute    []mispAttribute `json:"Attribute"`
+}
+
+/* writeMISP: the results as a MISP event, an attribute per name saying which
+ * seeds found it and an x509 object per certificate. Nothing is marked for IDS,
+ * these are the client's own assets rather than anything malicious.
+ */
+func writeMISP(w *bufio.Writer, results []sancrawler.Result) error {
+	now := time.Now().UTC()
+
+	var seeds []string
+	for _, res := range results {
+		for _, seed := range res.Seeds {
+			if !containsString(seeds, seed) {
+				seeds = append(seeds, seed)
+			}
+		}
+	}
+
+	event := mispEventBody{
+		UUID:          strings.TrimPrefix(stixID("event", nil), "event--"),
+		Info:          "SANCrawler: " + strings.Join(seeds, ", "),
+		Date:          now.Format("2006-01-02"),
+		Timestamp:     strconv.FormatInt(now.Unix(), 10),
+		ThreatLevelID: "4",
+		Analysis:      "2",
+		Distribution:  "0",
+		Attribute:     []mispAttribute{},
+		Object:        []mispObject{},
+	}
+
+	types := map[string]string{"domain": "domain", "ipv4": "ip-dst", "ipv6": "ip-dst", "email": "email"}
+	seen := make(map[string]bool)
+	for _, res := range results {
+		kind, value := indicatorValue(res)
+		if kind == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+
+		var notes []string
+		if len(res.Seeds) > 0 {
+			notes = append(notes, "Found by "+strings.Join(res.Seeds, ", "))
+		}
+		if strings.HasPrefix(res.Name, "*.") {
+			notes = append(notes, "has a wildcard certificate")
+		}
+		event.Attribute = append(event.Attribute, mispAttribute{
+			Type:     types[kind],
+			Category: "Network activity",
+			Value:    value,
+			Comment:  strings.Join(notes, ", "),
+		})
+	}
+
+	ids, certs := certificateNames(results)
+	for _, id := range ids {
+		first := certs[id][0]
+
+		obj := mispObject{Name: "x509", MetaCategory: "network", Comment: "crt.sh ID " + strconv.Itoa(id)}
+		add := func(relation, kind, value string) {
+			if value != "" {
+				obj.Attribute = append(obj.Attribute, mispAttribute{Type: kind, ObjectRelation: relation, Value: value})
+			}
+		}
+
+		add("issuer", "text", first.IssuerName)
+		if !first.NotBefore.IsZero() {
+			add("validity-not-before", "datetime", first.NotBefore.UTC().Format(time.RFC3339))
+		}
+		if !first.NotAfter.IsZero() {
+			add("validity-not-after", "datetime", first.NotAfter.UTC().Format(time.RFC3339))
+		}
+		for _, res := range certs[id] {
+			if res.Type == "" {
+				add("dns_names", "text", res.Name)
+			}
+		}
+		add("text", "text", "https://crt.sh/?id="+strconv.Itoa(id))
+
+		event.Object = append(event.Object, obj)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(mispEvent{Event: event})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cramppet/sancrawler2/pkg/dedup"
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// How many names each -low-memory set keeps in memory before spilling to disk
+const dedupMemory = 100000
+
+// nameStream writes names out as soon as the crawl turns them up, rather than
+// all at once at the end, so SANCrawler can sit in the middle of a pipeline
+// and a run that dies still leaves something behind. Names get the same clean
+// up and scope the final results do, and each is only written once. Text is a
+// name per line, json an object per line and csv a row per name. subfinder and
+// amass are their JSON lines. With -low-memory the names already written are
+// kept track of in a dedup.Set rather than a map, which the crawler checks
+// names against itself (see useLowMemory).
+type nameStream struct {
+	normalizer     sancrawler.Normalizer
+	scope          *sancrawler.Scope
+	stripWildcards bool
+	format         string
+
+	mu    sync.Mutex
+	w     *bufio.Writer
+	csv   *csv.Writer
+	seen  map[string]bool
+	names *dedup.Set
+	// Whether names have already been checked against names by the time they
+	// get to found
+	checked bool
+}
+
+/* newNameStream: a nameStream writing to w in opts.format, cleaning names up
+ * the way opts asks for.
+ */
+func newNameStream(w io.Writer, opts *options, scope *sancrawler.Scope) *nameStream {
+	s := &nameStream{
+		normalizer:     buildNormalizer(opts.normalize),
+		scope:          scope,
+		stripWildcards: opts.stripWildcards,
+		format:         opts.format,
+		w:              bufio.NewWriter(w),
+		seen:           make(map[string]bool),
+	}
+
+	if s.format == "csv" {
+		s.csv = csv.NewWriter(s.w)
+		s.csv.Write(csvHeader)
+		s.csv.Flush()
+	}
+
+	return s
+}
+
+/* found: writes out res if it's a new in scope DNS name. Safe to call from
+ * every crawling goroutine at once.
+ */
+func (s *nameStream) found(res sancrawler.Result) {
+	res, ok := s.prepare(res)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if s.checked || s.firstTime(res.Name) {
+		s.write(res)
+	}
+	s.mu.Unlock()
+}
+
+/* prepare: res the way it gets written out, false if it's not a DNS name in
+ * scope and doesn't get written at all.
+ */
+func (s *nameStream) prepare(res sancrawler.Result) (sancrawler.Result, bool) {
+	for _, res := range s.normalizer.Normalize(sancrawler.Results{res.Name: res}) {
+		if res.Type != "" {
+			return res, false
+		}
+
+		name := sancrawler.NormalizeWildcard(res.Name)
+		if s.stripWildcards || s.format == "subfinder" || s.format == "amass" {
+			name = strings.TrimPrefix(name, "*.")
+		}
+		if !s.scope.Allows(name) {
+			return res, false
+		}
+
+		res.Name = name
+		return res, true
+	}
+	return res, false
+}
+
+/* Add: implements sancrawler.NameSet for -low-memory, so the crawler checks
+ * names against the stream's set as they'll be written out rather than keeping
+ * a set of its own. Names that won't be written don't get remembered at all.
+ */
+func (s *nameStream) Add(name string) (bool, error) {
+	res, ok := s.prepare(sancrawler.Result{Name: name})
+	if !ok {
+		return false, nil
+	}
+	return s.names.Add(res.Name)
+}
+
+/* firstTime: whether name hasn't been written yet, s.mu has to be held. If
+ * that can't be worked out it gets written again rather than not at all.
+ */
+func (s *nameStream) firstTime(name string) bool {
+	if s.names == nil {
+		if s.seen[name] {
+			return false
+		}
+		s.seen[name] = true
+		return true
+	}
+
+	added, err := s.names.Add(name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Name":  name,
+			"Error": err,
+		}).Warn("Could not check whether name was already written")
+	}
+	return added || err != nil
+}
+
+/* close: throws away the names written so far, which for -low-memory can mean
+ * a file on disk.
+ */
+func (s *nameStream) close() {
+	if s.names == nil {
+		return
+	}
+	if err := s.names.Close(); err != nil {
+		log.Warn("Could not clean up names spilled to disk: ", err)
+	}
+}
+
+/* useLowMemory: stops the crawl from keeping the names it finds, they only
+ * exist in the stream. There's just the one set of names, the stream's, which
+ * the crawler checks each name against before handing it over. Returns what to
+ * call once the crawl is done, which says how many names were written.
+ */
+func useLowMemory(crawler *sancrawler.Crawler, opts *options, stream *nameStream) func() int {
+	stream.names = dedup.New(opts.expectedNames, dedupMemory, "")
+	stream.checked = true
+	crawler.Seen = stream
+	if db, ok := crawler.Backend.(*sancrawler.DBBackend); ok {
+		db.Discard = true
+	}
+
+	return func() int {
+		found := stream.names.Len()
+		log.WithFields(log.Fields{
+			"Found": found,
+		}).Info("Streamed names without keeping them")
+		return found
+	}
+}
+
+/* lowMemoryConflicts: the flags given that need every name kept around after
+ * the crawl, which -low-memory doesn't do. They'd only ever see nothing.
+ */
+func lowMemoryConflicts(opts *options) []string {
+	if !opts.lowMemory {
+		return nil
+	}
+
+	needs := []struct {
+		flag  string
+		given bool
+	}{
+		{"-recursive", opts.recursive},
+		{"-issuer-pivot", opts.issuerPivot},
+		{"-resume", opts.resume != ""},
+		{"-diff", opts.diffPath != ""},
+		{"-p", opts.print},
+		{"-stats", opts.statsPath != ""},
+		{"-report", opts.reportPath != ""},
+		{"-timeline", opts.timelinePath != ""},
+		{"-clusters", opts.clustersPath != ""},
+		{"-sqlite", opts.sqlitePath != ""},
+		{"-neo4j", opts.neo4jURL != ""},
+		{"-targets-exclude", opts.targetsExclude != ""},
+		{"-emails", opts.emails},
+		{"-homoglyphs", opts.homoglyphs},
+		{"-min-score", opts.minScore > 0},
+		{"-expiring-within", opts.expiringWithin != ""},
+		{"-wordlist", opts.wordlist != ""},
+		{"-dns-extra", opts.dnsExtra},
+		{"-dns-audit", opts.dnsAudit},
+		{"-resolve", opts.resolve},
+		{"-enrich", len(opts.enrichments) > 0},
+		{"-takeover-check", opts.takeoverCheck},
+		{"-probe", opts.probe},
+	}
+
+	var ret []string
+	for _, n := range needs {
+		if n.given {
+			ret = append(ret, n.flag)
+		}
+	}
+	return ret
+}
+
+/* write: writes a single result out in the stream's format, flushing it
+ * straight away so nothing is left sitting in a buffer.
+ */
+func (s *nameStream) write(res sancrawler.Result) {
+	switch s.format {
+	case "json":
+		json.NewEncoder(s.w).Encode(res)
+	case "csv":
+		s.csv.Write(csvRow(res))
+		s.csv.Flush()
+	case "subfinder", "amass":
+		json.NewEncoder(s.w).Encode(compatRecord(s.format, res))
+	default:
+		s.w.WriteString(res.Name + "\n")
+	}
+	s.w.Flush()
+}
+
+/* streamsToStdout: whether names should be written to stdout as they're found
+ * without -stream. That's the case whenever plain names would have gone to
+ * stdout anyway, unless something later on decides which names are kept.
+ */
+func streamsToStdout(opts *options) bool {
+	return opts.outfile == "" && opts.format == "text" && opts.diffPath == "" &&
+		!opts.watch && !opts.cloudOnly && !opts.noCloud
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* tail: the tail command. Follows CT logs directly and writes out the names on
+ * every new certificate matching the seeds as soon as it's logged, until ctx is
+ * done. Nothing gets crawled, so only what's logged from now on turns up.
+ */
+func tail(ctx context.Context, opts *options) {
+	switch opts.format {
+	case "text", "json", "csv":
+	default:
+		log.Fatal("tail only writes text, json or csv")
+	}
+
+	// The lookup modes aren't available here, so there's no crawler for
+	// buildQueries to need.
+	queries := buildQueries(ctx, nil, opts)
+
+	client := &http.Client{Timeout: time.Minute, Transport: buildProxy(opts).Transport()}
+
+	logs, err := listOrFile(opts.ctLogs)
+	if err != nil {
+		log.Fatal("Could not read CT logs: ", err)
+	}
+	if len(logs) == 0 {
+		if logs, err = sancrawler.UsableLogs(ctx, client, opts.logList); err != nil {
+			log.Fatal("Could not get the CT log list: ", err)
+		}
+	}
+
+	tailer := &sancrawler.LogTailer{
+		Logs:      logs,
+		Client:    client,
+		Interval:  opts.tailInterval,
+		BatchSize: opts.tailBatch,
+		Queries:   queries,
+		Domains:   opts.tailDomains,
+	}
+
+	log.WithFields(log.Fields{
+		"Logs":    len(logs),
+		"Seeds":   len(queries),
+		"Domains": strings.Join(opts.tailDomains, ","),
+	}).Info("Tailing CT logs for new certificates")
+
+	fHandle, err := openOutput(opts.outfile, true)
+	if err != nil {
+		log.Fatal("Could not write output: ", err)
+	}
+	if fHandle != os.Stdout {
+		defer fHandle.Close()
+	}
+
+	// No -normalize here, names get the default clean up
+	if opts.normalize == "" {
+		opts.normalize = "dots,ports,idn,ips"
+	}
+	stream := newNameStream(fHandle, opts, buildScope(opts))
+
+	if err := tailer.Tail(ctx, stream.found); err != nil {
+		log.Fatal(err)
+	}
+}
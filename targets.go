@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* targetAddresses: every address the results resolved to, or are, along with
+ * the names on each. Names that didn't resolve aren't anywhere.
+ */
+func targetAddresses(results []sancrawler.Result) ([]netip.Addr, map[netip.Addr][]string) {
+	names := make(map[netip.Addr][]string)
+
+	add := func(address string, name string) {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return
+		}
+		addr = addr.Unmap()
+		if _, ok := names[addr]; !ok {
+			names[addr] = nil
+		}
+		if name != "" && !containsString(names[addr], name) {
+			names[addr] = append(names[addr], name)
+		}
+	}
+
+	for _, res := range results {
+		switch {
+		case res.Type == sancrawler.TypeIP:
+			add(res.Name, "")
+		case res.Type == "" && res.DNS != nil:
+			for _, a := range append(append([]string(nil), res.DNS.A...), res.DNS.AAAA...) {
+				add(a, res.Name)
+			}
+		}
+	}
+
+	addrs := make([]netip.Addr, 0, len(names))
+	for addr := range names {
+		addrs = append(addrs, addr)
+	}
+
+	// netip sorts IPv4 before IPv6, which is what nmap users expect to see
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+	return addrs, names
+}
+
+/* writeTargets: one address per line, each only once, ready for nmap -iL or
+ * masscan -iL. With an output file the names behind each address go next to it
+ * in <outfile>.hosts, in /etc/hosts form, for virtual host scanning and for
+ * making sense of the scan results later.
+ */
+func writeTargets(w *bufio.Writer, outfile string, results []sancrawler.Result) error {
+	addrs, names := targetAddresses(results)
+
+	for _, addr := range addrs {
+		w.WriteString(addr.String() + "\n")
+	}
+
+	log.WithFields(log.Fields{
+		"Names":   len(results),
+		"Targets": len(addrs),
+	}).Info("Deduplicated names into scan targets")
+
+	if outfile == "" {
+		return nil
+	}
+
+	f, err := os.Create(outfile + ".hosts")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hosts := bufio.NewWriter(f)
+	for _, addr := range addrs {
+		if len(names[addr]) > 0 {
+			hosts.WriteString(addr.String() + "\t" + strings.Join(names[addr], " ") + "\n")
+		}
+	}
+	return hosts.Flush()
+}
+
+/* excludeTargets: resolves the names the scope filter dropped and writes their
+ * addresses to path, for nmap and masscan's --excludefile. Anything shared with
+ * an out of scope name (shared hosting, CDNs) then doesn't get scanned, since
+ * there's no telling whose it is.
+ */
+func excludeTargets(ctx context.Context, path string, resolver *sancrawler.Resolver, dropped sancrawler.Results) {
+	if len(dropped) > 0 {
+		log.WithFields(log.Fields{
+			"Names": len(dropped),
+		}).Info("Resolving out of scope names to exclude")
+
+		resolver.ResolveAll(ctx, dropped)
+	}
+
+	addrs, _ := targetAddresses(dropped.Sorted())
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal("Could not write excluded targets: ", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, addr := range addrs {
+		w.WriteString(addr.String() + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal("Could not write excluded targets: ", err)
+	}
+
+	log.WithFields(log.Fields{
+		"Excluded": len(addrs),
+		"File":     path,
+	}).Info("Wrote out of scope addresses")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	"gopkg.in/yaml.v3"
+)
+
+// tenant is a team sharing the server, with its API key and what it's allowed.
+// Zero for any of the limits means no limit of its own, the server's still
+// apply.
+type tenant struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// Like -qps and -max-connections, for this team's crawls alone
+	QPS      float64 `yaml:"qps"`
+	MaxConns int     `yaml:"max-connections"`
+	// How many of its crawls can run at once and how many can be waiting
+	MaxRunning int `yaml:"max-running"`
+	MaxQueued  int `yaml:"max-queued"`
+
+	limiter *sancrawler.Limiter
+	// Crawls running right now, REST and gRPC, guarded by tenants.mu
+	running int
+}
+
+// tenants are the API keys the server accepts (-api-keys). A nil tenants means
+// there's no authentication and everyone sees everything, like before.
+type tenants struct {
+	mu   sync.Mutex
+	list []*tenant
+}
+
+type tenantKey struct{}
+
+/* loadTenants: reads the API keys file, a YAML list of tenants.
+ */
+func loadTenants(path string) (*tenants, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*tenant
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: no API keys", path)
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for _, t := range list {
+		if t.Name == "" || t.Key == "" {
+			return nil, fmt.Errorf("%s: every API key needs a name and a key", path)
+		}
+		if names[t.Name] || keys[t.Key] {
+			return nil, fmt.Errorf("%s: %s is there twice", path, t.Name)
+		}
+		names[t.Name], keys[t.Key] = true, true
+
+		if t.QPS > 0 || t.MaxConns > 0 {
+			t.limiter = sancrawler.NewLimiter(t.MaxConns, t.QPS)
+		}
+	}
+
+	return &tenants{list: list}, nil
+}
+
+/* authenticate: the tenant whose key the request has, as a bearer token, an
+ * X-API-Key header or a key parameter (for download links). Nil if it doesn't
+ * have a valid one.
+ */
+func (ts *tenants) authenticate(r *http.Request) *tenant {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	return ts.byKey(key)
+}
+
+/* byKey: the tenant with the key, nil if there isn't one. Every key gets
+ * compared so how long it takes doesn't give anything away.
+ */
+func (ts *tenants) byKey(key string) *tenant {
+	if key == "" {
+		return nil
+	}
+
+	var found *tenant
+	for _, t := range ts.list {
+		if subtle.ConstantTimeCompare([]byte(t.Key), []byte(key)) == 1 {
+			found = t
+		}
+	}
+	return found
+}
+
+/* byName: the tenant called name, nil if there isn't one.
+ */
+func (ts *tenants) byName(name string) *tenant {
+	if ts == nil {
+		return nil
+	}
+	for _, t := range ts.list {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+/* require: wraps next so it only gets requests with a valid key, which it can
+ * find with tenantFrom. Without tenants everything goes through.
+ */
+func (ts *tenants) require(next http.HandlerFunc) http.HandlerFunc {
+	if ts == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := ts.authenticate(r)
+		if t == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sancrawler"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or unknown API key")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+	}
+}
+
+/* tenantFrom: the tenant require found for the request, nil without tenants.
+ */
+func tenantFrom(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantKey{}).(*tenant)
+	return t
+}
+
+/* owner: the name jobs belong to, empty without tenants.
+ */
+func (t *tenant) owner() string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+/* owns: whether the tenant gets to see a job. Without tenants everyone does.
+ */
+func (t *tenant) owns(j *job) bool {
+	return t == nil || j.Owner == t.Name
+}
+
+/* crawlContext: ctx with the tenant's limiter on it, if it has one.
+ */
+func (t *tenant) crawlContext(ctx context.Context) context.Context {
+	if t == nil || t.limiter == nil {
+		return ctx
+	}
+	return sancrawler.WithLimiter(ctx, t.limiter)
+}
+
+// Returned by tryStart when a tenant already has all the crawls it's allowed
+var errTooManyRunning = errors.New("too many crawls running for this API key")
+
+/* tryStart: counts another crawl for t, unless it's already running as many as
+ * it's allowed. A nil t can always start one.
+ */
+func (ts *tenants) tryStart(t *tenant) error {
+	if ts == nil || t == nil {
+		return nil
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if t.MaxRunning > 0 && t.running >= t.MaxRunning {
+		return errTooManyRunning
+	}
+	t.running++
+	return nil
+}
+
+/* finish: a crawl tryStart let through is done.
+ */
+func (ts *tenants) finish(t *tenant) {
+	if ts == nil || t == nil {
+		return
+	}
+	ts.mu.Lock()
+	t.running--
+	ts.mu.Unlock()
+}
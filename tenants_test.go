@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeys(t *testing.T, keys string) string {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTenants(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		ok   bool
+	}{
+		{"good", "- name: red\n  key: k1\n  qps: 2\n- name: blue\n  key: k2\n", true},
+		{"empty", "", false},
+		{"no key", "- name: red\n", false},
+		{"no name", "- key: k1\n", false},
+		{"same name twice", "- name: red\n  key: k1\n- name: red\n  key: k2\n", false},
+		{"same key twice", "- name: red\n  key: k1\n- name: blue\n  key: k1\n", false},
+		{"not a list", "name: red\nkey: k1\n", false},
+	}
+
+	for _, tt := range tests {
+		ts, err := loadTenants(writeKeys(t, tt.keys))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: loadTenants error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err == nil && ts.byName("red").limiter == nil {
+			t.Errorf("%s: qps didn't get a limiter", tt.name)
+		}
+	}
+
+	if _, err := loadTenants(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("loadTenants of a missing file didn't fail")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ts, err := loadTenants(writeKeys(t, "- name: red\n  key: k1\n- name: blue\n  key: k2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		url    string
+		want   string
+	}{
+		{"bearer", "Authorization", "Bearer k1", "/jobs", "red"},
+		{"header", "X-API-Key", "k2", "/jobs", "blue"},
+		{"parameter", "", "", "/jobs?key=k1", "red"},
+		{"bearer beats parameter", "Authorization", "Bearer k2", "/jobs?key=k1", "blue"},
+		{"wrong key", "X-API-Key", "k3", "/jobs", ""},
+		{"not bearer", "Authorization", "Basic k1", "/jobs", ""},
+		{"none", "", "", "/jobs", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := ts.authenticate(r).owner(); got != tt.want {
+			t.Errorf("%s: authenticated as %q, want %q", tt.name, got, tt.want)
+		}
+
+		// require only lets the ones with a key through, and says who they are
+		var through *tenant
+		w := httptest.NewRecorder()
+		ts.require(func(w http.ResponseWriter, r *http.Request) {
+			through = tenantFrom(r.Context())
+		})(w, r)
+		if tt.want == "" && (w.Code != http.StatusUnauthorized || through != nil) {
+			t.Errorf("%s: got %d, want 401", tt.name, w.Code)
+		}
+		if tt.want != "" && through.owner() != tt.want {
+			t.Errorf("%s: handler got %q, want %q", tt.name, through.owner(), tt.want)
+		}
+	}
+}
+
+func TestTenantLimits(t *testing.T) {
+	ts, err := loadTenants(writeKeys(t, "- name: red\n  key: k1\n  max-running: 2\n- name: blue\n  key: k2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	red, blue := ts.byName("red"), ts.byName("blue")
+
+	for i := 0; i < 2; i++ {
+		if err := ts.tryStart(red); err != nil {
+			t.Fatalf("crawl %d: %v", i+1, err)
+		}
+	}
+	if err := ts.tryStart(red); err != errTooManyRunning {
+		t.Errorf("third crawl: %v, want errTooManyRunning", err)
+	}
+
+	// Other keys aren't held back, and no limit means none
+	for i := 0; i < 10; i++ {
+		if err := ts.tryStart(blue); err != nil {
+			t.Fatalf("blue crawl %d: %v", i+1, err)
+		}
+	}
+
+	ts.finish(red)
+	if err := ts.tryStart(red); err != nil {
+		t.Errorf("after one finished: %v", err)
+	}
+
+	// Without keys everything goes
+	var none *tenants
+	if err := none.tryStart(nil); err != nil {
+		t.Error(err)
+	}
+	none.finish(nil)
+
+	// And jobs are only seen by their owner
+	j := &job{Owner: "red"}
+	if !red.owns(j) || blue.owns(j) || !(*tenant)(nil).owns(j) {
+		t.Error("owns doesn't keep jobs to their owner")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// How many of a period's new names the text timeline lists before it gives up
+// and just counts the rest
+const timelineNames = 3
+
+/* writeTimeline: writes when the names under each apex turned up to path, JSON
+ * if it ends in .json and text otherwise.
+ */
+func writeTimeline(path string, subdomains sancrawler.Results, period string) error {
+	timelines := sancrawler.NewTimelines(subdomains, period)
+
+	log.WithFields(log.Fields{
+		"Apexes": len(timelines),
+		"Period": period,
+		"File":   path,
+	}).Info("Built timeline of certificate issuance")
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		if timelines == nil {
+			timelines = []sancrawler.Timeline{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(timelines)
+	} else {
+		writeTimelineText(w, timelines, period)
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+/* writeTimelineText: a block per apex with a line for each period, a bar of how
+ * many new names turned up in it and the first few of them.
+ */
+func writeTimelineText(w *bufio.Writer, timelines []sancrawler.Timeline, period string) {
+	layout := "2006-01"
+	switch period {
+	case sancrawler.PeriodWeek:
+		layout = "2006-01-02"
+	case sancrawler.PeriodYear:
+		layout = "2006"
+	}
+
+	for _, t := range timelines {
+		fmt.Fprintf(w, "%s: %d names", t.Apex, t.Names)
+		if len(t.Periods) > 0 {
+			fmt.Fprintf(w, ", %s to %s", t.Periods[0].Start.Format(layout), t.Periods[len(t.Periods)-1].Start.Format(layout))
+		}
+		w.WriteString("\n")
+
+		most := 0
+		for _, p := range t.Periods {
+			if len(p.NewNames) > most {
+				most = len(p.NewNames)
+			}
+		}
+
+		for _, p := range t.Periods {
+			filled := 0
+			if most > 0 {
+				filled = (20*len(p.NewNames) + most - 1) / most
+			}
+			fmt.Fprintf(w, "  %-10s %-20s %4d new %4d certs", p.Start.Format(layout), strings.Repeat("#", filled), len(p.NewNames), p.Certificates)
+			if len(p.NewNames) > 0 {
+				w.WriteString("  " + timelineList(p.NewNames))
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString("\n")
+	}
+}
+
+/* timelineList: the first few names and how many more there are.
+ */
+func timelineList(names []string) string {
+	if len(names) <= timelineNames {
+		return strings.Join(names, ", ")
+	}
+	return strings.Join(names[:timelineNames], ", ") + fmt.Sprintf(" and %d more", len(names)-timelineNames)
+}
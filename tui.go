@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+// How many of the newest names the TUI keeps around to show
+const tuiFeedSize = 200
+
+// How many log lines are held back while the TUI is up. A long crawl can log a
+// lot, past this only the newest are kept.
+const tuiLogSize = 1000
+
+// The log is in colour if it was going to a terminal, which would only get in
+// the way of cutting lines to fit
+var ansiColour = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// A full screen view of a crawl in progress, drawn on the terminal rather than
+// stdout or stderr so neither gets anything mixed into it. The log is held back
+// while it's up and written out once it closes.
+type tui struct {
+	tty      *os.File
+	program  *tea.Program
+	done     chan struct{}
+	progress *sancrawler.Progress
+	limiter  *sancrawler.Limiter
+	seeds    int
+	start    time.Time
+
+	logOut io.Writer
+	logs   *tuiLog
+
+	mu       sync.Mutex
+	feed     []string
+	seen     map[string]bool
+	stopping bool
+	closed   bool
+}
+
+// Collects the log while the TUI is up, in a ring of the last tuiLogSize lines
+type tuiLog struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	dropped int
+}
+
+func (l *tuiLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(l.lines) < tuiLogSize {
+			l.lines = append(l.lines, line)
+			continue
+		}
+		l.lines[l.next] = line
+		l.next = (l.next + 1) % tuiLogSize
+		l.dropped++
+	}
+	return len(p), nil
+}
+
+/* all: the lines held on to, oldest first, and how many older ones were lost.
+ */
+func (l *tuiLog) all() ([]string, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := append([]string(nil), l.lines[l.next:]...)
+	return append(lines, l.lines[:l.next]...), l.dropped
+}
+
+func (l *tuiLog) last(n int) []string {
+	lines, _ := l.all()
+	if len(lines) < n {
+		n = len(lines)
+	}
+
+	last := lines[len(lines)-n:]
+	for i, line := range last {
+		last[i] = ansiColour.ReplaceAllString(line, "")
+	}
+	return last
+}
+
+// Sent to the model four times a second so the screen keeps up with the crawl
+type tuiTick struct{}
+
+/* tuiTicker: the next tuiTick.
+ */
+func tuiTicker() tea.Cmd {
+	return tea.Tick(250*time.Millisecond, func(time.Time) tea.Msg {
+		return tuiTick{}
+	})
+}
+
+// The bubbletea side of the TUI. All the state lives on the tui, the model only
+// knows how big the screen is.
+type tuiModel struct {
+	t      *tui
+	width  int
+	height int
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return tuiTicker()
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tea.KeyMsg:
+		m.t.key(msg.String())
+	case tuiTick:
+		return m, tuiTicker()
+	}
+	return m, nil
+}
+
+func (m tuiModel) View() string {
+	return strings.Join(m.t.render(m.width, m.height), "\n")
+}
+
+/* startTUI: takes over the terminal for the crawl. Keys come straight through
+ * without waiting for enter, Ctrl-C still gets to handleSignals.
+ */
+func startTUI(progress *sancrawler.Progress, limiter *sancrawler.Limiter, seeds int) (*tui, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New("-tui needs a terminal")
+	}
+
+	t := &tui{
+		tty:      tty,
+		done:     make(chan struct{}),
+		progress: progress,
+		limiter:  limiter,
+		seeds:    seeds,
+		start:    time.Now(),
+		logOut:   log.StandardLogger().Out,
+		logs:     &tuiLog{},
+		seen:     make(map[string]bool),
+	}
+
+	// The signals are left to handleSignals, bubbletea would only quit the TUI
+	// and leave the crawl running behind it
+	t.program = tea.NewProgram(tuiModel{t: t, width: 80, height: 24},
+		tea.WithInput(tty), tea.WithOutput(tty), tea.WithAltScreen(), tea.WithoutSignalHandler())
+
+	// Nothing else gets a chance to put the terminal back after a log.Fatal
+	log.RegisterExitHandler(t.close)
+	log.SetOutput(t.logs)
+
+	go func() {
+		defer close(t.done)
+		if _, err := t.program.Run(); err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Warn("The TUI stopped early")
+		}
+	}()
+
+	return t, nil
+}
+
+/* wrap: wraps found so every new name also goes into the TUI's feed.
+ */
+func (t *tui) wrap(found func(sancrawler.Result)) func(sancrawler.Result) {
+	return func(res sancrawler.Result) {
+		t.mu.Lock()
+		if !t.seen[res.Name] {
+			t.seen[res.Name] = true
+			t.feed = append(t.feed, res.Name)
+			if len(t.feed) > tuiFeedSize {
+				t.feed = t.feed[len(t.feed)-tuiFeedSize:]
+			}
+		}
+		t.mu.Unlock()
+
+		if found != nil {
+			found(res)
+		}
+	}
+}
+
+/* key: p pauses and resumes, + and - change how many queries can run at once
+ * and q stops the crawl gently, keeping what it found. The terminal is raw
+ * while the TUI is up, so Ctrl-C is a key too and gets passed on as SIGINT.
+ */
+func (t *tui) key(key string) {
+	running, max := t.limiter.Conns()
+	switch key {
+	case "p", " ":
+		if t.limiter.Paused() {
+			t.limiter.Resume()
+			log.Info("Resumed the crawl")
+		} else {
+			t.limiter.Pause()
+			log.Info("Paused the crawl, queries already running will finish")
+		}
+	case "+", "=":
+		if max > 0 {
+			t.limiter.SetMaxConns(max + 1)
+			log.WithFields(log.Fields{"Connections": max + 1}).Info("Raised the connection limit")
+		}
+	case "-", "_":
+		// Without a limit, start from however many there are right now
+		if max == 0 {
+			max = running
+		}
+		if max > 1 {
+			t.limiter.SetMaxConns(max - 1)
+			log.WithFields(log.Fields{"Connections": max - 1}).Info("Lowered the connection limit")
+		}
+	case "q":
+		t.mu.Lock()
+		t.stopping = true
+		t.mu.Unlock()
+		t.limiter.Stop()
+		log.Warn("Stopping, letting the queries in flight finish")
+	case "ctrl+c":
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}
+}
+
+/* render: the screen's lines, no more than fit.
+ */
+func (t *tui) render(width int, height int) []string {
+	stats := t.progress.Stats()
+	running, max := t.limiter.Conns()
+
+	t.mu.Lock()
+	names := len(t.seen)
+	feed := append([]string(nil), t.feed...)
+	stopping := t.stopping
+	t.mu.Unlock()
+
+	state := "crawling"
+	switch {
+	case stopping:
+		state = "stopping"
+	case t.limiter.Paused():
+		state = "paused"
+	}
+
+	limit := "no limit"
+	if max > 0 {
+		limit = "limit " + strconv.Itoa(max)
+	}
+
+	lines := []string{
+		fmt.Sprintf("SANCrawler  %d seeds  %s  %s", t.seeds, time.Since(t.start).Round(time.Second), strings.ToUpper(state)),
+		fmt.Sprintf("Names %d  Queries running %d (%s)", names, running, limit),
+	}
+	if stats.Certificates > 0 {
+		line := fmt.Sprintf("Certificates %d/%d (%.1f%%)  %.1f/s", stats.Done, stats.Certificates,
+			100*float64(stats.Done)/float64(stats.Certificates), stats.Rate)
+		if stats.ETA > 0 {
+			line += fmt.Sprintf("  ETA %s", stats.ETA.Round(time.Second))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "")
+
+	logs := t.logs.last(3)
+	footer := 1 + len(logs) + 1
+	room := height - len(lines) - footer
+
+	// CAs still being crawled first, the ones that are done after them. They
+	// get up to half of what's left, the feed gets the rest.
+	cas := t.progress.CAs()
+	var unfinished, finished []sancrawler.CAProgress
+	for _, ca := range cas {
+		if ca.Done >= ca.Certificates {
+			finished = append(finished, ca)
+		} else {
+			unfinished = append(unfinished, ca)
+		}
+	}
+	cas = append(unfinished, finished...)
+
+	if len(cas) > 0 && room > 4 {
+		show := len(cas)
+		if show > room/2-1 {
+			show = room/2 - 1
+		}
+		lines = append(lines, fmt.Sprintf("CAs (%d)", len(cas)))
+		for _, ca := range cas[:show] {
+			lines = append(lines, tuiBar(ca, width))
+		}
+		lines = append(lines, "")
+		room -= show + 2
+	}
+
+	if room > 1 {
+		lines = append(lines, "New names")
+		room--
+		if len(feed) > room {
+			feed = feed[len(feed)-room:]
+		}
+		for _, name := range feed {
+			lines = append(lines, "  "+name)
+		}
+		for i := len(feed); i < room; i++ {
+			lines = append(lines, "")
+		}
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, logs...)
+	lines = append(lines, "p pause/resume  +/- connections  q stop and keep the results  Ctrl-C twice to give up")
+
+	for i, line := range lines {
+		lines[i] = tuiTruncate(line, width)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+/* tuiBar: a line with the CA's name and a bar of how far through it we are.
+ */
+func tuiBar(ca sancrawler.CAProgress, width int) string {
+	percent := 0.0
+	if ca.Certificates > 0 {
+		percent = float64(ca.Done) / float64(ca.Certificates)
+	}
+
+	// The counts go on the end where they can't push the bars out of line
+	barWidth := 20
+	nameWidth := width - barWidth - 5 - 24
+	if nameWidth < 10 {
+		nameWidth = 10
+	}
+
+	name := tuiTruncate(ca.Name, nameWidth)
+	name += strings.Repeat(" ", nameWidth-utf8.RuneCountInString(name))
+
+	filled := int(percent * float64(barWidth))
+	if filled > barWidth {
+		filled = barWidth
+	}
+	return "  " + name + " [" + strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled) + "]" +
+		fmt.Sprintf(" %3.0f%% %d/%d", 100*percent, ca.Done, ca.Certificates)
+}
+
+/* tuiTruncate: cuts s down to width characters.
+ */
+func tuiTruncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+/* close: puts the terminal back how it was once the crawl is done. Safe to
+ * call more than once.
+ */
+func (t *tui) close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	t.program.Quit()
+	<-t.done
+	t.tty.Close()
+
+	// Everything logged while we were up goes where it would have anyway
+	log.SetOutput(t.logOut)
+	lines, dropped := t.logs.all()
+	if dropped > 0 {
+		log.WithFields(log.Fields{
+			"Lines": dropped,
+		}).Warn("Too much was logged while -tui was up, only the newest lines were kept")
+	}
+	for _, line := range lines {
+		fmt.Fprintln(t.logOut, line)
+	}
+}
+
+/* backendLimiter: the limiter the backend's queries go through, if any.
+ */
+func backendLimiter(backend sancrawler.Backend) *sancrawler.Limiter {
+	switch b := backend.(type) {
+	case *sancrawler.DBBackend:
+		return b.Limiter
+	case *sancrawler.APIBackend:
+		return b.Limiter
+	}
+	return nil
+}
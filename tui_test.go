@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTUILog(t *testing.T) {
+	l := &tuiLog{}
+	for i := 0; i < tuiLogSize+5; i++ {
+		fmt.Fprintf(l, "\x1b[36mline %d\x1b[0m\n", i)
+	}
+
+	lines, dropped := l.all()
+	if len(lines) != tuiLogSize || dropped != 5 {
+		t.Fatalf("got %d lines and %d dropped, want %d and 5", len(lines), dropped, tuiLogSize)
+	}
+	if lines[0] != "\x1b[36mline 5\x1b[0m" {
+		t.Errorf("oldest line = %q, want line 5", lines[0])
+	}
+
+	last := l.last(2)
+	want := []string{fmt.Sprintf("line %d", tuiLogSize+3), fmt.Sprintf("line %d", tuiLogSize+4)}
+	if len(last) != 2 || last[0] != want[0] || last[1] != want[1] {
+		t.Errorf("last(2) = %q, want %q", last, want)
+	}
+
+	// Writes with several lines in them are split up
+	l = &tuiLog{}
+	l.Write([]byte("a\nb\n"))
+	if got := l.last(10); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("last(10) = %q, want [a b]", got)
+	}
+}
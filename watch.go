@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+	log "github.com/sirupsen/logrus"
+)
+
+/* watch: re-crawls the queries every opts.interval until ctx is cancelled,
+ * writing out only the names that weren't there the round before. The first
+ * round just sets the baseline, unless -diff gave us one to start from.
+ */
+func watch(ctx context.Context, crawler *sancrawler.Crawler, opts *options, queries []sancrawler.Query, scope *sancrawler.Scope) {
+	var (
+		previous sancrawler.Results
+		since    time.Time
+	)
+
+	if opts.diffPath != "" {
+		var err error
+		previous, since, err = loadPrevious(opts.diffPath)
+		if err != nil {
+			log.Fatal("Could not load previous results: ", err)
+		}
+	}
+
+	for round := 1; ; round++ {
+		started := time.Now()
+
+		// -timeout applies to each round rather than the whole run, otherwise
+		// we'd never get past the first one.
+		roundCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.timeout > 0 {
+			roundCtx, cancel = context.WithTimeout(ctx, opts.timeout)
+		}
+
+		subdomains, failed := crawl(roundCtx, crawler, opts, queries, scope, nil)
+		partial := failed || roundCtx.Err() != nil
+		cancel()
+
+		// A round cut short by Ctrl-C would make everything it missed look like
+		// it had been removed, so don't bother comparing it.
+		if ctx.Err() != nil {
+			return
+		}
+
+		if previous == nil {
+			log.WithFields(log.Fields{
+				"Round": round,
+				"Names": len(subdomains),
+			}).Info("Baseline established, watching for new names")
+		} else {
+			diff := sancrawler.Compare(previous, subdomains, since, time.Now())
+
+			log.WithFields(log.Fields{
+				"Round": round,
+				"Names": len(subdomains),
+				"New":   len(diff.New),
+			}).Info("Finished watch round")
+
+			if len(diff.New) > 0 {
+				if err := writeNew(opts, diff.New); err != nil {
+					log.Error("Could not write new names: ", err)
+				}
+				if opts.notifyURL != "" {
+					if err := notify(opts, queries, diff.New); err != nil {
+						log.Error("Could not send notification: ", err)
+					}
+				}
+			}
+		}
+
+		if opts.sqlitePath != "" {
+			saveToStore(opts.sqlitePath, started, subdomains)
+		}
+		if opts.neo4jURL != "" {
+			saveToNeo4j(ctx, opts.neo4jURL, started, subdomains)
+		}
+
+		// A round that timed out or lost a backend only saw part of the picture,
+		// so it gets added to the baseline rather than replacing it.
+		if partial && previous != nil {
+			previous.Merge(subdomains)
+		} else {
+			previous = subdomains
+		}
+		since = started
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.interval):
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The dashboard is plain HTML and JavaScript talking to the REST API, so it's
+// built into the binary rather than needing anything installed alongside it.
+//
+//go:embed web
+var webFiles embed.FS
+
+/* dashboard: serves the web UI out of the embedded web directory.
+ */
+func dashboard() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
@@ -0,0 +1,173 @@
+// The dashboard only talks to the REST API, everything it does can be done
+// with curl too.
+
+"use strict";
+
+let selected = null;
+let results = [];
+
+function lines(value) {
+	return value.split("\n").map(s => s.trim()).filter(s => s !== "");
+}
+
+function cell(row, text, className) {
+	const td = row.insertCell();
+	td.textContent = text;
+	if (className) {
+		td.className = className;
+	}
+	return td;
+}
+
+function seeds(req) {
+	return [].concat(req.keywords || [], req.organizations || [], req.values || []).join(", ");
+}
+
+// With -api-keys every request needs the key, it's kept in localStorage so it
+// only has to be typed in once
+const apiKey = document.getElementById("api-key");
+apiKey.value = localStorage.getItem("sancrawler-api-key") || "";
+apiKey.addEventListener("change", () => {
+	localStorage.setItem("sancrawler-api-key", apiKey.value);
+	refreshJobs();
+});
+
+function api(path, options = {}) {
+	if (apiKey.value) {
+		options.headers = Object.assign({"X-API-Key": apiKey.value}, options.headers);
+	}
+	return fetch(path, options);
+}
+
+function when(t) {
+	return t ? new Date(t).toLocaleString() : "";
+}
+
+document.getElementById("crawl").addEventListener("submit", async e => {
+	e.preventDefault();
+	const form = e.target;
+	const error = document.getElementById("crawl-error");
+	error.textContent = "";
+
+	const req = {
+		keywords: lines(form.keywords.value),
+		organizations: lines(form.organizations.value),
+		field: form.field.value,
+		values: lines(form.values.value),
+		priority: parseInt(form.priority.value, 10) || 0,
+	};
+
+	const res = await api("crawl", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify(req),
+	});
+	const body = await res.json();
+	if (!res.ok) {
+		error.textContent = body.error;
+		return;
+	}
+
+	form.reset();
+	refreshJobs();
+});
+
+async function refreshJobs() {
+	const res = await api("jobs");
+	if (!res.ok) {
+		return;
+	}
+	const jobs = await res.json();
+
+	const tbody = document.querySelector("#jobs tbody");
+	tbody.replaceChildren();
+
+	for (const job of jobs) {
+		const row = tbody.insertRow();
+		if (job.id === selected) {
+			row.className = "selected";
+		}
+		cell(row, seeds(job.request));
+		cell(row, job.error ? job.status + ": " + job.error : job.status, "status-" + job.status);
+		cell(row, job.names);
+		cell(row, when(job.started));
+
+		const td = row.insertCell();
+		if (job.status === "done" || job.status === "failed" || job.status === "cancelled") {
+			const view = document.createElement("button");
+			view.textContent = "Results";
+			view.addEventListener("click", () => showResults(job.id));
+			td.appendChild(view);
+		} else {
+			const cancel = document.createElement("button");
+			cancel.textContent = "Cancel";
+			cancel.addEventListener("click", () => cancelJob(job.id));
+			td.appendChild(cancel);
+		}
+	}
+}
+
+async function cancelJob(id) {
+	await api("jobs/" + id, {method: "DELETE"});
+	refreshJobs();
+}
+
+async function showResults(id) {
+	const res = await api("jobs/" + id + "/results");
+	if (!res.ok) {
+		return;
+	}
+	results = await res.json();
+	selected = id;
+
+	document.getElementById("results").hidden = false;
+	document.getElementById("results-job").textContent = id;
+	for (const format of ["json", "csv", "txt"]) {
+		const a = document.getElementById("dl-" + format);
+		// Links can't send headers, so the key goes in the URL
+		a.href = "jobs/" + id + "/results?format=" + format +
+			(apiKey.value ? "&key=" + encodeURIComponent(apiKey.value) : "");
+		a.download = id + "." + format;
+	}
+
+	renderResults();
+	refreshJobs();
+}
+
+function renderResults() {
+	const filter = document.getElementById("filter").value.toLowerCase();
+	const hideExpired = document.getElementById("hide-expired").checked;
+
+	const tbody = document.querySelector("#results tbody");
+	tbody.replaceChildren();
+
+	let shown = 0;
+	for (const r of results) {
+		if (hideExpired && r.expired) {
+			continue;
+		}
+		const text = [r.name, r.issuer_name, (r.seeds || []).join(" ")].join(" ").toLowerCase();
+		if (filter && !text.includes(filter)) {
+			continue;
+		}
+
+		const row = tbody.insertRow();
+		if (r.expired) {
+			row.className = "expired";
+		}
+		cell(row, r.name);
+		cell(row, r.field);
+		cell(row, r.issuer_name);
+		cell(row, when(r.not_after));
+		cell(row, (r.seeds || []).join(", "));
+		shown++;
+	}
+
+	document.getElementById("results-count").textContent = shown + " of " + results.length + " names";
+}
+
+document.getElementById("filter").addEventListener("input", renderResults);
+document.getElementById("hide-expired").addEventListener("change", renderResults);
+
+refreshJobs();
+setInterval(refreshJobs, 2000);
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cramppet/sancrawler2/pkg/sancrawler"
+)
+
+// A worksheet, the first row being the header. Cells can be strings, ints,
+// bools or times.
+type xlsxSheet struct {
+	name string
+	rows [][]interface{}
+}
+
+// Excel won't take more than this in one cell
+const xlsxMaxCell = 32767
+
+/* writeXLSX: the results as a spreadsheet, with sheets for the names, the
+ * certificates they were found on, the issuers and the statistics. A diff
+ * gets a sheet of the changes in front of those.
+ */
+func writeXLSX(w io.Writer, subdomains sancrawler.Results, diff *sancrawler.Diff) error {
+	var sheets []xlsxSheet
+
+	if diff != nil {
+		changes := xlsxSheet{name: "Changes", rows: [][]interface{}{xlsxHeader(append([]string{"change"}, csvHeader...))}}
+		for _, change := range []struct {
+			kind    string
+			results []sancrawler.Result
+		}{{"new", diff.New}, {"removed", diff.Removed}, {"reissued", diff.Reissued}, {"expired", diff.Expired}} {
+			for _, res := range change.results {
+				changes.rows = append(changes.rows, append([]interface{}{change.kind}, xlsxRow(res)...))
+			}
+		}
+		sheets = append(sheets, changes)
+	}
+
+	names := xlsxSheet{name: "Names", rows: [][]interface{}{xlsxHeader(csvHeader)}}
+	for _, res := range subdomains.Sorted() {
+		names.rows = append(names.rows, xlsxRow(res))
+	}
+
+	sheets = append(sheets, names, xlsxCertificates(subdomains), xlsxIssuers(subdomains), xlsxStatistics(subdomains))
+
+	return writeWorkbook(w, sheets)
+}
+
+func xlsxHeader(header []string) []interface{} {
+	ret := make([]interface{}, len(header))
+	for i, h := range header {
+		ret[i] = h
+	}
+	return ret
+}
+
+/* xlsxRow: the same columns as csvRow, but with numbers, bools and times left
+ * as they are so they sort and filter properly.
+ */
+func xlsxRow(res sancrawler.Result) []interface{} {
+	row := xlsxHeader(csvRow(res))
+	row[3] = res.CertificateID
+	row[4] = res.IssuerCAID
+	row[6] = res.NotBefore
+	row[7] = res.NotAfter
+	row[8] = res.Expired
+	row[9] = res.Precert
+	return row
+}
+
+/* xlsxCertificates: one row per crt.sh certificate, with every name found on
+ * it. Names from sources without certificate IDs aren't in here.
+ */
+func xlsxCertificates(subdomains sancrawler.Results) xlsxSheet {
+	type certificate struct {
+		first sancrawler.Result
+		names []string
+	}
+	certs := make(map[int]*certificate)
+	var ids []int
+
+	for _, res := range subdomains.Sorted() {
+		if res.CertificateID == 0 {
+			continue
+		}
+		c, ok := certs[res.CertificateID]
+		if !ok {
+			c = &certificate{first: res}
+			certs[res.CertificateID] = c
+			ids = append(ids, res.CertificateID)
+		}
+		c.names = append(c.names, res.Name)
+	}
+	sort.Ints(ids)
+
+	sheet := xlsxSheet{name: "Certificates", rows: [][]interface{}{
+		{"certificate_id", "issuer_ca", "issuer_name", "not_before", "not_after", "expired", "precert", "names", "name_list"},
+	}}
+	for _, id := range ids {
+		c := certs[id]
+		sheet.rows = append(sheet.rows, []interface{}{
+			id, c.first.IssuerCAID, c.first.IssuerName, c.first.NotBefore, c.first.NotAfter,
+			c.first.Expired, c.first.Precert, len(c.names), strings.Join(c.names, "\n"),
+		})
+	}
+	return sheet
+}
+
+/* xlsxIssuers: how many names and certificates each CA issued, most names
+ * first.
+ */
+func xlsxIssuers(subdomains sancrawler.Results) xlsxSheet {
+	type issuer struct {
+		names, expired int
+		certs          map[int]bool
+	}
+	issuers := make(map[string]*issuer)
+
+	for _, res := range subdomains {
+		name := res.IssuerName
+		if name == "" {
+			name = "unknown"
+		}
+		i, ok := issuers[name]
+		if !ok {
+			i = &issuer{certs: make(map[int]bool)}
+			issuers[name] = i
+		}
+		i.names++
+		if res.Expired {
+			i.expired++
+		}
+		if res.CertificateID != 0 {
+			i.certs[res.CertificateID] = true
+		}
+	}
+
+	counts := make(map[string]int)
+	for name, i := range issuers {
+		counts[name] = i.names
+	}
+
+	sheet := xlsxSheet{name: "Issuers", rows: [][]interface{}{{"issuer_name", "names", "certificates", "expired_names"}}}
+	for _, c := range sancrawler.SortCounts(counts) {
+		i := issuers[c.Name]
+		sheet.rows = append(sheet.rows, []interface{}{c.Name, i.names, len(i.certs), i.expired})
+	}
+	return sheet
+}
+
+/* xlsxStatistics: the figures from -p that come from the names alone, then the
+ * names per apex domain.
+ */
+func xlsxStatistics(subdomains sancrawler.Results) xlsxSheet {
+	stats := sancrawler.NewStatistics(subdomains, 0, 0)
+
+	sheet := xlsxSheet{name: "Statistics", rows: [][]interface{}{
+		{"statistic", "value"},
+		{"unique_names", stats.Names},
+		{"wildcards", stats.Wildcards},
+		{"ip_sans", stats.IPs},
+		{"valid", stats.Valid},
+		{"expired", stats.Expired},
+		{"apex_domains", stats.ApexDomains},
+		{"unparsed", stats.Unparsed},
+		{"issuer_count", stats.IssuerCount},
+		{},
+		{"domain", "names"},
+	}}
+	for _, c := range stats.Domains {
+		sheet.rows = append(sheet.rows, []interface{}{c.Name, c.Count})
+	}
+	return sheet
+}
+
+/* writeWorkbook: writes the sheets out as an .xlsx, which is a zip of XML
+ * files. Only as much of the format as it takes for Excel, LibreOffice and
+ * Google Sheets to open it without complaint: strings inline, one bold style
+ * for the headers (which stay put while scrolling) and one for dates.
+ */
+func writeWorkbook(w io.Writer, sheets []xlsxSheet) error {
+	z := zip.NewWriter(w)
+
+	var contentTypes, workbook, rels strings.Builder
+
+	contentTypes.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+`)
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+`)
+
+	for i, sheet := range sheets {
+		n := strconv.Itoa(i + 1)
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%s.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%s" r:id="rId%s"/>`, xmlEscape(sheet.name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%s" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%s.xml"/>`+"\n", n, n)
+	}
+
+	contentTypes.WriteString("</Types>\n")
+	workbook.WriteString("</sheets></workbook>\n")
+	rels.WriteString("</Relationships>\n")
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>
+`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+</styleSheet>
+`},
+	}
+
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+
+	for i, sheet := range sheets {
+		fw, err := z.Create("xl/worksheets/sheet" + strconv.Itoa(i+1) + ".xml")
+		if err != nil {
+			return err
+		}
+		if err := writeWorksheet(fw, sheet); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+/* writeWorksheet: one sheet's XML. Times are stored as Excel serial dates so
+ * they can be sorted and filtered, and zero times are left blank.
+ */
+func writeWorksheet(w io.Writer, sheet xlsxSheet) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>
+<sheetData>
+`)
+
+	for r, row := range sheet.rows {
+		rowNum := strconv.Itoa(r + 1)
+		bw.WriteString(`<row r="` + rowNum + `">`)
+
+		for c, value := range row {
+			ref := xlsxColumn(c) + rowNum
+			style := ""
+			if r == 0 {
+				style = ` s="1"`
+			}
+
+			switch v := value.(type) {
+			case int:
+				bw.WriteString(`<c r="` + ref + `"` + style + `><v>` + strconv.Itoa(v) + `</v></c>`)
+			case bool:
+				b := "0"
+				if v {
+					b = "1"
+				}
+				bw.WriteString(`<c r="` + ref + `"` + style + ` t="b"><v>` + b + `</v></c>`)
+			case time.Time:
+				if !v.IsZero() {
+					bw.WriteString(`<c r="` + ref + `" s="2"><v>` + strconv.FormatFloat(xlsxDate(v), 'f', -1, 64) + `</v></c>`)
+				}
+			case string:
+				if v == "" {
+					continue
+				}
+				if len(v) > xlsxMaxCell {
+					v = v[:xlsxMaxCell]
+				}
+				bw.WriteString(`<c r="` + ref + `"` + style + ` t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(v) + `</t></is></c>`)
+			}
+		}
+
+		bw.WriteString("</row>\n")
+	}
+
+	bw.WriteString("</sheetData>\n</worksheet>\n")
+	return bw.Flush()
+}
+
+/* xlsxColumn: the letters for a zero based column number, A to Z then AA on.
+ */
+func xlsxColumn(n int) string {
+	name := ""
+	for n++; n > 0; n = (n - 1) / 26 {
+		name = string(rune('A'+(n-1)%26)) + name
+	}
+	return name
+}
+
+/* xlsxDate: a time as the number of days since Excel's epoch, in UTC.
+ */
+func xlsxDate(t time.Time) float64 {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	return t.UTC().Sub(epoch).Hours() / 24
+}